@@ -0,0 +1,80 @@
+/*!
+ * \file aging.go
+ * \brief Age-dependent fertility and speed.
+ *
+ * Juveniles cannot breed; old creatures breed less often and move on
+ * only some chronons.
+ */
+
+package main
+
+import (
+	"fmt"
+	"math"
+)
+
+/*!
+ * \brief Fertility and speed over a creature's life.
+ */
+type ageCurve struct {
+	Maturity  int     ///< Age from which creatures can breed (0 = from birth)
+	Old       int     ///< Age from which creatures are old (0 = never)
+	Fertility float64 ///< Breeding rate of old creatures relative to prime adults, in (0, 1]
+	Speed     float64 ///< Share of chronons on which old creatures move, in (0, 1]
+}
+
+/*!
+ * \brief Check an age curve.
+ * \param a The curve.
+ * \return An error naming the first setting out of range. Fertility and
+ *         speed are only checked if creatures get old.
+ */
+func (a ageCurve) check() error {
+	switch {
+	case a.Maturity < 0:
+		return fmt.Errorf("-maturity must not be negative, not %d", a.Maturity)
+	case a.Old < 0:
+		return fmt.Errorf("-old-age must not be negative, not %d", a.Old)
+	case a.Old > 0 && !(a.Fertility > 0 && a.Fertility <= 1):
+		return fmt.Errorf("-old-fertility must be in (0, 1], not %g", a.Fertility)
+	case a.Old > 0 && !(a.Speed > 0 && a.Speed <= 1):
+		return fmt.Errorf("-old-speed must be in (0, 1], not %g", a.Speed)
+	}
+	return nil
+}
+
+/*!
+ * \brief Check whether a creature is old.
+ * \param c The creature.
+ * \return True from the old age on.
+ */
+func (a *ageCurve) old(c *Creature) bool {
+	return a.Old > 0 && int(c.Age) >= a.Old
+}
+
+/*!
+ * \brief Check whether a creature is due to breed.
+ * \param c The creature, aged for the current chronon.
+ * \param breed Breed time of its species.
+ * \return True if it is mature and its breed time, stretched for old
+ *         creatures, has passed since its last litter.
+ */
+func (a *ageCurve) due(c *Creature, breed int) bool {
+	if a.old(c) && a.Fertility < 1 {
+		breed = int(math.Ceil(float64(breed) / a.Fertility))
+	}
+	return int(c.LastBreed) >= breed && int(c.Age) >= a.Maturity
+}
+
+/*!
+ * \brief Check whether a creature moves this chronon.
+ * \param c The creature, aged for the current chronon.
+ * \return True for young creatures; for old ones on the share of
+ *         chronons given by the speed, spread evenly over their ages.
+ */
+func (a *ageCurve) moves(c *Creature) bool {
+	if !a.old(c) || a.Speed >= 1 {
+		return true
+	}
+	return math.Floor(float64(c.Age)*a.Speed) > math.Floor(float64(c.Age-1)*a.Speed)
+}
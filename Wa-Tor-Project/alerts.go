@@ -0,0 +1,238 @@
+/*!
+ * \file alerts.go
+ * \brief Population alert rules with hysteresis.
+ *
+ * A rule such as "sharks<10 for 50" fires once when the condition has held
+ * for 50 consecutive chronons. It does not fire again until the population
+ * has recovered past the clear level (by default 10% beyond the
+ * threshold), so a population hovering around the threshold does not
+ * produce a stream of alerts.
+ */
+
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"regexp"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+/*!
+ * \brief Syntax of an alert rule: METRIC OP VALUE [for N] [clear VALUE].
+ */
+var alertRulePattern = regexp.MustCompile(`^(fish|sharks)\s*(<=|>=|<|>)\s*(\d+)(?:\s*for\s*(\d+))?(?:\s*clear\s*(\d+))?$`)
+
+/*!
+ * \brief One parsed alert rule and its state.
+ */
+type alertRule struct {
+	Text      string ///< Rule as written by the user
+	Metric    string ///< "fish" or "sharks"
+	Op        string ///< Comparison operator
+	Threshold int    ///< Value compared against
+	For       int    ///< Consecutive chronons the condition must hold
+	Clear     int    ///< Level the population must pass to re-arm the rule
+
+	streak int  ///< Consecutive chronons the condition has held
+	fired  bool ///< Whether the rule has fired and not yet cleared
+}
+
+/*!
+ * \brief Parse an alert rule.
+ * \param text Rule text, e.g. "sharks<10 for 50 clear 20".
+ * \return The rule, or an error describing the expected syntax.
+ */
+func parseAlertRule(text string) (*alertRule, error) {
+	m := alertRulePattern.FindStringSubmatch(strings.TrimSpace(text))
+	if m == nil {
+		return nil, fmt.Errorf("bad alert rule %q (want e.g. \"sharks<10 for 50\" or \"fish>2000 clear 1500\")", text)
+	}
+	r := &alertRule{Text: text, Metric: m[1], Op: m[2], For: 1}
+	r.Threshold, _ = strconv.Atoi(m[3])
+	if m[4] != "" {
+		r.For, _ = strconv.Atoi(m[4])
+	}
+
+	margin := r.Threshold / 10
+	if margin < 1 {
+		margin = 1
+	}
+	switch r.Op {
+	case "<", "<=":
+		r.Clear = r.Threshold + margin
+	default:
+		r.Clear = r.Threshold - margin
+	}
+	if m[5] != "" {
+		r.Clear, _ = strconv.Atoi(m[5])
+	}
+	return r, nil
+}
+
+/*!
+ * \brief Check whether the rule's condition holds.
+ * \param v Current value of the metric.
+ * \return True if the condition holds.
+ */
+func (r *alertRule) holds(v int) bool {
+	switch r.Op {
+	case "<":
+		return v < r.Threshold
+	case "<=":
+		return v <= r.Threshold
+	case ">":
+		return v > r.Threshold
+	}
+	return v >= r.Threshold
+}
+
+/*!
+ * \brief Check whether the population has recovered past the clear level.
+ * \param v Current value of the metric.
+ * \return True if the rule should re-arm.
+ */
+func (r *alertRule) cleared(v int) bool {
+	if r.Op == "<" || r.Op == "<=" {
+		return v >= r.Clear
+	}
+	return v <= r.Clear
+}
+
+/*!
+ * \brief Payload posted to the alert webhook.
+ */
+type alertNotice struct {
+	Rule    string `json:"rule"`
+	State   string `json:"state"`
+	Chronon int    `json:"chronon"`
+	Value   int    `json:"value"`
+}
+
+/*!
+ * \brief Observer evaluating alert rules on every frame.
+ */
+type alertObserver struct {
+	rules   []*alertRule   ///< Rules to evaluate
+	log     io.Writer      ///< Destination of alert log lines
+	webhook string         ///< URL notices are POSTed to ("" = none)
+	client  *http.Client   ///< Client used for the webhook
+	pending sync.WaitGroup ///< Webhook requests in flight
+}
+
+/*!
+ * \brief Create an alert observer.
+ * \param rules Rule texts.
+ * \param log Destination of alert log lines.
+ * \param webhook URL to POST notices to, or "".
+ * \return The observer, or an error if a rule does not parse.
+ */
+func newAlertObserver(rules []string, log io.Writer, webhook string) (*alertObserver, error) {
+	a := &alertObserver{log: log, webhook: webhook, client: &http.Client{Timeout: 5 * time.Second}}
+	for _, text := range rules {
+		r, err := parseAlertRule(text)
+		if err != nil {
+			return nil, err
+		}
+		a.rules = append(a.rules, r)
+	}
+	return a, nil
+}
+
+/*!
+ * \brief Evaluate every rule against a frame.
+ * \param f The frame to check.
+ * \return nil.
+ */
+func (a *alertObserver) Observe(f *Frame) error {
+	for _, r := range a.rules {
+		v := f.Fish
+		if r.Metric == "sharks" {
+			v = f.Sharks
+		}
+
+		if r.fired {
+			if r.cleared(v) {
+				r.fired = false
+				r.streak = 0
+				a.notify(r, "cleared", f.Chronon, v)
+			}
+			continue
+		}
+
+		if !r.holds(v) {
+			r.streak = 0
+			continue
+		}
+		r.streak++
+		if r.streak >= r.For {
+			r.fired = true
+			a.notify(r, "fired", f.Chronon, v)
+		}
+	}
+	return nil
+}
+
+/*!
+ * \brief Log a state change and POST it to the webhook.
+ * \param r The rule that changed state.
+ * \param state "fired" or "cleared".
+ * \param chronon Chronon of the change.
+ * \param value Current value of the metric.
+ */
+func (a *alertObserver) notify(r *alertRule, state string, chronon, value int) {
+	fmt.Fprintf(a.log, "ALERT %s: %s at chronon %d (%s=%d)\n", state, r.Text, chronon, r.Metric, value)
+	if a.webhook == "" {
+		return
+	}
+
+	body, _ := json.Marshal(alertNotice{Rule: r.Text, State: state, Chronon: chronon, Value: value})
+	a.pending.Add(1)
+	go func() {
+		defer a.pending.Done()
+		resp, err := a.client.Post(a.webhook, "application/json", bytes.NewReader(body))
+		if err != nil {
+			fmt.Fprintf(a.log, "alert webhook: %v\n", err)
+			return
+		}
+		resp.Body.Close()
+	}()
+}
+
+/*!
+ * \brief Wait for outstanding webhook requests.
+ * \return nil.
+ */
+func (a *alertObserver) Close() error {
+	a.pending.Wait()
+	return nil
+}
+
+/*!
+ * \brief flag.Value collecting every occurrence of a repeatable flag.
+ */
+type stringList []string
+
+/*!
+ * \brief Values joined for the flag's default text.
+ * \return The values separated by ", ".
+ */
+func (l *stringList) String() string {
+	return strings.Join(*l, ", ")
+}
+
+/*!
+ * \brief Append one occurrence.
+ * \param v Flag value.
+ * \return nil.
+ */
+func (l *stringList) Set(v string) error {
+	*l = append(*l, v)
+	return nil
+}
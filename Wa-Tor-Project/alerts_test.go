@@ -0,0 +1,104 @@
+/*!
+ * \file alerts_test.go
+ * \brief Alert rules fire once per crossing and re-arm past their clear level.
+ */
+
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"strings"
+	"sync"
+	"testing"
+)
+
+/*!
+ * \brief A population going back and forth across the threshold fires
+ *        exactly once per crossing, and jitter between the threshold and
+ *        the clear level neither fires nor clears the rule again.
+ */
+func TestAlertHysteresis(t *testing.T) {
+	tests := []struct {
+		rule   string
+		series []int
+		want   []string // State and chronon of every notice
+	}{
+		{"sharks<10", []int{20, 9, 10, 9, 10, 11, 9, 8, 12, 30},
+			[]string{"fired 1", "cleared 5", "fired 6", "cleared 8"}},
+		{"sharks<10 for 3", []int{20, 9, 9, 12, 9, 9, 9, 9, 10, 9, 11, 5, 5, 5},
+			[]string{"fired 6", "cleared 10", "fired 13"}},
+		{"sharks>100 clear 80", []int{50, 101, 90, 101, 85, 79, 120, 79},
+			[]string{"fired 1", "cleared 5", "fired 6", "cleared 7"}},
+	}
+	for _, tt := range tests {
+		var log strings.Builder
+		a, err := newAlertObserver([]string{tt.rule}, &log, "")
+		if err != nil {
+			t.Fatal(err)
+		}
+		for chronon, v := range tt.series {
+			a.Observe(&Frame{Chronon: chronon, Fish: 100, Sharks: v})
+		}
+		a.Close()
+
+		var got []string
+		for _, line := range strings.Split(strings.TrimSpace(log.String()), "\n") {
+			var state, chronon string
+			fmt.Sscanf(line, "ALERT %s", &state)
+			if _, after, ok := strings.Cut(line, " at chronon "); ok {
+				chronon, _, _ = strings.Cut(after, " ")
+			}
+			got = append(got, strings.TrimSuffix(state, ":")+" "+chronon)
+		}
+		if !reflect.DeepEqual(got, tt.want) {
+			t.Errorf("%s: notices %q, want %q", tt.rule, got, tt.want)
+		}
+	}
+}
+
+/*!
+ * \brief Every notice reaches the webhook once, with the rule and value.
+ */
+func TestAlertWebhook(t *testing.T) {
+	var mu sync.Mutex
+	var got []alertNotice
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		var n alertNotice
+		if err := json.NewDecoder(r.Body).Decode(&n); err != nil {
+			t.Error(err)
+		}
+		mu.Lock()
+		got = append(got, n)
+		mu.Unlock()
+	}))
+	defer server.Close()
+
+	a, err := newAlertObserver([]string{"fish<5"}, new(strings.Builder), server.URL)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for chronon, v := range []int{10, 4, 3, 6, 4} {
+		a.Observe(&Frame{Chronon: chronon, Fish: v})
+	}
+	a.Close()
+
+	mu.Lock()
+	defer mu.Unlock()
+	want := map[alertNotice]bool{
+		{Rule: "fish<5", State: "fired", Chronon: 1, Value: 4}:   true,
+		{Rule: "fish<5", State: "cleared", Chronon: 3, Value: 6}: true,
+		{Rule: "fish<5", State: "fired", Chronon: 4, Value: 4}:   true,
+	}
+	if len(got) != len(want) {
+		t.Fatalf("webhook got %d notices, want %d: %+v", len(got), len(want), got)
+	}
+	for _, n := range got {
+		if !want[n] {
+			t.Errorf("unexpected notice %+v", n)
+		}
+	}
+}
@@ -0,0 +1,66 @@
+/*!
+ * \file ambush.go
+ * \brief Sharks that sit and wait for their prey.
+ *
+ * A hungry shark may rest in its cell, striking only at fish that come
+ * next to it.
+ */
+
+package main
+
+import (
+	"fmt"
+	"math/rand"
+)
+
+/*!
+ * \brief When and how sharks rest in ambush.
+ */
+type ambushRule struct {
+	Below  int     ///< Energy below which a shark may rest (0 = never)
+	Chance float64 ///< Probability that a shark below the threshold rests for a chronon
+	Drain  float64 ///< Probability that a resting shark still loses a unit of energy
+}
+
+/*!
+ * \brief Check an ambush rule.
+ * \param a The rule.
+ * \return An error naming the first setting out of range.
+ */
+func (a ambushRule) check() error {
+	switch {
+	case a.Below < 0:
+		return fmt.Errorf("-ambush-below must not be negative, not %d", a.Below)
+	case !(a.Chance >= 0 && a.Chance <= 1):
+		return fmt.Errorf("-ambush-chance must be between 0 and 1, not %g", a.Chance)
+	case !(a.Drain >= 0 && a.Drain <= 1):
+		return fmt.Errorf("-ambush-drain must be between 0 and 1, not %g", a.Drain)
+	}
+	return nil
+}
+
+/*!
+ * \brief Decide whether a shark rests this chronon.
+ * \param c The shark, before paying for the chronon.
+ * \param rng Random source of the shark's cell.
+ * \return True if it lies in wait.
+ */
+func (a *ambushRule) rests(c *Creature, rng *rand.Rand) bool {
+	if int(c.Energy) >= a.Below || a.Chance == 0 {
+		return false
+	}
+	return a.Chance == 1 || rng.Float64() < a.Chance
+}
+
+/*!
+ * \brief Energy a shark burns this chronon.
+ * \param resting Whether it rests.
+ * \param rng Random source of the shark's cell.
+ * \return 1, or for a resting shark 1 with the drain probability and 0 otherwise.
+ */
+func (a *ambushRule) cost(resting bool, rng *rand.Rand) int32 {
+	if !resting || a.Drain == 1 || a.Drain > 0 && rng.Float64() < a.Drain {
+		return 1
+	}
+	return 0
+}
@@ -0,0 +1,218 @@
+/*!
+ * \file analyze.go
+ * \brief The analyze subcommand: summarise a statistics CSV after the fact.
+ *
+ * Reads the per-chronon CSV written by the -csv sink and prints the
+ * population ranges, extinctions and a Lotka-Volterra fit, optionally
+ * charting the populations as SVG. The update scheme recorded in the
+ * provenance is printed too, since the order in which creatures are
+ * updated shifts the populations by several percent.
+ */
+
+package main
+
+import (
+	"bytes"
+	"encoding/csv"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"strconv"
+)
+
+/*!
+ * \brief Population series read from a statistics CSV.
+ */
+type statsSeries struct {
+	Chronons   []float64 ///< Chronon of each row
+	Fish       []float64 ///< Fish population per row
+	Sharks     []float64 ///< Shark population per row
+	Efficiency []float64 ///< Rolling hunting efficiency per row, or nil if the column is missing
+}
+
+/*!
+ * \brief Read the population columns of a statistics CSV.
+ * \param r Source of the CSV.
+ * \return The series, or an error for a missing column or a bad number.
+ *
+ * Columns are found by their header name, so files from older versions
+ * with fewer columns are accepted. Comment lines starting with '#', such
+ * as the provenance written by run, are skipped.
+ */
+func readStatsCSV(r io.Reader) (*statsSeries, error) {
+	cr := csv.NewReader(r)
+	cr.FieldsPerRecord = -1
+	cr.Comment = '#'
+	header, err := cr.Read()
+	if err != nil {
+		return nil, fmt.Errorf("reading header: %v", err)
+	}
+	column := map[string]int{}
+	for i, name := range header {
+		column[name] = i
+	}
+	for _, name := range []string{"chronon", "fish", "sharks"} {
+		if _, ok := column[name]; !ok {
+			return nil, fmt.Errorf("no %q column", name)
+		}
+	}
+
+	s := &statsSeries{}
+	eff, hasEff := column["hunt_efficiency"]
+	for line := 2; ; line++ {
+		row, err := cr.Read()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return nil, err
+		}
+		field := func(i int) (float64, error) {
+			if i >= len(row) {
+				return 0, fmt.Errorf("line %d: too few fields", line)
+			}
+			v, err := strconv.ParseFloat(row[i], 64)
+			if err != nil {
+				return 0, fmt.Errorf("line %d: %v", line, err)
+			}
+			return v, nil
+		}
+		var values [3]float64
+		for i, name := range []string{"chronon", "fish", "sharks"} {
+			if values[i], err = field(column[name]); err != nil {
+				return nil, err
+			}
+		}
+		if hasEff {
+			v, err := field(eff)
+			if err != nil {
+				return nil, err
+			}
+			s.Efficiency = append(s.Efficiency, v)
+		}
+		s.Chronons = append(s.Chronons, values[0])
+		s.Fish = append(s.Fish, values[1])
+		s.Sharks = append(s.Sharks, values[2])
+	}
+	if len(s.Chronons) == 0 {
+		return nil, errors.New("no rows")
+	}
+	return s, nil
+}
+
+/*!
+ * \brief Read the update scheme from the provenance of a statistics CSV.
+ * \param data The file.
+ * \return The value of -scheme on its config line, or "" for a file without one.
+ */
+func statsScheme(data []byte) string {
+	for len(data) > 0 && data[0] == '#' {
+		line, rest, _ := bytes.Cut(data, []byte("\n"))
+		if config, ok := bytes.CutPrefix(line, []byte("# config: ")); ok {
+			var values map[string]string
+			if json.Unmarshal(config, &values) == nil {
+				return values["scheme"]
+			}
+		}
+		data = rest
+	}
+	return ""
+}
+
+/*!
+ * \brief Print the range, mean and extinction chronon of a population series.
+ * \param out Destination of the summary.
+ * \param name Species name.
+ * \param chronons Chronon of each value.
+ * \param series Population per chronon.
+ */
+func writeSeriesSummary(out io.Writer, name string, chronons, series []float64) {
+	lo, hi, sum := series[0], series[0], 0.0
+	extinct := -1.0
+	for i, v := range series {
+		lo, hi, sum = min(lo, v), max(hi, v), sum+v
+		if v == 0 && extinct < 0 {
+			extinct = chronons[i]
+		}
+	}
+	fmt.Fprintf(out, "  %-7s min %.0f, max %.0f, mean %.1f", name, lo, hi, sum/float64(len(series)))
+	if extinct >= 0 {
+		fmt.Fprintf(out, ", extinct at chronon %.0f", extinct)
+	}
+	fmt.Fprintln(out)
+}
+
+/*!
+ * \brief Entry point of the analyze subcommand.
+ * \param args Command-line arguments after "analyze".
+ * \return Process exit code.
+ */
+func analyzeCommand(args []string) int {
+	fs := newCommandFlags("analyze")
+	svg := fs.String("svg", "", "also chart the populations and the fitted model to this SVG `file`")
+	if err := fs.Parse(args); err != nil {
+		return flagExit(err)
+	}
+	if fs.NArg() != 1 {
+		fs.Usage()
+		return exitConfig
+	}
+	path := fs.Arg(0)
+
+	data, err := os.ReadFile(path)
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		return exitFailure
+	}
+	s, err := readStatsCSV(bytes.NewReader(data))
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "%s: %v\n", path, err)
+		return exitFailure
+	}
+
+	fmt.Printf("%s: %d chronons\n", path, len(s.Chronons))
+	if scheme := statsScheme(data); scheme != "" {
+		fmt.Printf("  update scheme %s\n", scheme)
+	}
+	writeSeriesSummary(os.Stdout, "fish", s.Chronons, s.Fish)
+	writeSeriesSummary(os.Stdout, "sharks", s.Chronons, s.Sharks)
+	if len(s.Efficiency) > 0 {
+		sum := 0.0
+		for _, v := range s.Efficiency {
+			sum += v
+		}
+		fmt.Printf("  mean hunting efficiency %.4f fish per shark-chronon\n", sum/float64(len(s.Efficiency)))
+	}
+
+	series := []chartSeries{
+		{Name: "fish", Colour: "#2a9d3a", X: s.Chronons, Y: s.Fish},
+		{Name: "sharks", Colour: "#d03030", X: s.Chronons, Y: s.Sharks},
+	}
+	if fit, err := fitLotkaVolterra(s.Fish, s.Sharks); err != nil {
+		fmt.Printf("Lotka-Volterra fit: %v\n", err)
+	} else {
+		writeLVSummary(os.Stdout, fit)
+		n := len(fit.Fish)
+		series = append(series,
+			chartSeries{Name: "fish (Lotka-Volterra)", Colour: "#2a9d3a", X: s.Chronons[:n], Y: fit.Fish, Dashed: true},
+			chartSeries{Name: "sharks (Lotka-Volterra)", Colour: "#d03030", X: s.Chronons[:n], Y: fit.Sharks, Dashed: true},
+		)
+	}
+
+	if *svg != "" {
+		chart, err := os.Create(*svg)
+		if err != nil {
+			fmt.Fprintln(os.Stderr, err)
+			return exitFailure
+		}
+		writeSVGChart(chart, "Population over time", "chronon", "population", series)
+		if err := chart.Close(); err != nil {
+			fmt.Fprintln(os.Stderr, err)
+			return exitFailure
+		}
+		fmt.Printf("Wrote %s\n", *svg)
+	}
+	return exitOK
+}
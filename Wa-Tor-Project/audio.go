@@ -0,0 +1,176 @@
+/*!
+ * \file audio.go
+ * \brief Sonification: the populations and the predation of a run as sound.
+ *
+ * Two tones follow the run: a sine for the fish and a triangle an octave
+ * lower for the sharks. The pitch of each rises two octaves from empty to
+ * a full grid, by the square root of the share of cells the species holds,
+ * so small populations still move it audibly. The volume of the shark
+ * tone follows the predation rate, the creatures eaten per shark in the
+ * chronon: loud while the sharks feast, quiet while they starve. Cycles
+ * of the populations are heard as the two tones chasing each other.
+ *
+ * The audio sink writes this as a WAV file, audioSamples samples per
+ * chronon, easy to play back in a presentation or alongside a GIF of the
+ * run. The live view of the serve subcommand plays the same tones through
+ * the browser. Frames replayed from a -frames log carry no events, so the
+ * shark tone stays at its quietest.
+ */
+
+package main
+
+import (
+	"bufio"
+	"encoding/binary"
+	"math"
+	"os"
+)
+
+/*!
+ * \brief Samples per second of the WAV file.
+ */
+const audioRate = 22050
+
+/*!
+ * \brief Samples per chronon, about a twentieth of a second.
+ */
+const audioSamples = 1102
+
+/*!
+ * \brief Lowest pitch of the fish tone, for no fish, in Hz; the sharks are an octave lower.
+ */
+const audioLow = 220.0
+
+/*!
+ * \brief Pitch of a tone.
+ * \param count Creatures of the species.
+ * \param cells Cells of the grid.
+ * \param low Pitch for no creatures, in Hz.
+ * \return The pitch, up to two octaves above low for a full grid.
+ */
+func audioPitch(count, cells int, low float64) float64 {
+	if cells <= 0 {
+		return low
+	}
+	return low * math.Pow(4, math.Sqrt(min(1, float64(count)/float64(cells))))
+}
+
+/*!
+ * \brief Volume of the shark tone.
+ * \param eaten Creatures eaten in the chronon.
+ * \param sharks Sharks after it.
+ * \return From 0.15 without predation to 1 when every shark ate.
+ */
+func audioPredation(eaten, sharks int) float64 {
+	return 0.15 + 0.85*min(1, float64(eaten)/float64(max(1, sharks)))
+}
+
+/*!
+ * \brief Writes the sonification of a run as a mono 16-bit WAV file.
+ */
+type audioSink struct {
+	file    *os.File      ///< Output file
+	w       *bufio.Writer ///< Buffer on top of file
+	samples int           ///< Samples written
+	started bool          ///< Whether a frame has set the tones yet
+	fish    float64       ///< Pitch of the fish tone at the end of the last chronon
+	sharks  float64       ///< Pitch of the shark tone at the end of the last chronon
+	volume  float64       ///< Volume of the shark tone at the end of the last chronon
+	phase   [2]float64    ///< Phase of the fish and shark tones, in cycles
+}
+
+/*!
+ * \brief Create an audio sink.
+ * \param path Output file path.
+ * \param params Parameters of the run (unused).
+ * \return The sink, or an error if the file cannot be created.
+ *
+ * The sizes in the header are filled in when the sink is closed.
+ */
+func openAudioSink(path string, params Config) (Observer, error) {
+	file, err := os.Create(path)
+	if err != nil {
+		return nil, err
+	}
+	s := &audioSink{file: file, w: bufio.NewWriter(file)}
+	if _, err := s.w.Write(wavHeader(0)); err != nil {
+		file.Close()
+		return nil, err
+	}
+	return s, nil
+}
+
+/*!
+ * \brief Header of a mono 16-bit PCM WAV file.
+ * \param samples Samples in the file.
+ * \return The 44-byte header.
+ */
+func wavHeader(samples int) []byte {
+	h := make([]byte, 44)
+	copy(h[0:], "RIFF")
+	binary.LittleEndian.PutUint32(h[4:], uint32(36+2*samples))
+	copy(h[8:], "WAVEfmt ")
+	binary.LittleEndian.PutUint32(h[16:], 16)
+	binary.LittleEndian.PutUint16(h[20:], 1) // PCM
+	binary.LittleEndian.PutUint16(h[22:], 1) // Mono
+	binary.LittleEndian.PutUint32(h[24:], audioRate)
+	binary.LittleEndian.PutUint32(h[28:], 2*audioRate)
+	binary.LittleEndian.PutUint16(h[32:], 2)
+	binary.LittleEndian.PutUint16(h[34:], 16)
+	copy(h[36:], "data")
+	binary.LittleEndian.PutUint32(h[40:], uint32(2*samples))
+	return h
+}
+
+/*!
+ * \brief Append the sound of a chronon.
+ * \param f The frame to record.
+ * \return Any write error.
+ *
+ * Pitches and volume glide from those of the previous chronon, so the
+ * tones change without clicks.
+ */
+func (s *audioSink) Observe(f *Frame) error {
+	eaten := 0
+	for ev := range f.Events() {
+		if ev.Kind == Eaten {
+			eaten++
+		}
+	}
+	cells := f.Size * f.Size
+	fish, sharks := audioPitch(f.Fish, cells, audioLow), audioPitch(f.Sharks, cells, audioLow/2)
+	volume := audioPredation(eaten, f.Sharks)
+	if !s.started {
+		s.fish, s.sharks, s.volume, s.started = fish, sharks, volume, true
+	}
+	var sample [2]byte
+	for i := 0; i < audioSamples; i++ {
+		t := float64(i) / audioSamples
+		s.phase[0] = math.Mod(s.phase[0]+(s.fish+(fish-s.fish)*t)/audioRate, 1)
+		s.phase[1] = math.Mod(s.phase[1]+(s.sharks+(sharks-s.sharks)*t)/audioRate, 1)
+		triangle := 4*math.Abs(s.phase[1]-0.5) - 1
+		v := 0.3*math.Sin(2*math.Pi*s.phase[0]) + 0.3*(s.volume+(volume-s.volume)*t)*triangle
+		binary.LittleEndian.PutUint16(sample[:], uint16(int16(v*math.MaxInt16)))
+		if _, err := s.w.Write(sample[:]); err != nil {
+			return err
+		}
+	}
+	s.samples += audioSamples
+	s.fish, s.sharks, s.volume = fish, sharks, volume
+	return nil
+}
+
+/*!
+ * \brief Write the sizes into the header and close the file.
+ * \return Any write or close error.
+ */
+func (s *audioSink) Close() error {
+	err := s.w.Flush()
+	if err == nil {
+		_, err = s.file.WriteAt(wavHeader(s.samples), 0)
+	}
+	if cerr := s.file.Close(); err == nil {
+		err = cerr
+	}
+	return err
+}
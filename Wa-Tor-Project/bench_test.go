@@ -0,0 +1,172 @@
+/*!
+ * \file bench_test.go
+ * \brief Benchmarks of the core simulation operations.
+ *
+ * Run with go test -run '^$' -bench . -benchmem *.go; the table in the
+ * README lists the baseline and the machine it was measured on. With
+ * fewer than four cores, BenchmarkStep1000x1000Workers4 measures the cost
+ * of dealing out the tiles rather than any speed-up.
+ */
+
+package main
+
+import (
+	"io"
+	"math/rand"
+	"testing"
+)
+
+func BenchmarkStep10x10(b *testing.B)         { benchStep(b, defaultConfig(), 10, 0.12, 0.04) }
+func BenchmarkStep100x100(b *testing.B)       { benchStep(b, defaultConfig(), 100, 0.12, 0.04) }
+func BenchmarkStep1000x1000(b *testing.B)     { benchStep(b, defaultConfig(), 1000, 0.12, 0.04) }
+func BenchmarkStep100x100Dense(b *testing.B)  { benchStep(b, defaultConfig(), 100, 0.50, 0.10) }
+func BenchmarkStep100x100Sparse(b *testing.B) { benchStep(b, defaultConfig(), 100, 0.05, 0.01) }
+func BenchmarkStep1000x1000Patch(b *testing.B) {
+	benchStep(b, benchPatch(defaultConfig(), 1000, 100), 1000, 0, 0)
+}
+
+func BenchmarkStep1000x1000Workers4(b *testing.B) {
+	params := defaultConfig()
+	params.Workers = 4
+	benchStep(b, params, 1000, 0.12, 0.04)
+}
+
+/*!
+ * \brief Parameters for a world whose creatures start in one corner.
+ * \param params Base parameters.
+ * \param size Grid size.
+ * \param patch Width of the populated corner, at 12% fish / 4% sharks.
+ * \return The adjusted Config, with the creatures given as a layout.
+ *
+ * Late in a run one species often survives in patches while the rest of
+ * the grid is empty water; this is the extreme case.
+ */
+func benchPatch(params Config, size, patch int) Config {
+	params.Layout = make([]Species, size*size)
+	rng := rand.New(rand.NewSource(1))
+	for y := 0; y < patch; y++ {
+		for x := 0; x < patch; x++ {
+			switch r := rng.Float64(); {
+			case r < 0.12:
+				params.Layout[y*size+x] = Fish
+			case r < 0.16:
+				params.Layout[y*size+x] = Shark
+			}
+		}
+	}
+	return params
+}
+
+/*!
+ * \brief Benchmark processChronon on a world of the given size and density.
+ * \param b The benchmark.
+ * \param params Base parameters.
+ * \param size Grid size.
+ * \param fishDensity Fraction of cells initially holding a fish.
+ * \param sharkDensity Fraction of cells initially holding a shark.
+ *
+ * The world is re-populated every 100 chronons (outside the timer) so the
+ * measured density stays close to the requested one.
+ */
+func benchStep(b *testing.B, params Config, size int, fishDensity, sharkDensity float64) {
+	cfg := benchConfig(params, size, fishDensity, sharkDensity)
+	sim := newSimulation(cfg, 1)
+	b.ReportAllocs()
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		if i > 0 && i%100 == 0 {
+			b.StopTimer()
+			sim = newSimulation(cfg, int64(i))
+			b.StartTimer()
+		}
+		sim.Step()
+	}
+}
+
+/*!
+ * \brief Result of the scan benchmarks, kept so the compiler cannot drop the scans.
+ */
+var benchSink int
+
+/*!
+ * \brief Benchmark summing the ages over a dense grid of creature values.
+ *
+ * Together with BenchmarkScanPointers1000x1000 this measures what storing
+ * creatures in the grid gains: the scan reads one contiguous block.
+ */
+func BenchmarkScanValues1000x1000(b *testing.B) {
+	world := newSimulation(benchConfig(defaultConfig(), 1000, 0.5, 0.1), 1).World
+	b.ReportAllocs()
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		var age int32
+		for _, column := range world.Grid {
+			for y := range column {
+				if column[y].Species != Empty {
+					age += column[y].Age
+				}
+			}
+		}
+		benchSink += int(age)
+	}
+}
+
+/*!
+ * \brief Benchmark summing the ages over a dense grid of creature pointers.
+ *
+ * The layout the grid had before creatures became values: a pointer per
+ * cell and every creature allocated on its own. The creatures are
+ * allocated in random order, as births and deaths scatter them over the
+ * heap in a long run.
+ */
+func BenchmarkScanPointers1000x1000(b *testing.B) {
+	const size = 1000
+	world := newSimulation(benchConfig(defaultConfig(), size, 0.5, 0.1), 1).World
+	grid := make([][]*Creature, size)
+	for x := range grid {
+		grid[x] = make([]*Creature, size)
+	}
+	for _, i := range rand.New(rand.NewSource(1)).Perm(size * size) {
+		if c := world.Grid[i/size][i%size]; c.Species != Empty {
+			grid[i/size][i%size] = &c
+		}
+	}
+	b.ReportAllocs()
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		var age int32
+		for _, column := range grid {
+			for _, c := range column {
+				if c != nil {
+					age += c.Age
+				}
+			}
+		}
+		benchSink += int(age)
+	}
+}
+
+/*!
+ * \brief Benchmark counting the population of a world, as every chronon of a run does.
+ */
+func BenchmarkCount1000x1000(b *testing.B) {
+	world := newSimulation(benchConfig(defaultConfig(), 1000, 0.5, 0.1), 1).World
+	b.ReportAllocs()
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		fish, sharks := countPopulation(world)
+		benchSink += fish + sharks
+	}
+}
+
+/*!
+ * \brief Benchmark printing the grid of a 100x100 world.
+ */
+func BenchmarkRender100x100(b *testing.B) {
+	frame := newFrame(newSimulation(benchConfig(defaultConfig(), 100, 0.12, 0.04), 1).World, 0)
+	b.ReportAllocs()
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		printFrame(io.Discard, frame)
+	}
+}
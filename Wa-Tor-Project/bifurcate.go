@@ -0,0 +1,210 @@
+/*!
+ * \file bifurcate.go
+ * \brief Bifurcation scan over a single parameter.
+ *
+ * For each value of the scanned parameter the simulation is run past its
+ * transient and the long-run minimum and maximum of both populations are
+ * recorded. Plotting them against the parameter shows where the
+ * populations settle, start to oscillate or die out.
+ */
+
+package main
+
+import (
+	"encoding/csv"
+	"fmt"
+	"io"
+	"os"
+	"strconv"
+)
+
+/*!
+ * \brief Long-run population range of one run.
+ */
+type longRun struct {
+	Value                     int     ///< Value of the scanned parameter
+	Seed                      int64   ///< Seed of the run
+	FishMin, FishMax          int     ///< Fish population range after the transient
+	SharkMin, SharkMax        int     ///< Shark population range after the transient
+	FishMean, SharkMean       float64 ///< Mean populations after the transient
+	FishExtinct, SharkExtinct bool    ///< Whether each species died out during the run
+}
+
+/*!
+ * \brief Summarise a trajectory after its transient.
+ * \param t The trajectory.
+ * \param transient Chronons to discard.
+ * \return The long-run range (Value is left for the caller).
+ */
+func summariseLongRun(t trajectory, transient int) longRun {
+	r := longRun{Seed: t.Seed, FishExtinct: t.FishExtinct >= 0, SharkExtinct: t.SharkExtinct >= 0}
+	tail := len(t.Fish) - transient
+	if tail <= 0 {
+		return r
+	}
+	r.FishMin, r.SharkMin = t.Fish[transient], t.Sharks[transient]
+	for c := transient; c < len(t.Fish); c++ {
+		r.FishMin, r.FishMax = min(r.FishMin, t.Fish[c]), max(r.FishMax, t.Fish[c])
+		r.SharkMin, r.SharkMax = min(r.SharkMin, t.Sharks[c]), max(r.SharkMax, t.Sharks[c])
+		r.FishMean += float64(t.Fish[c])
+		r.SharkMean += float64(t.Sharks[c])
+	}
+	r.FishMean /= float64(tail)
+	r.SharkMean /= float64(tail)
+	return r
+}
+
+/*!
+ * \brief Entry point of the bifurcate subcommand.
+ * \param args Command-line arguments after "bifurcate".
+ * \return Process exit code.
+ */
+func bifurcateCommand(args []string) int {
+	fs := newCommandFlags("bifurcate")
+	params := defaultConfig()
+	cfg := registerConfigFlags(fs, &params)
+	name := fs.String("param", "sharkbreed", "parameter to scan: fish, sharks, fishbreed, sharkbreed or starve")
+	from := fs.Int("from", 2, "first value of the parameter")
+	to := fs.Int("to", 30, "last value of the parameter")
+	step := fs.Int("step", 1, "increment between values")
+	runs := fs.Int("runs", 1, "seeds per value")
+	horizon := chrononsFlag(fs, "horizon", 2000, "chronons per run")
+	transient := fs.Int("transient", -1, "chronons discarded before measuring (-1 = half the horizon)")
+	out := fs.String("out", "bifurcation", "output `prefix` of the CSV and SVG chart")
+	if err := fs.Parse(args); err != nil {
+		return flagExit(err)
+	}
+
+	seed, err := cfg.resolve()
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		return exitConfig
+	}
+	if *cfg.dryRun {
+		writeDryRun(os.Stdout, cfg, seed)
+		return exitOK
+	}
+	p, err := findParam(*name)
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		return exitConfig
+	}
+	if *step <= 0 || *to < *from || *runs <= 0 {
+		fmt.Fprintln(os.Stderr, "bifurcate: need -step > 0, -to >= -from and -runs > 0")
+		return exitConfig
+	}
+	if *transient < 0 {
+		*transient = *horizon / 2
+	}
+
+	if err := bifurcate(os.Stdout, params, p, *from, *to, *step, *runs, *horizon, *transient, seed, *out); err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		return exitFailure
+	}
+	return exitOK
+}
+
+/*!
+ * \brief Run a bifurcation scan and write its outputs.
+ * \param out Destination of the progress summary.
+ * \param params Baseline parameters.
+ * \param p The scanned parameter.
+ * \param from First value.
+ * \param to Last value.
+ * \param step Increment between values.
+ * \param runs Seeds per value; run i uses seed+i for every value.
+ * \param horizon Chronons per run.
+ * \param transient Chronons discarded before measuring.
+ * \param seed Seed of the first run.
+ * \param prefix Output path prefix for PREFIX.csv and PREFIX.svg.
+ * \return Any file error, or an error naming the first value out of range
+ *         or whose creatures do not fit in the water.
+ */
+func bifurcate(out io.Writer, params Config, p paramSpec, from, to, step, runs, horizon, transient int, seed int64, prefix string) error {
+	values := []int{}
+	for v := from; v <= to; v += step {
+		c := params
+		*p.Field(&c) = v
+		err := checkParams(c)
+		if err == nil {
+			err = checkFit(c)
+		}
+		if err != nil {
+			return fmt.Errorf("-%s %d: %v", p.Name, v, err)
+		}
+		values = append(values, v)
+	}
+	fmt.Fprintf(out, "Scanning %s from %d to %d (%d values x %d runs x %d chronons, transient %d)\n",
+		p.Name, from, to, len(values), runs, horizon, transient)
+
+	// Every (value, seed) pair is an independent job
+	params.Workers = 1
+	results := make([]longRun, len(values)*runs)
+	parallelFor(len(results), func(i int) {
+		c := params
+		v := values[i/runs]
+		*p.Field(&c) = v
+		s := seed + int64(i%runs)
+		results[i] = summariseLongRun(runTrajectory(c, s, horizon), transient)
+		results[i].Value = v
+	})
+
+	file, err := os.Create(prefix + ".csv")
+	if err != nil {
+		return err
+	}
+	w := csv.NewWriter(file)
+	w.Write([]string{p.Name, "seed", "fish_min", "fish_max", "fish_mean", "sharks_min", "sharks_max", "sharks_mean", "fish_extinct", "sharks_extinct"})
+	for _, r := range results {
+		w.Write([]string{
+			strconv.Itoa(r.Value),
+			strconv.FormatInt(r.Seed, 10),
+			strconv.Itoa(r.FishMin),
+			strconv.Itoa(r.FishMax),
+			strconv.FormatFloat(r.FishMean, 'f', 2, 64),
+			strconv.Itoa(r.SharkMin),
+			strconv.Itoa(r.SharkMax),
+			strconv.FormatFloat(r.SharkMean, 'f', 2, 64),
+			strconv.FormatBool(r.FishExtinct),
+			strconv.FormatBool(r.SharkExtinct),
+		})
+	}
+	w.Flush()
+	if err := w.Error(); err != nil {
+		file.Close()
+		return err
+	}
+	if err := file.Close(); err != nil {
+		return err
+	}
+
+	// Chart of the range averaged over the runs of each value
+	xs := make([]float64, len(values))
+	fishMin, fishMax := make([]float64, len(values)), make([]float64, len(values))
+	sharkMin, sharkMax := make([]float64, len(values)), make([]float64, len(values))
+	for i, v := range values {
+		xs[i] = float64(v)
+		for _, r := range results[i*runs : (i+1)*runs] {
+			fishMin[i] += float64(r.FishMin) / float64(runs)
+			fishMax[i] += float64(r.FishMax) / float64(runs)
+			sharkMin[i] += float64(r.SharkMin) / float64(runs)
+			sharkMax[i] += float64(r.SharkMax) / float64(runs)
+		}
+	}
+	chart, err := os.Create(prefix + ".svg")
+	if err != nil {
+		return err
+	}
+	writeSVGChart(chart, fmt.Sprintf("Long-run population range vs %s", p.Name), p.Name, "population", []chartSeries{
+		{Name: "fish max", Colour: "#2a9d3a", X: xs, Y: fishMax},
+		{Name: "fish min", Colour: "#2a9d3a", X: xs, Y: fishMin, Dashed: true},
+		{Name: "sharks max", Colour: "#d03030", X: xs, Y: sharkMax},
+		{Name: "sharks min", Colour: "#d03030", X: xs, Y: sharkMin, Dashed: true},
+	})
+	if err := chart.Close(); err != nil {
+		return err
+	}
+
+	fmt.Fprintf(out, "Wrote %s.csv and %s.svg\n", prefix, prefix)
+	return nil
+}
@@ -0,0 +1,281 @@
+/*!
+ * \file binary.go
+ * \brief Compact binary encoding of snapshots.
+ *
+ * JSON snapshots of large worlds are huge, mostly because every creature
+ * repeats its field names and the terrain takes a byte per cell. The
+ * binary encoding of the state after the header line is:
+ *
+ * - a uvarint length and a JSON object with everything but the creatures
+ *   and the terrain (seed, parameters, hunting window, ...), so those small
+ *   parts keep the tolerant decoding of JSON;
+ * - the cells in column order (index x*grid+y). Each item starts with a
+ *   byte whose low nibble is the cell kind (0 water, 1 fish, 2 shark,
+ *   3 land, 4 to 11 the registered species of a food web). Water and land
+ *   are run-length encoded: the byte is followed by a uvarint run length.
+ *   A creature has the number of fields that follow in the high nibble,
+ *   then the fields as zig-zag varints: ID, parent, age, energy, last
+ *   breed, offspring, kills, hatch, female (1), strategy (1 flee,
+ *   2 pursue), generation.
+ *
+ * Like unknown JSON fields, creature fields beyond the ones this program
+ * knows are skipped, and missing trailing fields are zero.
+ */
+
+package main
+
+import (
+	"bufio"
+	"encoding/binary"
+	"encoding/json"
+	"fmt"
+	"io"
+	"strconv"
+)
+
+/*!
+ * \brief Kinds of cell in the binary encoding.
+ */
+const (
+	binaryWater = 0 ///< Run of empty water cells
+	binaryFish  = 1 ///< One fish
+	binaryShark = 2 ///< One shark
+	binaryLand  = 3 ///< Run of land cells
+	binaryWeb   = 4 ///< One creature of the first registered species; the others follow
+)
+
+/*!
+ * \brief Largest accepted length of the JSON part, against corrupt input.
+ */
+const maxBinaryMeta = 64 << 20
+
+/*!
+ * \brief Buffered writer of varints that keeps the first error.
+ */
+type varintWriter struct {
+	w   *bufio.Writer               ///< Destination
+	buf [binary.MaxVarintLen64]byte ///< Scratch space of one varint
+	err error                       ///< First write error
+}
+
+/*!
+ * \brief Write an unsigned varint.
+ * \param v The value.
+ */
+func (vw *varintWriter) uvarint(v uint64) {
+	if vw.err == nil {
+		_, vw.err = vw.w.Write(vw.buf[:binary.PutUvarint(vw.buf[:], v)])
+	}
+}
+
+/*!
+ * \brief Write a zig-zag signed varint.
+ * \param v The value.
+ */
+func (vw *varintWriter) varint(v int64) {
+	if vw.err == nil {
+		_, vw.err = vw.w.Write(vw.buf[:binary.PutVarint(vw.buf[:], v)])
+	}
+}
+
+/*!
+ * \brief Write raw bytes.
+ * \param b The bytes.
+ */
+func (vw *varintWriter) bytes(b ...byte) {
+	if vw.err == nil {
+		_, vw.err = vw.w.Write(b)
+	}
+}
+
+/*!
+ * \brief Write the state of a snapshot in the binary encoding.
+ * \param w Destination.
+ * \param r The state.
+ * \return Error if writing fails or a creature has an unknown species.
+ */
+func writeBinarySnapshot(w io.Writer, r *snapshotRecord) error {
+	meta := *r
+	meta.Creatures = nil
+	meta.Params.Land = ""
+	js, err := json.Marshal(meta)
+	if err != nil {
+		return err
+	}
+	vw := &varintWriter{w: bufio.NewWriter(w)}
+	vw.uvarint(uint64(len(js)))
+	vw.bytes(js...)
+
+	kinds, err := binaryKinds(r.Params.Species)
+	if err != nil {
+		return err
+	}
+	size := r.Params.Grid
+	at := make([]int, size*size) // Index+1 of the creature in each cell, 0 if none
+	for i, c := range r.Creatures {
+		if _, ok := kinds[c.Species]; !ok {
+			return fmt.Errorf("creature %d has unknown species %q", c.ID, c.Species)
+		}
+		at[c.X*size+c.Y] = i + 1
+	}
+	kindAt := func(i int) byte {
+		if at[i] > 0 {
+			return kinds[r.Creatures[at[i]-1].Species]
+		}
+		if x, y := i/size, i%size; r.Params.Land != "" && r.Params.Land[y*size+x] == '#' {
+			return binaryLand
+		}
+		return binaryWater
+	}
+
+	for i := 0; i < len(at); {
+		kind := kindAt(i)
+		if kind == binaryWater || kind == binaryLand {
+			run := 1
+			for i+run < len(at) && kindAt(i+run) == kind {
+				run++
+			}
+			vw.bytes(kind)
+			vw.uvarint(uint64(run))
+			i += run
+			continue
+		}
+
+		c := r.Creatures[at[i]-1]
+		female := 0
+		if c.Female {
+			female = 1
+		}
+		strategy, _ := parseStrategy(c.Strategy)
+		fields := []int{c.ID, c.ParentID, c.Age, c.Energy, c.LastBreed, c.Offspring, c.Kills, c.Hatch, female, int(strategy), c.Gen}
+		for len(fields) > 7 && fields[len(fields)-1] == 0 {
+			fields = fields[:len(fields)-1] // Trailing zeros of the newer fields are left out
+		}
+		vw.bytes(kind | byte(len(fields))<<4)
+		for _, f := range fields {
+			vw.varint(int64(f))
+		}
+		i++
+	}
+	if vw.err != nil {
+		return vw.err
+	}
+	return vw.w.Flush()
+}
+
+/*!
+ * \brief Read the state of a snapshot in the binary encoding.
+ * \param br Source, positioned after the header line.
+ * \return The state, or an error if the data is truncated or malformed.
+ */
+func readBinarySnapshot(br *bufio.Reader) (*snapshotRecord, error) {
+	n, err := binary.ReadUvarint(br)
+	if err != nil || n > maxBinaryMeta {
+		return nil, fmt.Errorf("malformed binary snapshot")
+	}
+	js := make([]byte, n)
+	if _, err := io.ReadFull(br, js); err != nil {
+		return nil, err
+	}
+	r := &snapshotRecord{}
+	if err := json.Unmarshal(js, r); err != nil {
+		return nil, err
+	}
+
+	size := r.Params.Grid
+	if size < 1 || size > maxGridSize {
+		return nil, fmt.Errorf("binary snapshot has invalid grid size %d", size)
+	}
+	kinds, err := binaryKinds(r.Params.Species)
+	if err != nil {
+		return nil, err
+	}
+	names := make([]string, binaryWeb+maxWebSpecies)
+	for name, kind := range kinds {
+		names[kind] = name
+	}
+	cells := size * size
+	var land []byte
+	for i := 0; i < cells; {
+		b, err := br.ReadByte()
+		if err != nil {
+			return nil, fmt.Errorf("binary snapshot ends after %d of %d cells", i, cells)
+		}
+		switch kind := b & 15; kind {
+		case binaryWater, binaryLand:
+			run, err := binary.ReadUvarint(br)
+			if err != nil || run == 0 || run > uint64(cells-i) {
+				return nil, fmt.Errorf("malformed run at cell %d", i)
+			}
+			if kind == binaryLand {
+				if land == nil {
+					land = make([]byte, cells)
+					for j := range land {
+						land[j] = '.'
+					}
+				}
+				for j := i; j < i+int(run); j++ {
+					land[(j%size)*size+j/size] = '#'
+				}
+			}
+			i += int(run)
+		default:
+			if int(kind) >= len(names) || names[kind] == "" {
+				return nil, fmt.Errorf("unknown cell kind %d at cell %d", kind, i)
+			}
+			var fields [11]int
+			for f := 0; f < int(b>>4); f++ {
+				v, err := binary.ReadVarint(br)
+				if err != nil {
+					return nil, fmt.Errorf("malformed creature at cell %d", i)
+				}
+				if f < len(fields) {
+					fields[f] = int(v)
+				}
+			}
+			r.Creatures = append(r.Creatures, creatureRecord{
+				X: i / size, Y: i % size, ID: fields[0], ParentID: fields[1], Species: names[kind],
+				Age: fields[2], Energy: fields[3], LastBreed: fields[4], Offspring: fields[5], Kills: fields[6], Hatch: fields[7],
+				Female: fields[8] != 0, Strategy: binaryStrategy(fields[9]), Gen: fields[10],
+			})
+			i++
+		}
+	}
+	if land != nil {
+		r.Params.Land = string(land)
+	}
+	return r, nil
+}
+
+/*!
+ * \brief Cell kinds of the creatures of a snapshot.
+ * \param species Registered species of the snapshot, as for -species.
+ * \return The kind of each species name, or an error for malformed species.
+ */
+func binaryKinds(species string) (map[string]byte, error) {
+	registered, err := parseWebSpecies(species)
+	if err != nil {
+		return nil, err
+	}
+	kinds := map[string]byte{"fish": binaryFish, "shark": binaryShark}
+	for i, r := range registered {
+		kinds[r.Name] = binaryWeb + byte(i)
+	}
+	return kinds, nil
+}
+
+/*!
+ * \brief Name of a strategy in the binary encoding.
+ * \param v The strategy field of a creature.
+ * \return "" for random, the strategy's name, or the number itself for an
+ *         unknown strategy, which the snapshot decoder rejects.
+ */
+func binaryStrategy(v int) string {
+	if v == int(Random) {
+		return ""
+	}
+	if v > 0 && v < int(strategyCount) {
+		return Strategy(v).String()
+	}
+	return strconv.Itoa(v)
+}
@@ -0,0 +1,137 @@
+/*!
+ * \file breeding.go
+ * \brief What a litter costs its parent, and what the newborn gets.
+ *
+ * Also whether and where a shark that eats leaves its newborn (see
+ * LitterPlace).
+ */
+
+package main
+
+import (
+	"fmt"
+	"math"
+)
+
+/*!
+ * \brief Whether and where a shark that eats leaves a newborn.
+ */
+type LitterPlace uint8
+
+const (
+	LitterLeft LitterPlace = iota ///< In the cell the shark leaves for the prey, as when it swims
+	LitterPrey                    ///< In the cell of the prey, the shark staying where it is if it breeds
+	LitterSwim                    ///< Nowhere: eating takes the turn, and the shark breeds at its next move to an empty cell
+)
+
+/*!
+ * \brief Parse the name of a litter placement.
+ * \param name "left", "prey" or "swim".
+ * \return The placement, or an error if the name is unknown.
+ */
+func parseLitterPlace(name string) (LitterPlace, error) {
+	switch name {
+	case "left":
+		return LitterLeft, nil
+	case "prey":
+		return LitterPrey, nil
+	case "swim":
+		return LitterSwim, nil
+	}
+	return LitterLeft, fmt.Errorf("unknown shark litter placement %q (want left, prey or swim)", name)
+}
+
+/*!
+ * \brief Name of a litter placement.
+ * \param p The placement.
+ * \return "left", "prey" or "swim".
+ */
+func litterPlaceName(p LitterPlace) string {
+	switch p {
+	case LitterPrey:
+		return "prey"
+	case LitterSwim:
+		return "swim"
+	}
+	return "left"
+}
+
+/*!
+ * \brief Cost of breeding, and the energy and place of the newborns.
+ */
+type breedingCost struct {
+	SharkEnergy  int         ///< Energy a shark pays for a litter (0 = free)
+	FishCooldown float64     ///< Multiplier of the breed time of fish that have bred, at least 1
+	SharkBirth   int         ///< Energy of a newborn shark (0 = a full stomach)
+	FishBirth    int         ///< Energy of a newborn fish with an energy budget (0 = the full budget)
+	SharkLitter  LitterPlace ///< Where a shark that eats leaves its newborn
+}
+
+/*!
+ * \brief Check the cost of breeding.
+ * \param b The cost.
+ * \param starve Shark starvation time, the most energy a shark can have.
+ * \param budget Energy budget of the fish (0 = none).
+ * \return An error naming the first setting out of range.
+ */
+func (b breedingCost) check(starve, budget int) error {
+	switch {
+	case b.SharkEnergy < 0 || b.SharkEnergy > 0 && b.SharkEnergy >= starve:
+		return fmt.Errorf("-shark-birth-cost must be between 0 and -starve - 1 (%d), not %d", starve-1, b.SharkEnergy)
+	case !(b.FishCooldown >= 1):
+		return fmt.Errorf("-fish-cooldown must be at least 1, not %g", b.FishCooldown)
+	case b.SharkBirth < 0 || b.SharkBirth > starve:
+		return fmt.Errorf("-shark-birth-energy must be between 0 and -starve (%d), not %d", starve, b.SharkBirth)
+	case b.FishBirth != 0 && budget == 0:
+		return fmt.Errorf("-fish-birth-energy needs a -fish-energy budget")
+	case b.FishBirth < 0 || b.FishBirth > budget:
+		return fmt.Errorf("-fish-birth-energy must be between 0 and -fish-energy (%d), not %d", budget, b.FishBirth)
+	}
+	return nil
+}
+
+/*!
+ * \brief Breed time of a fish.
+ * \param c The fish.
+ * \param breed Breed time of fish.
+ * \return The breed time, stretched by the cooldown once the fish has bred.
+ */
+func (b *breedingCost) fishBreed(c *Creature, breed int) int {
+	if c.Offspring > 0 && b.FishCooldown > 1 {
+		return int(math.Ceil(float64(breed) * b.FishCooldown))
+	}
+	return breed
+}
+
+/*!
+ * \brief Check whether a shark can afford a litter.
+ * \param c The shark.
+ * \return True if it would have energy left after paying for one.
+ */
+func (b *breedingCost) affords(c *Creature) bool {
+	return int(c.Energy) > b.SharkEnergy
+}
+
+/*!
+ * \brief Energy of a newborn shark.
+ * \param starve Shark starvation time, a full stomach.
+ * \return The birth energy, at most a full stomach as the starvation time may have been reloaded.
+ */
+func (b *breedingCost) sharkNewborn(starve int) int32 {
+	if b.SharkBirth == 0 {
+		return int32(starve)
+	}
+	return int32(min(b.SharkBirth, starve))
+}
+
+/*!
+ * \brief Energy of a newborn fish.
+ * \param e Energy budget of the fish.
+ * \return The birth energy, or the full budget (0 without one).
+ */
+func (b *breedingCost) fishNewborn(e *fishEnergy) int32 {
+	if b.FishBirth == 0 {
+		return e.full()
+	}
+	return int32(b.FishBirth)
+}
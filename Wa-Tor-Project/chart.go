@@ -0,0 +1,132 @@
+/*!
+ * \file chart.go
+ * \brief Minimal SVG line charts for reports and exported plots.
+ */
+
+package main
+
+import (
+	"fmt"
+	"html"
+	"io"
+	"math"
+	"strings"
+)
+
+/*!
+ * \brief Maximum number of points drawn per series; longer series are thinned.
+ */
+const maxChartPoints = 2000
+
+/*!
+ * \brief One line of a chart.
+ */
+type chartSeries struct {
+	Name   string    ///< Legend label
+	Colour string    ///< CSS colour of the line
+	X, Y   []float64 ///< Points of the line
+	Band   []float64 ///< Optional half-width of a shaded band around Y
+	Dashed bool      ///< Draw the line dashed
+}
+
+/*!
+ * \brief Write an SVG line chart.
+ * \param w Destination of the SVG markup.
+ * \param title Chart title.
+ * \param xLabel X axis label.
+ * \param yLabel Y axis label.
+ * \param series Lines to draw.
+ */
+func writeSVGChart(w io.Writer, title, xLabel, yLabel string, series []chartSeries) {
+	const width, height = 720.0, 360.0
+	const left, right, top, bottom = 60.0, 20.0, 30.0, 45.0
+
+	// Data bounds over every point, including bands
+	minX, maxX, minY, maxY := math.Inf(1), math.Inf(-1), 0.0, math.Inf(-1)
+	for _, s := range series {
+		for i := range s.X {
+			minX = math.Min(minX, s.X[i])
+			maxX = math.Max(maxX, s.X[i])
+			hi, lo := s.Y[i], s.Y[i]
+			if s.Band != nil {
+				hi += s.Band[i]
+				lo -= s.Band[i]
+			}
+			maxY = math.Max(maxY, hi)
+			minY = math.Min(minY, lo)
+		}
+	}
+	if math.IsInf(minX, 1) {
+		minX, maxX, maxY = 0, 1, 1
+	}
+	if maxX == minX {
+		maxX = minX + 1
+	}
+	if maxY == minY {
+		maxY = minY + 1
+	}
+
+	px := func(x float64) float64 { return left + (x-minX)/(maxX-minX)*(width-left-right) }
+	py := func(y float64) float64 { return height - bottom - (y-minY)/(maxY-minY)*(height-top-bottom) }
+
+	fmt.Fprintf(w, `<svg xmlns="http://www.w3.org/2000/svg" viewBox="0 0 %g %g" width="%g" height="%g" font-family="sans-serif" font-size="12">`+"\n", width, height, width, height)
+	fmt.Fprintf(w, `<rect width="%g" height="%g" fill="white"/>`+"\n", width, height)
+	fmt.Fprintf(w, `<text x="%g" y="18" text-anchor="middle" font-size="14">%s</text>`+"\n", width/2, html.EscapeString(title))
+
+	// Axes with five ticks each
+	fmt.Fprintf(w, `<path d="M%g %g V%g H%g" stroke="black" fill="none"/>`+"\n", left, top, height-bottom, width-right)
+	for i := 0; i <= 4; i++ {
+		xv := minX + float64(i)/4*(maxX-minX)
+		yv := minY + float64(i)/4*(maxY-minY)
+		fmt.Fprintf(w, `<text x="%g" y="%g" text-anchor="middle">%s</text>`+"\n", px(xv), height-bottom+16, formatTick(xv))
+		fmt.Fprintf(w, `<text x="%g" y="%g" text-anchor="end">%s</text>`+"\n", left-6, py(yv)+4, formatTick(yv))
+		fmt.Fprintf(w, `<path d="M%g %g H%g" stroke="#ddd"/>`+"\n", left+1, py(yv), width-right)
+	}
+	fmt.Fprintf(w, `<text x="%g" y="%g" text-anchor="middle">%s</text>`+"\n", (left+width-right)/2, height-8, html.EscapeString(xLabel))
+	fmt.Fprintf(w, `<text x="14" y="%g" text-anchor="middle" transform="rotate(-90 14 %g)">%s</text>`+"\n", (top+height-bottom)/2, (top+height-bottom)/2, html.EscapeString(yLabel))
+
+	for n, s := range series {
+		step := 1
+		if len(s.X) > maxChartPoints {
+			step = (len(s.X) + maxChartPoints - 1) / maxChartPoints
+		}
+
+		if s.Band != nil {
+			var upper, lower []string
+			for i := 0; i < len(s.X); i += step {
+				upper = append(upper, fmt.Sprintf("%.1f,%.1f", px(s.X[i]), py(s.Y[i]+s.Band[i])))
+				lower = append([]string{fmt.Sprintf("%.1f,%.1f", px(s.X[i]), py(s.Y[i]-s.Band[i]))}, lower...)
+			}
+			fmt.Fprintf(w, `<polygon points="%s %s" fill="%s" fill-opacity="0.2" stroke="none"/>`+"\n",
+				strings.Join(upper, " "), strings.Join(lower, " "), s.Colour)
+		}
+
+		var points []string
+		for i := 0; i < len(s.X); i += step {
+			points = append(points, fmt.Sprintf("%.1f,%.1f", px(s.X[i]), py(s.Y[i])))
+		}
+		dash := ""
+		if s.Dashed {
+			dash = ` stroke-dasharray="6 4"`
+		}
+		fmt.Fprintf(w, `<polyline points="%s" fill="none" stroke="%s" stroke-width="1.5"%s/>`+"\n", strings.Join(points, " "), s.Colour, dash)
+
+		// Legend entry
+		ly := top + 4 + float64(n)*16
+		fmt.Fprintf(w, `<path d="M%g %g h18" stroke="%s" stroke-width="2"%s/>`+"\n", width-right-150, ly, s.Colour, dash)
+		fmt.Fprintf(w, `<text x="%g" y="%g">%s</text>`+"\n", width-right-126, ly+4, html.EscapeString(s.Name))
+	}
+	fmt.Fprintln(w, `</svg>`)
+}
+
+/*!
+ * \brief Format an axis tick value compactly.
+ * \param v The value.
+ * \return v without decimals when it is large, with two otherwise.
+ */
+func formatTick(v float64) string {
+	if math.Abs(v) >= 100 || v == math.Trunc(v) {
+		return fmt.Sprintf("%.0f", v)
+	}
+	return fmt.Sprintf("%.2f", v)
+}
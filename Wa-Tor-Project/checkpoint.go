@@ -0,0 +1,383 @@
+/*!
+ * \file checkpoint.go
+ * \brief Rolling checkpoints, so long runs survive crashes.
+ *
+ * With -checkpoint-every N, run writes the complete simulation state to
+ * a checkpoint file every N chronons, replacing the previous one. A run
+ * that ends normally removes the file; one stopped by -duration leaves it
+ * behind on purpose. If the file exists when run starts, the previous run
+ * was interrupted and can be resumed from the last checkpoint; run will
+ * not overwrite it unasked.
+ *
+ * math/rand cannot export the state of its source, so the checkpoint
+ * stores the seed and the number of values drawn so far. Restoring
+ * re-seeds the source and draws the same number of values, which puts
+ * it in exactly the state it had: a resumed run continues the way the
+ * interrupted one would have.
+ */
+
+package main
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"math/rand"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+/*!
+ * \brief Random source that counts the values drawn from it.
+ *
+ * It deliberately implements only rand.Source, not rand.Source64, so
+ * every method of rand.Rand draws through Int63 and is counted.
+ */
+type countingSource struct {
+	src   rand.Source ///< The underlying source
+	draws uint64      ///< Values drawn since seeding
+}
+
+/*!
+ * \brief Create a counting source.
+ * \param seed Seed of the underlying source.
+ * \return The source, with no values drawn.
+ */
+func newCountingSource(seed int64) *countingSource {
+	return &countingSource{src: rand.NewSource(seed)}
+}
+
+/*!
+ * \brief Draw a value.
+ * \return A non-negative pseudo-random 63-bit integer.
+ */
+func (s *countingSource) Int63() int64 {
+	s.draws++
+	return s.src.Int63()
+}
+
+/*!
+ * \brief Re-seed the source and reset the count.
+ * \param seed The new seed.
+ */
+func (s *countingSource) Seed(seed int64) {
+	s.src.Seed(seed)
+	s.draws = 0
+}
+
+/*!
+ * \brief A creature and its cell.
+ */
+type placedCreature struct {
+	X, Y int
+	Creature
+}
+
+/*!
+ * \brief Complete state of a run between two chronons.
+ *
+ * Stored on disk in the snapshot format of snapshot.go.
+ */
+type checkpoint struct {
+	Seed      int64            ///< Seed of the random source
+	Draws     uint64           ///< Values drawn from the random source so far
+	Chronon   int              ///< Chronons processed so far
+	Params    Config           ///< Current parameters, including reloaded changes
+	LastID    int64            ///< Most recently issued creature ID
+	Creatures []placedCreature ///< Every creature alive
+	Hunting   []huntSample     ///< Hunting window, oldest sample first
+	Pollution []float32        ///< Pollution level per cell, or nil
+	Whales    []whale          ///< Every whale alive
+	Outcome   runOutcome       ///< Extinction chronons seen so far
+}
+
+/*!
+ * \brief Capture the state of a simulation.
+ * \param sim The simulation, between two chronons.
+ * \param outcome Outcome of the run so far.
+ * \return The checkpoint.
+ */
+func takeCheckpoint(sim *Simulation, outcome runOutcome) *checkpoint {
+	cp := &checkpoint{
+		Seed:    sim.Seed,
+		Draws:   sim.source.draws,
+		Chronon: sim.Chronon,
+		Params:  sim.Params,
+		LastID:  sim.World.ids.last.Load(),
+		Hunting: sim.hunting.recent(),
+		Outcome: outcome,
+	}
+	if sim.World.pollution != nil {
+		cp.Pollution = append([]float32(nil), sim.World.pollution...)
+	}
+	for x, column := range sim.World.Grid {
+		for y, c := range column {
+			if c.Species != Empty && c.Species != Whale {
+				cp.Creatures = append(cp.Creatures, placedCreature{x, y, c})
+			}
+		}
+	}
+	cp.Whales = append([]whale(nil), sim.World.whales...)
+	return cp
+}
+
+/*!
+ * \brief Rebuild the simulation a checkpoint was taken from.
+ * \return The simulation, or an error if the checkpoint is inconsistent.
+ */
+func (cp *checkpoint) restore() (*Simulation, error) {
+	p := cp.Params
+	if err := checkParams(p); err != nil {
+		return nil, err
+	}
+	if p.Land != nil && len(p.Land) != p.GridSize*p.GridSize {
+		return nil, fmt.Errorf("terrain has %d cells, want %d", len(p.Land), p.GridSize*p.GridSize)
+	}
+	if p.Reef != nil && len(p.Reef) != p.GridSize*p.GridSize {
+		return nil, fmt.Errorf("reef map has %d cells, want %d", len(p.Reef), p.GridSize*p.GridSize)
+	}
+	if cp.Pollution != nil && len(cp.Pollution) != p.GridSize*p.GridSize {
+		return nil, fmt.Errorf("pollution field has %d cells, want %d", len(cp.Pollution), p.GridSize*p.GridSize)
+	}
+	for _, l := range cp.Pollution {
+		if !(l >= 0 && l <= 1) {
+			return nil, fmt.Errorf("pollution level %g outside [0, 1]", l)
+		}
+	}
+	if p.Layout != nil && len(p.Layout) != p.GridSize*p.GridSize {
+		return nil, fmt.Errorf("layout has %d cells, want %d", len(p.Layout), p.GridSize*p.GridSize)
+	}
+
+	world := createWorld(p.GridSize)
+	world.land = p.Land
+	world.reef = p.Reef
+	world.pollution = cp.Pollution
+	world.polluting = p.Pollution
+	world.climate = p.Climate
+	world.cycle = p.DayNight
+	world.tide = p.Tide
+	world.tidal = tidalZone(p)
+	world.elapsed = cp.Chronon
+	world.bounded = p.Bounded
+	world.aging = p.Aging
+	world.fishEnergy = p.FishEnergy
+	world.breeding = p.Breeding
+	world.ambush = p.Ambush
+	world.mortality = p.Mortality
+	world.crowding = p.Crowding
+	world.territory = p.SharkTerritory
+	world.starvation = p.Starvation
+	world.conflicts = p.Conflicts
+	world.eggs = p.Eggs
+	world.sexes = p.Sexes
+	world.strategies = p.Strategies
+	world.drift = moveTableOf(p.MoveWeights)
+	world.web = p.Web.table()
+	world.FishBreed, world.SharkBreed, world.Starve = p.FishBreed, p.SharkBreed, p.Starve
+	world.ids.last.Store(cp.LastID)
+	for _, pc := range cp.Creatures {
+		if pc.X < 0 || pc.X >= p.GridSize || pc.Y < 0 || pc.Y >= p.GridSize {
+			return nil, fmt.Errorf("creature %d at (%d,%d) is outside the grid", pc.ID, pc.X, pc.Y)
+		}
+		if pc.Species != Fish && pc.Species != Shark && p.Web.registered(pc.Species) == nil {
+			return nil, fmt.Errorf("creature %d at (%d,%d) is a %s", pc.ID, pc.X, pc.Y, pc.Species)
+		}
+		if world.occupied(pc.X, pc.Y) || world.isLand(pc.X, pc.Y) {
+			return nil, fmt.Errorf("creature %d at (%d,%d) is on an occupied or land cell", pc.ID, pc.X, pc.Y)
+		}
+		if world.barredFromReef(pc.Species) && world.isReef(pc.X, pc.Y) {
+			return nil, fmt.Errorf("%s %d at (%d,%d) is on a reef", p.Web.name(pc.Species), pc.ID, pc.X, pc.Y)
+		}
+		world.put(pc.X, pc.Y, &pc.Creature)
+	}
+	world.whaleSize = p.Whales.Size
+	for _, wh := range cp.Whales {
+		fits := wh.X >= 0 && wh.X < p.GridSize && wh.Y >= 0 && wh.Y < p.GridSize &&
+			world.whaleBody(wh.X, wh.Y, func(cx, cy int) bool { return !world.occupied(cx, cy) && !world.isLand(cx, cy) })
+		if !fits {
+			return nil, fmt.Errorf("whale %d at (%d,%d) is outside the grid or on an occupied or land cell", wh.ID, wh.X, wh.Y)
+		}
+		world.putWhale(wh)
+	}
+
+	source := newCountingSource(cp.Seed)
+	for source.draws < cp.Draws {
+		source.Int63()
+	}
+	hunting := newHuntWindow(p.Window)
+	for _, s := range cp.Hunting {
+		hunting.push(s)
+	}
+	sim := &Simulation{
+		World:   world,
+		Params:  p,
+		Chronon: cp.Chronon,
+		Seed:    cp.Seed,
+		rng:     rand.New(source),
+		source:  source,
+		hunting: hunting,
+	}
+	sim.fish, sim.sharks = countPopulation(world)
+	return sim, nil
+}
+
+/*!
+ * \brief Default checkpoint file.
+ * \return wator.checkpoint in the temporary directory.
+ */
+func defaultCheckpointPath() string {
+	return filepath.Join(os.TempDir(), "wator.checkpoint")
+}
+
+/*!
+ * \brief Write a checkpoint, replacing the file atomically.
+ * \param path Checkpoint file.
+ * \param cp The checkpoint.
+ * \param encoding Snapshot encoding, see writeSnapshot.
+ * \return Error if the file cannot be written.
+ *
+ * The checkpoint is written to a temporary file next to path and renamed
+ * over it, so a crash while writing leaves the previous checkpoint intact.
+ */
+func saveCheckpoint(path string, cp *checkpoint, encoding string) error {
+	tmp := path + ".tmp"
+	f, err := os.Create(tmp)
+	if err != nil {
+		return err
+	}
+	w := bufio.NewWriter(f)
+	err = writeSnapshot(w, cp, encoding)
+	if err == nil {
+		err = w.Flush()
+	}
+	if err == nil {
+		err = f.Sync()
+	}
+	if cerr := f.Close(); err == nil {
+		err = cerr
+	}
+	if err == nil {
+		err = os.Rename(tmp, path)
+	}
+	if err != nil {
+		os.Remove(tmp)
+		return fmt.Errorf("checkpoint %s: %w", path, err)
+	}
+	return nil
+}
+
+/*!
+ * \brief Read a checkpoint.
+ * \param path Checkpoint file.
+ * \return The checkpoint, or an error if it cannot be read.
+ */
+func loadCheckpoint(path string) (*checkpoint, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+	cp, err := readSnapshot(f)
+	if err != nil {
+		return nil, fmt.Errorf("checkpoint %s: %w", path, err)
+	}
+	return cp, nil
+}
+
+/*!
+ * \brief Writes the rolling checkpoints of a run.
+ */
+type checkpointer struct {
+	path     string    ///< Checkpoint file
+	every    int       ///< Chronons between checkpoints (0 = only when the time limit stops the run)
+	encoding string    ///< Snapshot encoding of the file
+	errOut   io.Writer ///< Destination of write errors
+}
+
+/*!
+ * \brief Write a checkpoint, reporting rather than returning failures.
+ * \param sim The simulation, between two chronons.
+ * \param outcome Outcome of the run so far.
+ *
+ * A failed checkpoint does not stop the run; the previous one is kept.
+ */
+func (c *checkpointer) save(sim *Simulation, outcome runOutcome) {
+	if err := saveCheckpoint(c.path, takeCheckpoint(sim, outcome), c.encoding); err != nil {
+		fmt.Fprintln(c.errOut, err)
+	}
+}
+
+/*!
+ * \brief Remove the checkpoint of a run that ended normally.
+ */
+func (c *checkpointer) remove() {
+	if err := os.Remove(c.path); err != nil && !os.IsNotExist(err) {
+		fmt.Fprintln(c.errOut, err)
+	}
+}
+
+/*!
+ * \brief Check whether standard input is an interactive terminal.
+ * \return True if a user can answer prompts.
+ */
+func stdinIsTerminal() bool {
+	info, err := os.Stdin.Stat()
+	if err != nil || info.Mode()&os.ModeCharDevice == 0 {
+		return false
+	}
+	// /dev/null is a character device too
+	null, err := os.Stat(os.DevNull)
+	return err != nil || !os.SameFile(info, null)
+}
+
+/*!
+ * \brief Ask a yes/no question on the terminal.
+ * \param out Destination of the question.
+ * \param in Source of the answer.
+ * \param question The question, without the [y/N] suffix.
+ * \return True if the answer starts with y or Y.
+ */
+func confirm(out io.Writer, in io.Reader, question string) bool {
+	fmt.Fprintf(out, "%s [y/N] ", question)
+	answer, _ := bufio.NewReader(in).ReadString('\n')
+	answer = strings.TrimSpace(answer)
+	return strings.HasPrefix(answer, "y") || strings.HasPrefix(answer, "Y")
+}
+
+/*!
+ * \brief Decide whether to resume from an existing checkpoint.
+ * \param path Checkpoint file.
+ * \param resume True if -resume was given.
+ * \param out Destination of notices and the prompt.
+ * \return The checkpoint to resume from (nil to start afresh), or an error.
+ *
+ * With -resume the checkpoint must exist. Without it, an interactive
+ * user is asked; a non-interactive run refuses to start rather than
+ * overwrite the checkpoint.
+ */
+func findCheckpoint(path string, resume bool, out io.Writer) (*checkpoint, error) {
+	if _, err := os.Stat(path); os.IsNotExist(err) {
+		if resume {
+			return nil, fmt.Errorf("no checkpoint to resume at %s", path)
+		}
+		return nil, nil
+	}
+	cp, err := loadCheckpoint(path)
+	if err != nil {
+		return nil, err
+	}
+	if resume {
+		return cp, nil
+	}
+
+	question := fmt.Sprintf("Found a checkpoint of an interrupted run (seed %d, chronon %d) at %s. Resume?", cp.Seed, cp.Chronon, path)
+	if stdinIsTerminal() {
+		if confirm(out, os.Stdin, question) {
+			return cp, nil
+		}
+		return nil, nil
+	}
+	return nil, fmt.Errorf("found the checkpoint of an interrupted run (seed %d, chronon %d) at %s: pass -resume to continue it, or remove it or choose another -checkpoint to start afresh",
+		cp.Seed, cp.Chronon, path)
+}
@@ -0,0 +1,196 @@
+/*!
+ * \file climate.go
+ * \brief A temperature gradient across the grid and a warming trend.
+ *
+ * Each species breeds fastest at its optimum temperature and slower away
+ * from it.
+ */
+
+package main
+
+import (
+	"encoding/csv"
+	"errors"
+	"fmt"
+	"math"
+	"strconv"
+)
+
+/*!
+ * \brief Temperatures of the water and how the creatures respond to them.
+ */
+type climateRules struct {
+	Equator      float64 ///< Temperature of the equator row at the start
+	Gradient     float64 ///< Drop in temperature from the equator to the poles (0 = uniform)
+	Warming      float64 ///< Rise in temperature per 1000 chronons
+	FishOptimum  float64 ///< Temperature at which fish breed fastest
+	SharkOptimum float64 ///< Temperature at which sharks breed fastest
+	Tolerance    float64 ///< Width of the thermal performance curves
+	Bands        int     ///< Latitude bands recorded by the band sink
+}
+
+/*!
+ * \brief Check whether temperatures affect the run.
+ * \return True with a gradient or a warming trend.
+ */
+func (c *climateRules) enabled() bool {
+	return c.Gradient != 0 || c.Warming != 0
+}
+
+/*!
+ * \brief Check the climate settings.
+ * \param c The settings.
+ * \return An error naming the first setting out of range.
+ */
+func (c climateRules) check() error {
+	switch {
+	case !(c.Gradient >= 0):
+		return fmt.Errorf("-temp-gradient must not be negative, not %g", c.Gradient)
+	case !(c.Tolerance > 0):
+		return fmt.Errorf("-thermal-tolerance must be positive, not %g", c.Tolerance)
+	case c.Bands < 1:
+		return fmt.Errorf("-climate-bands must be at least 1, not %d", c.Bands)
+	}
+	return nil
+}
+
+/*!
+ * \brief Latitude of a row.
+ * \param y The row.
+ * \param size Height of the grid.
+ * \return 0 at the equator (the middle row) rising to 1 at the poles
+ *         (the top and bottom rows).
+ */
+func latitude(y, size int) float64 {
+	return (1 + math.Cos(2*math.Pi*(float64(y)+0.5)/float64(size))) / 2
+}
+
+/*!
+ * \brief Temperature of a row.
+ * \param y The row.
+ * \param size Height of the grid.
+ * \param elapsed Chronons stepped since the start of the run.
+ * \return The temperature.
+ */
+func (c *climateRules) temperature(y, size, elapsed int) float64 {
+	return c.Equator - c.Gradient*latitude(y, size) + c.Warming*float64(elapsed)/1000
+}
+
+/*!
+ * \brief Breed time of a creature at the temperature of its row.
+ * \param w The world the creature is stepped from.
+ * \param species Fish or Shark.
+ * \param y Row of the creature.
+ * \param breed Breed time at the optimum temperature.
+ * \return The breed time divided by the fertility, or a time no creature
+ *         lives to see if the fertility has all but vanished.
+ */
+func (w *World) warmedBreed(species Species, y, breed int) int {
+	c := &w.climate
+	if !c.enabled() {
+		return breed
+	}
+	optimum := c.FishOptimum
+	if species == Shark {
+		optimum = c.SharkOptimum
+	}
+	d := (c.temperature(y, w.Size, w.elapsed) - optimum) / c.Tolerance
+	stretched := float64(breed) / math.Exp(-d*d/2)
+	if !(stretched < math.MaxInt32) {
+		return math.MaxInt32
+	}
+	return int(math.Ceil(stretched))
+}
+
+/*!
+ * \brief Temperature of every row of a world during a chronon.
+ * \param w The world.
+ * \param chronon The chronon, counted from 0.
+ * \return Temperatures by row, nil without a gradient or warming.
+ */
+func (w *World) rowTemperatures(chronon int) []float64 {
+	if !w.climate.enabled() {
+		return nil
+	}
+	temps := make([]float64, w.Size)
+	for y := range temps {
+		temps[y] = w.climate.temperature(y, w.Size, chronon)
+	}
+	return temps
+}
+
+/*!
+ * \brief Writes the populations of latitude bands as CSV.
+ *
+ * Band 0 holds the rows nearest the equator and the last band the rows
+ * nearest the poles, both hemispheres together.
+ */
+type bandSink struct {
+	*csvSink       ///< Output file and encoder
+	band     []int ///< Band of each row
+	bands    int   ///< Number of bands
+}
+
+/*!
+ * \brief Create a band sink.
+ * \param path Output file path.
+ * \param params Parameters of the run, with a gradient or warming.
+ * \return The sink, or an error if the run has no climate or the file
+ *         cannot be created.
+ */
+func openBandSink(path string, params Config) (Observer, error) {
+	if !params.Climate.enabled() {
+		return nil, errors.New("needs -temp-gradient or -warming")
+	}
+	file, err := createCSV(path)
+	if err != nil {
+		return nil, err
+	}
+	s := &bandSink{csvSink: &csvSink{file: file, w: csv.NewWriter(file)}, band: make([]int, params.GridSize), bands: params.Climate.Bands}
+	for y := range s.band {
+		s.band[y] = min(s.bands-1, int(latitude(y, params.GridSize)*float64(s.bands)))
+	}
+	s.w.Write([]string{"chronon", "band", "temperature", "fish", "sharks"})
+	return s, nil
+}
+
+/*!
+ * \brief Write a row per band for a frame.
+ * \param f The frame to record.
+ * \return Any write error.
+ */
+func (s *bandSink) Observe(f *Frame) error {
+	fish, sharks := make([]int, s.bands), make([]int, s.bands)
+	temp, rows := make([]float64, s.bands), make([]int, s.bands)
+	for y := 0; y < f.Size; y++ {
+		b := s.band[y]
+		if t, ok := f.RowTemperature(y); ok {
+			temp[b] += t
+		}
+		rows[b]++
+		for x := 0; x < f.Size; x++ {
+			switch f.At(x, y) {
+			case Fish:
+				fish[b]++
+			case Shark:
+				sharks[b]++
+			}
+		}
+	}
+	for b := 0; b < s.bands; b++ {
+		if rows[b] == 0 {
+			continue
+		}
+		err := s.w.Write([]string{
+			strconv.Itoa(f.Chronon),
+			strconv.Itoa(b),
+			strconv.FormatFloat(temp[b]/float64(rows[b]), 'f', 2, 64),
+			strconv.Itoa(fish[b]),
+			strconv.Itoa(sharks[b]),
+		})
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
@@ -0,0 +1,226 @@
+/*!
+ * \file compare.go
+ * \brief The compare subcommand: A/B runs in lockstep with divergence detection.
+ *
+ * Two simulations start from the same seed and differ in one parameter.
+ * They are stepped together, and the first chronon at which a population
+ * of one differs from the other's by more than a threshold is reported:
+ * how long a change of the rules takes to show in the populations, and
+ * whether it shows at all. The placement of the initial creatures draws
+ * the same random numbers in both runs unless the parameter changes the
+ * populations, so the worlds start out identical.
+ */
+
+package main
+
+import (
+	"bufio"
+	"encoding/csv"
+	"fmt"
+	"io"
+	"math"
+	"os"
+	"strconv"
+)
+
+/*!
+ * \brief Relative difference of two populations.
+ * \param a Population of run A.
+ * \param b Population of run B.
+ * \return |a-b| divided by the larger of the two, 0 if both are 0.
+ */
+func relativeDifference(a, b int) float64 {
+	if a == 0 && b == 0 {
+		return 0
+	}
+	return math.Abs(float64(a-b)) / float64(max(a, b))
+}
+
+/*!
+ * \brief How far apart two runs drifted.
+ */
+type divergence struct {
+	At        int     ///< First chronon at which the runs differed beyond the threshold, or -1
+	Largest   float64 ///< Largest relative difference seen
+	LargestAt int     ///< Chronon of the largest difference
+}
+
+/*!
+ * \brief Record the difference of one chronon.
+ * \param fa Frame of run A.
+ * \param fb Frame of run B, of the same chronon.
+ * \param threshold Relative difference counting as divergence.
+ * \return The larger relative difference of the two species.
+ */
+func (d *divergence) add(fa, fb *Frame, threshold float64) float64 {
+	diff := max(relativeDifference(fa.Fish, fb.Fish), relativeDifference(fa.Sharks, fb.Sharks))
+	if diff > d.Largest {
+		d.Largest, d.LargestAt = diff, fa.Chronon
+	}
+	if diff > threshold && d.At < 0 {
+		d.At = fa.Chronon
+	}
+	return diff
+}
+
+/*!
+ * \brief Draw the grids of both runs next to each other.
+ * \param out Destination of the drawing.
+ * \param fa Frame of run A.
+ * \param fb Frame of run B.
+ * \param diff Relative difference of the chronon.
+ * \param tui Redraw in place in colour rather than printing symbols.
+ * \return Any write error.
+ */
+func drawPair(out io.Writer, fa, fb *Frame, diff float64, tui bool) error {
+	w := bufio.NewWriter(out)
+	status := fmt.Sprintf("Chronon %d | A: Fish=%d Sharks=%d | B: Fish=%d Sharks=%d | Difference %.1f%%",
+		fa.Chronon, fa.Fish, fa.Sharks, fb.Fish, fb.Sharks, 100*diff)
+	if !tui {
+		w.WriteString(status + "\n")
+	} else {
+		w.WriteString(ansiHome)
+	}
+	for y := 0; y < fa.Size; y++ {
+		for i, f := range []*Frame{fa, fb} {
+			if i > 0 {
+				w.WriteString("  ")
+			}
+			for x := 0; x < f.Size; x++ {
+				s := f.At(x, y)
+				if tui {
+					w.WriteString(tuiColour(s) + "  ")
+				} else {
+					w.WriteString(string(f.Char(s)) + " ")
+				}
+			}
+			if tui {
+				w.WriteString(ansiReset)
+			}
+		}
+		w.WriteByte('\n')
+	}
+	if tui {
+		w.WriteString(status + ansiClearLine + "\n")
+	} else {
+		w.WriteByte('\n')
+	}
+	return w.Flush()
+}
+
+/*!
+ * \brief Entry point of the compare subcommand.
+ * \param args Command-line arguments after "compare".
+ * \return Process exit code: exitOK, exitConfig for bad settings or
+ *         exitFailure if the CSV cannot be written.
+ */
+func compareCommand(args []string) int {
+	fs := newCommandFlags("compare")
+	params := defaultConfig()
+	cfg := registerConfigFlags(fs, &params)
+	change := fs.String("b", "", "parameter of run B differing from run A, e.g. sharkbreed=12")
+	threshold := fs.Float64("threshold", 0.1, "relative difference of a population counting as divergence")
+	horizon := chrononsFlag(fs, "horizon", 1000, "chronons to run")
+	render := fs.String("render", "none", "paired rendering: plain, tui or none")
+	cps := fs.Float64("cps", 10, "target chronons per second while rendering (0 = as fast as possible)")
+	csvPath := fs.String("csv", "", "write both population trajectories to this CSV `file`")
+	if err := fs.Parse(args); err != nil {
+		return flagExit(err)
+	}
+
+	seed, err := cfg.resolve()
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		return exitConfig
+	}
+	if *cfg.dryRun {
+		writeDryRun(os.Stdout, cfg, seed)
+		return exitOK
+	}
+	p, values, err := parseParamValues("-b", *change)
+	if err == nil && len(values) != 1 {
+		err = fmt.Errorf("-b takes one value, not %d", len(values))
+	}
+	paramsB := params
+	if err == nil {
+		*p.Field(&paramsB) = values[0]
+		err = checkParams(paramsB)
+	}
+	if err == nil {
+		if err = checkFit(paramsB); err != nil {
+			err = fmt.Errorf("-b %s: %v", *change, err)
+		}
+	}
+	if err == nil && (*threshold <= 0 || *horizon < 1) {
+		err = fmt.Errorf("compare: need a positive -threshold and -chronons")
+	}
+	if err == nil && *render != "none" && *render != "plain" && *render != "tui" {
+		err = fmt.Errorf("unknown -render %q (want plain, tui or none)", *render)
+	}
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		return exitConfig
+	}
+
+	var table *csv.Writer
+	if *csvPath != "" {
+		file, err := os.Create(*csvPath)
+		if err != nil {
+			fmt.Fprintln(os.Stderr, err)
+			return exitFailure
+		}
+		defer file.Close()
+		table = csv.NewWriter(file)
+		table.Write([]string{"chronon", "fish_a", "sharks_a", "fish_b", "sharks_b", "difference"})
+	}
+
+	a, b := newSimulation(params, seed), newSimulation(paramsB, seed)
+	gov := newGovernor(0)
+	if *render != "none" {
+		gov = newGovernor(*cps)
+	}
+	if *render == "tui" {
+		fmt.Print(ansiClear + ansiHideCursor)
+	}
+	d := divergence{At: -1}
+	for c := 0; c < *horizon; c++ {
+		a.Step()
+		b.Step()
+		fa, fb := a.Frame(), b.Frame()
+		diff := d.add(fa, fb, *threshold)
+		if table != nil {
+			table.Write([]string{strconv.Itoa(fa.Chronon), strconv.Itoa(fa.Fish), strconv.Itoa(fa.Sharks),
+				strconv.Itoa(fb.Fish), strconv.Itoa(fb.Sharks), strconv.FormatFloat(diff, 'f', 4, 64)})
+		}
+		if *render != "none" {
+			drawPair(os.Stdout, fa, fb, diff, *render == "tui")
+		}
+		if fa.Fish+fa.Sharks == 0 && fb.Fish+fb.Sharks == 0 {
+			break
+		}
+		gov.wait()
+	}
+	if *render == "tui" {
+		fmt.Print(ansiShowCursor)
+	}
+	if table != nil {
+		table.Flush()
+		if err := table.Error(); err != nil {
+			fmt.Fprintln(os.Stderr, err)
+			return exitFailure
+		}
+	}
+
+	fishA, sharksA := a.Population()
+	fishB, sharksB := b.Population()
+	fmt.Printf("A (%s=%d): chronon %d, fish=%d sharks=%d\n", p.Name, *p.Field(&params), a.Chronon, fishA, sharksA)
+	fmt.Printf("B (%s=%d): chronon %d, fish=%d sharks=%d\n", p.Name, values[0], b.Chronon, fishB, sharksB)
+	if d.At >= 0 {
+		fmt.Printf("Diverged beyond %.1f%% at chronon %d; largest difference %.1f%% at chronon %d\n",
+			100**threshold, d.At, 100*d.Largest, d.LargestAt)
+	} else {
+		fmt.Printf("No divergence beyond %.1f%% in %d chronons; largest difference %.1f%% at chronon %d\n",
+			100**threshold, a.Chronon, 100*d.Largest, d.LargestAt)
+	}
+	return exitOK
+}
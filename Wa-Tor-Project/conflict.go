@@ -0,0 +1,142 @@
+/*!
+ * \file conflict.go
+ * \brief Who gets a free cell that two creatures want in the same chronon.
+ *
+ * First come lets the update order decide; random and older let later
+ * creatures contest it.
+ */
+
+package main
+
+import (
+	"fmt"
+	"math/rand"
+)
+
+/*!
+ * \brief Rule deciding which of two creatures gets a cell both move to.
+ */
+type ConflictPolicy uint8
+
+const (
+	FirstCome    ConflictPolicy = iota ///< The creature updated first takes the cell
+	RandomWinner                       ///< Either creature, with equal chances
+	OlderWins                          ///< The older creature; the first to arrive on a tie
+)
+
+/*!
+ * \brief Parse the name of a conflict policy.
+ * \param name "first", "random" or "older".
+ * \return The policy, or an error if the name is unknown.
+ */
+func parseConflictPolicy(name string) (ConflictPolicy, error) {
+	switch name {
+	case "first":
+		return FirstCome, nil
+	case "random":
+		return RandomWinner, nil
+	case "older":
+		return OlderWins, nil
+	}
+	return FirstCome, fmt.Errorf("unknown conflict policy %q (want first, random or older)", name)
+}
+
+/*!
+ * \brief Name of a conflict policy.
+ * \param p The policy.
+ * \return "first", "random" or "older".
+ */
+func conflictPolicyName(p ConflictPolicy) string {
+	switch p {
+	case RandomWinner:
+		return "random"
+	case OlderWins:
+		return "older"
+	}
+	return "first"
+}
+
+/*!
+ * \brief Check whether a creature may pick a cell that was free at the start of the chronon.
+ * \param newWorld Next world state.
+ * \param pos The cell.
+ * \return True if nothing has moved there yet, or if another creature
+ *         has and the policy lets it be contested.
+ */
+func (w *World) claimable(newWorld *World, pos [2]int) bool {
+	s := newWorld.Grid[pos[0]][pos[1]].Species
+	return s == Empty || w.conflicts != FirstCome && s != Whale
+}
+
+/*!
+ * \brief Pick the free cell a creature moves to and settle any contest for it.
+ * \param newWorld Next world state.
+ * \param x X coordinate of the creature.
+ * \param y Y coordinate of the creature.
+ * \param c The creature.
+ * \param cells The free cells, claimable ones included; lost ones are removed.
+ * \param n Number of free cells, at least 1.
+ * \param rng Random source of the creature's cell.
+ * \return The cell as from pickMove and true, or false if the creature
+ *         lost the contest for every cell it picked.
+ */
+func (w *World) pickClaim(newWorld *World, x, y int, c *Creature, cells *[4][2]int, n int, rng *rand.Rand) ([2]int, bool) {
+	for n > 0 {
+		// pickMove narrows its cells in place
+		left := *cells
+		pos := w.pickMove(newWorld, x, y, c, &left, n, rng)
+		if w.claim(newWorld, pos, c, rng) {
+			return pos, true
+		}
+		for i := range n {
+			if cells[i] == pos {
+				cells[i] = cells[n-1]
+				break
+			}
+		}
+		n--
+	}
+	return [2]int{}, false
+}
+
+/*!
+ * \brief Settle whether a creature gets the cell it picked.
+ * \param newWorld Next world state.
+ * \param pos The cell, free at the start of the chronon.
+ * \param c The creature moving there.
+ * \param rng Random source of the creature's cell.
+ * \return True if the cell is its to take. If another creature got there
+ *         first and loses, that creature is moved back to where it came from.
+ */
+func (w *World) claim(newWorld *World, pos [2]int, c *Creature, rng *rand.Rand) bool {
+	holder := newWorld.Grid[pos[0]][pos[1]]
+	if holder.Species == Empty {
+		return true
+	}
+
+	// The first creature came from a neighbour of the cell
+	var origin [2]int
+	found := false
+	for _, n := range getAdjacentPositions(pos[0], pos[1], w.Size, w.bounded) {
+		if w.Grid[n[0]][n[1]].ID == holder.ID && newWorld.Grid[n[0]][n[1]].Species == Empty {
+			origin, found = n, true
+			break
+		}
+	}
+	wins := false
+	switch w.conflicts {
+	case RandomWinner:
+		wins = rng.Float64() < 0.5 && found
+	case OlderWins:
+		wins = c.Age > holder.Age && found
+	}
+
+	winner, loser := c, &holder
+	if wins {
+		newWorld.put(origin[0], origin[1], &holder)
+	} else {
+		winner, loser = loser, winner
+	}
+	newWorld.record(Event{Kind: Conflict, Species: winner.Species, ID: winner.ID, Rival: loser.ID, X: pos[0], Y: pos[1]})
+	return wins
+}
@@ -0,0 +1,81 @@
+/*!
+ * \file crowding.go
+ * \brief Density-dependent breeding: crowded creatures breed more slowly.
+ *
+ * The breed time grows with the occupied neighbours beyond a tolerance.
+ */
+
+package main
+
+import (
+	"fmt"
+	"math"
+)
+
+/*!
+ * \brief How local crowding stretches the breed times.
+ */
+type crowdingRule struct {
+	Fish      float64 ///< Share of its breed time a fish waits longer per crowded neighbour
+	Shark     float64 ///< Share of its breed time a shark waits longer per crowded neighbour
+	Tolerance int     ///< Occupied neighbours that do not slow breeding yet
+}
+
+/*!
+ * \brief Check a crowding rule.
+ * \param c The rule.
+ * \return An error naming the first setting out of range.
+ */
+func (c crowdingRule) check() error {
+	switch {
+	case !(c.Fish >= 0 && c.Fish <= 100):
+		return fmt.Errorf("-fish-crowding must be between 0 and 100, not %g", c.Fish)
+	case !(c.Shark >= 0 && c.Shark <= 100):
+		return fmt.Errorf("-shark-crowding must be between 0 and 100, not %g", c.Shark)
+	case c.Tolerance < 0 || c.Tolerance > 3:
+		return fmt.Errorf("-crowding-tolerance must be between 0 and 3, not %d", c.Tolerance)
+	}
+	return nil
+}
+
+/*!
+ * \brief Check whether crowding slows either species.
+ * \return True if either strength is above 0.
+ */
+func (c crowdingRule) enabled() bool {
+	return c.Fish > 0 || c.Shark > 0
+}
+
+/*!
+ * \brief Breed time of a creature stretched by the crowding around it.
+ * \param species Fish or Shark.
+ * \param x X position of the creature at the start of the chronon.
+ * \param y Y position of the creature at the start of the chronon.
+ * \param breed Breed time before crowding.
+ * \return The stretched breed time, breed itself without crowding.
+ */
+func (w *World) crowdedBreed(species Species, x, y, breed int) int {
+	strength := w.crowding.Fish
+	if species == Shark {
+		strength = w.crowding.Shark
+	}
+	if strength == 0 {
+		return breed
+	}
+	occupied := 0
+	for _, pos := range getAdjacentPositions(x, y, w.Size, w.bounded) {
+		// Past a wall the neighbour is the cell itself
+		if (pos[0] != x || pos[1] != y) && w.creatures.has(pos[0], pos[1]) {
+			occupied++
+		}
+	}
+	excess := occupied - w.crowding.Tolerance
+	if excess <= 0 {
+		return breed
+	}
+	stretched := float64(breed) * (1 + strength*float64(excess))
+	if !(stretched < math.MaxInt32) {
+		return math.MaxInt32
+	}
+	return int(math.Ceil(stretched))
+}
@@ -0,0 +1,95 @@
+/*!
+ * \file daynight.go
+ * \brief A day/night cycle that changes how well sharks hunt.
+ *
+ * By day fish may escape the sharks; by night they may rest instead of
+ * moving.
+ */
+
+package main
+
+import (
+	"fmt"
+	"math/rand"
+)
+
+/*!
+ * \brief Length and strength of the day/night cycle.
+ */
+type dayCycle struct {
+	Length    int     ///< Chronons per day and night together (0 = no cycle)
+	Night     float64 ///< Share of the cycle that is night
+	Amplitude float64 ///< Drop in shark catches by day and in fish movement by night
+}
+
+/*!
+ * \brief Check a day/night cycle.
+ * \param d The cycle.
+ * \return An error naming the first setting out of range.
+ */
+func (d dayCycle) check() error {
+	switch {
+	case d.Length < 0:
+		return fmt.Errorf("-day-length must not be negative, not %d", d.Length)
+	case !(d.Night >= 0 && d.Night <= 1):
+		return fmt.Errorf("-night-share must be between 0 and 1, not %g", d.Night)
+	case !(d.Amplitude >= 0 && d.Amplitude <= 1):
+		return fmt.Errorf("-day-amplitude must be between 0 and 1, not %g", d.Amplitude)
+	}
+	return nil
+}
+
+/*!
+ * \brief Check whether a chronon falls in the night.
+ * \param chronon The chronon, counted from 0.
+ * \return True at night, false by day or without a cycle.
+ */
+func (d *dayCycle) night(chronon int) bool {
+	if d.Length == 0 {
+		return false
+	}
+	return chronon%d.Length >= d.Length-int(float64(d.Length)*d.Night+0.5)
+}
+
+/*!
+ * \brief Name of the phase of a chronon, for the status bar.
+ * \param chronon The chronon, counted from 0.
+ * \return "day" or "night", or "" without a cycle.
+ */
+func (d *dayCycle) phase(chronon int) string {
+	switch {
+	case d.Length == 0:
+		return ""
+	case d.night(chronon):
+		return "night"
+	}
+	return "day"
+}
+
+/*!
+ * \brief Decide whether a shark catches the fish it reaches.
+ * \param chronon The chronon, counted from 0.
+ * \param rng Random source of the shark's cell.
+ * \return True at night; by day with a probability of 1 minus the
+ *         amplitude.
+ */
+func (d *dayCycle) catches(chronon int, rng *rand.Rand) bool {
+	if d.Amplitude == 0 || d.night(chronon) || d.Length == 0 {
+		return true
+	}
+	return d.Amplitude < 1 && rng.Float64() >= d.Amplitude
+}
+
+/*!
+ * \brief Decide whether a fish swims this chronon.
+ * \param chronon The chronon, counted from 0.
+ * \param rng Random source of the fish's cell.
+ * \return True by day; at night with a probability of 1 minus the
+ *         amplitude.
+ */
+func (d *dayCycle) swims(chronon int, rng *rand.Rand) bool {
+	if d.Amplitude == 0 || !d.night(chronon) {
+		return true
+	}
+	return d.Amplitude < 1 && rng.Float64() >= d.Amplitude
+}
@@ -0,0 +1,211 @@
+/*!
+ * \file delta.go
+ * \brief Delta-encoded frame streams for streaming and frame logs.
+ *
+ * A frame stream is a sequence of JSON objects. A keyframe carries every
+ * cell; the frames between keyframes carry only the cells that changed
+ * since the previous frame, as pairs of cell index and new state. Most
+ * cells of a large world do not change from one chronon to the next, so
+ * a stream is far smaller than a sequence of full frames.
+ *
+ * A delta names the chronon it applies to. A receiver that missed a frame
+ * notices the mismatch and waits for (or asks for) the next keyframe; an
+ * encoder that skips frames emits a keyframe after the gap by itself.
+ */
+
+package main
+
+import (
+	"bufio"
+	"encoding/json"
+	"errors"
+	"os"
+	"strconv"
+	"strings"
+)
+
+/*!
+ * \brief Frames between two keyframes of a stream.
+ */
+const keyframeInterval = 100
+
+/*!
+ * \brief One frame of a stream, either a keyframe or a delta.
+ */
+type deltaRecord struct {
+	Kind    string `json:"kind"` ///< "key" or "delta"
+	Chronon int    `json:"chronon"`
+	Size    int    `json:"size"`
+	Fish    int    `json:"fish"`
+	Sharks  int    `json:"sharks"`
+	Cells   string `json:"cells,omitempty"`   ///< Keyframes: one of speciesChars per cell, row-major
+	Base    int    `json:"base,omitempty"`    ///< Deltas: chronon of the frame the changes apply to
+	Changes []int  `json:"changes,omitempty"` ///< Deltas: cell index and new state (0 water, 1 fish, 2 shark), pairwise
+}
+
+/*!
+ * \brief Turns consecutive frames into keyframes and deltas.
+ */
+type deltaEncoder struct {
+	interval int    ///< Frames between keyframes
+	prev     *Frame ///< Last encoded frame, or nil
+	sinceKey int    ///< Frames encoded since the last keyframe
+}
+
+/*!
+ * \brief Create an encoder.
+ * \param interval Frames between keyframes (at least 1).
+ * \return The encoder; its first frame is a keyframe.
+ */
+func newDeltaEncoder(interval int) *deltaEncoder {
+	return &deltaEncoder{interval: max(interval, 1)}
+}
+
+/*!
+ * \brief Encode the next frame.
+ * \param f The frame.
+ * \return A keyframe at the start, every interval frames, after a gap in
+ *         the chronons and when so many cells changed that the keyframe is
+ *         smaller; a delta against the previous frame otherwise.
+ */
+func (e *deltaEncoder) encode(f *Frame) deltaRecord {
+	p := e.prev
+	e.prev = f
+	e.sinceKey++
+	if p == nil || p.Chronon+1 != f.Chronon || p.Size != f.Size || e.sinceKey >= e.interval {
+		e.sinceKey = 0
+		return e.keyframe(f)
+	}
+	r := deltaRecord{Kind: "delta", Chronon: f.Chronon, Size: f.Size, Fish: f.Fish, Sharks: f.Sharks}
+
+	// A delta costs about the digits of the index plus three bytes per change
+	cost := len(strconv.Itoa(f.Size*f.Size)) + 3
+	r.Base = p.Chronon
+	r.Changes = []int{}
+	for i, s := range f.Cells() {
+		if s != p.Cell(i) {
+			r.Changes = append(r.Changes, i, int(s))
+		}
+	}
+	if len(r.Changes)/2*cost >= f.Size*f.Size {
+		e.sinceKey = 0
+		return e.keyframe(f)
+	}
+	return r
+}
+
+/*!
+ * \brief Encode a frame as a keyframe.
+ * \param f The frame.
+ * \return The keyframe.
+ */
+func (e *deltaEncoder) keyframe(f *Frame) deltaRecord {
+	cells := make([]byte, f.Size*f.Size)
+	for i, s := range f.Cells() {
+		cells[i] = speciesChars[s]
+	}
+	return deltaRecord{Kind: "key", Chronon: f.Chronon, Size: f.Size, Fish: f.Fish, Sharks: f.Sharks, Cells: string(cells)}
+}
+
+/*!
+ * \brief Returned by deltaDecoder.apply for a delta that does not follow the current frame.
+ */
+var errDeltaGap = errors.New("delta does not follow the current frame")
+
+/*!
+ * \brief Rebuilds frames from keyframes and deltas.
+ */
+type deltaDecoder struct {
+	frame *Frame ///< Current frame, or nil before the first keyframe
+}
+
+/*!
+ * \brief Apply the next record of a stream.
+ * \param r The record.
+ * \return The frame it describes, errDeltaGap for a delta that does not
+ *         apply to the current frame, or an error for a malformed record.
+ *
+ * After errDeltaGap the decoder keeps rejecting deltas until the next
+ * keyframe resynchronises it.
+ */
+func (d *deltaDecoder) apply(r deltaRecord) (*Frame, error) {
+	f := &Frame{Chronon: r.Chronon, Size: r.Size, Fish: r.Fish, Sharks: r.Sharks}
+	switch r.Kind {
+	case "key":
+		if r.Size < 1 || r.Size > maxGridSize || len(r.Cells) != r.Size*r.Size {
+			return nil, errors.New("keyframe has the wrong number of cells")
+		}
+		f.cells = make([]Species, len(r.Cells))
+		for i := range r.Cells {
+			if s := strings.IndexByte(speciesChars, r.Cells[i]); s > 0 {
+				f.cells[i] = Species(s)
+			}
+		}
+	case "delta":
+		if d.frame == nil || d.frame.Chronon != r.Base || d.frame.Size != r.Size {
+			d.frame = nil
+			return nil, errDeltaGap
+		}
+		if len(r.Changes)%2 != 0 {
+			return nil, errors.New("delta has an odd number of change values")
+		}
+		f.cells = append([]Species(nil), d.frame.cells...)
+		for i := 0; i < len(r.Changes); i += 2 {
+			cell, s := r.Changes[i], r.Changes[i+1]
+			if cell < 0 || cell >= len(f.cells) || s < int(Empty) || s >= speciesCount {
+				return nil, errors.New("delta changes a cell outside the grid or to an unknown state")
+			}
+			f.cells[cell] = Species(s)
+		}
+	default:
+		return nil, errors.New("unknown frame kind " + r.Kind)
+	}
+	d.frame = f
+	return f, nil
+}
+
+/*!
+ * \brief Writes the run as a delta-encoded frame log, one JSON object per line.
+ */
+type frameLogSink struct {
+	file *os.File      ///< Output file
+	w    *bufio.Writer ///< Buffer on top of file
+	enc  *json.Encoder ///< JSON encoder on top of w
+	code *deltaEncoder ///< Keyframe and delta encoder
+}
+
+/*!
+ * \brief Create a frame log sink.
+ * \param path Output file path.
+ * \param params Parameters of the run (unused).
+ * \return The sink, or an error if the file cannot be created.
+ */
+func openFrameLogSink(path string, params Config) (Observer, error) {
+	file, err := os.Create(path)
+	if err != nil {
+		return nil, err
+	}
+	w := bufio.NewWriter(file)
+	return &frameLogSink{file: file, w: w, enc: json.NewEncoder(w), code: newDeltaEncoder(keyframeInterval)}, nil
+}
+
+/*!
+ * \brief Write the keyframe or delta of a frame.
+ * \param f The frame to record.
+ * \return Any write error.
+ */
+func (s *frameLogSink) Observe(f *Frame) error {
+	return s.enc.Encode(s.code.encode(f))
+}
+
+/*!
+ * \brief Flush and close the file.
+ * \return Any write or close error.
+ */
+func (s *frameLogSink) Close() error {
+	if err := s.w.Flush(); err != nil {
+		s.file.Close()
+		return err
+	}
+	return s.file.Close()
+}
@@ -0,0 +1,54 @@
+/*!
+ * \file delta_test.go
+ * \brief Delta-encoded streams rebuild every frame and recover from a lost one.
+ */
+
+package main
+
+import (
+	"errors"
+	"testing"
+)
+
+/*!
+ * \brief A decoder that misses a delta rejects the deltas after it and is
+ *        back in step from the next keyframe on.
+ */
+func TestDeltaResync(t *testing.T) {
+	const interval, chronons, dropped = 10, 35, 13
+	cfg := defaultConfig()
+	// Fish that only move change few enough cells for every frame between keyframes to be a delta
+	cfg.GridSize, cfg.NumFish, cfg.NumShark, cfg.FishBreed = 100, 200, 0, 1000
+	sim := newSimulation(cfg, 1)
+	enc := newDeltaEncoder(interval)
+	var frames []*Frame
+	var records []deltaRecord
+	for i := 0; i < chronons; i++ {
+		f := sim.Frame()
+		frames = append(frames, f)
+		records = append(records, enc.encode(f))
+		sim.Step()
+	}
+	if records[dropped].Kind != "delta" || records[2*interval].Kind != "key" {
+		t.Fatalf("records %d and %d are a %s and a %s, want a delta and a keyframe",
+			dropped, 2*interval, records[dropped].Kind, records[2*interval].Kind)
+	}
+
+	var dec deltaDecoder
+	for i, r := range records {
+		if i == dropped {
+			continue
+		}
+		got, err := dec.apply(r)
+		switch {
+		case i > dropped && i < 2*interval:
+			if !errors.Is(err, errDeltaGap) {
+				t.Fatalf("record %d after the gap: got %v, want errDeltaGap", i, err)
+			}
+		case err != nil:
+			t.Fatalf("record %d: %v", i, err)
+		case got.Chronon != frames[i].Chronon || enc.keyframe(got).Cells != enc.keyframe(frames[i]).Cells:
+			t.Fatalf("record %d decodes to a different frame", i)
+		}
+	}
+}
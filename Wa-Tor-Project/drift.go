@@ -0,0 +1,223 @@
+/*!
+ * \file drift.go
+ * \brief Preferred directions of movement, for a prevailing drift.
+ *
+ * Moves to free water favour the directions with larger weights.
+ */
+
+package main
+
+import (
+	"fmt"
+	"math"
+	"math/rand"
+	"strconv"
+	"strings"
+)
+
+/*!
+ * \brief Names of the directions, in the order of getAdjacentPositions.
+ */
+var directionNames = [4]string{"west", "east", "north", "south"}
+
+/*!
+ * \brief Weights of the four directions for one species or all of them.
+ */
+type moveWeights struct {
+	Species Species    ///< Species the weights apply to; Empty for every species without weights of its own
+	Weights [4]float64 ///< Weight per direction, in the order of directionNames
+}
+
+/*!
+ * \brief Parse movement weights.
+ * \param s Weights "[SPECIES:] DIRECTION=WEIGHT ...; ...", e.g.
+ *          "east=2; shark: north=3"; a direction not listed has weight 1.
+ * \param species Registered species the names may refer to.
+ * \return The weights, or an error for a malformed entry.
+ */
+func parseMoveWeights(s string, species []webSpecies) ([]moveWeights, error) {
+	web := foodWeb{Species: species}
+	var rules []moveWeights
+	for _, text := range strings.Split(s, ";") {
+		text = strings.TrimSpace(text)
+		if text == "" {
+			continue
+		}
+		r := moveWeights{Weights: [4]float64{1, 1, 1, 1}}
+		list := text
+		if name, rest, ok := strings.Cut(text, ":"); ok {
+			var known bool
+			if r.Species, known = web.lookup(strings.TrimSpace(name)); !known {
+				return nil, fmt.Errorf("invalid movement weights %q: unknown species %q", text, strings.TrimSpace(name))
+			}
+			list = rest
+		}
+		fields := strings.Fields(list)
+		if len(fields) == 0 {
+			return nil, fmt.Errorf("invalid movement weights %q: want [SPECIES:] DIRECTION=WEIGHT ...", text)
+		}
+		for _, field := range fields {
+			dir, value, _ := strings.Cut(field, "=")
+			d := -1
+			for i, name := range directionNames {
+				if name == dir {
+					d = i
+				}
+			}
+			w, err := strconv.ParseFloat(value, 64)
+			if d < 0 || err != nil {
+				return nil, fmt.Errorf("invalid movement weight %q in %q: want DIRECTION=WEIGHT with a direction of %s",
+					field, text, strings.Join(directionNames[:], ", "))
+			}
+			r.Weights[d] = w
+		}
+		rules = append(rules, r)
+	}
+	return rules, nil
+}
+
+/*!
+ * \brief Format movement weights for the -move-weights flag.
+ * \param rules The weights.
+ * \param web Food web naming the registered species.
+ * \return The weights joined with "; ", leaving out directions of weight 1.
+ */
+func formatMoveWeights(rules []moveWeights, web *foodWeb) string {
+	texts := make([]string, len(rules))
+	for i, r := range rules {
+		var fields []string
+		for d, w := range r.Weights {
+			if w != 1 {
+				fields = append(fields, directionNames[d]+"="+strconv.FormatFloat(w, 'g', -1, 64))
+			}
+		}
+		if fields == nil {
+			fields = []string{"east=1"} // All even, but an entry needs a direction
+		}
+		if r.Species != Empty {
+			fields = append([]string{web.name(r.Species) + ":"}, fields...)
+		}
+		texts[i] = strings.Join(fields, " ")
+	}
+	return strings.Join(texts, "; ")
+}
+
+/*!
+ * \brief Check movement weights against the food web.
+ * \param params Parameters with the weights.
+ * \return An error for a weight that is not positive, a species that is
+ *         not registered or one given weights twice.
+ */
+func checkMoveWeights(params Config) error {
+	seen := map[Species]bool{}
+	for _, r := range params.MoveWeights {
+		name := "every species"
+		if r.Species != Empty {
+			name = params.Web.name(r.Species)
+		}
+		switch {
+		case r.Species != Empty && r.Species != Fish && r.Species != Shark && params.Web.registered(r.Species) == nil:
+			return fmt.Errorf("movement weights of %s: not registered", r.Species)
+		case seen[r.Species]:
+			return fmt.Errorf("movement weights of %s: given twice", name)
+		}
+		seen[r.Species] = true
+		for d, w := range r.Weights {
+			if !(w > 0) || math.IsInf(w, 1) {
+				return fmt.Errorf("movement weights of %s: %s weight must be positive, not %g", name, directionNames[d], w)
+			}
+		}
+	}
+	return nil
+}
+
+/*!
+ * \brief Movement weights compiled per species for stepping.
+ */
+type moveTable [speciesCount]*[4]float64
+
+/*!
+ * \brief Compile movement weights.
+ * \param rules The weights.
+ * \return The weights of every species, or nil without any.
+ */
+func moveTableOf(rules []moveWeights) *moveTable {
+	if len(rules) == 0 {
+		return nil
+	}
+	t := &moveTable{}
+	for i := range rules {
+		if r := &rules[i]; r.Species != Empty {
+			t[r.Species] = &r.Weights
+		}
+	}
+	for i := range rules {
+		if r := &rules[i]; r.Species == Empty {
+			for s := range t {
+				if t[s] == nil {
+					t[s] = &r.Weights
+				}
+			}
+		}
+	}
+	return t
+}
+
+/*!
+ * \brief Direction of a neighbouring cell.
+ * \param x X coordinate of the creature.
+ * \param y Y coordinate of the creature.
+ * \param pos The neighbouring cell.
+ * \param size Width/height of the grid.
+ * \return Its index in directionNames.
+ */
+func direction(x, y int, pos [2]int, size int) int {
+	switch {
+	case pos[0] == x+1 || pos[0] == x+1-size:
+		return 1
+	case pos[0] != x:
+		return 0
+	case pos[1] == y-1 || pos[1] == y-1+size:
+		return 2
+	}
+	return 3
+}
+
+/*!
+ * \brief Pick a free cell by the movement weights of a creature.
+ * \param newWorld Next world state.
+ * \param x X coordinate of the creature.
+ * \param y Y coordinate of the creature.
+ * \param c The creature.
+ * \param cells The free cells to pick from, at least one.
+ * \param rng Random source of the creature's cell.
+ * \return A cell at random, each with a chance proportional to the weight
+ *         of its direction times, for a territorial shark, its territory
+ *         factor; evenly without either.
+ */
+func (w *World) pickWeighted(newWorld *World, x, y int, c *Creature, cells [][2]int, rng *rand.Rand) [2]int {
+	drift := w.drift != nil && w.drift[c.Species] != nil
+	territorial := w.territorial(c)
+	if !drift && !territorial {
+		return cells[rng.Intn(len(cells))]
+	}
+	var weights [4]float64
+	total := 0.0
+	for i, pos := range cells {
+		weights[i] = 1
+		if drift {
+			weights[i] = w.drift[c.Species][direction(x, y, pos, w.Size)]
+		}
+		if territorial {
+			weights[i] *= w.territoryWeight(newWorld, pos)
+		}
+		total += weights[i]
+	}
+	r := rng.Float64() * total
+	for i, pos := range cells {
+		if r -= weights[i]; r < 0 {
+			return pos
+		}
+	}
+	return cells[len(cells)-1]
+}
@@ -0,0 +1,136 @@
+/*!
+ * \file dryrun.go
+ * \brief -dry-run: print the resolved configuration instead of running.
+ *
+ * Settings can come from defaults, presets, scenarios, config files and
+ * the command line. The dry run shows the value every flag ended up with
+ * and where it came from, plus an estimate of the memory the grid needs.
+ */
+
+package main
+
+import (
+	"flag"
+	"fmt"
+	"io"
+	"unsafe"
+)
+
+/*!
+ * \brief Estimated bytes of one world.
+ * \param size Grid size.
+ * \return Bytes for the grid and its three bitsets (creatures, fish, moved).
+ *         Creatures are stored in the grid, so the population does not
+ *         matter.
+ */
+func worldBytes(size int) uint64 {
+	cells := uint64(size * size)
+	grid := cells*uint64(unsafe.Sizeof(Creature{})) + uint64(size)*uint64(unsafe.Sizeof([]Creature{}))
+	masks := 3 * uint64(size) * uint64((size+63)/64) * 8
+	return grid + masks
+}
+
+/*!
+ * \brief Print the resolved configuration of a command.
+ * \param out Destination of the listing.
+ * \param c Resolved flags.
+ * \param seed The resolved seed.
+ *
+ * Every flag of the command is listed, including the command's own
+ * flags, with the source of its value.
+ */
+func writeDryRun(out io.Writer, c *configFlags, seed int64) {
+	fmt.Fprintln(out, "Resolved configuration:")
+	// Columns as wide as the longest name and value; a long list such as
+	// -eats only pushes out its own row
+	const maxValueWidth = 24
+	var rows [][3]string
+	nameWidth, valueWidth := 0, 0
+	c.fs.VisitAll(func(f *flag.Flag) {
+		if f.Name == "dry-run" {
+			return
+		}
+		source := "default"
+		switch {
+		case c.explicit[f.Name]:
+			source = "command line"
+		case c.sources[f.Name] != "":
+			source = c.sources[f.Name]
+		}
+		value := f.Value.String()
+		if f.Name == "seed" {
+			value = fmt.Sprint(seed)
+			if *c.seed == 0 {
+				source = "derived from the clock"
+			}
+		}
+		rows = append(rows, [3]string{f.Name, value, source})
+		nameWidth, valueWidth = max(nameWidth, len(f.Name)), min(max(valueWidth, len(value)), maxValueWidth)
+	})
+	for _, r := range rows {
+		fmt.Fprintf(out, "  %-*s %-*s (%s)\n", nameWidth, r[0], valueWidth, r[1], r[2])
+	}
+
+	p := c.params
+	cells := p.GridSize * p.GridSize
+	water := cells
+	for _, land := range p.Land {
+		if land {
+			water--
+		}
+	}
+	if p.Land != nil {
+		fmt.Fprintf(out, "Terrain: %d land and %d water cells\n", cells-water, water)
+	}
+	if reef := countCells(p.Reef); reef > 0 {
+		fmt.Fprintf(out, "Reefs: %d water cells out of the sharks' reach\n", reef)
+	}
+	if p.Pollution.enabled() {
+		fmt.Fprintf(out, "Pollution: %d sources, diffusion %g, decay %g\n", len(p.Pollution.Sources), p.Pollution.Diffusion, p.Pollution.Decay)
+	}
+	if e := p.Eggs; e.enabled() {
+		fmt.Fprintf(out, "Eggs: fish hatch after %d chronons, sharks after %d\n", e.Fish, e.Shark)
+	}
+	if p.Sexes.Enabled {
+		fmt.Fprintf(out, "Sexes: females breed next to a male, mate bias %g\n", p.Sexes.MateBias)
+	}
+	if p.Strategies.Mutation > 0 {
+		fmt.Fprintf(out, "Strategies: newborns switch movement strategy with probability %g\n", p.Strategies.Mutation)
+	}
+	if p.Web.enabled() {
+		fmt.Fprintf(out, "Food web: %d registered species, %d creatures; eats %q\n", len(p.Web.Species), p.Web.population(), p.Web.formatDiet())
+	}
+	if p.Introductions != nil {
+		fmt.Fprintf(out, "Introductions: %q\n", formatIntroductions(p.Introductions, &p.Web))
+	}
+	if p.MoveWeights != nil {
+		fmt.Fprintf(out, "Movement weights: %q\n", formatMoveWeights(p.MoveWeights, &p.Web))
+	}
+	if w := p.Whales; w.Count > 0 {
+		fmt.Fprintf(out, "Whales: %d of %dx%d cells, placed after the fish and sharks\n", w.Count, w.Size, w.Size)
+	}
+	if zone := countCells(tidalZone(*p)); zone > 0 {
+		fmt.Fprintf(out, "Tides: %d water cells lie dry for %g of every %d chronons\n", zone, p.Tide.Low, p.Tide.Period)
+	}
+	if d := p.DayNight; d.Length > 0 {
+		fmt.Fprintf(out, "Day/night: %d-chronon cycle, %g night, amplitude %g\n", d.Length, d.Night, d.Amplitude)
+	}
+	if c := p.Climate; c.enabled() {
+		fmt.Fprintf(out, "Climate: %g at the equator, %g at the poles, warming %g per 1000 chronons\n",
+			c.Equator, c.Equator-c.Gradient, c.Warming)
+	}
+	if p.Layout != nil {
+		fmt.Fprintf(out, "Layout: %d fish and %d sharks placed from the layout\n", p.NumFish, p.NumShark)
+	}
+	if c.description != "" {
+		fmt.Fprintf(out, "Description: %s\n", c.description)
+	}
+
+	// Stepping keeps the old and the new world alive at the same time
+	worlds := 2 * worldBytes(p.GridSize)
+	frame := uint64(cells) * uint64(unsafe.Sizeof(Species(0)))
+	fmt.Fprintln(out, "Estimated memory:")
+	fmt.Fprintf(out, "  worlds while stepping  %s\n", formatBytes(worlds))
+	fmt.Fprintf(out, "  frames                 %s each, up to %d buffered per renderer or sink (%s)\n",
+		formatBytes(frame), frameBuffer, formatBytes(frame*frameBuffer))
+}
@@ -0,0 +1,157 @@
+/*!
+ * \file edges.go
+ * \brief Bounded worlds that exchange creatures with a larger ocean at their edges.
+ *
+ * The classic Wa-Tor world is a torus. With -topology bounded its edges
+ * are walls instead, and the world can be opened up: every chronon, an
+ * empty water cell on the edge may receive a fish or shark swimming in
+ * from outside, and a creature on the edge may swim off and leave. This
+ * models a patch of a larger ocean, where a local extinction can be
+ * undone by immigrants.
+ *
+ * The exchange happens after the creatures have moved, in one serial
+ * pass over the edge cells, so it draws from the simulation's random
+ * source in the same order however the grid was stepped.
+ */
+
+package main
+
+import (
+	"fmt"
+	"math/rand"
+)
+
+/*!
+ * \brief Parse the name of a topology.
+ * \param name "torus" or "bounded".
+ * \return Whether the world is bounded, or an error if the name is unknown.
+ */
+func parseTopology(name string) (bool, error) {
+	switch name {
+	case "torus":
+		return false, nil
+	case "bounded":
+		return true, nil
+	}
+	return false, fmt.Errorf("unknown topology %q (want torus or bounded)", name)
+}
+
+/*!
+ * \brief Name of a topology.
+ * \param bounded Whether the world is bounded.
+ * \return "bounded" or "torus".
+ */
+func topologyName(bounded bool) string {
+	if bounded {
+		return "bounded"
+	}
+	return "torus"
+}
+
+/*!
+ * \brief Check the exchange rates of an open world.
+ * \param params Resolved parameters.
+ * \return An error for a rate outside [0, 1], immigration rates adding up
+ *         to more than 1, or rates on a torus.
+ */
+func checkExchange(params Config) error {
+	rates := []struct {
+		name string
+		v    float64
+	}{{"immigrate-fish", params.ImmigrateFish}, {"immigrate-sharks", params.ImmigrateSharks}, {"emigrate", params.Emigrate}}
+	open := false
+	for _, r := range rates {
+		if !(r.v >= 0 && r.v <= 1) {
+			return fmt.Errorf("-%s must be between 0 and 1, not %g", r.name, r.v)
+		}
+		open = open || r.v > 0
+	}
+	if params.ImmigrateFish+params.ImmigrateSharks > 1 {
+		return fmt.Errorf("-immigrate-fish and -immigrate-sharks add up to more than 1")
+	}
+	if open && !params.Bounded {
+		return fmt.Errorf("immigration and emigration need -topology bounded")
+	}
+	return nil
+}
+
+/*!
+ * \brief Check whether a world exchanges creatures at its edges.
+ * \param params Simulation parameters.
+ * \return True if any exchange rate is set.
+ */
+func isOpen(params Config) bool {
+	return params.Bounded && (params.ImmigrateFish > 0 || params.ImmigrateSharks > 0 || params.Emigrate > 0)
+}
+
+/*!
+ * \brief Let creatures leave and enter a world at its edges.
+ * \param w The world after the chronon's moves.
+ * \param params Simulation parameters with the exchange rates.
+ * \param rng Random source of the simulation.
+ *
+ * The edge cells are visited once each, clockwise from the top left
+ * corner. A creature there leaves with probability params.Emigrate; an
+ * empty water cell receives a fish with probability params.ImmigrateFish
+ * or else a shark with probability params.ImmigrateSharks. Immigrants
+ * arrive newborn with full energy, like spawned creatures; a shark bound
+ * for a reef cell stays out. Land and the cells the tide exposes in the
+ * coming chronon are skipped. No random number is drawn for a rate of 0.
+ */
+func exchangeAtEdges(w *World, params Config, rng *rand.Rand) {
+	last := w.Size - 1
+	visit := func(x, y int) {
+		if w.isDry(x, y) {
+			return
+		}
+		if c := &w.Grid[x][y]; c.Species == Whale {
+			return
+		} else if c.Species != Empty {
+			if params.Emigrate > 0 && rng.Float64() < params.Emigrate {
+				w.record(deathEvent(Emigrated, c, x, y))
+				w.remove(x, y)
+			}
+			return
+		}
+		if params.ImmigrateFish+params.ImmigrateSharks == 0 {
+			return
+		}
+		r := rng.Float64()
+		species := Fish
+		switch {
+		case r < params.ImmigrateFish:
+		case r < params.ImmigrateFish+params.ImmigrateSharks:
+			species = Shark
+		default:
+			return
+		}
+		if species == Shark && w.isReef(x, y) {
+			return
+		}
+		c := Creature{ID: w.ids.next(), Species: species, Female: w.sexes.female(rng)}
+		if species == Shark {
+			c.Energy = int32(w.Starve)
+		} else {
+			c.Energy = w.fishEnergy.full()
+		}
+		w.put(x, y, &c)
+		w.record(Event{Kind: Immigrated, Species: species, ID: c.ID, X: x, Y: y})
+	}
+
+	if last == 0 {
+		visit(0, 0)
+		return
+	}
+	for x := 0; x < last; x++ {
+		visit(x, 0)
+	}
+	for y := 0; y < last; y++ {
+		visit(last, y)
+	}
+	for x := last; x > 0; x-- {
+		visit(x, last)
+	}
+	for y := last; y > 0; y-- {
+		visit(0, y)
+	}
+}
@@ -0,0 +1,72 @@
+/*!
+ * \file eggs.go
+ * \brief An egg stage between breeding and the newborn.
+ *
+ * A breeding creature leaves an egg that hatches after the egg time.
+ */
+
+package main
+
+import (
+	"fmt"
+	"math"
+)
+
+/*!
+ * \brief Egg times of the two species.
+ */
+type eggRules struct {
+	Fish  int ///< Chronons a fish egg takes to hatch (0 = newborns)
+	Shark int ///< Chronons a shark egg takes to hatch (0 = newborns)
+}
+
+/*!
+ * \brief Check the egg times.
+ * \param e The egg times.
+ * \return An error naming the first setting out of range.
+ */
+func (e eggRules) check() error {
+	switch {
+	case e.Fish < 0 || e.Fish > math.MaxInt16:
+		return fmt.Errorf("-fish-egg-time must be between 0 and %d, not %d", math.MaxInt16, e.Fish)
+	case e.Shark < 0 || e.Shark > math.MaxInt16:
+		return fmt.Errorf("-shark-egg-time must be between 0 and %d, not %d", math.MaxInt16, e.Shark)
+	}
+	return nil
+}
+
+/*!
+ * \brief Check whether breeding lays eggs.
+ * \return True if either species has an egg time.
+ */
+func (e *eggRules) enabled() bool {
+	return e.Fish > 0 || e.Shark > 0
+}
+
+/*!
+ * \brief Hatch counter of a newly laid egg.
+ * \param species The species of the parent.
+ * \return The egg time of fish and sharks, 0 for a newborn; the registered
+ *         species of a food web have no egg stage.
+ */
+func (e *eggRules) hatch(species Species) int16 {
+	switch species {
+	case Fish:
+		return int16(e.Fish)
+	case Shark:
+		return int16(e.Shark)
+	}
+	return 0
+}
+
+/*!
+ * \brief Let an egg sit out a chronon.
+ * \param newWorld Next world state.
+ * \param x X position of the egg.
+ * \param y Y position of the egg.
+ * \param egg The egg, in oldWorld's grid; updated in place, then copied to newWorld.
+ */
+func incubate(newWorld *World, x, y int, egg *Creature) {
+	egg.Hatch--
+	newWorld.put(x, y, egg)
+}
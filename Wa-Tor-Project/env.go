@@ -0,0 +1,47 @@
+/*!
+ * \file env.go
+ * \brief Configuration overrides from WATOR_* environment variables.
+ *
+ * Every flag can also be set from the environment, so containerised runs
+ * can be configured without rewriting files: -grid is WATOR_GRID,
+ * -init-pattern is WATOR_INIT_PATTERN, and so on. The environment
+ * overrides config files, scenarios and presets; the command line
+ * overrides the environment.
+ */
+
+package main
+
+import (
+	"flag"
+	"os"
+	"strings"
+)
+
+/*!
+ * \brief Prefix of the environment variables that set flags.
+ */
+const envPrefix = "WATOR_"
+
+/*!
+ * \brief Name of the environment variable that sets a flag.
+ * \param flagName Flag name, e.g. "init-pattern".
+ * \return The variable name, e.g. "WATOR_INIT_PATTERN".
+ */
+func envName(flagName string) string {
+	return envPrefix + strings.ToUpper(strings.ReplaceAll(flagName, "-", "_"))
+}
+
+/*!
+ * \brief Collect the flag values set in the environment.
+ * \param fs Flag set whose flags are looked up.
+ * \return Flag name to value for every non-empty WATOR_* variable naming a flag of fs.
+ */
+func envFlagValues(fs *flag.FlagSet) map[string]string {
+	values := map[string]string{}
+	fs.VisitAll(func(f *flag.Flag) {
+		if v := os.Getenv(envName(f.Name)); v != "" {
+			values[f.Name] = v
+		}
+	})
+	return values
+}
@@ -0,0 +1,174 @@
+/*!
+ * \file event.go
+ * \brief Births and deaths recorded while a chronon is processed.
+ */
+
+package main
+
+/*!
+ * \brief Kind of a simulation event.
+ */
+type EventKind int
+
+const (
+	Birth      EventKind = iota ///< A creature reproduced; the newborn is at (X, Y)
+	Eaten                       ///< A creature at (X, Y) was eaten: a fish by a shark, or any prey of a food web
+	Starved                     ///< A shark, or a fish with an energy budget, at (X, Y) ran out of energy
+	Spawn                       ///< A creature was placed at (X, Y) when the world was populated
+	Immigrated                  ///< A creature swam in from outside a bounded world at the edge cell (X, Y)
+	Emigrated                   ///< A creature left a bounded world from the edge cell (X, Y)
+	Introduced                  ///< A creature was released at (X, Y) by a scheduled introduction
+	Died                        ///< A fish or shark at (X, Y) died of background mortality
+	Conflict                    ///< A creature won the cell (X, Y) that a rival also moved to
+)
+
+/*!
+ * \brief Lower-case name of an event kind.
+ * \return "birth", "eaten", "starved", "spawn", "immigrated", "emigrated", "introduced", "died" or
+ *         "conflict".
+ */
+func (k EventKind) String() string {
+	switch k {
+	case Birth:
+		return "birth"
+	case Eaten:
+		return "eaten"
+	case Starved:
+		return "starved"
+	case Spawn:
+		return "spawn"
+	case Immigrated:
+		return "immigrated"
+	case Emigrated:
+		return "emigrated"
+	case Introduced:
+		return "introduced"
+	case Died:
+		return "died"
+	case Conflict:
+		return "conflict"
+	}
+	return "unknown"
+}
+
+/*!
+ * \brief Something that happened to a creature during a chronon.
+ */
+type Event struct {
+	Kind     EventKind ///< What happened
+	Species  Species   ///< Species of the creature it happened to
+	ID       int       ///< ID of the creature it happened to (the newborn for Birth)
+	ParentID int       ///< ID of the parent (Birth only)
+	Rival    int       ///< ID of the creature that lost the cell (Conflict only)
+	X, Y     int       ///< Cell where it happened
+
+	// Life summary of the creature, set for deaths (Eaten, Starved, Died) and Emigrated
+	Age       int ///< Age in chronons
+	Offspring int ///< Number of offspring produced
+	Kills     int ///< Fish eaten (sharks only)
+}
+
+/*!
+ * \brief Number of events of each kind in a chronon.
+ */
+type eventCounts struct {
+	FishBirths    int ///< Fish born
+	SharkBirths   int ///< Sharks born
+	FishEaten     int ///< Fish eaten by sharks (or other predators of a food web)
+	SharksEaten   int ///< Sharks eaten by the predators of a food web
+	FishStarved   int ///< Fish that starved
+	SharksStarved int ///< Sharks that starved
+	FishDied      int ///< Fish that died of background mortality
+	SharksDied    int ///< Sharks that died of background mortality
+	FishIn        int ///< Fish that swam into a bounded world or were introduced
+	SharksIn      int ///< Sharks that swam into a bounded world or were introduced
+	FishOut       int ///< Fish that left a bounded world
+	SharksOut     int ///< Sharks that left a bounded world
+}
+
+/*!
+ * \brief Change of the fish population.
+ * \return Births and immigrants minus the fish eaten, starved or dead otherwise and emigrants.
+ */
+func (n eventCounts) fishChange() int {
+	return n.FishBirths + n.FishIn - n.FishEaten - n.FishStarved - n.FishDied - n.FishOut
+}
+
+/*!
+ * \brief Change of the shark population.
+ * \return Births and immigrants minus the sharks eaten, starved or dead otherwise and emigrants.
+ */
+func (n eventCounts) sharkChange() int {
+	return n.SharkBirths + n.SharksIn - n.SharksEaten - n.SharksStarved - n.SharksDied - n.SharksOut
+}
+
+/*!
+ * \brief Count births and deaths.
+ * \param events Events of a chronon.
+ * \return The counts per kind.
+ */
+func countEvents(events []Event) eventCounts {
+	var n eventCounts
+	for _, ev := range events {
+		switch {
+		case ev.Kind == Birth && ev.Species == Fish:
+			n.FishBirths++
+		case ev.Kind == Birth && ev.Species == Shark:
+			n.SharkBirths++
+		case ev.Kind == Eaten && ev.Species == Fish:
+			n.FishEaten++
+		case ev.Kind == Eaten && ev.Species == Shark:
+			n.SharksEaten++
+		case ev.Kind == Starved && ev.Species == Fish:
+			n.FishStarved++
+		case ev.Kind == Starved && ev.Species == Shark:
+			n.SharksStarved++
+		case ev.Kind == Died && ev.Species == Fish:
+			n.FishDied++
+		case ev.Kind == Died && ev.Species == Shark:
+			n.SharksDied++
+		case (ev.Kind == Immigrated || ev.Kind == Introduced) && ev.Species == Fish:
+			n.FishIn++
+		case (ev.Kind == Immigrated || ev.Kind == Introduced) && ev.Species == Shark:
+			n.SharksIn++
+		case ev.Kind == Emigrated && ev.Species == Fish:
+			n.FishOut++
+		case ev.Kind == Emigrated && ev.Species == Shark:
+			n.SharksOut++
+		}
+	}
+	return n
+}
+
+/*!
+ * \brief Build the event for the death of a creature.
+ * \param kind Eaten, Starved, Died or Emigrated.
+ * \param c The creature that died.
+ * \param x X position where it died.
+ * \param y Y position where it died.
+ * \return The event, including the creature's life summary.
+ */
+func deathEvent(kind EventKind, c *Creature, x, y int) Event {
+	return Event{
+		Kind:      kind,
+		Species:   c.Species,
+		ID:        c.ID,
+		X:         x,
+		Y:         y,
+		Age:       int(c.Age),
+		Offspring: int(c.Offspring),
+		Kills:     int(c.Kills),
+	}
+}
+
+/*!
+ * \brief Append an event to the world's event list.
+ * \param ev The event to record.
+ *
+ * Safe to call from the parallel tile workers.
+ */
+func (w *World) record(ev Event) {
+	w.mu.Lock()
+	w.Events = append(w.Events, ev)
+	w.mu.Unlock()
+}
@@ -0,0 +1,270 @@
+/*!
+ * \file evolve.go
+ * \brief Genetic algorithm evolving parameter sets.
+ *
+ * A population of parameter sets is evolved over generations by
+ * tournament selection, uniform crossover and mutation, keeping the best
+ * individuals unchanged. Every candidate of a generation is simulated in
+ * parallel with the headless runner.
+ */
+
+package main
+
+import (
+	"encoding/csv"
+	"fmt"
+	"io"
+	"math"
+	"math/rand"
+	"os"
+	"sort"
+	"strconv"
+)
+
+/*!
+ * \brief A parameter set and its fitness.
+ */
+type individual struct {
+	Values  []int   ///< Value of each tunable parameter
+	Fitness float64 ///< Mean fitness over the evaluation runs
+}
+
+/*!
+ * \brief Fitness of one run for a named objective.
+ * \param objective "survival" or "oscillation".
+ * \param t The trajectory.
+ * \return The fitness, higher is better.
+ *
+ * Survival scores like the tuner: coexistence time as a fraction of the
+ * run plus a tenth of the robustness. Oscillation rewards runs in which
+ * both species survive and the sharks cycle with a large amplitude
+ * relative to their mean.
+ */
+func runFitness(objective string, t trajectory) float64 {
+	persistence, robustness := coexistence(t)
+	if objective == "oscillation" {
+		if persistence < 1 {
+			return persistence
+		}
+		tail := t.Sharks[len(t.Sharks)/2:]
+		mean := 0.0
+		for _, v := range tail {
+			mean += float64(v)
+		}
+		mean /= float64(len(tail))
+		return 1 + math.Min(1, oscillationAmplitude(t.Sharks)/mean)
+	}
+	return persistence + 0.1*robustness
+}
+
+/*!
+ * \brief Entry point of the evolve subcommand.
+ * \param args Command-line arguments after "evolve".
+ * \return Process exit code.
+ */
+func evolveCommand(args []string) int {
+	fs := newCommandFlags("evolve")
+	params := defaultConfig()
+	cfg := registerConfigFlags(fs, &params)
+	objective := fs.String("fitness", "survival", "fitness objective: survival or oscillation")
+	popSize := fs.Int("population", 24, "individuals per generation")
+	generations := fs.Int("generations", 15, "number of generations")
+	elite := fs.Int("elite", 2, "best individuals copied unchanged into the next generation")
+	mutation := fs.Float64("mutation", 0.2, "probability that each parameter of a child mutates")
+	runs := fs.Int("runs", 2, "seeds per individual")
+	horizon := chrononsFlag(fs, "horizon", 1000, "chronons per run")
+	out := fs.String("out", "", "also write the best and mean fitness per generation to this CSV `file`")
+	if err := fs.Parse(args); err != nil {
+		return flagExit(err)
+	}
+
+	seed, err := cfg.resolve()
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		return exitConfig
+	}
+	if *cfg.dryRun {
+		writeDryRun(os.Stdout, cfg, seed)
+		return exitOK
+	}
+	if *objective != "survival" && *objective != "oscillation" {
+		fmt.Fprintf(os.Stderr, "unknown fitness %q (want survival or oscillation)\n", *objective)
+		return exitConfig
+	}
+	if *popSize < 2 || *generations < 1 || *runs < 1 || *horizon < 2 || *elite < 0 || *elite >= *popSize {
+		fmt.Fprintln(os.Stderr, "evolve: need -population >= 2, 0 <= -elite < -population, and -generations, -runs, -chronons >= 1")
+		return exitConfig
+	}
+
+	history := evolve(os.Stdout, params, *objective, *popSize, *generations, *elite, *mutation, *runs, *horizon, seed)
+	if *out != "" {
+		if err := writeEvolveCSV(*out, history); err != nil {
+			fmt.Fprintln(os.Stderr, err)
+			return exitFailure
+		}
+		fmt.Printf("Wrote %s\n", *out)
+	}
+	return exitOK
+}
+
+/*!
+ * \brief Summary of one generation.
+ */
+type generationStats struct {
+	Best individual ///< Fittest individual
+	Mean float64    ///< Mean fitness of the generation
+}
+
+/*!
+ * \brief Evolve parameter sets.
+ * \param out Destination of per-generation progress.
+ * \param params Baseline parameters; the first individual is taken from them.
+ * \param objective Fitness objective.
+ * \param popSize Individuals per generation.
+ * \param generations Number of generations.
+ * \param elite Individuals carried over unchanged.
+ * \param mutation Per-parameter mutation probability.
+ * \param runs Seeds per individual.
+ * \param horizon Chronons per run.
+ * \param seed Seed of the algorithm; runs use seed, seed+1, ...
+ * \return Statistics of every generation.
+ */
+func evolve(out io.Writer, params Config, objective string, popSize, generations, elite int, mutation float64, runs, horizon int, seed int64) []generationStats {
+	rng := rand.New(rand.NewSource(seed))
+	seeds := make([]int64, runs)
+	for i := range seeds {
+		seeds[i] = seed + int64(i)
+	}
+
+	// First generation: the baseline plus random parameter sets
+	randomValue := func(j int) int {
+		lo, hi := tuneBounds(tunableParams[j], params)
+		return lo + rng.Intn(hi-lo+1)
+	}
+	pop := make([]individual, popSize)
+	for i := range pop {
+		pop[i].Values = make([]int, len(tunableParams))
+		for j, p := range tunableParams {
+			if i == 0 {
+				lo, hi := tuneBounds(p, params)
+				pop[i].Values[j] = max(lo, min(hi, *p.Field(&params)))
+			} else {
+				pop[i].Values[j] = randomValue(j)
+			}
+		}
+	}
+
+	fmt.Fprintf(out, "Evolving %d individuals for %d generations (fitness %s, %d runs x %d chronons)\n",
+		popSize, generations, objective, runs, horizon)
+	history := []generationStats{}
+	for g := 0; g < generations; g++ {
+		evaluatePopulation(pop, params, objective, seeds, horizon)
+		sort.SliceStable(pop, func(a, b int) bool { return pop[a].Fitness > pop[b].Fitness })
+
+		stats := generationStats{Best: pop[0]}
+		for _, ind := range pop {
+			stats.Mean += ind.Fitness / float64(popSize)
+		}
+		history = append(history, stats)
+		fmt.Fprintf(out, "  generation %2d: best %.3f mean %.3f  %s\n", g, stats.Best.Fitness, stats.Mean, formatTuneValues(stats.Best.Values))
+
+		if g == generations-1 {
+			break
+		}
+
+		// Next generation: elites, then children of tournament winners
+		tournament := func() individual {
+			a, b := pop[rng.Intn(popSize)], pop[rng.Intn(popSize)]
+			if a.Fitness >= b.Fitness {
+				return a
+			}
+			return b
+		}
+		next := make([]individual, 0, popSize)
+		for i := 0; i < elite; i++ {
+			next = append(next, individual{Values: pop[i].Values})
+		}
+		for len(next) < popSize {
+			mum, dad := tournament(), tournament()
+			child := make([]int, len(tunableParams))
+			for j := range child {
+				child[j] = mum.Values[j]
+				if rng.Intn(2) == 0 {
+					child[j] = dad.Values[j]
+				}
+				if rng.Float64() < mutation {
+					lo, hi := tuneBounds(tunableParams[j], params)
+					delta := max(1, child[j]/5)
+					child[j] = max(lo, min(hi, child[j]+rng.Intn(2*delta+1)-delta))
+				}
+			}
+			next = append(next, individual{Values: child})
+		}
+		pop = next
+	}
+
+	fmt.Fprintf(out, "Best: %s (fitness %.3f)\n", formatTuneValues(history[len(history)-1].Best.Values), history[len(history)-1].Best.Fitness)
+	return history
+}
+
+/*!
+ * \brief Simulate every individual of a generation in parallel.
+ * \param pop The individuals; their Fitness is set.
+ * \param params Baseline parameters.
+ * \param objective Fitness objective.
+ * \param seeds Seeds of the runs of each individual.
+ * \param horizon Chronons per run.
+ */
+func evaluatePopulation(pop []individual, params Config, objective string, seeds []int64, horizon int) {
+	params.Workers = 1
+	fitness := make([]float64, len(pop)*len(seeds))
+	parallelFor(len(fitness), func(i int) {
+		c := params
+		for j, p := range tunableParams {
+			*p.Field(&c) = pop[i/len(seeds)].Values[j]
+		}
+		fitness[i] = runFitness(objective, runTrajectory(c, seeds[i%len(seeds)], horizon))
+	})
+	for i := range pop {
+		pop[i].Fitness = 0
+		for _, f := range fitness[i*len(seeds) : (i+1)*len(seeds)] {
+			pop[i].Fitness += f / float64(len(seeds))
+		}
+	}
+}
+
+/*!
+ * \brief Write the fitness history as CSV.
+ * \param path Output file path.
+ * \param history Statistics of every generation.
+ * \return Any file error.
+ */
+func writeEvolveCSV(path string, history []generationStats) error {
+	file, err := os.Create(path)
+	if err != nil {
+		return err
+	}
+	w := csv.NewWriter(file)
+	header := []string{"generation", "best_fitness", "mean_fitness"}
+	for _, p := range tunableParams {
+		header = append(header, "best_"+p.Name)
+	}
+	w.Write(header)
+	for g, s := range history {
+		row := []string{
+			strconv.Itoa(g),
+			strconv.FormatFloat(s.Best.Fitness, 'f', 4, 64),
+			strconv.FormatFloat(s.Mean, 'f', 4, 64),
+		}
+		for _, v := range s.Best.Values {
+			row = append(row, strconv.Itoa(v))
+		}
+		w.Write(row)
+	}
+	w.Flush()
+	if err := w.Error(); err != nil {
+		file.Close()
+		return err
+	}
+	return file.Close()
+}
@@ -0,0 +1,68 @@
+/*!
+ * \file fishenergy.go
+ * \brief An energy budget for fish.
+ *
+ * Fish graze plankton every chronon and starve when moving has used up
+ * their energy.
+ */
+
+package main
+
+import "fmt"
+
+/*!
+ * \brief Energy budget of the fish.
+ */
+type fishEnergy struct {
+	Budget   int ///< Energy of a newborn fish and the most a fish can hold (0 = no budget)
+	Move     int ///< Energy a move costs
+	Plankton int ///< Energy a fish grazes every chronon
+}
+
+/*!
+ * \brief Check an energy budget.
+ * \param e The budget.
+ * \return An error naming the first setting out of range.
+ */
+func (e fishEnergy) check() error {
+	switch {
+	case e.Budget < 0:
+		return fmt.Errorf("-fish-energy must not be negative, not %d", e.Budget)
+	case e.Move < 0:
+		return fmt.Errorf("-fish-move-cost must not be negative, not %d", e.Move)
+	case e.Plankton < 0:
+		return fmt.Errorf("-plankton must not be negative, not %d", e.Plankton)
+	}
+	return nil
+}
+
+/*!
+ * \brief Energy of a newborn fish.
+ * \return The full budget, 0 without one.
+ */
+func (e *fishEnergy) full() int32 {
+	return int32(e.Budget)
+}
+
+/*!
+ * \brief Let a fish graze for the chronon.
+ * \param c The fish.
+ */
+func (e *fishEnergy) graze(c *Creature) {
+	if e.Budget > 0 {
+		c.Energy = min(c.Energy+int32(e.Plankton), int32(e.Budget))
+	}
+}
+
+/*!
+ * \brief Pay for a move.
+ * \param c The fish.
+ * \return False if the fish starved on the move.
+ */
+func (e *fishEnergy) spend(c *Creature) bool {
+	if e.Budget == 0 {
+		return true
+	}
+	c.Energy -= int32(e.Move)
+	return c.Energy > 0
+}
@@ -0,0 +1,289 @@
+/*!
+ * \file flow.go
+ * \brief The movement field: where fish and sharks are heading, region by region.
+ *
+ * A creature moves at most one cell per chronon, so where it came from is
+ * found by looking up its ID in the previous world at its own cell and
+ * its four neighbours. Frames carry the direction each creature moved in
+ * when something asks for it; the simulation itself is unchanged.
+ *
+ * The movement is summed per region of flowRegion by flowRegion cells
+ * over windows of flowWindow chronons. The net displacement of a region,
+ * divided by the creature-chronons counted, is its mean velocity in cells
+ * per chronon: near zero where creatures wander at random, pointing the
+ * way of a migration wave where they keep heading one way, and turning
+ * around the torus where the waves circle it. The flow sink writes it per
+ * window as CSV, and the live view of the serve subcommand draws it as
+ * arrows over the grid.
+ */
+
+package main
+
+import (
+	"encoding/csv"
+	"math"
+	"strconv"
+)
+
+/*!
+ * \brief Width and height of the regions the movement is summed over, in cells.
+ */
+const flowRegion = 8
+
+/*!
+ * \brief Chronons the movement is summed over.
+ */
+const flowWindow = 50
+
+/*!
+ * \brief Values of Frame.Moves besides the indices of directionNames.
+ */
+const (
+	moveUnknown int8 = -1 ///< No creature, or one that was not in the previous world
+	moveStayed  int8 = 4  ///< A creature that did not move
+)
+
+/*!
+ * \brief Opposite of each direction, in the order of directionNames.
+ */
+var oppositeDirection = [4]int8{1, 0, 3, 2}
+
+/*!
+ * \brief Displacement of each direction, in the order of directionNames.
+ */
+var directionSteps = [4][2]int32{{-1, 0}, {1, 0}, {0, -1}, {0, 1}}
+
+/*!
+ * \brief Direction every creature moved in during a chronon.
+ * \param before The world before the chronon.
+ * \param after The world after it.
+ * \return Per cell of after, row-major: the index in directionNames of
+ *         the move of its creature, moveStayed, or moveUnknown.
+ */
+func movesOf(before, after *World) []int8 {
+	moves := make([]int8, after.Size*after.Size)
+	for i := range moves {
+		moves[i] = moveUnknown
+	}
+	if before == nil || before.Size != after.Size {
+		return moves
+	}
+	for x := 0; x < after.Size; x++ {
+		for y := 0; y < after.Size; y++ {
+			c := &after.Grid[x][y]
+			if c.Species == Empty || c.Species == Whale {
+				continue
+			}
+			if before.Grid[x][y].ID == c.ID {
+				moves[y*after.Size+x] = moveStayed
+				continue
+			}
+			for _, pos := range getAdjacentPositions(x, y, after.Size, after.bounded) {
+				if pos != [2]int{x, y} && before.Grid[pos[0]][pos[1]].ID == c.ID {
+					moves[y*after.Size+x] = oppositeDirection[direction(x, y, pos, after.Size)]
+					break
+				}
+			}
+		}
+	}
+	return moves
+}
+
+/*!
+ * \brief Movement of fish and sharks summed per region.
+ */
+type flowField struct {
+	size    int        ///< Width/height of the grid
+	regions int        ///< Regions per row and column
+	from    int        ///< First chronon summed
+	fish    []flowCell ///< Movement of the fish per region, row-major
+	sharks  []flowCell ///< Movement of the sharks per region, row-major
+}
+
+/*!
+ * \brief Movement summed over one region.
+ */
+type flowCell struct {
+	DX, DY int32 ///< Net displacement in cells
+	N      int32 ///< Creature-chronons counted, moves and stays
+}
+
+/*!
+ * \brief Mean velocity of a region.
+ * \return Cells per chronon along x and y; 0 without creatures.
+ */
+func (c flowCell) velocity() (float64, float64) {
+	if c.N == 0 {
+		return 0, 0
+	}
+	return float64(c.DX) / float64(c.N), float64(c.DY) / float64(c.N)
+}
+
+/*!
+ * \brief Create an empty movement field.
+ * \param size Width/height of the grid.
+ * \param from First chronon to be summed.
+ * \return The field.
+ */
+func newFlowField(size, from int) *flowField {
+	regions := (size + flowRegion - 1) / flowRegion
+	return &flowField{size: size, regions: regions, from: from,
+		fish: make([]flowCell, regions*regions), sharks: make([]flowCell, regions*regions)}
+}
+
+/*!
+ * \brief Add the moves of a frame.
+ * \param f The frame, with moves (see Frame.HasMoves).
+ */
+func (ff *flowField) add(f *Frame) {
+	for i, m := range f.Moves() {
+		if m == moveUnknown {
+			continue
+		}
+		var cells []flowCell
+		switch f.Cell(i) {
+		case Fish:
+			cells = ff.fish
+		case Shark:
+			cells = ff.sharks
+		default:
+			continue
+		}
+		c := &cells[i/f.Size/flowRegion*ff.regions+i%f.Size/flowRegion]
+		if m != moveStayed {
+			c.DX += directionSteps[m][0]
+			c.DY += directionSteps[m][1]
+		}
+		c.N++
+	}
+}
+
+/*!
+ * \brief Check whether the window of a field is complete.
+ * \param chronon Chronon of the last frame added.
+ * \return True after flowWindow chronons.
+ */
+func (ff *flowField) full(chronon int) bool {
+	return chronon-ff.from >= flowWindow-1
+}
+
+/*!
+ * \brief JSON form of a movement field, as sent to the live view.
+ */
+type flowRecord struct {
+	Chronon int       `json:"chronon"` ///< Last chronon of the window
+	Region  int       `json:"region"`  ///< Width/height of a region in cells
+	Fish    []float64 `json:"fish"`    ///< Mean velocity of the fish per region, row-major, as x,y pairs
+	Sharks  []float64 `json:"sharks"`  ///< Mean velocity of the sharks, like Fish
+}
+
+/*!
+ * \brief Encode a movement field.
+ * \param chronon Last chronon of the window.
+ * \return The field with its velocities rounded to 1/1000 cell per chronon.
+ */
+func (ff *flowField) record(chronon int) *flowRecord {
+	r := &flowRecord{Chronon: chronon, Region: flowRegion}
+	round := func(v float64) float64 {
+		return math.Round(v*1000) / 1000
+	}
+	for i := range ff.fish {
+		fx, fy := ff.fish[i].velocity()
+		sx, sy := ff.sharks[i].velocity()
+		r.Fish = append(r.Fish, round(fx), round(fy))
+		r.Sharks = append(r.Sharks, round(sx), round(sy))
+	}
+	return r
+}
+
+/*!
+ * \brief Centre of a region.
+ * \param r Index of the region, row-major.
+ * \return Its x and y in cells, halfway across it.
+ */
+func (ff *flowField) centre(r int) (float64, float64) {
+	x, y := r%ff.regions*flowRegion, r/ff.regions*flowRegion
+	return float64(x) + float64(min(flowRegion, ff.size-x))/2, float64(y) + float64(min(flowRegion, ff.size-y))/2
+}
+
+/*!
+ * \brief Writes the movement field of every window as CSV.
+ */
+type flowSink struct {
+	*csvSink            ///< Output file and encoder
+	field    *flowField ///< Movement of the current window; nil before the first frame
+	last     int        ///< Chronon of the last frame added
+}
+
+/*!
+ * \brief Create a flow sink.
+ * \param path Output file path.
+ * \param params Parameters of the run (unused).
+ * \return The sink, or an error if the file cannot be created.
+ */
+func openFlowSink(path string, params Config) (Observer, error) {
+	file, err := createCSV(path)
+	if err != nil {
+		return nil, err
+	}
+	s := &flowSink{csvSink: &csvSink{file: file, w: csv.NewWriter(file)}}
+	s.w.Write([]string{"chronon", "x", "y", "fish_dx", "fish_dy", "fish_samples", "shark_dx", "shark_dy", "shark_samples"})
+	return s, nil
+}
+
+/*!
+ * \brief Add the moves of a frame, writing the window when it is complete.
+ * \param f The frame to record.
+ * \return Any write error.
+ */
+func (s *flowSink) Observe(f *Frame) error {
+	if s.field == nil || s.field.size != f.Size {
+		s.field = newFlowField(f.Size, f.Chronon)
+	}
+	s.field.add(f)
+	s.last = f.Chronon
+	if !s.field.full(f.Chronon) {
+		return nil
+	}
+	err := s.write(f.Chronon)
+	s.field = nil
+	return err
+}
+
+/*!
+ * \brief Write the window cut short by the end of the run, if any, and close the file.
+ * \return Any write or close error.
+ */
+func (s *flowSink) Close() error {
+	var err error
+	if s.field != nil {
+		err = s.write(s.last)
+	}
+	if cerr := s.csvSink.Close(); err == nil {
+		err = cerr
+	}
+	return err
+}
+
+/*!
+ * \brief Write a row per region of the current window.
+ * \param chronon Last chronon of the window.
+ * \return Any write error.
+ */
+func (s *flowSink) write(chronon int) error {
+	format := func(v float64) string {
+		return strconv.FormatFloat(v, 'f', 4, 64)
+	}
+	for r := range s.field.fish {
+		x, y := s.field.centre(r)
+		fdx, fdy := s.field.fish[r].velocity()
+		sdx, sdy := s.field.sharks[r].velocity()
+		err := s.w.Write([]string{strconv.Itoa(chronon), format(x), format(y),
+			format(fdx), format(fdy), strconv.Itoa(int(s.field.fish[r].N)),
+			format(sdx), format(sdy), strconv.Itoa(int(s.field.sharks[r].N))})
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
@@ -0,0 +1,608 @@
+/*!
+ * \file foodweb.go
+ * \brief Registered species and a predation matrix between all species.
+ *
+ * Registered species follow the shark rules in their simple form, eating
+ * what the matrix lists.
+ */
+
+package main
+
+import (
+	"encoding/csv"
+	"errors"
+	"fmt"
+	"image/color"
+	"math/bits"
+	"math/rand"
+	"strconv"
+	"strings"
+)
+
+/*!
+ * \brief Species value of the first registered species; the others follow.
+ */
+const firstWebSpecies = Whale + 1
+
+/*!
+ * \brief Most species that can be registered.
+ */
+const maxWebSpecies = 8
+
+/*!
+ * \brief Number of species values, registered ones included.
+ */
+const speciesCount = int(firstWebSpecies) + maxWebSpecies
+
+/*!
+ * \brief A species registered with -species.
+ */
+type webSpecies struct {
+	Name   string ///< Name used in -eats and the outputs
+	Char   byte   ///< Map character of the plain renderer
+	Count  int    ///< Creatures placed at the start
+	Breed  int    ///< Chronons needed to reproduce
+	Starve int    ///< Energy of a newborn and the most a creature can have (0 = never starves)
+}
+
+/*!
+ * \brief An entry of the predation matrix.
+ */
+type predation struct {
+	Predator Species ///< Species that eats
+	Prey     Species ///< Species eaten
+	Gain     int     ///< Energy a meal gives (0 = a full meal)
+}
+
+/*!
+ * \brief Registered species and who eats whom.
+ */
+type foodWeb struct {
+	Species []webSpecies ///< Registered species, as Species values from firstWebSpecies on
+	Diet    []predation  ///< The predation matrix, one entry per predator and prey
+}
+
+/*!
+ * \brief Predation matrix of the classic rules.
+ * \return Sharks eat fish as a full meal.
+ */
+func classicDiet() []predation {
+	return []predation{{Predator: Shark, Prey: Fish}}
+}
+
+/*!
+ * \brief Check whether a food web differs from the classic rules.
+ * \return True with registered species or a matrix other than "shark fish".
+ */
+func (f *foodWeb) enabled() bool {
+	return len(f.Species) > 0 || len(f.Diet) != 1 || f.Diet[0] != classicDiet()[0]
+}
+
+/*!
+ * \brief The registered species with a Species value.
+ * \param s The value.
+ * \return The species, or nil if s is not registered.
+ */
+func (f *foodWeb) registered(s Species) *webSpecies {
+	if s < firstWebSpecies || int(s-firstWebSpecies) >= len(f.Species) {
+		return nil
+	}
+	return &f.Species[s-firstWebSpecies]
+}
+
+/*!
+ * \brief Name of a species in a food web.
+ * \param s The species.
+ * \return "fish", "shark" or the registered name.
+ */
+func (f *foodWeb) name(s Species) string {
+	if r := f.registered(s); r != nil {
+		return r.Name
+	}
+	return s.String()
+}
+
+/*!
+ * \brief Look up a species by name.
+ * \param name "fish", "shark", "sharks" or a registered name.
+ * \return The species, or false for an unknown name.
+ */
+func (f *foodWeb) lookup(name string) (Species, bool) {
+	switch name {
+	case "fish":
+		return Fish, true
+	case "shark", "sharks":
+		return Shark, true
+	}
+	for i, r := range f.Species {
+		if r.Name == name {
+			return firstWebSpecies + Species(i), true
+		}
+	}
+	return Empty, false
+}
+
+/*!
+ * \brief Check a food web, as restored from a file.
+ * \return An error for a species or diet entry -species or -eats would reject.
+ */
+func (f *foodWeb) check() error {
+	if len(f.Species) > maxWebSpecies {
+		return fmt.Errorf("%d species registered, at most %d allowed", len(f.Species), maxWebSpecies)
+	}
+	for _, r := range f.Species {
+		if r.Count < 0 || r.Breed < 1 || r.Starve < 0 {
+			return fmt.Errorf("species %q needs a count of at least 0, a breed time of at least 1 and a starve time of at least 0", r.Name)
+		}
+	}
+	for _, p := range f.Diet {
+		for _, s := range []Species{p.Predator, p.Prey} {
+			if s != Fish && s != Shark && f.registered(s) == nil {
+				return fmt.Errorf("-eats refers to %s, which is not registered", s)
+			}
+		}
+		if p.Predator == p.Prey || p.Gain < 0 {
+			return fmt.Errorf("-eats entry %q is invalid", f.name(p.Predator)+" "+f.name(p.Prey))
+		}
+	}
+	return nil
+}
+
+/*!
+ * \brief Number of creatures of the registered species placed at the start.
+ * \return The sum of their counts.
+ */
+func (f *foodWeb) population() int {
+	n := 0
+	for _, r := range f.Species {
+		n += r.Count
+	}
+	return n
+}
+
+/*!
+ * \brief Parse registered species.
+ * \param s Species "NAME CHAR COUNT BREED [STARVE]; ...".
+ * \return The species, or an error for a malformed, duplicate or reserved one.
+ */
+func parseWebSpecies(s string) ([]webSpecies, error) {
+	var species []webSpecies
+	for _, text := range strings.Split(s, ";") {
+		text = strings.TrimSpace(text)
+		if text == "" {
+			continue
+		}
+		fields := strings.Fields(text)
+		var r webSpecies
+		var err error
+		if len(fields) < 4 || len(fields) > 5 || len(fields[1]) != 1 {
+			err = errors.New("bad fields")
+		} else {
+			r.Name, r.Char = fields[0], fields[1][0]
+			_, err = fmt.Sscanf(strings.Join(fields[2:], " "), "%d %d", &r.Count, &r.Breed)
+			if err == nil && len(fields) == 5 {
+				_, err = fmt.Sscanf(fields[4], "%d", &r.Starve)
+			}
+		}
+		if err != nil {
+			return nil, fmt.Errorf("invalid species %q: want NAME CHAR COUNT BREED [STARVE]", text)
+		}
+		if r.Count < 0 || r.Breed < 1 || r.Starve < 0 {
+			return nil, fmt.Errorf("invalid species %q: need a count of at least 0, a breed time of at least 1 and a starve time of at least 0", text)
+		}
+		if strings.IndexByte(speciesChars+"~fs", r.Char) >= 0 || r.Char <= ' ' || r.Char > '~' {
+			return nil, fmt.Errorf("invalid species %q: %q is not a free printable map character", text, r.Char)
+		}
+		for _, reserved := range []string{"empty", "fish", "shark", "sharks", "land", "whale"} {
+			if r.Name == reserved {
+				return nil, fmt.Errorf("invalid species %q: %q is a built-in name", text, r.Name)
+			}
+		}
+		for _, o := range species {
+			if o.Name == r.Name || o.Char == r.Char {
+				return nil, fmt.Errorf("invalid species %q: name or character already registered", text)
+			}
+		}
+		species = append(species, r)
+	}
+	if len(species) > maxWebSpecies {
+		return nil, fmt.Errorf("%d species registered, at most %d allowed", len(species), maxWebSpecies)
+	}
+	return species, nil
+}
+
+/*!
+ * \brief Format registered species for the -species flag.
+ * \param species The species.
+ * \return The species joined with "; ".
+ */
+func formatWebSpecies(species []webSpecies) string {
+	texts := make([]string, len(species))
+	for i, r := range species {
+		texts[i] = fmt.Sprintf("%s %c %d %d %d", r.Name, r.Char, r.Count, r.Breed, r.Starve)
+	}
+	return strings.Join(texts, "; ")
+}
+
+/*!
+ * \brief Parse a predation matrix.
+ * \param s Entries "PREDATOR PREY [GAIN]; ...".
+ * \param species Registered species the names may refer to.
+ * \return The entries, or an error for a malformed, unknown, cannibal or
+ *         duplicate one.
+ */
+func parseDiet(s string, species []webSpecies) ([]predation, error) {
+	web := foodWeb{Species: species}
+	diet := []predation{}
+	for _, text := range strings.Split(s, ";") {
+		text = strings.TrimSpace(text)
+		if text == "" {
+			continue
+		}
+		fields := strings.Fields(text)
+		var p predation
+		if len(fields) < 2 || len(fields) > 3 {
+			return nil, fmt.Errorf("invalid diet %q: want PREDATOR PREY [GAIN]", text)
+		}
+		if len(fields) == 3 {
+			if _, err := fmt.Sscanf(fields[2], "%d", &p.Gain); err != nil || p.Gain < 0 {
+				return nil, fmt.Errorf("invalid diet %q: the gain must be a number of at least 0", text)
+			}
+		}
+		var known [2]bool
+		p.Predator, known[0] = web.lookup(fields[0])
+		p.Prey, known[1] = web.lookup(fields[1])
+		for i, ok := range known {
+			if !ok {
+				return nil, fmt.Errorf("invalid diet %q: unknown species %q", text, fields[i])
+			}
+		}
+		if p.Predator == p.Prey {
+			return nil, fmt.Errorf("invalid diet %q: no species eats its own kind", text)
+		}
+		for _, o := range diet {
+			if o.Predator == p.Predator && o.Prey == p.Prey {
+				return nil, fmt.Errorf("invalid diet %q: %s already eats %s", text, fields[0], fields[1])
+			}
+		}
+		diet = append(diet, p)
+	}
+	return diet, nil
+}
+
+/*!
+ * \brief Format a predation matrix for the -eats flag.
+ * \return The entries joined with "; ", the gain left out for full meals.
+ */
+func (f *foodWeb) formatDiet() string {
+	texts := make([]string, len(f.Diet))
+	for i, p := range f.Diet {
+		texts[i] = f.name(p.Predator) + " " + f.name(p.Prey)
+		if p.Gain > 0 {
+			texts[i] += " " + strconv.Itoa(p.Gain)
+		}
+	}
+	return strings.Join(texts, "; ")
+}
+
+/*!
+ * \brief Food web compiled for stepping, shared by successive worlds.
+ */
+type webTable struct {
+	species []webSpecies                    ///< Registered species
+	preys   [speciesCount]uint16            ///< Bit p set if species s eats species p
+	gain    [speciesCount][speciesCount]int ///< Gain of a meal, 0 = full
+}
+
+/*!
+ * \brief Compile a food web.
+ * \return The table, or nil for the classic rules.
+ */
+func (f *foodWeb) table() *webTable {
+	if !f.enabled() {
+		return nil
+	}
+	t := &webTable{species: f.Species}
+	for _, p := range f.Diet {
+		t.preys[p.Predator] |= 1 << p.Prey
+		t.gain[p.Predator][p.Prey] = p.Gain
+	}
+	return t
+}
+
+/*!
+ * \brief Check whether a species is kept off the reefs.
+ * \param w The world.
+ * \param s The species.
+ * \return True for sharks and registered predators.
+ */
+func (w *World) barredFromReef(s Species) bool {
+	switch {
+	case s == Shark:
+		return true
+	case s == Fish || w.web == nil:
+		return false
+	}
+	return w.web.preys[s] != 0
+}
+
+/*!
+ * \brief Find the creature in a cell, part way through a chronon.
+ * \param oldWorld Current world state.
+ * \param newWorld Next world state.
+ * \param x X coordinate.
+ * \param y Y coordinate.
+ * \return The creature, or nil if the cell is empty.
+ *
+ * A creature that has already been updated is found at its new position
+ * in newWorld; one that has not is still at its old position in oldWorld.
+ */
+func preyAt(oldWorld, newWorld *World, x, y int) *Creature {
+	switch {
+	case newWorld.occupied(x, y):
+		return &newWorld.Grid[x][y]
+	case oldWorld.occupied(x, y) && !newWorld.moved.has(x, y):
+		return &oldWorld.Grid[x][y]
+	}
+	return nil
+}
+
+/*!
+ * \brief Find the neighbours holding prey of a creature.
+ * \param oldWorld Current world state.
+ * \param newWorld Next world state.
+ * \param c The hunting creature.
+ * \param adjacent Its neighbours, from getAdjacentPositions.
+ * \return Bit i set if neighbour i holds prey, before reefs and tides are
+ *         taken into account; 0 without a food web.
+ */
+func preyAround(oldWorld, newWorld *World, c *Creature, adjacent [4][2]int) uint {
+	if oldWorld.web == nil || oldWorld.web.preys[c.Species] == 0 {
+		return 0
+	}
+	var set uint
+	for i, pos := range adjacent {
+		if p := preyAt(oldWorld, newWorld, pos[0], pos[1]); p != nil && oldWorld.web.preys[c.Species]&(1<<p.Species) != 0 {
+			set |= 1 << i
+		}
+	}
+	return set
+}
+
+/*!
+ * \brief Which of a cell's neighbours are out of every predator's reach.
+ * \param adjacent The neighbours, from getAdjacentPositions.
+ * \return Bit i set if neighbour i is reef or exposed by the tide.
+ */
+func (w *World) sheltered(adjacent [4][2]int) uint {
+	var set uint
+	if w.reef != nil {
+		set |= w.reefAround(adjacent)
+	}
+	if w.tidal != nil {
+		set |= w.exposedAround(adjacent)
+	}
+	return set
+}
+
+/*!
+ * \brief Most energy a creature can have.
+ * \param w The world.
+ * \param s Species of the creature.
+ * \return The starve time, the fish energy budget, or 0 for creatures
+ *         without energy.
+ */
+func (w *World) maxEnergy(s Species) int {
+	switch s {
+	case Shark:
+		return w.Starve
+	case Fish:
+		return w.fishEnergy.Budget
+	}
+	if r := w.web.registeredSpecies(s); r != nil {
+		return r.Starve
+	}
+	return 0
+}
+
+/*!
+ * \brief The registered species with a Species value, from a compiled table.
+ * \param s The value.
+ * \return The species, or nil if the table is nil or s is not registered.
+ */
+func (t *webTable) registeredSpecies(s Species) *webSpecies {
+	if t == nil || s < firstWebSpecies || int(s-firstWebSpecies) >= len(t.species) {
+		return nil
+	}
+	return &t.species[s-firstWebSpecies]
+}
+
+/*!
+ * \brief Energy of a predator after a meal.
+ * \param w The world.
+ * \param c The predator.
+ * \param prey The creature it eats.
+ * \return The energy, raised by the gain up to the most it can have; the
+ *         starve time for a shark under the classic rules. A predator
+ *         out of energy eats from empty.
+ */
+func (w *World) meal(c *Creature, prey *Creature) int32 {
+	most := w.maxEnergy(c.Species)
+	gain := most
+	if w.web != nil && w.web.gain[c.Species][prey.Species] != 0 {
+		gain = w.web.gain[c.Species][prey.Species]
+	} else if c.Species == Shark && w.starvation.Meal > 0 {
+		gain = w.starvation.Meal
+	}
+	return int32(min(most, int(max(c.Energy, 0))+gain))
+}
+
+/*!
+ * \brief Let a predator eat the prey in a neighbouring cell.
+ * \param oldWorld Current world state.
+ * \param newWorld Next world state.
+ * \param pos Cell of the prey, which the predator moves to.
+ * \param c The predator; its energy and kills are updated.
+ */
+func eat(oldWorld, newWorld *World, pos [2]int, c *Creature) {
+	victim := preyAt(oldWorld, newWorld, pos[0], pos[1])
+	newWorld.record(deathEvent(Eaten, victim, pos[0], pos[1]))
+	c.Energy = oldWorld.meal(c, victim)
+	c.Kills++
+}
+
+/*!
+ * \brief Process hunting, movement, starvation and reproduction of a
+ *        registered species.
+ * \param oldWorld Current world state.
+ * \param newWorld Next world state.
+ * \param x X position of the creature.
+ * \param y Y position of the creature.
+ * \param c The creature, in oldWorld's grid; updated in place, then copied to newWorld.
+ * \param rng Random source driving movement choices.
+ */
+func processForager(oldWorld, newWorld *World, x, y int, c *Creature, rng *rand.Rand) {
+	kind := oldWorld.web.registeredSpecies(c.Species)
+	if kind.Starve > 0 {
+		c.Energy--
+		if c.Energy <= 0 {
+			newWorld.record(deathEvent(Starved, c, x, y))
+			return
+		}
+	}
+	if oldWorld.exposed(x, y) {
+		newWorld.put(x, y, c)
+		return
+	}
+	adjacent := getAdjacentPositions(x, y, oldWorld.Size, oldWorld.bounded)
+
+	var newPos [2]int
+	if prey := preyAround(oldWorld, newWorld, c, adjacent) &^ oldWorld.sheltered(adjacent); prey != 0 {
+		newPos = adjacent[nthBit(prey, rng.Intn(bits.OnesCount(prey)))]
+		eat(oldWorld, newWorld, newPos, c)
+	} else {
+		barred := oldWorld.barredFromReef(c.Species)
+		var emptyCells [4][2]int
+		empty := 0
+		for _, pos := range adjacent {
+			if oldWorld.Grid[pos[0]][pos[1]].Species == Empty && oldWorld.claimable(newWorld, pos) &&
+				!oldWorld.isDry(pos[0], pos[1]) && !(barred && oldWorld.isReef(pos[0], pos[1])) {
+				emptyCells[empty] = pos
+				empty++
+			}
+		}
+		if empty == 0 {
+			newWorld.put(x, y, c)
+			return
+		}
+		var moved bool
+		if newPos, moved = oldWorld.pickClaim(newWorld, x, y, c, &emptyCells, empty, rng); !moved {
+			newWorld.put(x, y, c)
+			return
+		}
+	}
+
+	if oldWorld.aging.due(c, kind.Breed) && oldWorld.mated(x, y, c) {
+		baby := Creature{
+			ID:       newWorld.ids.next(),
+			Species:  c.Species,
+			Energy:   int32(kind.Starve),
+			Female:   oldWorld.sexes.female(rng),
+			Strategy: oldWorld.strategies.inherit(c, rng),
+			Gen:      c.Gen + 1,
+		}
+		newWorld.put(x, y, &baby)
+		newWorld.record(Event{Kind: Birth, Species: c.Species, ID: baby.ID, ParentID: c.ID, X: x, Y: y})
+		c.LastBreed = 0
+		c.Offspring++
+	}
+	newWorld.put(newPos[0], newPos[1], c)
+}
+
+/*!
+ * \brief Population of every registered species in a world.
+ * \param w The world.
+ * \return Creatures per registered species, nil without any.
+ */
+func (w *World) webPopulations() []int {
+	if w.web == nil || len(w.web.species) == 0 {
+		return nil
+	}
+	counts := make([]int, len(w.web.species))
+	for _, column := range w.Grid {
+		for _, c := range column {
+			if c.Species >= firstWebSpecies {
+				counts[c.Species-firstWebSpecies]++
+			}
+		}
+	}
+	return counts
+}
+
+/*!
+ * \brief GIF colours of the registered species, in order.
+ */
+var webGIFColours = color.Palette{
+	color.RGBA{0xf0, 0x90, 0xb0, 0xff}, // Pink
+	color.RGBA{0xf0, 0x90, 0x30, 0xff}, // Orange
+	color.RGBA{0x30, 0xb0, 0xb0, 0xff}, // Teal
+	color.RGBA{0xe0, 0xe0, 0xe0, 0xff}, // White
+	color.RGBA{0x90, 0x90, 0x20, 0xff}, // Olive
+	color.RGBA{0x90, 0x50, 0x20, 0xff}, // Brown
+	color.RGBA{0x80, 0xb0, 0xf0, 0xff}, // Light blue
+	color.RGBA{0x70, 0x70, 0x70, 0xff}, // Grey
+}
+
+/*!
+ * \brief TUI background colours of the registered species, in order
+ *        (256-colour palette, close to the GIF colours).
+ */
+var webTUIColours = [maxWebSpecies]string{
+	"\x1b[48;5;218m", "\x1b[48;5;208m", "\x1b[48;5;37m", "\x1b[48;5;255m",
+	"\x1b[48;5;100m", "\x1b[48;5;94m", "\x1b[48;5;111m", "\x1b[48;5;244m",
+}
+
+/*!
+ * \brief Writes the populations of every species of a food web as CSV.
+ */
+type webSink struct {
+	*csvSink     ///< Output file and encoder
+	names    int ///< Number of registered species
+}
+
+/*!
+ * \brief Create a food web sink.
+ * \param path Output file path.
+ * \param params Parameters of the run, with registered species.
+ * \return The sink, or an error if no species are registered or the file
+ *         cannot be created.
+ */
+func openWebSink(path string, params Config) (Observer, error) {
+	if len(params.Web.Species) == 0 {
+		return nil, errors.New("needs -species")
+	}
+	file, err := createCSV(path)
+	if err != nil {
+		return nil, err
+	}
+	s := &webSink{csvSink: &csvSink{file: file, w: csv.NewWriter(file)}, names: len(params.Web.Species)}
+	header := []string{"chronon", "fish", "sharks"}
+	for _, r := range params.Web.Species {
+		header = append(header, r.Name)
+	}
+	s.w.Write(header)
+	return s, nil
+}
+
+/*!
+ * \brief Write the row of a frame.
+ * \param f The frame to record.
+ * \return Any write error.
+ */
+func (s *webSink) Observe(f *Frame) error {
+	row := []string{strconv.Itoa(f.Chronon), strconv.Itoa(f.Fish), strconv.Itoa(f.Sharks)}
+	for i := 0; i < s.names; i++ {
+		row = append(row, strconv.Itoa(f.Other(i)))
+	}
+	return s.w.Write(row)
+}
@@ -0,0 +1,336 @@
+/*!
+ * \file fork.go
+ * \brief The fork subcommand: several futures branched from one checkpoint.
+ *
+ * A checkpoint taken close to a critical state (a crash of the sharks, a
+ * near extinction) is continued several times in parallel. Without -vary
+ * every branch is re-seeded, seed, seed+1, ..., so the branches differ
+ * only by chance; with -vary NAME=V1,V2,... each branch gets one value of
+ * a breed or starvation time and all continue the random stream of the
+ * checkpoint, so they differ only by the parameter. Either way they all
+ * start from the same world.
+ *
+ * Every branch writes its statistics to PREFIX-N.csv, in the format of
+ * run -csv, and PREFIX.csv holds the populations of all branches side by
+ * side with their spread: the relative difference between the smallest
+ * and the largest population of either species. The summary names the
+ * chronon at which the spread first exceeds -threshold.
+ */
+
+package main
+
+import (
+	"encoding/csv"
+	"fmt"
+	"os"
+	"strconv"
+	"time"
+)
+
+/*!
+ * \brief One continuation of a checkpoint.
+ */
+type forkBranch struct {
+	Label  string ///< Caption in the summary: the seed or the varied parameter
+	Seed   int64  ///< Seed the branch continues with
+	Reseed bool   ///< Re-seed the random source rather than continue the checkpoint's
+	Param  string ///< Varied parameter, or ""
+	Value  int    ///< Value of the varied parameter
+}
+
+/*!
+ * \brief Populations of a branch after every chronon it ran.
+ */
+type forkHistory struct {
+	Fish          []int ///< Fish after each chronon
+	Sharks        []int ///< Sharks after each chronon
+	FishExtinct   int   ///< Chronon after which no fish were left, or -1
+	SharksExtinct int   ///< Chronon after which no sharks were left, or -1
+}
+
+/*!
+ * \brief Build the branches of a fork.
+ * \param cp The checkpoint branched from.
+ * \param seed Seed of the first branch; branch i uses seed+i.
+ * \param branches Number of branches when no parameter is varied.
+ * \param vary "" or "NAME=V1,V2,...": one branch per value of a breed or
+ *             starvation time, all continuing the checkpoint's random stream.
+ * \return The branches, or an error for a bad -vary or parameter value.
+ */
+func forkBranches(cp *checkpoint, seed int64, branches int, vary string) ([]forkBranch, error) {
+	if vary == "" {
+		if branches < 1 {
+			return nil, fmt.Errorf("-branches must be at least 1, not %d", branches)
+		}
+		list := make([]forkBranch, branches)
+		for i := range list {
+			s := seed + int64(i)
+			list[i] = forkBranch{Label: fmt.Sprintf("seed %d", s), Seed: s, Reseed: true}
+		}
+		return list, nil
+	}
+
+	p, values, err := parseParamValues("-vary", vary)
+	if err != nil {
+		return nil, err
+	}
+	if !reloadableParams[p.Name] {
+		return nil, fmt.Errorf("-vary: %s cannot change while running (want fishbreed, sharkbreed or starve)", p.Name)
+	}
+	var list []forkBranch
+	for _, v := range values {
+		if v < p.Min {
+			return nil, fmt.Errorf("-vary: %s must be at least %d, not %d", p.Name, p.Min, v)
+		}
+		list = append(list, forkBranch{Label: fmt.Sprintf("%s=%d", p.Name, v), Seed: cp.Seed, Param: p.Name, Value: v})
+	}
+	return list, nil
+}
+
+/*!
+ * \brief Rebuild the simulation of a checkpoint as the start of a branch.
+ * \param cp The checkpoint.
+ * \param b The branch.
+ * \return The simulation, or an error if the checkpoint is inconsistent.
+ *
+ * The pollution field is copied, since every branch changes its own.
+ */
+func (b forkBranch) start(cp *checkpoint) (*Simulation, error) {
+	own := *cp
+	own.Pollution = append([]float32(nil), cp.Pollution...)
+	sim, err := own.restore()
+	if err != nil {
+		return nil, err
+	}
+	// Parallelise over branches rather than within them
+	sim.Params.Workers = 1
+	if b.Reseed {
+		sim.source.Seed(b.Seed)
+		sim.Seed = b.Seed
+	}
+	if b.Param != "" {
+		p, _ := findParam(b.Param)
+		*p.Field(&sim.Params) = b.Value
+		sim.World.FishBreed, sim.World.SharkBreed, sim.World.Starve = sim.Params.FishBreed, sim.Params.SharkBreed, sim.Params.Starve
+	}
+	return sim, nil
+}
+
+/*!
+ * \brief Continue a branch and record its populations.
+ * \param sim The simulation of the branch, at the checkpoint.
+ * \param chronons Chronons to run.
+ * \param stats Sink of its statistics.
+ * \return The populations; a branch stops early once both species are extinct.
+ */
+func runForkBranch(sim *Simulation, chronons int, stats Observer) forkHistory {
+	h := forkHistory{FishExtinct: -1, SharksExtinct: -1}
+	for c := 0; c < chronons; c++ {
+		sim.Step()
+		f := sim.Frame()
+		stats.Observe(f)
+		h.Fish, h.Sharks = append(h.Fish, f.Fish), append(h.Sharks, f.Sharks)
+		if f.Fish == 0 && h.FishExtinct < 0 {
+			h.FishExtinct = f.Chronon
+		}
+		if f.Sharks == 0 && h.SharksExtinct < 0 {
+			h.SharksExtinct = f.Chronon
+		}
+		if f.Fish == 0 && f.Sharks == 0 {
+			break
+		}
+	}
+	return h
+}
+
+/*!
+ * \brief Spread of the branches at one chronon.
+ * \param histories Populations of every branch.
+ * \param c Index of the chronon; branches that stopped early, with both
+ *          species extinct, count as zero.
+ * \return The larger relative difference, of the two species, between the smallest and largest population.
+ */
+func forkSpread(histories []forkHistory, c int) float64 {
+	at := func(series []int) int {
+		if len(series) == 0 {
+			return 0
+		}
+		return series[min(c, len(series)-1)]
+	}
+	spread := 0.0
+	for _, pick := range []func(h forkHistory) []int{
+		func(h forkHistory) []int { return h.Fish },
+		func(h forkHistory) []int { return h.Sharks },
+	} {
+		lo, hi := at(pick(histories[0])), at(pick(histories[0]))
+		for _, h := range histories[1:] {
+			lo, hi = min(lo, at(pick(h))), max(hi, at(pick(h)))
+		}
+		spread = max(spread, relativeDifference(lo, hi))
+	}
+	return spread
+}
+
+/*!
+ * \brief Write the populations of every branch side by side.
+ * \param path Output file path.
+ * \param start Chronon of the checkpoint.
+ * \param histories Populations of every branch.
+ * \param chronons Chronons run by the longest branch.
+ * \return Any file error.
+ */
+func writeForkTable(path string, start int, histories []forkHistory, chronons int) error {
+	file, err := createCSV(path)
+	if err != nil {
+		return err
+	}
+	w := csv.NewWriter(file)
+	header := []string{"chronon"}
+	for i := range histories {
+		header = append(header, fmt.Sprintf("fish_%d", i+1), fmt.Sprintf("sharks_%d", i+1))
+	}
+	w.Write(append(header, "spread"))
+	for c := 0; c < chronons; c++ {
+		row := []string{strconv.Itoa(start + c)}
+		for _, h := range histories {
+			// Branches that died out early stay at zero
+			fish, sharks := 0, 0
+			if c < len(h.Fish) {
+				fish, sharks = h.Fish[c], h.Sharks[c]
+			}
+			row = append(row, strconv.Itoa(fish), strconv.Itoa(sharks))
+		}
+		w.Write(append(row, strconv.FormatFloat(forkSpread(histories, c), 'f', 4, 64)))
+	}
+	w.Flush()
+	if err := w.Error(); err != nil {
+		file.Close()
+		return err
+	}
+	return file.Close()
+}
+
+/*!
+ * \brief Entry point of the fork subcommand.
+ * \param args Command-line arguments after "fork".
+ * \return Process exit code: exitOK, exitConfig for bad settings or an
+ *         unreadable checkpoint, or exitFailure if an output cannot be written.
+ */
+func forkCommand(args []string) int {
+	fs := newCommandFlags("fork")
+	branches := fs.Int("branches", 4, "number of branches, re-seeded with seed, seed+1, ... (ignored with -vary)")
+	vary := fs.String("vary", "", "one branch per value of a parameter, all continuing the checkpoint's random stream, e.g. starve=4,5,6")
+	seed := fs.Int64("seed", 0, "seed of the first branch (0 = derive from the clock)")
+	chronons := fs.Int("chronons", 1000, "chronons every branch runs past the checkpoint")
+	threshold := fs.Float64("threshold", 0.1, "relative spread of a population counting as divergence")
+	prefix := fs.String("o", "fork", "output path `prefix`: PREFIX.csv for all branches, PREFIX-N.csv for branch N")
+	if err := fs.Parse(args); err != nil {
+		return flagExit(err)
+	}
+	if fs.NArg() != 1 {
+		fs.Usage()
+		return exitConfig
+	}
+	path := fs.Arg(0)
+	if *chronons < 1 || *threshold <= 0 {
+		fmt.Fprintln(os.Stderr, "fork: need a positive -chronons and -threshold")
+		return exitConfig
+	}
+	cp, err := loadCheckpoint(path)
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		return exitConfig
+	}
+	if *seed == 0 {
+		*seed = time.Now().UnixNano()
+	}
+	list, err := forkBranches(cp, *seed, *branches, *vary)
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		return exitConfig
+	}
+
+	// Check the checkpoint and open the outputs before anything runs
+	sims := make([]*Simulation, len(list))
+	stats := make([]Observer, len(list))
+	closeAll := func() {
+		for _, s := range stats {
+			if s != nil {
+				s.Close()
+			}
+		}
+	}
+	base := newProvenance("fork "+path, fs, 0)
+	base.Resumed = path
+	for i, b := range list {
+		if sims[i], err = b.start(cp); err != nil {
+			fmt.Fprintf(os.Stderr, "checkpoint %s: %v\n", path, err)
+			closeAll()
+			return exitConfig
+		}
+		prov := *base
+		prov.Command, prov.Seed = fmt.Sprintf("fork %s, branch %d (%s)", path, i+1, b.Label), b.Seed
+		artifactProvenance = &prov
+		if stats[i], err = openCSVSink(fmt.Sprintf("%s-%d.csv", *prefix, i+1), sims[i].Params); err != nil {
+			fmt.Fprintln(os.Stderr, err)
+			closeAll()
+			return exitFailure
+		}
+	}
+	artifactProvenance = base
+
+	fmt.Printf("Forking %d branches of %d chronons from chronon %d of %s\n", len(list), *chronons, cp.Chronon, path)
+	histories := make([]forkHistory, len(list))
+	parallelFor(len(list), func(i int) {
+		histories[i] = runForkBranch(sims[i], *chronons, stats[i])
+	})
+
+	failed := false
+	for _, s := range stats {
+		if err := s.Close(); err != nil {
+			fmt.Fprintln(os.Stderr, err)
+			failed = true
+		}
+	}
+	longest := 0
+	for _, h := range histories {
+		longest = max(longest, len(h.Fish))
+	}
+	if err := writeForkTable(*prefix+".csv", cp.Chronon, histories, longest); err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		failed = true
+	}
+
+	d := divergence{At: -1, LargestAt: cp.Chronon}
+	for c := 0; c < longest; c++ {
+		spread := forkSpread(histories, c)
+		if spread > d.Largest {
+			d.Largest, d.LargestAt = spread, cp.Chronon+c
+		}
+		if spread > *threshold && d.At < 0 {
+			d.At = cp.Chronon + c
+		}
+	}
+	for i, h := range histories {
+		fish, sharks := sims[i].Population()
+		fmt.Printf("Branch %d (%s): chronon %d, fish=%d sharks=%d", i+1, list[i].Label, sims[i].Chronon, fish, sharks)
+		if h.FishExtinct >= 0 {
+			fmt.Printf(", fish extinct at %d", h.FishExtinct)
+		}
+		if h.SharksExtinct >= 0 {
+			fmt.Printf(", sharks extinct at %d", h.SharksExtinct)
+		}
+		fmt.Println()
+	}
+	if d.At >= 0 {
+		fmt.Printf("Diverged beyond %.1f%% at chronon %d; largest spread %.1f%% at chronon %d\n",
+			100**threshold, d.At, 100*d.Largest, d.LargestAt)
+	} else {
+		fmt.Printf("No divergence beyond %.1f%% in %d chronons; largest spread %.1f%% at chronon %d\n",
+			100**threshold, longest, 100*d.Largest, d.LargestAt)
+	}
+	if failed {
+		return exitFailure
+	}
+	return exitOK
+}
@@ -0,0 +1,435 @@
+/*!
+ * \file frame.go
+ * \brief Frame snapshots and the channel fan-out that delivers them to observers.
+ *
+ * The simulation loop publishes one Frame per chronon. Each attached
+ * observer runs in its own goroutine and reads frames from a buffered
+ * channel, so a slow renderer does not hold up the simulation until its
+ * buffer is full. A live renderer (see liveObserver) has room for a
+ * single frame, which the next one replaces if it is still unread, so it
+ * never holds up the simulation at all.
+ *
+ * A frame is a value snapshot: its cells, populations, events and
+ * chronon are copied out of the world, which reuses its buffers while
+ * observers read them. The reefs and registered species never change in
+ * place (a reserve replaces the reef map), so frames share them instead.
+ * Observers share each frame too, so they reach its slices and maps only
+ * through read-only accessors.
+ */
+
+package main
+
+import (
+	"errors"
+	"hash/fnv"
+	"iter"
+	"math/bits"
+	"slices"
+	"sync"
+)
+
+/*!
+ * \brief Number of frames an observer may fall behind before the simulation waits.
+ */
+const frameBuffer = 64
+
+/*!
+ * \brief Snapshot of the world after a chronon.
+ *
+ * A Frame is never modified after creation, so it can be shared between
+ * goroutines without locking. Its exported fields are plain values; the
+ * per-cell data is read through the accessors.
+ */
+type Frame struct {
+	Chronon      int            ///< Chronon the snapshot was taken after
+	Size         int            ///< Width/Height of the grid
+	Fish         int            ///< Number of fish
+	Sharks       int            ///< Number of sharks
+	FemaleFish   int            ///< Number of female fish; 0 without sexes
+	FemaleSharks int            ///< Number of female sharks; 0 without sexes
+	others       []int          ///< Population of each registered species (see Other); nil without any
+	SharkEnergy  float64        ///< Mean energy of the hatched sharks; 0 without any
+	FishAge      float64        ///< Mean age of the hatched fish in chronons; 0 without any
+	strategies   []strategyMix  ///< Creatures of each strategy per species (see StrategyMix); nil without strategy mutation
+	registered   []webSpecies   ///< Registered species of a food web, shared (see Registered)
+	cells        []Species      ///< Species (or Land) per cell, row-major (index y*Size+x; see At, Cell and Cells)
+	reef         []bool         ///< Reef cells, row-major, shared (see IsReef); nil = no reefs
+	pollution    []float32      ///< Pollution level per cell, row-major (see PollutionAt); nil = clean water
+	eggs         []bool         ///< Cells holding an egg, row-major (see IsEgg); nil without egg times
+	temperature  []float64      ///< Temperature per row during the chronon (see RowTemperature); nil without a climate
+	Phase        string         ///< "day" or "night" during the chronon; "" without a day/night cycle
+	events       []Event        ///< Births and deaths during the chronon (see Events)
+	hunger       *hungerMap     ///< Hunger of the sharks (see HungerAt); nil unless the hunger renderer is used
+	fishMap      *fishMap       ///< Age and generation of the fish (see FishAt); nil unless the age or generation renderer is used
+	moves        []int8         ///< Direction each creature moved in, per cell (see Moves); nil unless movement is tracked
+	Hunting      HuntingMetrics ///< Rolling hunting metrics (set by Simulation.Frame)
+}
+
+/*!
+ * \brief Take a snapshot of a world.
+ * \param world Pointer to the World to copy.
+ * \param chronon Chronon number of the snapshot.
+ * \return Pointer to the new Frame, sharing only the reefs and registered
+ *         species with the world. Its Fish and Sharks are left for the
+ *         caller, who keeps the populations from the events.
+ */
+func newFrame(world *World, chronon int) *Frame {
+	f := &Frame{
+		Chronon: chronon,
+		Size:    world.Size,
+		cells:   make([]Species, world.Size*world.Size),
+	}
+	// Events are copied, as the world reuses its buffer next chronon
+	if world.Events != nil {
+		f.events = append([]Event(nil), world.Events...)
+	}
+	f.reef = world.reef
+	f.temperature = world.rowTemperatures(chronon)
+	f.Phase = world.cycle.phase(chronon)
+	if world.pollution != nil {
+		f.pollution = append([]float32(nil), world.pollution...)
+	}
+	for i, land := range world.land {
+		if land {
+			f.cells[i] = Land
+		}
+	}
+	// Tidal cells lying dry during the chronon show as land unless a creature is stranded there
+	if world.tidal != nil && world.tide.out(chronon) {
+		for i, tidal := range world.tidal {
+			if tidal {
+				f.cells[i] = Land
+			}
+		}
+	}
+	if world.eggs.enabled() {
+		f.eggs = make([]bool, len(f.cells))
+	}
+	if world.web != nil && len(world.web.species) > 0 {
+		f.registered = world.web.species
+		f.others = make([]int, len(f.registered))
+	}
+	if world.strategies.Mutation > 0 {
+		f.strategies = make([]strategyMix, speciesCount)
+	}
+	sharks, fish, energy, age := 0, 0, 0, 0
+	// Visit the occupied cells only, a strip of the mask at a time
+	for x := 0; x < world.Size; x++ {
+		for i := 0; i < world.creatures.stride; i++ {
+			for word := world.creatures.columnWord(x, i, 0, world.Size); word != 0; word &= word - 1 {
+				y := i<<6 + bits.TrailingZeros64(word)
+				c := &world.Grid[x][y]
+				f.cells[y*world.Size+x] = c.Species
+				if f.eggs != nil && c.Hatch > 0 {
+					f.eggs[y*world.Size+x] = true
+				}
+				if f.strategies != nil && c.Species != Whale {
+					f.strategies[c.Species][c.Strategy]++
+				}
+				switch {
+				case c.Hatch > 0: // Eggs neither hunt nor age
+				case c.Species == Shark:
+					sharks++
+					energy += int(c.Energy)
+				case c.Species == Fish:
+					fish++
+					age += int(c.Age)
+				}
+				if c.Species >= firstWebSpecies {
+					f.others[c.Species-firstWebSpecies]++
+				} else if c.Female && c.Species == Fish {
+					f.FemaleFish++
+				} else if c.Female {
+					f.FemaleSharks++
+				}
+			}
+		}
+	}
+	if sharks > 0 {
+		f.SharkEnergy = float64(energy) / float64(sharks)
+	}
+	if fish > 0 {
+		f.FishAge = float64(age) / float64(fish)
+	}
+	return f
+}
+
+/*!
+ * \brief Species in a cell of the frame.
+ * \param x X coordinate.
+ * \param y Y coordinate.
+ * \return The Species at (x, y).
+ */
+func (f *Frame) At(x, y int) Species {
+	return f.cells[y*f.Size+x]
+}
+
+/*!
+ * \brief Species in a cell of the frame, by its index.
+ * \param i Row-major index of the cell, y*Size+x.
+ * \return The Species of the cell.
+ */
+func (f *Frame) Cell(i int) Species {
+	return f.cells[i]
+}
+
+/*!
+ * \brief Species in every cell of the frame.
+ * \return The row-major index and species of each cell.
+ */
+func (f *Frame) Cells() iter.Seq2[int, Species] {
+	return slices.All(f.cells)
+}
+
+/*!
+ * \brief Population of a registered species.
+ * \param i Index of the species among the registered ones.
+ * \return The population, 0 if no such species is registered.
+ */
+func (f *Frame) Other(i int) int {
+	if i < 0 || i >= len(f.others) {
+		return 0
+	}
+	return f.others[i]
+}
+
+/*!
+ * \brief Creatures of each movement strategy in a species.
+ * \param s The species.
+ * \return A copy of the counts; all 0 without strategy mutation.
+ */
+func (f *Frame) StrategyMix(s Species) strategyMix {
+	if int(s) >= len(f.strategies) {
+		return strategyMix{}
+	}
+	return f.strategies[s]
+}
+
+/*!
+ * \brief Temperature of a row during the chronon.
+ * \param y Y coordinate of the row.
+ * \return The temperature, and false without a climate.
+ */
+func (f *Frame) RowTemperature(y int) (float64, bool) {
+	if f.temperature == nil {
+		return 0, false
+	}
+	return f.temperature[y], true
+}
+
+/*!
+ * \brief Hunger of the shark in a cell.
+ * \param x X coordinate.
+ * \param y Y coordinate.
+ * \return Its energy over the starvation time (0 without a hatched
+ *         shark), and false without a hunger map.
+ */
+func (f *Frame) HungerAt(x, y int) (float32, bool) {
+	if f.hunger == nil {
+		return 0, false
+	}
+	return f.hunger.Ratio[y*f.Size+x], true
+}
+
+/*!
+ * \brief Starvation time the hunger of the sharks is relative to.
+ * \return The starvation time, 0 without a hunger map.
+ */
+func (f *Frame) HungerStarve() int {
+	if f.hunger == nil {
+		return 0
+	}
+	return f.hunger.Starve
+}
+
+/*!
+ * \brief Age and generation of the fish in a cell.
+ * \param i Row-major index of the cell, y*Size+x.
+ * \return Its age and generation (0 without a hatched fish), and false
+ *         without a fish map.
+ */
+func (f *Frame) FishAt(i int) (age, gen int32, ok bool) {
+	if f.fishMap == nil {
+		return 0, 0, false
+	}
+	return f.fishMap.Age[i], f.fishMap.Gen[i], true
+}
+
+/*!
+ * \brief Direction each creature moved in during the chronon.
+ * \return The row-major index of each cell and its move (see movesOf);
+ *         nothing unless movement is tracked.
+ */
+func (f *Frame) Moves() iter.Seq2[int, int8] {
+	return slices.All(f.moves)
+}
+
+/*!
+ * \brief Check whether the frame tracks the moves of the creatures.
+ * \return True if Moves yields a move for every cell.
+ */
+func (f *Frame) HasMoves() bool {
+	return f.moves != nil
+}
+
+/*!
+ * \brief Map character of a species in the frame.
+ * \param s The species.
+ * \return Its character in speciesChars, or the one given to a registered species.
+ */
+func (f *Frame) Char(s Species) byte {
+	if i := int(s) - int(firstWebSpecies); i >= 0 && i < len(f.registered) {
+		return f.registered[i].Char
+	}
+	return speciesChars[s]
+}
+
+/*!
+ * \brief Check whether a cell of the frame is reef.
+ * \param x X coordinate.
+ * \param y Y coordinate.
+ * \return True if the cell is reef.
+ */
+func (f *Frame) IsReef(x, y int) bool {
+	return f.reef != nil && f.reef[y*f.Size+x]
+}
+
+/*!
+ * \brief Births and deaths during the chronon.
+ * \return The events, in the order they happened.
+ */
+func (f *Frame) Events() iter.Seq[Event] {
+	return slices.Values(f.events)
+}
+
+/*!
+ * \brief Births and deaths during the chronon, counted.
+ * \return The counts per kind.
+ */
+func (f *Frame) EventCounts() eventCounts {
+	return countEvents(f.events)
+}
+
+/*!
+ * \brief Registered species of a food web.
+ * \return The index and a copy of each species, in registration order.
+ */
+func (f *Frame) Registered() iter.Seq2[int, webSpecies] {
+	return slices.All(f.registered)
+}
+
+/*!
+ * \brief Check whether a cell of the frame holds an egg.
+ * \param x X coordinate.
+ * \param y Y coordinate.
+ * \return True if the fish or shark in the cell has not hatched yet.
+ */
+func (f *Frame) IsEgg(x, y int) bool {
+	return f.eggs != nil && f.eggs[y*f.Size+x]
+}
+
+/*!
+ * \brief Pollution level of a cell of the frame.
+ * \param x X coordinate.
+ * \param y Y coordinate.
+ * \return The level, 0 in clean water.
+ */
+func (f *Frame) PollutionAt(x, y int) float64 {
+	if f.pollution == nil {
+		return 0
+	}
+	return float64(f.pollution[y*f.Size+x])
+}
+
+/*!
+ * \brief Checksum of the cell contents.
+ * \return FNV-1a hash of the species of every cell, row-major.
+ *
+ * Two runs that agree on every cell have the same checksum, so comparing
+ * checksums chronon by chronon detects where runs diverge.
+ */
+func (f *Frame) Checksum() uint64 {
+	h := fnv.New64a()
+	buf := make([]byte, len(f.cells))
+	for i, s := range f.cells {
+		buf[i] = byte(s)
+	}
+	h.Write(buf)
+	return h.Sum64()
+}
+
+/*!
+ * \brief Fans frames out to any number of observer goroutines.
+ */
+type frameBus struct {
+	subs []chan *Frame   ///< One channel per attached observer
+	live []*liveObserver ///< The live renderer of each channel, or nil
+	errs []error         ///< First error of each observer
+	wg   sync.WaitGroup  ///< Tracks running observer goroutines
+}
+
+/*!
+ * \brief Attach an observer; it runs in its own goroutine until the bus closes.
+ * \param obs Observer called with every published frame, in order.
+ *
+ * After the first error the observer receives no more frames; the error
+ * is returned by close(). A live renderer receives only the latest frame.
+ */
+func (b *frameBus) attach(obs Observer) {
+	buffer := frameBuffer
+	live, _ := obs.(*liveObserver)
+	if live != nil {
+		buffer = 1
+	}
+	ch := make(chan *Frame, buffer)
+	b.subs = append(b.subs, ch)
+	b.live = append(b.live, live)
+	b.errs = append(b.errs, nil)
+	slot := len(b.errs) - 1
+	b.wg.Add(1)
+	go func() {
+		defer b.wg.Done()
+		var err error
+		for f := range ch {
+			if err == nil {
+				err = obs.Observe(f)
+			}
+		}
+		if cerr := obs.Close(); err == nil {
+			err = cerr
+		}
+		b.errs[slot] = err
+	}()
+}
+
+/*!
+ * \brief Deliver a frame to every attached observer.
+ * \param f The frame to publish.
+ */
+func (b *frameBus) publish(f *Frame) {
+	for i, ch := range b.subs {
+		if live := b.live[i]; live != nil {
+			// Only the bus sends, so once the unread frame is taken back
+			// the send below cannot block
+			select {
+			case <-ch:
+				live.skipped++
+			default:
+			}
+			live.published++
+		}
+		ch <- f
+	}
+}
+
+/*!
+ * \brief Stop accepting frames and wait until all observers have drained.
+ * \return The observers' errors joined together, or nil.
+ */
+func (b *frameBus) close() error {
+	for _, ch := range b.subs {
+		close(ch)
+	}
+	b.wg.Wait()
+	return errors.Join(b.errs...)
+}
@@ -0,0 +1,286 @@
+/*!
+ * \file fuzz_test.go
+ * \brief Fuzz targets for the parsers of user-supplied files.
+ *
+ * Each target feeds one kind of file to the loader that reads it, which
+ * must reject malformed input with an error and never panic, hang or
+ * exhaust memory. The seed corpus is generated from small worlds, so
+ * "go test" checks every valid seed and "go test -fuzz" mutates them.
+ */
+
+package main
+
+import (
+	"bytes"
+	"flag"
+	"io"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"testing"
+)
+
+/*!
+ * \brief Largest grid the scenario and snapshot targets build a world for.
+ *
+ * Larger grids are valid but take too long per input; they are still
+ * decoded.
+ */
+const fuzzMaxGrid = 64
+
+/*!
+ * \brief Most random draws the snapshot target replays when restoring.
+ */
+const fuzzMaxDraws = 1 << 20
+
+/*!
+ * \brief Parameters of the worlds the seed inputs are generated from.
+ * \return A small world with terrain, so every optional part of a file is present.
+ */
+func fuzzSeedConfig() Config {
+	p := defaultConfig()
+	p.GridSize, p.NumFish, p.NumShark = 12, 40, 8
+	p.FishBreed, p.SharkBreed, p.Starve = 3, 6, 4
+	p.Land = generateIslands(p.GridSize, 1, 4, 0.75)
+	return p
+}
+
+/*!
+ * \brief Add inputs to the seed corpus of a target.
+ * \param f The fuzz test.
+ * \param seeds The inputs.
+ */
+func addSeeds(f *testing.F, seeds ...[]byte) {
+	for _, s := range seeds {
+		f.Add(s)
+	}
+}
+
+/*!
+ * \brief Output of a sink for a short run.
+ * \param f The fuzz test.
+ * \param open Constructor of the sink.
+ * \param chronons Chronons to record.
+ * \return The file the sink wrote.
+ */
+func sinkOutput(f *testing.F, open func(path string, params Config) (Observer, error), chronons int) []byte {
+	f.Helper()
+	path := filepath.Join(f.TempDir(), "out")
+	p := fuzzSeedConfig()
+	obs, err := open(path, p)
+	if err != nil {
+		f.Fatal(err)
+	}
+	sim := newSimulation(p, 1)
+	for i := 0; i < chronons; i++ {
+		sim.Step()
+		if err := obs.Observe(sim.Frame()); err != nil {
+			f.Fatal(err)
+		}
+	}
+	if err := obs.Close(); err != nil {
+		f.Fatal(err)
+	}
+	return readFile(f, path)
+}
+
+/*!
+ * \brief Read a file the test wrote.
+ * \param f The fuzz test.
+ * \param path File path.
+ * \return Its contents.
+ */
+func readFile(f *testing.F, path string) []byte {
+	f.Helper()
+	data, err := os.ReadFile(path)
+	if err != nil {
+		f.Fatal(err)
+	}
+	return data
+}
+
+/*!
+ * \brief Land maps, as read by -map.
+ */
+func FuzzParseLandMap(f *testing.F) {
+	p := fuzzSeedConfig()
+	var plain, spaced bytes.Buffer
+	writeGrid(&plain, p.GridSize, func(i int) byte {
+		if p.Land[i] {
+			return '#'
+		}
+		return '.'
+	})
+	for _, line := range strings.SplitAfter(plain.String(), "\n") {
+		spaced.WriteString(strings.Join(strings.Split(line, ""), " "))
+	}
+	addSeeds(f, plain.Bytes(), spaced.Bytes(), []byte("~#\n#~\n"))
+	f.Fuzz(func(t *testing.T, data []byte) {
+		parseLandMap(bytes.NewReader(data))
+	})
+}
+
+/*!
+ * \brief Initial layouts, as read by -layout.
+ */
+func FuzzParseLayout(f *testing.F) {
+	frame := newSimulation(fuzzSeedConfig(), 1).Frame()
+	var buf bytes.Buffer
+	writeGrid(&buf, frame.Size, func(i int) byte {
+		if s := frame.Cell(i); s == Fish || s == Shark {
+			return speciesChars[s]
+		}
+		return '.'
+	})
+	addSeeds(f, buf.Bytes())
+	f.Fuzz(func(t *testing.T, data []byte) {
+		parseLayout(bytes.NewReader(data))
+	})
+}
+
+/*!
+ * \brief Config files, as read by -config: decoded, applied to the run
+ *        flags and checked.
+ */
+func FuzzParseConfig(f *testing.F) {
+	var buf bytes.Buffer
+	if err := writeFlagValues(&buf, map[string]string{
+		"grid": "50", "fish": "300", "scheme": "raster", "gen-islands": "true", "sea-level": "0.4",
+	}); err != nil {
+		f.Fatal(err)
+	}
+	addSeeds(f, buf.Bytes(), []byte(`{"$schema": "run.json", "starve": 2, "shark-birth-cost": 3}`))
+	f.Fuzz(func(t *testing.T, data []byte) {
+		values, err := readFlagValues(bytes.NewReader(data))
+		if err != nil {
+			return
+		}
+		params := defaultConfig()
+		fs := flag.NewFlagSet("fuzz", flag.ContinueOnError)
+		fs.SetOutput(io.Discard)
+		registerConfigFlags(fs, &params)
+		if applyFlagValues(fs, values, map[string]bool{}, "config") == nil {
+			checkParams(params)
+		}
+	})
+}
+
+/*!
+ * \brief Scenario archives, as loaded by run -scenario.
+ */
+func FuzzReadScenario(f *testing.F) {
+	p := fuzzSeedConfig()
+	withLayout := p
+	frame := newSimulation(p, 1).Frame()
+	withLayout.Layout = make([]Species, frame.Size*frame.Size)
+	for i, s := range frame.Cells() {
+		if s == Fish || s == Shark {
+			withLayout.Layout[i] = s
+		}
+	}
+	dir := f.TempDir()
+	for i, sc := range []struct {
+		params      Config
+		seed        int64
+		description string
+	}{{defaultConfig(), 0, ""}, {p, 7, "Islands"}, {withLayout, 0, "Layout\nover two lines"}} {
+		path := filepath.Join(dir, strconv.Itoa(i)+".wator")
+		if err := saveScenario(path, sc.params, sc.seed, sc.description); err != nil {
+			f.Fatal(err)
+		}
+		addSeeds(f, readFile(f, path))
+	}
+	f.Fuzz(func(t *testing.T, data []byte) {
+		sc, err := readScenario(bytes.NewReader(data), int64(len(data)))
+		if err != nil {
+			return
+		}
+		// Resolve the settings like run would, without reading other files
+		params := defaultConfig()
+		fs := flag.NewFlagSet("fuzz", flag.ContinueOnError)
+		fs.SetOutput(io.Discard)
+		cfg := registerConfigFlags(fs, &params)
+		cfg.ignoreEnv = true
+		files := map[string]bool{"scenario": true, "config": true, "map": true, "layout": true}
+		if err := applyFlagValues(fs, sc.Flags, files, "scenario"); err != nil {
+			return
+		}
+		if err := checkParams(params); err != nil || params.GridSize > fuzzMaxGrid {
+			return
+		}
+		params.Land, params.Reef, params.Layout = sc.Land, sc.Reef, sc.Layout
+		params.Pollution.Map = sc.Pollution
+		cfg.resolve()
+	})
+}
+
+/*!
+ * \brief Snapshots in every encoding, as loaded by run -resume and then
+ *        stepped.
+ *
+ * Snapshots larger than fuzzMaxGrid or fuzzMaxDraws are only decoded.
+ */
+func FuzzReadSnapshot(f *testing.F) {
+	sim := newSimulation(fuzzSeedConfig(), 1)
+	for i := 0; i < 5; i++ {
+		sim.Step()
+		sim.Frame()
+	}
+	cp := takeCheckpoint(sim, runOutcome{Chronons: sim.Chronon, FishExtinct: -1, SharksExtinct: -1})
+	for _, encoding := range snapshotEncodings {
+		var buf bytes.Buffer
+		if err := writeSnapshot(&buf, cp, encoding); err != nil {
+			f.Fatal(err)
+		}
+		addSeeds(f, buf.Bytes())
+	}
+	f.Fuzz(func(t *testing.T, data []byte) {
+		cp, err := readSnapshot(bytes.NewReader(data))
+		if err != nil || cp.Params.GridSize > fuzzMaxGrid || cp.Draws > fuzzMaxDraws {
+			return
+		}
+		sim, err := cp.restore()
+		if err != nil {
+			return
+		}
+		// Panics in worker goroutines would end the fuzzer, so step serially
+		sim.Params.Workers = 1
+		for i := 0; i < 3; i++ {
+			sim.Step()
+			sim.Frame()
+		}
+	})
+}
+
+/*!
+ * \brief Frame logs with keyframes and deltas, as played by replay.
+ */
+func FuzzPlayFrameLog(f *testing.F) {
+	addSeeds(f, sinkOutput(f, openFrameLogSink, keyframeInterval+5))
+	f.Fuzz(func(t *testing.T, data []byte) {
+		playFrameLog(bytes.NewReader(data), nil, newGovernor(0), io.Discard)
+	})
+}
+
+/*!
+ * \brief Statistics CSVs, as analysed by the analyze subcommand.
+ */
+func FuzzReadStatsCSV(f *testing.F) {
+	addSeeds(f, sinkOutput(f, openCSVSink, 40))
+	f.Fuzz(func(t *testing.T, data []byte) {
+		statsScheme(data)
+		s, err := readStatsCSV(bytes.NewReader(data))
+		if err != nil {
+			return
+		}
+		writeSeriesSummary(io.Discard, "fish", s.Chronons, s.Fish)
+		writeSeriesSummary(io.Discard, "sharks", s.Chronons, s.Sharks)
+		series := []chartSeries{{Name: "fish", X: s.Chronons, Y: s.Fish}, {Name: "sharks", X: s.Chronons, Y: s.Sharks}}
+		if fit, err := fitLotkaVolterra(s.Fish, s.Sharks); err == nil {
+			n := len(fit.Fish)
+			series = append(series, chartSeries{Name: "fit", X: s.Chronons[:n], Y: fit.Fish})
+		}
+		writeSVGChart(io.Discard, "", "", "", series)
+	})
+}
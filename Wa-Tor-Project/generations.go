@@ -0,0 +1,190 @@
+/*!
+ * \file generations.go
+ * \brief The age and generation renderers: fish coloured by how old they are or how far their lineage goes back.
+ *
+ * Every creature has a generation: 0 when placed at the start or arriving
+ * from outside the grid, and one more than its parent's when born. The
+ * age renderer colours each fish by its age in doubling buckets (under 2,
+ * 4, 8, ... chronons), from pale yellow for the young to deep green for
+ * the old; the generation renderer colours it by its generation, from
+ * blue for the lowest in the frame to orange for the highest. Old stable
+ * schools show up as patches of old fish of low generations, and fresh
+ * expansion fronts as young fish of high generations, whose lineage bred
+ * through many quick generations to get there.
+ *
+ * Like the hunger of the sharks, the age and generation of the fish are
+ * only taken from the world when one of these renderers is selected.
+ * Frames replayed from a -frames log carry neither; their fish are drawn
+ * in the first colour.
+ */
+
+package main
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"math/bits"
+)
+
+/*!
+ * \brief Background colours of the fish from young to old
+ *        (256-colour palette), one per doubling of the age.
+ */
+var ageColours = []string{"\x1b[48;5;229m", "\x1b[48;5;191m", "\x1b[48;5;155m", "\x1b[48;5;114m",
+	"\x1b[48;5;71m", "\x1b[48;5;28m", "\x1b[48;5;22m"}
+
+/*!
+ * \brief Background colours of the fish from the lowest to the highest
+ *        generation of a frame (256-colour palette).
+ */
+var generationColours = []string{"\x1b[48;5;27m", "\x1b[48;5;63m", "\x1b[48;5;99m", "\x1b[48;5;135m",
+	"\x1b[48;5;171m", "\x1b[48;5;205m", "\x1b[48;5;208m"}
+
+/*!
+ * \brief Age and generation of the fish of a frame.
+ */
+type fishMap struct {
+	Age []int32 ///< Age per cell of a hatched fish, row-major; 0 elsewhere
+	Gen []int32 ///< Generation per cell of a hatched fish, row-major; 0 elsewhere
+}
+
+/*!
+ * \brief Take the age and generation of the fish of a world.
+ * \param world The world.
+ * \return Those of every hatched fish.
+ */
+func fishMapOf(world *World) *fishMap {
+	m := &fishMap{Age: make([]int32, world.Size*world.Size), Gen: make([]int32, world.Size*world.Size)}
+	for x := 0; x < world.Size; x++ {
+		for i := 0; i < world.creatures.stride; i++ {
+			for word := world.creatures.columnWord(x, i, 0, world.Size); word != 0; word &= word - 1 {
+				y := i<<6 + bits.TrailingZeros64(word)
+				if c := &world.Grid[x][y]; c.Species == Fish && c.Hatch == 0 {
+					m.Age[y*world.Size+x], m.Gen[y*world.Size+x] = c.Age, c.Gen
+				}
+			}
+		}
+	}
+	return m
+}
+
+/*!
+ * \brief Renderer redrawing the fish coloured by age or generation in place with a status bar.
+ */
+type fishRenderer struct {
+	out        io.Writer ///< Destination of the output
+	started    bool      ///< Whether the screen has been cleared yet
+	generation bool      ///< Colour by generation rather than by age
+}
+
+/*!
+ * \brief Create an age renderer.
+ * \param out Destination of the output (a terminal).
+ * \return The renderer.
+ */
+func newAgeRenderer(out io.Writer) Observer {
+	return &fishRenderer{out: out}
+}
+
+/*!
+ * \brief Create a generation renderer.
+ * \param out Destination of the output (a terminal).
+ * \return The renderer.
+ */
+func newGenerationRenderer(out io.Writer) Observer {
+	return &fishRenderer{out: out, generation: true}
+}
+
+/*!
+ * \brief Bucket of an age.
+ * \param age Age in chronons.
+ * \return 0 for ages under 2, 1 under 4, and so on, at most the last
+ *         of ageColours.
+ */
+func ageBucket(age int32) int {
+	return min(len(ageColours)-1, max(0, bits.Len32(uint32(age))-1))
+}
+
+/*!
+ * \brief Redraw the screen for a frame.
+ * \param f The frame to draw.
+ * \return Any write error.
+ */
+func (r *fishRenderer) Observe(f *Frame) error {
+	// Generations are coloured relative to the range of the frame
+	lowest, highest := int32(0), int32(0)
+	if r.generation {
+		first := true
+		for i, s := range f.Cells() {
+			_, gen, ok := f.FishAt(i)
+			if ok && s == Fish && !f.IsEgg(i%f.Size, i/f.Size) {
+				if first || gen < lowest {
+					lowest = gen
+				}
+				highest = max(highest, gen)
+				first = false
+			}
+		}
+	}
+
+	w := bufio.NewWriter(r.out)
+	if !r.started {
+		w.WriteString(ansiClear + ansiHideCursor)
+		r.started = true
+	}
+	w.WriteString(ansiHome)
+	for y := 0; y < f.Size; y++ {
+		var current string
+		for x := 0; x < f.Size; x++ {
+			age, gen, mapped := f.FishAt(y*f.Size + x)
+			colour := tuiColour(f.At(x, y))
+			switch {
+			case f.At(x, y) != Fish:
+			case f.IsEgg(x, y):
+				colour = tuiEggColours[Fish]
+			case mapped && r.generation && highest > lowest:
+				n := len(generationColours)
+				colour = generationColours[min(n-1, int(gen-lowest)*n/int(highest-lowest))]
+			case r.generation:
+				colour = generationColours[0]
+			case mapped:
+				colour = ageColours[ageBucket(age)]
+			default:
+				colour = ageColours[0]
+			}
+			if x == 0 || colour != current {
+				w.WriteString(colour)
+				current = colour
+			}
+			w.WriteString("  ")
+		}
+		w.WriteString(ansiReset + "\n")
+	}
+
+	// A legend of the colours with the values they stand for
+	fmt.Fprintf(w, "Chronon %d | Fish=%d | Sharks=%d | ", f.Chronon, f.Fish, f.Sharks)
+	if r.generation {
+		fmt.Fprintf(w, "Generation %d ", lowest)
+		for _, colour := range generationColours {
+			w.WriteString(colour + "  ")
+		}
+		fmt.Fprintf(w, "%s %d%s\n", ansiReset, highest, ansiClearLine)
+	} else {
+		w.WriteString("Age 0 ")
+		for _, colour := range ageColours {
+			w.WriteString(colour + "  ")
+		}
+		fmt.Fprintf(w, "%s %d+%s\n", ansiReset, 1<<(len(ageColours)-1), ansiClearLine)
+	}
+	return w.Flush()
+}
+
+/*!
+ * \brief Restore the cursor.
+ * \return Any write error.
+ */
+func (r *fishRenderer) Close() error {
+	_, err := io.WriteString(r.out, ansiShowCursor)
+	return err
+}
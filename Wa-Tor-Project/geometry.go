@@ -0,0 +1,165 @@
+/*!
+ * \file geometry.go
+ * \brief Distances, rings and nearest-creature queries on a world's grid.
+ *
+ * Creatures move one cell west, east, north or south per chronon, so the
+ * natural distance between two cells is the number of such steps: the
+ * taxicab distance, taken the shorter way round each axis of a torus. A
+ * bounded world measures it straight, since its walls do not wrap.
+ *
+ * Ring visits the cells at exactly a distance r from a cell, a diamond of
+ * up to 4r cells, and Within the cells up to r away, ring by ring. On a
+ * torus smaller than the diamond the ring wraps onto itself; every cell
+ * is still visited once, at its true distance. Nearest searches outward
+ * for a creature of a species, the first in ring order winning a tie, so
+ * the answer is deterministic.
+ *
+ * Together they serve rules that look further than the four neighbours,
+ * such as scent or flight, and analyses written against the functional
+ * API (step.go). All of them read the grid only.
+ */
+
+package main
+
+import "iter"
+
+/*!
+ * \brief Shortest signed offset between two coordinates along one axis.
+ * \param a The coordinate to measure from.
+ * \param b The coordinate to measure to.
+ * \return b - a, the shorter way round on a torus: between -(Size-1)/2
+ *         and Size/2, so a half-way point on an even grid is positive.
+ */
+func (w *World) axisOffset(a, b int) int {
+	d := b - a
+	if w.bounded {
+		return d
+	}
+	if d > w.Size/2 {
+		d -= w.Size
+	} else if d < -(w.Size-1)/2 {
+		d += w.Size
+	}
+	return d
+}
+
+/*!
+ * \brief Shortest displacement from one cell to another.
+ * \param x0 X coordinate of the first cell.
+ * \param y0 Y coordinate of the first cell.
+ * \param x1 X coordinate of the second cell.
+ * \param y1 Y coordinate of the second cell.
+ * \return The offsets along x and y, the shorter way round each axis of a
+ *         torus; math.Hypot of them is the Euclidean distance.
+ */
+func (w *World) Offset(x0, y0, x1, y1 int) (dx, dy int) {
+	return w.axisOffset(x0, x1), w.axisOffset(y0, y1)
+}
+
+/*!
+ * \brief Number of steps between two cells.
+ * \param x0 X coordinate of the first cell.
+ * \param y0 Y coordinate of the first cell.
+ * \param x1 X coordinate of the second cell.
+ * \param y1 Y coordinate of the second cell.
+ * \return The taxicab distance, wrapped on a torus.
+ */
+func (w *World) Distance(x0, y0, x1, y1 int) int {
+	dx, dy := w.Offset(x0, y0, x1, y1)
+	return max(dx, -dx) + max(dy, -dy)
+}
+
+/*!
+ * \brief Largest distance between two cells of the grid.
+ * \return Size/2 twice on a torus, Size-1 twice in a bounded world.
+ */
+func (w *World) MaxDistance() int {
+	if w.bounded {
+		return 2 * (w.Size - 1)
+	}
+	return 2 * (w.Size / 2)
+}
+
+/*!
+ * \brief The cell at an offset from another, if the offset is its shortest one.
+ * \param x X coordinate of the cell to measure from.
+ * \param y Y coordinate of the cell to measure from.
+ * \param dx Offset along x.
+ * \param dy Offset along y.
+ * \return The cell and true; false past the walls of a bounded world, or
+ *         if a shorter way round a torus reaches the cell.
+ */
+func (w *World) offsetCell(x, y, dx, dy int) (int, int, bool) {
+	if w.bounded {
+		cx, cy := x+dx, y+dy
+		return cx, cy, cx >= 0 && cy >= 0 && cx < w.Size && cy < w.Size
+	}
+	cx, cy := wrap(x+dx, w.Size), wrap(y+dy, w.Size)
+	return cx, cy, w.axisOffset(x, cx) == dx && w.axisOffset(y, cy) == dy
+}
+
+/*!
+ * \brief The cells at exactly a distance from a cell.
+ * \param x X coordinate of the centre.
+ * \param y Y coordinate of the centre.
+ * \param r The distance; 0 gives the centre alone, and a negative one nothing.
+ * \return The cells, each once, going round from the west.
+ */
+func (w *World) Ring(x, y, r int) iter.Seq2[int, int] {
+	return func(yield func(int, int) bool) {
+		if r == 0 {
+			yield(x, y)
+			return
+		}
+		for dx := -r; dx <= r; dx++ {
+			dy := r - max(dx, -dx)
+			if cx, cy, ok := w.offsetCell(x, y, dx, dy); ok && !yield(cx, cy) {
+				return
+			}
+			if dy == 0 {
+				continue
+			}
+			if cx, cy, ok := w.offsetCell(x, y, dx, -dy); ok && !yield(cx, cy) {
+				return
+			}
+		}
+	}
+}
+
+/*!
+ * \brief The cells up to a distance from a cell.
+ * \param x X coordinate of the centre.
+ * \param y Y coordinate of the centre.
+ * \param r The largest distance.
+ * \return The cells, each once, ring by ring from the centre outwards.
+ */
+func (w *World) Within(x, y, r int) iter.Seq2[int, int] {
+	return func(yield func(int, int) bool) {
+		for d := 0; d <= min(r, w.MaxDistance()); d++ {
+			for cx, cy := range w.Ring(x, y, d) {
+				if !yield(cx, cy) {
+					return
+				}
+			}
+		}
+	}
+}
+
+/*!
+ * \brief Find the nearest creature of a species.
+ * \param x X coordinate to search from.
+ * \param y Y coordinate to search from.
+ * \param s The species; eggs count as creatures of theirs.
+ * \param r The largest distance to search; MaxDistance covers the grid.
+ * \return The creature's cell and true, or false if none is within r.
+ *         The cell (x, y) itself is not searched, so a creature asking
+ *         from its own cell finds another.
+ */
+func (w *World) Nearest(x, y int, s Species, r int) (int, int, bool) {
+	for cx, cy := range w.Within(x, y, r) {
+		if (cx != x || cy != y) && w.Grid[cx][cy].Species == s {
+			return cx, cy, true
+		}
+	}
+	return 0, 0, false
+}
@@ -0,0 +1,138 @@
+/*!
+ * \file geometry_test.go
+ * \brief Distances, rings and nearest-creature queries at the edges of the grid.
+ */
+
+package main
+
+import "testing"
+
+/*!
+ * \brief Create an empty world for the geometry tests.
+ * \param size Width/height of the grid.
+ * \param bounded Whether the edges are walls rather than wrapping around.
+ * \return The world.
+ */
+func geometryWorld(size int, bounded bool) *World {
+	w := createWorld(size)
+	w.bounded = bounded
+	return w
+}
+
+/*!
+ * \brief Offsets and distances wrap the shorter way round a torus only.
+ */
+func TestDistanceWraps(t *testing.T) {
+	tests := []struct {
+		size           int
+		bounded        bool
+		x0, y0, x1, y1 int
+		dx, dy, d      int
+	}{
+		{10, false, 0, 0, 9, 0, -1, 0, 1},
+		{10, false, 9, 9, 0, 0, 1, 1, 2},
+		{10, false, 0, 0, 5, 5, 5, 5, 10},
+		{10, false, 5, 0, 0, 0, 5, 0, 5},
+		{11, false, 0, 0, 6, 0, -5, 0, 5},
+		{10, false, 1, 1, 8, 8, -3, -3, 6},
+		{10, true, 0, 0, 9, 0, 9, 0, 9},
+		{10, true, 9, 9, 0, 0, -9, -9, 18},
+	}
+	for _, tt := range tests {
+		w := geometryWorld(tt.size, tt.bounded)
+		if dx, dy := w.Offset(tt.x0, tt.y0, tt.x1, tt.y1); dx != tt.dx || dy != tt.dy {
+			t.Errorf("size %d bounded %v: Offset(%d,%d,%d,%d) = %d,%d, want %d,%d",
+				tt.size, tt.bounded, tt.x0, tt.y0, tt.x1, tt.y1, dx, dy, tt.dx, tt.dy)
+		}
+		if d := w.Distance(tt.x0, tt.y0, tt.x1, tt.y1); d != tt.d {
+			t.Errorf("size %d bounded %v: Distance(%d,%d,%d,%d) = %d, want %d",
+				tt.size, tt.bounded, tt.x0, tt.y0, tt.x1, tt.y1, d, tt.d)
+		}
+	}
+}
+
+/*!
+ * \brief A ring has 4r distinct cells at distance r, fewer where it meets a
+ *        wall or wraps onto itself.
+ */
+func TestRingCounts(t *testing.T) {
+	tests := []struct {
+		size    int
+		bounded bool
+		x, y, r int
+		cells   int
+	}{
+		{21, false, 10, 10, 0, 1},
+		{21, false, 10, 10, 1, 4},
+		{21, false, 0, 0, 3, 12},
+		{21, false, 20, 20, 10, 40},
+		{4, false, 0, 0, 1, 4},
+		{4, false, 0, 0, 2, 6},
+		{4, false, 0, 0, 3, 4},
+		{4, false, 0, 0, 4, 1},
+		{10, true, 0, 0, 2, 3},
+		{10, true, 5, 0, 2, 5},
+		{10, true, 9, 9, 18, 1},
+		{10, false, 5, 5, -1, 0},
+	}
+	for _, tt := range tests {
+		w := geometryWorld(tt.size, tt.bounded)
+		seen := map[[2]int]bool{}
+		for x, y := range w.Ring(tt.x, tt.y, tt.r) {
+			if seen[[2]int{x, y}] {
+				t.Errorf("size %d bounded %v: Ring(%d,%d,%d) visits (%d,%d) twice", tt.size, tt.bounded, tt.x, tt.y, tt.r, x, y)
+			}
+			seen[[2]int{x, y}] = true
+			if d := w.Distance(tt.x, tt.y, x, y); d != tt.r {
+				t.Errorf("size %d bounded %v: Ring(%d,%d,%d) visits (%d,%d) at distance %d", tt.size, tt.bounded, tt.x, tt.y, tt.r, x, y, d)
+			}
+		}
+		if len(seen) != tt.cells {
+			t.Errorf("size %d bounded %v: Ring(%d,%d,%d) has %d cells, want %d", tt.size, tt.bounded, tt.x, tt.y, tt.r, len(seen), tt.cells)
+		}
+	}
+
+	// Within the largest distance covers the whole grid once
+	for _, bounded := range []bool{false, true} {
+		w := geometryWorld(5, bounded)
+		n := 0
+		for range w.Within(1, 3, w.MaxDistance()) {
+			n++
+		}
+		if n != 25 {
+			t.Errorf("bounded %v: Within(1,3,MaxDistance) has %d cells, want 25", bounded, n)
+		}
+	}
+}
+
+/*!
+ * \brief Nearest finds the closest creature, the first in ring order on a
+ *        tie, and never the cell it searches from.
+ */
+func TestNearestTies(t *testing.T) {
+	tests := []struct {
+		fish    [][2]int
+		r       int
+		x, y    int
+		found   bool
+		comment string
+	}{
+		{[][2]int{{3, 5}, {7, 5}}, 5, 3, 5, true, "west before east"},
+		{[][2]int{{5, 3}, {5, 7}}, 5, 5, 7, true, "south before north on the middle column"},
+		{[][2]int{{4, 4}, {4, 6}}, 5, 4, 6, true, "south before north on a column"},
+		{[][2]int{{5, 2}, {6, 5}}, 5, 6, 5, true, "nearer beats earlier"},
+		{[][2]int{{5, 5}}, 5, 0, 0, false, "own cell"},
+		{[][2]int{{0, 5}}, 4, 0, 0, false, "out of range"},
+		{[][2]int{{10, 5}}, 5, 10, 5, true, "at the far edge"},
+	}
+	for _, tt := range tests {
+		w := geometryWorld(11, false)
+		for _, f := range tt.fish {
+			w.put(f[0], f[1], &Creature{Species: Fish})
+		}
+		x, y, found := w.Nearest(5, 5, Fish, tt.r)
+		if found != tt.found || found && (x != tt.x || y != tt.y) {
+			t.Errorf("%s: Nearest = %d,%d,%v, want %d,%d,%v", tt.comment, x, y, found, tt.x, tt.y, tt.found)
+		}
+	}
+}
@@ -0,0 +1,210 @@
+/*!
+ * \file gifenc.go
+ * \brief Encoding of GIF frames on a pool of worker goroutines.
+ *
+ * image/gif only encodes a whole animation at once, so the GIF sink used
+ * to keep every frame as a raw image and encode them all when the run
+ * ended: the run finished, then sat for seconds compressing. The sink now
+ * hands each frame it keeps to an encoder pool instead. Workers scale the
+ * cells up to pixels and LZW-compress them into a complete GIF image block
+ * while the simulation carries on; when the run ends only the header and
+ * the finished blocks remain to be written.
+ *
+ * The pool has one worker per CPU and a queue of two frames per worker.
+ * When the queue is full the sink waits, and so, once its frame buffer is
+ * full too, does the simulation, so a run never gets ahead of its GIF by
+ * more than a bounded number of frames.
+ */
+
+package main
+
+import (
+	"bufio"
+	"compress/lzw"
+	"encoding/binary"
+	"fmt"
+	"io"
+	"sync"
+)
+
+/*!
+ * \brief Delay between GIF frames, in hundredths of a second.
+ */
+const gifDelay = 10
+
+/*!
+ * \brief One frame of a GIF, compressed or being compressed.
+ */
+type gifFrame struct {
+	block []byte ///< Image descriptor and LZW data; valid once the pool is closed
+}
+
+/*!
+ * \brief Work for a GIF encoder: a frame and where its block goes.
+ */
+type gifJob struct {
+	frame *Frame    ///< Frame to encode
+	out   *gifFrame ///< Destination of the block
+}
+
+/*!
+ * \brief Pool of goroutines encoding GIF frames.
+ */
+type gifEncoder struct {
+	jobs chan gifJob    ///< Bounded queue of frames to encode
+	wg   sync.WaitGroup ///< Tracks running workers
+}
+
+/*!
+ * \brief Start a pool of GIF encoders.
+ * \param workers Number of worker goroutines.
+ * \return The pool; close it to wait for the queued frames.
+ */
+func newGIFEncoder(workers int) *gifEncoder {
+	e := &gifEncoder{jobs: make(chan gifJob, 2*workers)}
+	for w := 0; w < workers; w++ {
+		e.wg.Add(1)
+		go func() {
+			defer e.wg.Done()
+			for job := range e.jobs {
+				job.out.block = encodeGIFBlock(job.frame)
+			}
+		}()
+	}
+	return e
+}
+
+/*!
+ * \brief Queue a frame, waiting while the queue is full.
+ * \param f The frame; frames are immutable, so it is not copied.
+ * \return The frame's entry, whose block is set once the pool is closed.
+ */
+func (e *gifEncoder) encode(f *Frame) *gifFrame {
+	out := &gifFrame{}
+	e.jobs <- gifJob{f, out}
+	return out
+}
+
+/*!
+ * \brief Stop accepting frames and wait until the queued ones are encoded.
+ */
+func (e *gifEncoder) close() {
+	close(e.jobs)
+	e.wg.Wait()
+}
+
+/*!
+ * \brief Size field of the GIF colour table.
+ * \return n such that the table of 2^(n+1) colours holds gifPalette.
+ */
+func gifPaletteBits() int {
+	n := 0
+	for 2<<n < len(gifPalette) {
+		n++
+	}
+	return n
+}
+
+/*!
+ * \brief Splits a byte stream into GIF data sub-blocks of at most 255 bytes.
+ */
+type gifBlockWriter struct {
+	out []byte    ///< Sub-blocks written so far
+	buf [255]byte ///< Pending sub-block
+	n   int       ///< Bytes pending
+}
+
+/*!
+ * \brief Append bytes, emitting full sub-blocks.
+ * \param p The bytes.
+ * \return len(p) and nil.
+ */
+func (b *gifBlockWriter) Write(p []byte) (int, error) {
+	for _, c := range p {
+		b.buf[b.n] = c
+		b.n++
+		if b.n == len(b.buf) {
+			b.flush()
+		}
+	}
+	return len(p), nil
+}
+
+/*!
+ * \brief Emit the pending bytes as a sub-block.
+ */
+func (b *gifBlockWriter) flush() {
+	if b.n > 0 {
+		b.out = append(b.out, byte(b.n))
+		b.out = append(b.out, b.buf[:b.n]...)
+		b.n = 0
+	}
+}
+
+/*!
+ * \brief Encode a frame as a GIF image block, gifScale pixels per cell.
+ * \param f The frame.
+ * \return The image descriptor, the LZW minimum code size and the terminated data sub-blocks.
+ */
+func encodeGIFBlock(f *Frame) []byte {
+	side := f.Size * gifScale
+	litWidth := max(gifPaletteBits()+1, 2)
+	b := &gifBlockWriter{}
+	b.out = append(b.out, 0x2c, 0, 0, 0, 0)
+	b.out = binary.LittleEndian.AppendUint16(b.out, uint16(side))
+	b.out = binary.LittleEndian.AppendUint16(b.out, uint16(side))
+	b.out = append(b.out, 0, byte(litWidth))
+
+	lz := lzw.NewWriter(b, lzw.LSB, litWidth)
+	row := make([]byte, side)
+	for y := 0; y < side; y++ {
+		for x := range row {
+			row[x] = uint8(f.At(x/gifScale, y/gifScale))
+		}
+		lz.Write(row)
+	}
+	lz.Close()
+	b.flush()
+	return append(b.out, 0)
+}
+
+/*!
+ * \brief Write an animated GIF from encoded frames.
+ * \param w Destination.
+ * \param size Grid size of the frames.
+ * \param frames The frames, in order, with their blocks encoded.
+ * \return Any write error.
+ */
+func writeGIF(w io.Writer, size int, frames []*gifFrame) error {
+	side := size * gifScale
+	if side > 0xffff {
+		return fmt.Errorf("a %dx%d grid is too large for a GIF", size, size)
+	}
+	bw := bufio.NewWriter(w)
+	bits := gifPaletteBits()
+	header := []byte("GIF89a")
+	header = binary.LittleEndian.AppendUint16(header, uint16(side))
+	header = binary.LittleEndian.AppendUint16(header, uint16(side))
+	header = append(header, 0x80|byte(bits), 0, 0)
+	for i := 0; i < 2<<bits; i++ {
+		if i < len(gifPalette) {
+			r, g, b, _ := gifPalette[i].RGBA()
+			header = append(header, byte(r>>8), byte(g>>8), byte(b>>8))
+		} else {
+			header = append(header, 0, 0, 0)
+		}
+	}
+	if len(frames) > 1 {
+		// Loop forever.
+		header = append(header, 0x21, 0xff, 0x0b)
+		header = append(header, "NETSCAPE2.0"...)
+		header = append(header, 0x03, 0x01, 0, 0, 0)
+	}
+	bw.Write(header)
+	for _, f := range frames {
+		bw.Write([]byte{0x21, 0xf9, 0x04, 0, gifDelay, 0, 0, 0})
+		bw.Write(f.block)
+	}
+	bw.WriteByte(0x3b)
+	return bw.Flush()
+}
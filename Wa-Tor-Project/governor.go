@@ -0,0 +1,75 @@
+/*!
+ * \file governor.go
+ * \brief Rate limiter that paces the simulation to a target chronons/sec.
+ */
+
+package main
+
+import "time"
+
+/*!
+ * \brief Longest backlog the governor will try to catch up on.
+ *
+ * After a stall longer than this (e.g. the terminal was suspended) the
+ * schedule is reset instead of racing through the missed chronons.
+ */
+const maxCatchUp = time.Second
+
+/*!
+ * \brief Paces a loop to a target number of iterations per second.
+ *
+ * Deadlines are computed from a fixed schedule rather than by sleeping a
+ * fixed amount after each iteration, so a slow chronon is followed by
+ * shorter waits until the loop is back on schedule.
+ */
+type governor struct {
+	interval time.Duration ///< Time budget per chronon (0 = unlimited)
+	start    time.Time     ///< When the governor was created
+	next     time.Time     ///< Deadline of the next chronon
+	ticks    int           ///< Chronons completed
+}
+
+/*!
+ * \brief Create a governor.
+ * \param cps Target chronons per second; 0 or less means unlimited.
+ * \return Pointer to the new governor.
+ */
+func newGovernor(cps float64) *governor {
+	g := &governor{start: time.Now()}
+	if cps > 0 {
+		g.interval = time.Duration(float64(time.Second) / cps)
+	}
+	g.next = g.start.Add(g.interval)
+	return g
+}
+
+/*!
+ * \brief Mark a chronon as done and wait until the next one is due.
+ */
+func (g *governor) wait() {
+	g.ticks++
+	if g.interval == 0 {
+		return
+	}
+
+	now := time.Now()
+	if now.Sub(g.next) > maxCatchUp {
+		g.next = now
+	}
+	if d := g.next.Sub(now); d > 0 {
+		time.Sleep(d)
+	}
+	g.next = g.next.Add(g.interval)
+}
+
+/*!
+ * \brief Achieved rate since the governor was created.
+ * \return Chronons per second actually completed.
+ */
+func (g *governor) rate() float64 {
+	elapsed := time.Since(g.start).Seconds()
+	if elapsed == 0 {
+		return 0
+	}
+	return float64(g.ticks) / elapsed
+}
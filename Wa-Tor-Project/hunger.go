@@ -0,0 +1,188 @@
+/*!
+ * \file hunger.go
+ * \brief The hunger renderer: a heatmap of starvation, to spot famine fronts early.
+ *
+ * Every shark is coloured by how close it is to starving, its energy as a
+ * share of the starvation time, from green when well fed through yellow
+ * to red on its last chronons. The water is shaded by how long ago
+ * predation last happened around it: the grid is divided into regions of
+ * hungerRegion by hungerRegion cells, and each region shows the chronons
+ * since a creature was last eaten anywhere in it, from dark blue for
+ * recent kills to magenta for two starvation times or more. Single cells
+ * would be too sparse to show anything, since most never see a kill. A
+ * region turning magenta with sharks in it is a famine front, visible
+ * before the sharks die and the population crashes.
+ *
+ * The energy of the sharks is only taken from the world when this
+ * renderer is selected, so other renderers do not pay for it. Frames
+ * replayed from a -frames log carry neither energy nor kills, so their
+ * sharks are drawn red and their water magenta.
+ */
+
+package main
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"math/bits"
+)
+
+/*!
+ * \brief Width and height of the regions the water is shaded by, in cells.
+ */
+const hungerRegion = 8
+
+/*!
+ * \brief Share of the starvation time below which a shark counts as starving.
+ */
+const starvingRatio = 0.25
+
+/*!
+ * \brief Background colours of the sharks from starving to well fed
+ *        (256-colour palette).
+ */
+var hungerSharkColours = []string{"\x1b[48;5;196m", "\x1b[48;5;202m", "\x1b[48;5;208m", "\x1b[48;5;214m",
+	"\x1b[48;5;220m", "\x1b[48;5;154m", "\x1b[48;5;46m"}
+
+/*!
+ * \brief Background colours of the water from recent predation to two
+ *        starvation times without any (256-colour palette).
+ */
+var hungerWaterColours = []string{"\x1b[48;5;17m", "\x1b[48;5;18m", "\x1b[48;5;19m", "\x1b[48;5;54m",
+	"\x1b[48;5;90m", "\x1b[48;5;126m", "\x1b[48;5;163m"}
+
+/*!
+ * \brief Hunger of the sharks of a frame.
+ */
+type hungerMap struct {
+	Ratio  []float32 ///< Energy over starvation time per cell of a hatched shark, row-major; 0 elsewhere
+	Starve int       ///< Shark starvation time
+}
+
+/*!
+ * \brief Take the hunger of the sharks of a world.
+ * \param world The world.
+ * \return The hunger of every hatched shark.
+ */
+func hungerOf(world *World) *hungerMap {
+	h := &hungerMap{Ratio: make([]float32, world.Size*world.Size), Starve: world.Starve}
+	if world.Starve <= 0 {
+		return h
+	}
+	for x := 0; x < world.Size; x++ {
+		for i := 0; i < world.creatures.stride; i++ {
+			for word := world.creatures.columnWord(x, i, 0, world.Size); word != 0; word &= word - 1 {
+				y := i<<6 + bits.TrailingZeros64(word)
+				if c := &world.Grid[x][y]; c.Species == Shark && c.Hatch == 0 {
+					h.Ratio[y*world.Size+x] = min(1, max(0, float32(c.Energy)/float32(world.Starve)))
+				}
+			}
+		}
+	}
+	return h
+}
+
+/*!
+ * \brief Renderer redrawing the starvation heatmap in place with a status bar.
+ */
+type hungerRenderer struct {
+	out     io.Writer ///< Destination of the output
+	started bool      ///< Whether the screen has been cleared yet
+	eaten   []int     ///< Chronon of the last predation per region, row-major
+	size    int       ///< Width/height of the grid the regions cover
+}
+
+/*!
+ * \brief Create a hunger renderer.
+ * \param out Destination of the output (a terminal).
+ * \return The renderer.
+ */
+func newHungerRenderer(out io.Writer) Observer {
+	return &hungerRenderer{out: out}
+}
+
+/*!
+ * \brief Redraw the heatmap for a frame.
+ * \param f The frame to draw.
+ * \return Any write error.
+ */
+func (r *hungerRenderer) Observe(f *Frame) error {
+	regions := (f.Size + hungerRegion - 1) / hungerRegion
+	if r.size != f.Size {
+		// Regions without predation yet count from the first frame
+		r.size = f.Size
+		r.eaten = make([]int, regions*regions)
+		for i := range r.eaten {
+			r.eaten[i] = f.Chronon - 1
+		}
+	}
+	for ev := range f.Events() {
+		if ev.Kind == Eaten {
+			r.eaten[ev.Y/hungerRegion*regions+ev.X/hungerRegion] = f.Chronon
+		}
+	}
+	starve := defaultConfig().Starve
+	if s := f.HungerStarve(); s > 0 {
+		starve = s
+	}
+	famine := 0
+	for _, chronon := range r.eaten {
+		if f.Chronon-chronon >= starve {
+			famine++
+		}
+	}
+
+	w := bufio.NewWriter(r.out)
+	if !r.started {
+		w.WriteString(ansiClear + ansiHideCursor)
+		r.started = true
+	}
+	w.WriteString(ansiHome)
+	starving := 0
+	for y := 0; y < f.Size; y++ {
+		var current string
+		for x := 0; x < f.Size; x++ {
+			var colour string
+			ratio, mapped := f.HungerAt(x, y)
+			switch s := f.At(x, y); {
+			case s == Shark && f.IsEgg(x, y):
+				colour = tuiEggColours[Shark]
+			case s == Shark && !mapped:
+				colour = hungerSharkColours[0]
+			case s == Shark:
+				if ratio < starvingRatio {
+					starving++
+				}
+				n := len(hungerSharkColours)
+				colour = hungerSharkColours[min(n-1, int(ratio*float32(n)))]
+			case s == Empty:
+				n := len(hungerWaterColours)
+				since := f.Chronon - r.eaten[y/hungerRegion*regions+x/hungerRegion]
+				colour = hungerWaterColours[min(n-1, since*n/(2*starve))]
+			case s == Fish && f.IsEgg(x, y):
+				colour = tuiEggColours[Fish]
+			default:
+				colour = tuiColour(s)
+			}
+			if x == 0 || colour != current {
+				w.WriteString(colour)
+				current = colour
+			}
+			w.WriteString("  ")
+		}
+		w.WriteString(ansiReset + "\n")
+	}
+	fmt.Fprintf(w, "Chronon %d | Fish=%d | Sharks=%d | Starving=%d | Famine regions=%d/%d%s\n",
+		f.Chronon, f.Fish, f.Sharks, starving, famine, len(r.eaten), ansiClearLine)
+	return w.Flush()
+}
+
+/*!
+ * \brief Restore the cursor.
+ * \return Any write error.
+ */
+func (r *hungerRenderer) Close() error {
+	_, err := io.WriteString(r.out, ansiShowCursor)
+	return err
+}
@@ -0,0 +1,139 @@
+/*!
+ * \file hunting.go
+ * \brief Rolling shark hunting-efficiency metrics.
+ *
+ * Hunting efficiency is the number of fish eaten divided by the number of
+ * shark-chronons (one shark being updated for one chronon) in the sampling
+ * window. Time-to-starve is the mean age of the sharks that starved in the
+ * window.
+ */
+
+package main
+
+/*!
+ * \brief Hunting metrics over the most recent sampling window.
+ */
+type HuntingMetrics struct {
+	Efficiency       float64 ///< Fish eaten per shark-chronon
+	MeanTimeToStarve float64 ///< Mean age at starvation (0 if no shark starved)
+}
+
+/*!
+ * \brief Hunting totals of a single chronon.
+ */
+type huntSample struct {
+	Hunts         int ///< Fish eaten
+	SharkChronons int ///< Sharks updated
+	Starved       int ///< Sharks that starved
+	StarvedAge    int ///< Summed age of the starved sharks
+}
+
+/*!
+ * \brief Ring buffer of per-chronon hunting samples with running totals.
+ */
+type huntWindow struct {
+	samples []huntSample ///< Ring buffer of the last len(samples) chronons
+	next    int          ///< Index the next sample is written to
+	filled  int          ///< Number of valid samples
+	total   huntSample   ///< Sum over the valid samples
+}
+
+/*!
+ * \brief Create a window.
+ * \param size Number of chronons in the window (at least 1).
+ * \return Pointer to the new window.
+ */
+func newHuntWindow(size int) *huntWindow {
+	if size < 1 {
+		size = 1
+	}
+	return &huntWindow{samples: make([]huntSample, size)}
+}
+
+/*!
+ * \brief Add the events of a chronon to the window.
+ * \param sharks Number of sharks alive after the chronon.
+ * \param events Events of the chronon.
+ */
+func (w *huntWindow) add(sharks int, events []Event) {
+	s := huntSample{}
+	births, left := 0, 0
+	for _, ev := range events {
+		switch {
+		case ev.Kind == Eaten && ev.Species == Fish:
+			s.Hunts++
+		case ev.Kind == Starved && ev.Species == Shark:
+			s.Starved++
+			s.StarvedAge += ev.Age
+		case ev.Kind == Died && ev.Species == Shark:
+			left++
+		case (ev.Kind == Birth || ev.Kind == Immigrated || ev.Kind == Introduced) && ev.Species == Shark:
+			births++
+		case ev.Kind == Emigrated && ev.Species == Shark:
+			left++
+		}
+	}
+	// Sharks updated this chronon: those alive now that were not just born or arrived, plus those that starved, died or left
+	s.SharkChronons = sharks - births + s.Starved + left
+	w.push(s)
+}
+
+/*!
+ * \brief Add a sample to the window, dropping the oldest if it is full.
+ * \param s The sample.
+ */
+func (w *huntWindow) push(s huntSample) {
+	if w.filled == len(w.samples) {
+		w.total = w.total.minus(w.samples[w.next])
+	} else {
+		w.filled++
+	}
+	w.samples[w.next] = s
+	w.next = (w.next + 1) % len(w.samples)
+	w.total = w.total.plus(s)
+}
+
+/*!
+ * \brief Valid samples of the window, oldest first.
+ * \return The samples; pushing them into an empty window of the same size restores it.
+ */
+func (w *huntWindow) recent() []huntSample {
+	out := make([]huntSample, 0, w.filled)
+	for i := w.filled; i > 0; i-- {
+		out = append(out, w.samples[(w.next-i+len(w.samples))%len(w.samples)])
+	}
+	return out
+}
+
+/*!
+ * \brief Metrics over the current window.
+ * \return The hunting metrics.
+ */
+func (w *huntWindow) metrics() HuntingMetrics {
+	m := HuntingMetrics{}
+	if w.total.SharkChronons > 0 {
+		m.Efficiency = float64(w.total.Hunts) / float64(w.total.SharkChronons)
+	}
+	if w.total.Starved > 0 {
+		m.MeanTimeToStarve = float64(w.total.StarvedAge) / float64(w.total.Starved)
+	}
+	return m
+}
+
+/*!
+ * \brief Field-wise sum of two samples.
+ * \param o The other sample.
+ * \return s + o.
+ */
+func (s huntSample) plus(o huntSample) huntSample {
+	return huntSample{s.Hunts + o.Hunts, s.SharkChronons + o.SharkChronons, s.Starved + o.Starved, s.StarvedAge + o.StarvedAge}
+}
+
+/*!
+ * \brief Field-wise difference of two samples.
+ * \param o The other sample.
+ * \return s - o.
+ */
+func (s huntSample) minus(o huntSample) huntSample {
+	return huntSample{s.Hunts - o.Hunts, s.SharkChronons - o.SharkChronons, s.Starved - o.Starved, s.StarvedAge - o.StarvedAge}
+}
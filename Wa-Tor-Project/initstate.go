@@ -0,0 +1,70 @@
+/*!
+ * \file initstate.go
+ * \brief Random ages, breed counters and energy of the initial creatures.
+ *
+ * With -init-state random the initial creatures do not all breed and
+ * starve in step.
+ */
+
+package main
+
+import (
+	"fmt"
+	"math/rand"
+)
+
+/*!
+ * \brief Parse the name of an initial state.
+ * \param name "newborn" or "random".
+ * \return Whether the initial creatures start in a random state, or an error if the name is unknown.
+ */
+func parseInitState(name string) (bool, error) {
+	switch name {
+	case "newborn":
+		return false, nil
+	case "random":
+		return true, nil
+	}
+	return false, fmt.Errorf("unknown initial state %q (want newborn or random)", name)
+}
+
+/*!
+ * \brief Name of an initial state.
+ * \param random Whether the initial creatures start in a random state.
+ * \return "random" or "newborn".
+ */
+func initStateName(random bool) string {
+	if random {
+		return "random"
+	}
+	return "newborn"
+}
+
+/*!
+ * \brief Put a creature placed at the start at a random point of its cycle.
+ * \param c The creature, as a newborn.
+ * \param params Simulation parameters.
+ * \param rng Random source.
+ */
+func randomizeState(c *Creature, params Config, rng *rand.Rand) {
+	breed := params.FishBreed
+	if r := params.Web.registered(c.Species); r != nil {
+		breed = r.Breed
+	} else if c.Species == Shark {
+		breed = params.SharkBreed
+	}
+	breed = max(breed, 1)
+	c.LastBreed = int32(rng.Intn(breed))
+	c.Age = c.LastBreed
+	if a := params.Aging; a.Maturity > 0 || a.Old > 0 {
+		span := a.Old
+		if span == 0 {
+			span = a.Maturity + breed
+		}
+		c.Age = int32(rng.Intn(span))
+		c.LastBreed = min(c.LastBreed, c.Age)
+	}
+	if c.Energy > 0 {
+		c.Energy = 1 + int32(rng.Intn(int(c.Energy)))
+	}
+}
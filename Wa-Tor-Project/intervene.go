@@ -0,0 +1,440 @@
+/*!
+ * \file intervene.go
+ * \brief Counterfactual interventions: an intervened and a control branch from one state.
+ *
+ * An intervention changes the world at a chosen chronon, and its effect
+ * is measured against the run that would have happened without it. The
+ * intervene subcommand runs the simulation to the chronon of the
+ * intervention (or starts from a checkpoint), captures the state, and
+ * continues it twice in lockstep: once untouched as the control and once
+ * with the intervention. Its actions draw from a random source of their
+ * own, seeded with the seed of the run, so both branches continue the
+ * same random stream and differ only by the intervention.
+ *
+ * Actions, separated by ";":
+ *
+ *     cull SPECIES PERCENT% [AREA]  remove that share of a species, at random
+ *     add SPECIES COUNT [AREA]      release creatures on free water cells
+ *     reserve AREA                  make the water of a region a reef, where
+ *                                   fish are safe; the predators in it are removed
+ *
+ * An AREA is "X,Y WxH" or a compass name as for -introduce; without one
+ * the whole grid is meant. Intervene applies actions to World values in
+ * the style of the functional API of step.go.
+ */
+
+package main
+
+import (
+	"encoding/csv"
+	"errors"
+	"fmt"
+	"io"
+	"math/rand"
+	"os"
+	"strconv"
+	"strings"
+)
+
+/*!
+ * \brief One action of an intervention.
+ */
+type intervention struct {
+	Kind    string  ///< "cull", "add" or "reserve"
+	Species Species ///< Species culled or added
+	Share   float64 ///< Share of the species culled, in [0, 1]
+	Count   int     ///< Creatures added
+	X, Y    int     ///< Top left cell of the region
+	Width   int     ///< Columns covered
+	Height  int     ///< Rows covered
+	Text    string  ///< The action as given
+}
+
+/*!
+ * \brief Parse the region at the end of an action.
+ * \param fields The fields of the region: none, a compass name, or X,Y and WxH.
+ * \param size Width/height of the grid.
+ * \return The top left cell and size of the region, the whole grid for no fields.
+ */
+func parseInterventionArea(fields []string, size int) (x, y, w, h int, err error) {
+	switch len(fields) {
+	case 0:
+		return 0, 0, size, size, nil
+	case 1:
+		var ok bool
+		if x, y, w, ok = introductionArea(fields[0], size); !ok {
+			return 0, 0, 0, 0, fmt.Errorf("unknown area %q (want X,Y WxH or one of %s)", fields[0], strings.Join(introductionAreas, ", "))
+		}
+		return x, y, w, w, nil
+	case 2:
+		if _, err := fmt.Sscanf(strings.Join(fields, " "), "%d,%d %dx%d", &x, &y, &w, &h); err != nil {
+			return 0, 0, 0, 0, errors.New("want an area X,Y WxH")
+		}
+		if w < 1 || h < 1 || x < 0 || y < 0 || x+w > size || y+h > size {
+			return 0, 0, 0, 0, fmt.Errorf("region %d,%d %dx%d does not fit the %dx%d grid", x, y, w, h, size, size)
+		}
+		return x, y, w, h, nil
+	}
+	return 0, 0, 0, 0, errors.New("too many fields")
+}
+
+/*!
+ * \brief Parse the actions of an intervention.
+ * \param s Actions "cull SPECIES PERCENT% [AREA]; add SPECIES COUNT [AREA]; reserve AREA".
+ * \param params Parameters of the run, for the grid size and the registered species.
+ * \return The actions, or an error for a malformed one.
+ */
+func parseInterventions(s string, params Config) ([]intervention, error) {
+	var actions []intervention
+	for _, text := range strings.Split(s, ";") {
+		text = strings.TrimSpace(text)
+		if text == "" {
+			continue
+		}
+		fields := strings.Fields(text)
+		iv := intervention{Kind: fields[0], Text: text}
+		var area []string
+		var err error
+		switch {
+		case iv.Kind == "reserve" && len(fields) > 1:
+			area = fields[1:]
+		case (iv.Kind == "cull" || iv.Kind == "add") && len(fields) >= 3:
+			var ok bool
+			if iv.Species, ok = params.Web.lookup(fields[1]); !ok {
+				return nil, fmt.Errorf("invalid intervention %q: unknown species %q", text, fields[1])
+			}
+			if iv.Kind == "cull" {
+				var percent float64
+				percent, err = strconv.ParseFloat(strings.TrimSuffix(fields[2], "%"), 64)
+				if err != nil || !strings.HasSuffix(fields[2], "%") || percent < 0 || percent > 100 {
+					return nil, fmt.Errorf("invalid intervention %q: want a share such as 50%% of at most 100%%", text)
+				}
+				iv.Share = percent / 100
+			} else if iv.Count, err = strconv.Atoi(fields[2]); err != nil || iv.Count < 0 {
+				return nil, fmt.Errorf("invalid intervention %q: want a count of at least 0", text)
+			}
+			area = fields[3:]
+		default:
+			return nil, fmt.Errorf("invalid intervention %q: want cull SPECIES PERCENT%% [AREA], add SPECIES COUNT [AREA] or reserve AREA", text)
+		}
+		if iv.X, iv.Y, iv.Width, iv.Height, err = parseInterventionArea(area, params.GridSize); err != nil {
+			return nil, fmt.Errorf("invalid intervention %q: %v", text, err)
+		}
+		actions = append(actions, iv)
+	}
+	if len(actions) == 0 {
+		return nil, errors.New("no intervention given")
+	}
+	return actions, nil
+}
+
+/*!
+ * \brief Apply an action to a world.
+ * \param w The world, between two chronons; changed in place.
+ * \param params Parameters of the run; a reserve replaces their reef map.
+ * \param rng Random source choosing the creatures culled and the cells filled.
+ * \return What the action did, e.g. "removed 52 of 104 sharks".
+ */
+func (iv intervention) apply(w *World, params *Config, rng *rand.Rand) string {
+	inside := func(x, y int) bool {
+		return x >= iv.X && x < iv.X+iv.Width && y >= iv.Y && y < iv.Y+iv.Height
+	}
+	name := params.Web.name(iv.Species)
+	if iv.Species == Shark {
+		name = "sharks"
+	}
+	switch iv.Kind {
+	case "cull":
+		var cells [][2]int
+		for x, column := range w.Grid {
+			for y, c := range column {
+				if c.Species == iv.Species && inside(x, y) {
+					cells = append(cells, [2]int{x, y})
+				}
+			}
+		}
+		n := int(iv.Share*float64(len(cells)) + 0.5)
+		for i := 0; i < n; i++ {
+			j := i + rng.Intn(len(cells)-i)
+			cells[i], cells[j] = cells[j], cells[i]
+			w.remove(cells[i][0], cells[i][1])
+		}
+		return fmt.Sprintf("removed %d of %d %s", n, len(cells), name)
+	case "add":
+		before := w.creatures.count()
+		release := introduction{Chronon: w.elapsed - 1, Species: iv.Species, Count: iv.Count,
+			X: iv.X, Y: iv.Y, Width: iv.Width, Height: iv.Height}
+		introduce(w, Config{Introductions: []introduction{release}}, rng)
+		return fmt.Sprintf("added %d %s", w.creatures.count()-before, name)
+	}
+
+	// The reef map is shared between worlds, so a reserve gets a map of its own
+	reef := make([]bool, w.Size*w.Size)
+	copy(reef, w.reef)
+	cells, removed := 0, 0
+	for x := iv.X; x < iv.X+iv.Width; x++ {
+		for y := iv.Y; y < iv.Y+iv.Height; y++ {
+			if w.isLand(x, y) {
+				continue
+			}
+			reef[y*w.Size+x] = true
+			cells++
+			if c := w.Grid[x][y]; w.occupied(x, y) && c.Species != Whale && w.barredFromReef(c.Species) {
+				w.remove(x, y)
+				removed++
+			}
+		}
+	}
+	w.reef, params.Reef = reef, reef
+	return fmt.Sprintf("reserve of %d water cells, %d predators removed", cells, removed)
+}
+
+/*!
+ * \brief Apply an intervention to a world.
+ * \param w The world, between two chronons.
+ * \param cfg Parameters of the run.
+ * \param actions The actions, applied in order.
+ * \param rng Random source of the actions.
+ * \return The changed world and parameters; w and cfg are left unchanged.
+ *
+ * Like Step, Intervene never modifies its input and draws only from rng.
+ */
+func Intervene(w World, cfg Config, actions []intervention, rng *rand.Rand) (World, Config) {
+	c := w.Clone()
+	for _, iv := range actions {
+		iv.apply(c, &cfg, rng)
+	}
+	return *c, cfg
+}
+
+/*!
+ * \brief Apply an intervention to a running simulation.
+ * \param actions The actions, applied in order.
+ * \param rng Random source of the actions.
+ * \return What every action did.
+ */
+func (s *Simulation) intervene(actions []intervention, rng *rand.Rand) []string {
+	var done []string
+	for _, iv := range actions {
+		done = append(done, iv.apply(s.World, &s.Params, rng))
+	}
+	s.fish, s.sharks = countPopulation(s.World)
+	return done
+}
+
+/*!
+ * \brief Populations of one branch of a counterfactual.
+ */
+type branchOutcome struct {
+	Fish, Sharks       int ///< Populations at the end
+	SumFish, SumSharks int ///< Populations summed over the chronons after the intervention
+	FishExtinct        int ///< Chronon after which no fish were left, or -1
+	SharksExtinct      int ///< Chronon after which no sharks were left, or -1
+}
+
+/*!
+ * \brief Record a chronon of a branch.
+ * \param f Its frame.
+ */
+func (o *branchOutcome) add(f *Frame) {
+	o.Fish, o.Sharks = f.Fish, f.Sharks
+	o.SumFish, o.SumSharks = o.SumFish+f.Fish, o.SumSharks+f.Sharks
+	if f.Fish == 0 && o.FishExtinct < 0 {
+		o.FishExtinct = f.Chronon
+	}
+	if f.Sharks == 0 && o.SharksExtinct < 0 {
+		o.SharksExtinct = f.Chronon
+	}
+}
+
+/*!
+ * \brief Mean populations of a branch.
+ * \param chronons Chronons recorded.
+ * \return The mean fish and shark populations.
+ */
+func (o *branchOutcome) means(chronons int) (float64, float64) {
+	return float64(o.SumFish) / float64(chronons), float64(o.SumSharks) / float64(chronons)
+}
+
+/*!
+ * \brief Describe a branch.
+ * \param name Name of the branch.
+ * \param chronons Chronons recorded.
+ * \return A line such as "Control: fish=812 sharks=201, mean fish 903.1 sharks 215.7".
+ */
+func (o *branchOutcome) describe(name string, chronons int) string {
+	fish, sharks := o.means(chronons)
+	line := fmt.Sprintf("%-11s fish=%d sharks=%d, mean fish %.1f sharks %.1f", name+":", o.Fish, o.Sharks, fish, sharks)
+	if o.FishExtinct >= 0 {
+		line += fmt.Sprintf(", fish extinct at %d", o.FishExtinct)
+	}
+	if o.SharksExtinct >= 0 {
+		line += fmt.Sprintf(", sharks extinct at %d", o.SharksExtinct)
+	}
+	return line
+}
+
+/*!
+ * \brief Describe the effect of the intervention on a quantity.
+ * \param control Value in the control branch.
+ * \param treated Value in the intervened branch.
+ * \return The signed difference, with its percentage of the control value if that is not 0.
+ */
+func effect(control, treated float64) string {
+	s := fmt.Sprintf("%+.1f", treated-control)
+	if control != 0 {
+		s += fmt.Sprintf(" (%+.1f%%)", 100*(treated-control)/control)
+	}
+	return s
+}
+
+/*!
+ * \brief Write the report of a counterfactual.
+ * \param out Destination.
+ * \param control The control branch.
+ * \param treated The intervened branch.
+ * \param chronons Chronons both ran.
+ * \param d Their divergence.
+ * \param threshold Relative difference counting as divergence.
+ */
+func writeCounterfactual(out io.Writer, control, treated *branchOutcome, chronons int, d divergence, threshold float64) {
+	fmt.Fprintln(out, control.describe("Control", chronons))
+	fmt.Fprintln(out, treated.describe("Intervened", chronons))
+	fishA, sharksA := control.means(chronons)
+	fishB, sharksB := treated.means(chronons)
+	fmt.Fprintf(out, "Effect:     fish %s at the end, %s on average; sharks %s at the end, %s on average\n",
+		effect(float64(control.Fish), float64(treated.Fish)), effect(fishA, fishB),
+		effect(float64(control.Sharks), float64(treated.Sharks)), effect(sharksA, sharksB))
+	if d.At >= 0 {
+		fmt.Fprintf(out, "Diverged beyond %.1f%% at chronon %d; largest difference %.1f%% at chronon %d\n",
+			100*threshold, d.At, 100*d.Largest, d.LargestAt)
+	} else {
+		fmt.Fprintf(out, "No divergence beyond %.1f%% in %d chronons; largest difference %.1f%% at chronon %d\n",
+			100*threshold, chronons, 100*d.Largest, d.LargestAt)
+	}
+}
+
+/*!
+ * \brief Entry point of the intervene subcommand.
+ * \param args Command-line arguments after "intervene".
+ * \return Process exit code: exitOK, exitConfig for bad settings, or
+ *         exitFailure if an output cannot be written.
+ */
+func interveneCommand(args []string) int {
+	fs := newCommandFlags("intervene")
+	params := defaultConfig()
+	cfg := registerConfigFlags(fs, &params)
+	actions := fs.String("do", "", "the intervention: `actions` \"cull SPECIES PERCENT% [AREA]; add SPECIES COUNT [AREA]; reserve AREA\"")
+	at := fs.Int("at", 500, "chronons run before the intervention")
+	from := fs.String("from", "", "start from this checkpoint `file` instead of a new world (its settings replace the flags)")
+	horizon := chrononsFlag(fs, "horizon", 1000, "chronons both branches run after the intervention")
+	threshold := fs.Float64("threshold", 0.1, "relative difference of a population counting as divergence")
+	csvPath := fs.String("csv", "", "write the populations of both branches to this CSV `file`")
+	save := fs.String("save", "", "write the state before the intervention to this checkpoint `file`, e.g. for fork")
+	if err := fs.Parse(args); err != nil {
+		return flagExit(err)
+	}
+
+	seed, err := cfg.resolve()
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		return exitConfig
+	}
+	if *cfg.dryRun {
+		writeDryRun(os.Stdout, cfg, seed)
+		return exitOK
+	}
+	if *horizon < 1 || *threshold <= 0 {
+		fmt.Fprintln(os.Stderr, "intervene: need a positive -chronons and -threshold")
+		return exitConfig
+	}
+
+	// The state before the intervention, from a checkpoint or a new world
+	var sim *Simulation
+	if *from != "" {
+		cp, err := loadCheckpoint(*from)
+		if err == nil {
+			sim, err = cp.restore()
+		}
+		if err != nil {
+			fmt.Fprintln(os.Stderr, err)
+			return exitConfig
+		}
+		params, seed = sim.Params, sim.Seed
+	} else {
+		sim = newSimulation(params, seed)
+	}
+	plan, err := parseInterventions(*actions, params)
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		return exitConfig
+	}
+	if *at < sim.Chronon {
+		fmt.Fprintf(os.Stderr, "-at %d is before chronon %d of the checkpoint\n", *at, sim.Chronon)
+		return exitConfig
+	}
+	for sim.Chronon < *at {
+		sim.Step()
+		sim.Frame()
+		if fish, sharks := sim.Population(); fish == 0 && sharks == 0 {
+			fmt.Fprintf(os.Stderr, "both species died out at chronon %d, before the intervention\n", sim.Chronon-1)
+			return exitFailure
+		}
+	}
+
+	artifactProvenance = newProvenance("intervene", fs, seed)
+	cp := takeCheckpoint(sim, runOutcome{FishExtinct: -1, SharksExtinct: -1})
+	if *save != "" {
+		if err := saveCheckpoint(*save, cp, "binary"); err != nil {
+			fmt.Fprintln(os.Stderr, err)
+			return exitFailure
+		}
+	}
+	control, err := forkBranch{}.start(cp)
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		return exitFailure
+	}
+	treated, _ := forkBranch{}.start(cp)
+	fmt.Printf("Intervention at chronon %d (seed %d): %s\n", *at, seed, *actions)
+	for i, done := range treated.intervene(plan, rand.New(rand.NewSource(seed))) {
+		fmt.Printf("  %s: %s\n", plan[i].Text, done)
+	}
+
+	var table *csv.Writer
+	if *csvPath != "" {
+		file, err := createCSV(*csvPath)
+		if err != nil {
+			fmt.Fprintln(os.Stderr, err)
+			return exitFailure
+		}
+		defer file.Close()
+		table = csv.NewWriter(file)
+		table.Write([]string{"chronon", "fish_control", "sharks_control", "fish_intervened", "sharks_intervened", "difference"})
+	}
+
+	a := branchOutcome{FishExtinct: -1, SharksExtinct: -1}
+	b := a
+	d := divergence{At: -1, LargestAt: *at}
+	for c := 0; c < *horizon; c++ {
+		control.Step()
+		treated.Step()
+		fa, fb := control.Frame(), treated.Frame()
+		a.add(fa)
+		b.add(fb)
+		diff := d.add(fa, fb, *threshold)
+		if table != nil {
+			table.Write([]string{strconv.Itoa(fa.Chronon), strconv.Itoa(fa.Fish), strconv.Itoa(fa.Sharks),
+				strconv.Itoa(fb.Fish), strconv.Itoa(fb.Sharks), strconv.FormatFloat(diff, 'f', 4, 64)})
+		}
+	}
+	if table != nil {
+		table.Flush()
+		if err := table.Error(); err != nil {
+			fmt.Fprintln(os.Stderr, err)
+			return exitFailure
+		}
+	}
+	writeCounterfactual(os.Stdout, &a, &b, *horizon, d, *threshold)
+	return exitOK
+}
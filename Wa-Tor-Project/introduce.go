@@ -0,0 +1,271 @@
+/*!
+ * \file introduce.go
+ * \brief Scheduled introductions of invasive species and the spread of the invaders.
+ *
+ * The invasion sink follows how far each introduced species spreads from
+ * its release.
+ */
+
+package main
+
+import (
+	"encoding/csv"
+	"errors"
+	"fmt"
+	"math"
+	"math/rand"
+	"strconv"
+	"strings"
+)
+
+/*!
+ * \brief A release of creatures at a scheduled chronon.
+ */
+type introduction struct {
+	Chronon int     ///< Chronon of the release
+	Species Species ///< Species released
+	Count   int     ///< Creatures released
+	X, Y    int     ///< Top left cell of the region
+	Width   int     ///< Columns covered
+	Height  int     ///< Rows covered
+}
+
+/*!
+ * \brief Compass names of the regions an introduction can target.
+ */
+var introductionAreas = []string{"north", "south", "east", "west", "northeast", "northwest", "southeast", "southwest", "centre"}
+
+/*!
+ * \brief Region of a compass name.
+ * \param name One of introductionAreas.
+ * \param size Width/height of the grid.
+ * \return The top left cell and size of a square a quarter of the grid
+ *         wide at that edge or corner, or in the middle; false for an
+ *         unknown name.
+ */
+func introductionArea(name string, size int) (x, y, w int, ok bool) {
+	w = max(1, size/4)
+	far, mid := size-w, (size-w)/2
+	switch name {
+	case "north":
+		return mid, 0, w, true
+	case "south":
+		return mid, far, w, true
+	case "east":
+		return far, mid, w, true
+	case "west":
+		return 0, mid, w, true
+	case "northeast":
+		return far, 0, w, true
+	case "northwest":
+		return 0, 0, w, true
+	case "southeast":
+		return far, far, w, true
+	case "southwest":
+		return 0, far, w, true
+	case "centre", "center":
+		return mid, mid, w, true
+	}
+	return 0, 0, 0, false
+}
+
+/*!
+ * \brief Parse introductions.
+ * \param s Introductions "CHRONON SPECIES COUNT X,Y WxH; ..." or
+ *          "CHRONON SPECIES COUNT AREA; ..." with a compass name as area.
+ * \param size Width/height of the grid, which the compass areas depend on.
+ * \param species Registered species the names may refer to.
+ * \return The introductions, or an error for a malformed one.
+ */
+func parseIntroductions(s string, size int, species []webSpecies) ([]introduction, error) {
+	web := foodWeb{Species: species}
+	var ins []introduction
+	for _, text := range strings.Split(s, ";") {
+		text = strings.TrimSpace(text)
+		if text == "" {
+			continue
+		}
+		fields := strings.Fields(text)
+		var in introduction
+		var err error
+		switch len(fields) {
+		case 4:
+			_, err = fmt.Sscanf(strings.Join(fields[:3:3], " "), "%d %s %d", &in.Chronon, new(string), &in.Count)
+			var ok bool
+			if in.X, in.Y, in.Width, ok = introductionArea(fields[3], size); !ok {
+				return nil, fmt.Errorf("invalid introduction %q: unknown area %q (want X,Y WxH or one of %s)",
+					text, fields[3], strings.Join(introductionAreas, ", "))
+			}
+			in.Height = in.Width
+		case 5:
+			_, err = fmt.Sscanf(text, "%d %s %d %d,%d %dx%d", &in.Chronon, new(string), &in.Count, &in.X, &in.Y, &in.Width, &in.Height)
+		default:
+			err = errors.New("bad fields")
+		}
+		if err != nil {
+			return nil, fmt.Errorf("invalid introduction %q: want CHRONON SPECIES COUNT X,Y WxH or CHRONON SPECIES COUNT AREA", text)
+		}
+		var ok bool
+		if in.Species, ok = web.lookup(fields[1]); !ok {
+			return nil, fmt.Errorf("invalid introduction %q: unknown species %q", text, fields[1])
+		}
+		ins = append(ins, in)
+	}
+	return ins, nil
+}
+
+/*!
+ * \brief Format introductions for the -introduce flag.
+ * \param ins The introductions.
+ * \param web Food web naming the registered species.
+ * \return The introductions joined with "; ", regions as X,Y WxH.
+ */
+func formatIntroductions(ins []introduction, web *foodWeb) string {
+	texts := make([]string, len(ins))
+	for i, in := range ins {
+		texts[i] = fmt.Sprintf("%d %s %d %d,%d %dx%d", in.Chronon, web.name(in.Species), in.Count, in.X, in.Y, in.Width, in.Height)
+	}
+	return strings.Join(texts, "; ")
+}
+
+/*!
+ * \brief Check introductions against the grid and the food web.
+ * \param params Parameters with the introductions.
+ * \return An error for an introduction before the first chronon, with a
+ *         negative count, of an unknown species or outside the grid.
+ */
+func checkIntroductions(params Config) error {
+	for _, in := range params.Introductions {
+		switch {
+		case in.Chronon < 0 || in.Count < 0:
+			return fmt.Errorf("introduction at chronon %d of %d creatures: need a chronon and a count of at least 0", in.Chronon, in.Count)
+		case in.Species != Fish && in.Species != Shark && params.Web.registered(in.Species) == nil:
+			return fmt.Errorf("introduction at chronon %d: %s is not registered", in.Chronon, in.Species)
+		case in.Width < 1 || in.Height < 1 || in.X < 0 || in.Y < 0 ||
+			in.X+in.Width > params.GridSize || in.Y+in.Height > params.GridSize:
+			return fmt.Errorf("introduction at chronon %d: region %d,%d %dx%d does not fit the %dx%d grid",
+				in.Chronon, in.X, in.Y, in.Width, in.Height, params.GridSize, params.GridSize)
+		}
+	}
+	return nil
+}
+
+/*!
+ * \brief Release the creatures of the introductions due in the chronon that produced a world.
+ * \param w The world after the chronon's moves and edge exchange.
+ * \param params Simulation parameters with the introductions.
+ * \param rng Random source of the simulation.
+ *
+ * The creatures take distinct free cells of the region drawn at random;
+ * if fewer cells are free, every free cell receives one.
+ */
+func introduce(w *World, params Config, rng *rand.Rand) {
+	for _, in := range params.Introductions {
+		// A world's elapsed count is one ahead of the chronon of its frame
+		if in.Chronon != w.elapsed-1 || in.Count == 0 {
+			continue
+		}
+		barred := w.barredFromReef(in.Species)
+		var free [][2]int
+		for x := in.X; x < in.X+in.Width; x++ {
+			for y := in.Y; y < in.Y+in.Height; y++ {
+				if !w.occupied(x, y) && !w.isDry(x, y) && !(barred && w.isReef(x, y)) {
+					free = append(free, [2]int{x, y})
+				}
+			}
+		}
+		for i := 0; i < min(in.Count, len(free)); i++ {
+			j := i + rng.Intn(len(free)-i)
+			free[i], free[j] = free[j], free[i]
+			x, y := free[i][0], free[i][1]
+			c := Creature{ID: w.ids.next(), Species: in.Species, Energy: int32(w.maxEnergy(in.Species)), Female: w.sexes.female(rng)}
+			w.put(x, y, &c)
+			w.record(Event{Kind: Introduced, Species: in.Species, ID: c.ID, X: x, Y: y})
+		}
+	}
+}
+
+/*!
+ * \brief Writes the spread of every introduced species as CSV.
+ */
+type invasionSink struct {
+	*csvSink                ///< Output file and encoder
+	ins      []introduction ///< The introductions, in order
+	names    []string       ///< Name of the species of each introduction
+	bounded  bool           ///< Edges are walls, so distances do not wrap
+}
+
+/*!
+ * \brief Create an invasion sink.
+ * \param path Output file path.
+ * \param params Parameters of the run, with introductions.
+ * \return The sink, or an error if the run has no introductions or the
+ *         file cannot be created.
+ */
+func openInvasionSink(path string, params Config) (Observer, error) {
+	if len(params.Introductions) == 0 {
+		return nil, errors.New("needs -introduce")
+	}
+	file, err := createCSV(path)
+	if err != nil {
+		return nil, err
+	}
+	s := &invasionSink{csvSink: &csvSink{file: file, w: csv.NewWriter(file)}, ins: params.Introductions, bounded: params.Bounded}
+	for _, in := range s.ins {
+		s.names = append(s.names, params.Web.name(in.Species))
+	}
+	s.w.Write([]string{"chronon", "introduction", "species", "population", "front", "mean_distance"})
+	return s, nil
+}
+
+/*!
+ * \brief Distance along one axis.
+ * \param a A coordinate.
+ * \param b The other coordinate.
+ * \param size Width/height of the grid.
+ * \param bounded Whether the axis ends at walls rather than wrapping around.
+ * \return |a - b|, the shorter way round on a torus.
+ */
+func axisDistance(a, b float64, size int, bounded bool) float64 {
+	d := math.Abs(a - b)
+	if !bounded {
+		d = math.Min(d, float64(size)-d)
+	}
+	return d
+}
+
+/*!
+ * \brief Write a row per introduction that has happened.
+ * \param f The frame to record.
+ * \return Any write error.
+ */
+func (s *invasionSink) Observe(f *Frame) error {
+	for i, in := range s.ins {
+		if in.Chronon > f.Chronon {
+			continue
+		}
+		cx, cy := float64(in.X)+float64(in.Width-1)/2, float64(in.Y)+float64(in.Height-1)/2
+		n, front, total := 0, 0.0, 0.0
+		for cell, sp := range f.Cells() {
+			if sp != in.Species {
+				continue
+			}
+			dx := axisDistance(float64(cell%f.Size), cx, f.Size, s.bounded)
+			dy := axisDistance(float64(cell/f.Size), cy, f.Size, s.bounded)
+			d := math.Hypot(dx, dy)
+			n++
+			front = math.Max(front, d)
+			total += d
+		}
+		mean := ""
+		if n > 0 {
+			mean = strconv.FormatFloat(total/float64(n), 'f', 2, 64)
+		}
+		err := s.w.Write([]string{strconv.Itoa(f.Chronon), strconv.Itoa(i + 1), s.names[i], strconv.Itoa(n),
+			strconv.FormatFloat(front, 'f', 2, 64), mean})
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
@@ -0,0 +1,489 @@
+/*!
+ * \file invariants_test.go
+ * \brief The rule invariants as property tests, over random configurations.
+ */
+
+package main
+
+import (
+	"errors"
+	"fmt"
+	"math/rand"
+	"sort"
+	"testing"
+)
+
+/*!
+ * \brief Random but valid simulation parameters.
+ * \param rng Random source.
+ * \return Parameters on a small grid (2 to 40 cells wide) with random
+ *         breed and starve times, update scheme, worker count, topology
+ *         and edge exchange, age curve, fish energy, breeding cost,
+ *         ambush rule, egg times, sexes, strategy mutation, terrain with
+ *         reefs and tides, pollution, climate, day/night cycle, whales,
+ *         food web, introductions, movement weights, placement pattern,
+ *         initial state, background mortality, crowding, territorial
+ *         sharks, starvation order and meal energy, conflict policy, and
+ *         populations that fit in the water.
+ */
+func randomConfig(rng *rand.Rand) Config {
+	p := defaultConfig()
+	p.GridSize = 2 + rng.Intn(39)
+	p.FishBreed = 1 + rng.Intn(10)
+	p.SharkBreed = 1 + rng.Intn(15)
+	p.Starve = 1 + rng.Intn(10)
+	p.Scheme = UpdateScheme(rng.Intn(5))
+	p.Workers = 1 + rng.Intn(4)
+	p.TileSize = 2 + rng.Intn(7)
+	p.Window = 1 + rng.Intn(50)
+	if rng.Intn(3) == 0 {
+		p.Bounded = true
+		if rng.Intn(2) == 0 {
+			p.ImmigrateFish = rng.Float64() * 0.2
+			p.ImmigrateSharks = rng.Float64() * 0.1
+			p.Emigrate = rng.Float64() * 0.2
+		}
+	}
+	if rng.Intn(3) == 0 {
+		p.Aging = ageCurve{Maturity: rng.Intn(10), Old: 1 + rng.Intn(30),
+			Fertility: 0.1 + 0.9*rng.Float64(), Speed: 0.1 + 0.9*rng.Float64()}
+	}
+	if rng.Intn(3) == 0 {
+		p.FishEnergy = fishEnergy{Budget: 1 + rng.Intn(20), Move: rng.Intn(4), Plankton: rng.Intn(3)}
+	}
+	if rng.Intn(3) == 0 {
+		p.Breeding = breedingCost{SharkEnergy: rng.Intn(p.Starve), FishCooldown: 1 + 3*rng.Float64()}
+	}
+	if rng.Intn(3) == 0 {
+		p.Breeding.SharkBirth = 1 + rng.Intn(p.Starve)
+		if p.FishEnergy.Budget > 0 {
+			p.Breeding.FishBirth = 1 + rng.Intn(p.FishEnergy.Budget)
+		}
+	}
+	if rng.Intn(3) == 0 {
+		p.Ambush = ambushRule{Below: 1 + rng.Intn(p.Starve), Chance: rng.Float64(), Drain: rng.Float64()}
+	}
+	if rng.Intn(3) == 0 {
+		p.Eggs = eggRules{Fish: rng.Intn(6), Shark: rng.Intn(6)}
+	}
+	if rng.Intn(3) == 0 {
+		p.Sexes = sexRules{Enabled: true, MateBias: rng.Float64()}
+	}
+	if rng.Intn(3) == 0 {
+		p.Strategies.Mutation = rng.Float64()
+	}
+	if rng.Intn(3) == 0 {
+		p.Web = foodWeb{Species: make([]webSpecies, 1+rng.Intn(3))}
+		all := []Species{Fish, Shark}
+		for i := range p.Web.Species {
+			p.Web.Species[i] = webSpecies{Name: fmt.Sprintf("sp%d", i+1), Char: "xyz"[i], Breed: 1 + rng.Intn(10), Starve: rng.Intn(10)}
+			all = append(all, firstWebSpecies+Species(i))
+		}
+		for _, predator := range all {
+			for _, prey := range all {
+				if predator != prey && rng.Intn(3) == 0 {
+					p.Web.Diet = append(p.Web.Diet, predation{Predator: predator, Prey: prey, Gain: rng.Intn(5)})
+				}
+			}
+		}
+	}
+
+	cells := p.GridSize * p.GridSize
+	water := cells
+	if rng.Intn(3) == 0 {
+		density := rng.Float64() * 0.5
+		p.Land = make([]bool, cells)
+		for i := range p.Land {
+			if rng.Float64() < density {
+				p.Land[i] = true
+				water--
+			}
+		}
+		if rng.Intn(2) == 0 {
+			p.Reef = growReefs(p.Land, nil, p.GridSize, 1+rng.Intn(2))
+		}
+		if rng.Intn(2) == 0 {
+			p.Tide = tideRules{Width: 1 + rng.Intn(2), Period: 1 + rng.Intn(20), Low: rng.Float64()}
+		}
+	}
+	if rng.Intn(2) == 0 {
+		names := make([]string, 0, len(initPatterns))
+		for name := range initPatterns {
+			names = append(names, name)
+		}
+		sort.Strings(names)
+		p.InitPattern = names[rng.Intn(len(names))]
+	}
+	if rng.Intn(3) == 0 {
+		p.Whales = whaleRules{Count: 1 + rng.Intn(4), Size: 1 + rng.Intn(min(3, p.GridSize-1))}
+	}
+	if rng.Intn(3) == 0 {
+		p.DayNight = dayCycle{Length: 1 + rng.Intn(20), Night: rng.Float64(), Amplitude: rng.Float64()}
+	}
+	if rng.Intn(3) == 0 {
+		p.Climate = climateRules{Equator: 10 + 20*rng.Float64(), Gradient: 20 * rng.Float64(), Warming: 100 * (rng.Float64() - 0.3),
+			FishOptimum: 10 + 20*rng.Float64(), SharkOptimum: 10 + 20*rng.Float64(), Tolerance: 1 + 10*rng.Float64(), Bands: 1 + rng.Intn(5)}
+	}
+	if rng.Intn(3) == 0 {
+		for n := 1 + rng.Intn(3); n > 0; n-- {
+			p.Pollution.Sources = append(p.Pollution.Sources, pollutionSource{X: rng.Intn(p.GridSize), Y: rng.Intn(p.GridSize),
+				Radius: rng.Intn(p.GridSize/2 + 1), Level: 1 - rng.Float64()})
+		}
+		p.Pollution.Diffusion, p.Pollution.Decay = rng.Float64(), rng.Float64()*0.1
+		p.Pollution.SharkDrain, p.Pollution.FishHarm = rng.Float64(), rng.Float64()
+	}
+	p.NumFish = rng.Intn(water + 1)
+	p.NumShark = rng.Intn(min(water-p.NumFish, water-countCells(p.Reef)) + 1)
+	// Registered predators stay off the reefs too, wherever the fish went
+	free := max(0, water-countCells(p.Reef)-p.NumFish-p.NumShark)
+	for i := range p.Web.Species {
+		p.Web.Species[i].Count = rng.Intn(free/len(p.Web.Species) + 1)
+	}
+	if rng.Intn(3) == 0 {
+		for n := 1 + rng.Intn(3); n > 0; n-- {
+			in := introduction{Chronon: rng.Intn(50), Species: Fish, Count: rng.Intn(20), X: rng.Intn(p.GridSize), Y: rng.Intn(p.GridSize)}
+			if k := rng.Intn(2 + len(p.Web.Species)); k == 1 {
+				in.Species = Shark
+			} else if k > 1 {
+				in.Species = firstWebSpecies + Species(k-2)
+			}
+			in.Width, in.Height = 1+rng.Intn(p.GridSize-in.X), 1+rng.Intn(p.GridSize-in.Y)
+			p.Introductions = append(p.Introductions, in)
+		}
+	}
+	if rng.Intn(3) == 0 {
+		for _, s := range []Species{Empty, Fish, Shark, firstWebSpecies} {
+			if rng.Intn(2) == 0 || s == firstWebSpecies && len(p.Web.Species) == 0 {
+				continue
+			}
+			r := moveWeights{Species: s}
+			for d := range r.Weights {
+				r.Weights[d] = 0.1 + 4*rng.Float64()
+			}
+			p.MoveWeights = append(p.MoveWeights, r)
+		}
+	}
+	p.RandomState = rng.Intn(3) == 0
+	p.Breeding.SharkLitter = LitterPlace(rng.Intn(3))
+	if rng.Intn(3) == 0 {
+		p.Mortality = mortalityRule{Fish: 0.2 * rng.Float64(), Shark: 0.2 * rng.Float64()}
+	}
+	if rng.Intn(3) == 0 {
+		p.Crowding = crowdingRule{Fish: 2 * rng.Float64(), Shark: 2 * rng.Float64(), Tolerance: rng.Intn(4)}
+	}
+	if rng.Intn(3) == 0 {
+		p.SharkTerritory = 5 * rng.Float64()
+	}
+	p.Starvation.Order = StarveOrder(rng.Intn(2))
+	if rng.Intn(3) == 0 {
+		p.Starvation.Meal = 1 + rng.Intn(p.Starve)
+	}
+	p.Conflicts = ConflictPolicy(rng.Intn(3))
+	return p
+}
+
+/*!
+ * \brief Check the invariants of one chronon.
+ * \param before The world before the chronon.
+ * \param after The world Step returned for it.
+ * \return nil, or an error listing every violated invariant.
+ *
+ * The invariants are:
+ * - no creature occupies two cells, none stands on land and no shark or
+ *   registered predator on a reef, and none moved onto a cell the tide
+ *   exposed;
+ * - creature IDs are unique and no larger than the last one issued;
+ * - populations are conserved: fish after = fish before + fish births +
+ *   immigrants and introduced - fish eaten or starved - emigrants, and likewise for
+ *   sharks and every registered species;
+ * - fish have between 1 and their energy budget, or no energy without
+ *   one; sharks and registered species have between 1 and their starve
+ *   time, or no energy if they never starve;
+ * - ages grow by one per chronon, and no creature waited longer to breed
+ *   than it has lived;
+ * - eggs stay where they were laid, and their hatch counters drop by one
+ *   per chronon from at most the egg time;
+ * - only females breed, and only with sexes are there any;
+ * - creatures keep their movement strategy, and only with strategy
+ *   mutation are there any but random;
+ * - creatures keep their generation, and a newborn's is one more than
+ *   its parent's;
+ * - pollution levels lie between 0 and 1, and land stays clean;
+ * - every whale keeps its whole body, off the land, and no whale cell
+ *   belongs to no whale.
+ */
+func checkInvariants(before, after World) error {
+	var errs []error
+	violate := func(format string, args ...any) {
+		errs = append(errs, fmt.Errorf(format, args...))
+	}
+
+	fishBefore, sharksBefore := countPopulation(&before)
+	fishAfter, sharksAfter := countPopulation(&after)
+	n := countEvents(after.Events)
+	if want := fishBefore + n.fishChange(); fishAfter != want {
+		violate("%d fish, want %d (%d + %d births + %d in - %d eaten - %d starved - %d died - %d out)", fishAfter, want, fishBefore,
+			n.FishBirths, n.FishIn, n.FishEaten, n.FishStarved, n.FishDied, n.FishOut)
+	}
+	if want := sharksBefore + n.sharkChange(); sharksAfter != want {
+		violate("%d sharks, want %d (%d + %d births + %d in - %d eaten - %d starved - %d died - %d out)", sharksAfter, want, sharksBefore,
+			n.SharkBirths, n.SharksIn, n.SharksEaten, n.SharksStarved, n.SharksDied, n.SharksOut)
+	}
+	othersBefore, othersAfter := before.webPopulations(), after.webPopulations()
+	for i := range othersAfter {
+		want := othersBefore[i]
+		for _, ev := range after.Events {
+			if ev.Species != firstWebSpecies+Species(i) {
+				continue
+			}
+			switch ev.Kind {
+			case Birth, Introduced:
+				want++
+			case Eaten, Starved, Died, Emigrated:
+				want--
+			}
+		}
+		if othersAfter[i] != want {
+			violate("%d of %s, want %d", othersAfter[i], after.web.species[i].Name, want)
+		}
+	}
+
+	ages := map[int]int32{}
+	cells := map[int][2]int{}
+	hatch := map[int]int16{}
+	females := map[int]bool{}
+	strategies := map[int]Strategy{}
+	gens := map[int]int32{}
+	for x, column := range before.Grid {
+		for y, c := range column {
+			if c.Species != Empty {
+				ages[c.ID] = c.Age
+				cells[c.ID] = [2]int{x, y}
+				hatch[c.ID] = c.Hatch
+				females[c.ID] = c.Female
+				strategies[c.ID] = c.Strategy
+				gens[c.ID] = c.Gen
+			}
+		}
+	}
+	parents := map[int]int{}
+	for _, ev := range after.Events {
+		if ev.Kind == Birth {
+			parents[ev.ID] = ev.ParentID
+		}
+		if ev.Kind == Birth && before.sexes.Enabled && !females[ev.ParentID] {
+			violate("male %d bred offspring %d", ev.ParentID, ev.ID)
+		}
+	}
+	ids := map[int]bool{}
+	last := int(after.ids.last.Load())
+	for x, column := range after.Grid {
+		for y, c := range column {
+			if c.Species == Empty || c.Species == Whale {
+				continue
+			}
+			if ids[c.ID] {
+				violate("creature ID %d occupies more than one cell, one of them (%d,%d)", c.ID, x, y)
+			}
+			ids[c.ID] = true
+			if c.ID < 1 || c.ID > last {
+				violate("creature ID %d at (%d,%d) was never issued (last %d)", c.ID, x, y, last)
+			}
+			if after.isLand(x, y) {
+				violate("creature %d stands on land at (%d,%d)", c.ID, x, y)
+			}
+			if after.barredFromReef(c.Species) && after.isReef(x, y) {
+				violate("%s %d is on a reef at (%d,%d)", c.Species, c.ID, x, y)
+			}
+			if from, ok := cells[c.ID]; ok && before.exposed(x, y) && from != [2]int{x, y} {
+				violate("creature %d moved from (%d,%d) onto (%d,%d), exposed by the tide", c.ID, from[0], from[1], x, y)
+			}
+			if budget := after.fishEnergy.Budget; c.Species == Fish && budget == 0 && c.Energy != 0 {
+				violate("fish %d has energy %d", c.ID, c.Energy)
+			} else if c.Species == Fish && budget > 0 && (c.Energy < 1 || int(c.Energy) > budget) {
+				violate("fish %d has energy %d, outside 1..%d", c.ID, c.Energy, budget)
+			}
+			if c.Species == Shark && (c.Energy < 1 || int(c.Energy) > after.Starve) {
+				violate("shark %d has energy %d, outside 1..%d", c.ID, c.Energy, after.Starve)
+			}
+			if r := after.web.registeredSpecies(c.Species); r != nil && (r.Starve == 0 && c.Energy != 0 || r.Starve > 0 && (c.Energy < 1 || int(c.Energy) > r.Starve)) {
+				violate("%s %d has energy %d, starve time %d", r.Name, c.ID, c.Energy, r.Starve)
+			}
+			if age, ok := ages[c.ID]; ok && c.Age != age+1 {
+				violate("creature %d aged from %d to %d", c.ID, age, c.Age)
+			}
+			if c.Female && !after.sexes.Enabled {
+				violate("creature %d is female without sexes", c.ID)
+			}
+			if s, ok := strategies[c.ID]; ok && c.Strategy != s || c.Strategy >= strategyCount ||
+				c.Strategy != Random && after.strategies.Mutation == 0 {
+				violate("creature %d has strategy %d", c.ID, c.Strategy)
+			}
+			if g, ok := gens[c.ID]; ok && c.Gen != g || !ok && parents[c.ID] != 0 && c.Gen != gens[parents[c.ID]]+1 {
+				violate("creature %d of parent %d has generation %d", c.ID, parents[c.ID], c.Gen)
+			}
+			if c.LastBreed < 0 || c.LastBreed > c.Age {
+				violate("creature %d last bred %d chronons ago at age %d", c.ID, c.LastBreed, c.Age)
+			}
+			if h, ok := hatch[c.ID]; ok && h > 0 && (c.Hatch != h-1 || cells[c.ID] != [2]int{x, y}) {
+				violate("egg %d went from %d chronons to hatch at (%d,%d) to %d at (%d,%d)", c.ID, h, cells[c.ID][0], cells[c.ID][1], c.Hatch, x, y)
+			} else if c.Hatch < 0 || c.Hatch > after.eggs.hatch(c.Species) || ok && h == 0 && c.Hatch != 0 {
+				violate("creature %d has %d chronons to hatch", c.ID, c.Hatch)
+			}
+		}
+	}
+	if err := after.checkWhales(); err != nil {
+		violate("%v", err)
+	}
+	if len(after.whales) != len(before.whales) {
+		violate("%d whales, want %d", len(after.whales), len(before.whales))
+	}
+	for i, level := range after.pollution {
+		if !(level >= 0 && level <= 1) || level > 0 && after.land != nil && after.land[i] {
+			violate("pollution %g at (%d,%d)", level, i%after.Size, i/after.Size)
+		}
+	}
+	return errors.Join(errs...)
+}
+
+/*!
+ * \brief Command line that recreates a configuration.
+ * \param p The parameters.
+ * \param seed Seed of the run.
+ * \return Flags for the run command; terrain is noted, not reproduced.
+ */
+func configFlagsText(p Config, seed int64) string {
+	s := fmt.Sprintf("-grid %d -fish %d -sharks %d -fishbreed %d -sharkbreed %d -starve %d -scheme %s -workers %d -tile %d -seed %d",
+		p.GridSize, p.NumFish, p.NumShark, p.FishBreed, p.SharkBreed, p.Starve, schemeName(p.Scheme), p.Workers, p.TileSize, seed)
+	if p.Bounded {
+		s += fmt.Sprintf(" -topology bounded -immigrate-fish %g -immigrate-sharks %g -emigrate %g", p.ImmigrateFish, p.ImmigrateSharks, p.Emigrate)
+	}
+	if p.Aging.Old > 0 {
+		s += fmt.Sprintf(" -maturity %d -old-age %d -old-fertility %g -old-speed %g", p.Aging.Maturity, p.Aging.Old, p.Aging.Fertility, p.Aging.Speed)
+	}
+	if p.FishEnergy.Budget > 0 {
+		s += fmt.Sprintf(" -fish-energy %d -fish-move-cost %d -plankton %d", p.FishEnergy.Budget, p.FishEnergy.Move, p.FishEnergy.Plankton)
+	}
+	if b := p.Breeding; b.SharkEnergy > 0 || b.FishCooldown != 1 {
+		s += fmt.Sprintf(" -shark-birth-cost %d -fish-cooldown %g", b.SharkEnergy, b.FishCooldown)
+	}
+	if b := p.Breeding; b.SharkBirth > 0 || b.FishBirth > 0 {
+		s += fmt.Sprintf(" -shark-birth-energy %d -fish-birth-energy %d", b.SharkBirth, b.FishBirth)
+	}
+	if p.Breeding.SharkLitter != LitterLeft {
+		s += " -shark-litter " + litterPlaceName(p.Breeding.SharkLitter)
+	}
+	if p.Ambush.Below > 0 {
+		s += fmt.Sprintf(" -ambush-below %d -ambush-chance %g -ambush-drain %g", p.Ambush.Below, p.Ambush.Chance, p.Ambush.Drain)
+	}
+	if m := p.Mortality; m.enabled() {
+		s += fmt.Sprintf(" -fish-mortality %g -shark-mortality %g", m.Fish, m.Shark)
+	}
+	if c := p.Crowding; c.enabled() {
+		s += fmt.Sprintf(" -fish-crowding %g -shark-crowding %g -crowding-tolerance %d", c.Fish, c.Shark, c.Tolerance)
+	}
+	if p.SharkTerritory > 0 {
+		s += fmt.Sprintf(" -shark-territory %g", p.SharkTerritory)
+	}
+	if p.Starvation.Order != StarveFirst {
+		s += " -starve-order " + starveOrderName(p.Starvation.Order)
+	}
+	if p.Starvation.Meal > 0 {
+		s += fmt.Sprintf(" -shark-meal %d", p.Starvation.Meal)
+	}
+	if p.Conflicts != FirstCome {
+		s += " -conflicts " + conflictPolicyName(p.Conflicts)
+	}
+	if p.Sexes.Enabled {
+		s += fmt.Sprintf(" -sexes -mate-bias %g", p.Sexes.MateBias)
+	}
+	if p.Strategies.Mutation > 0 {
+		s += fmt.Sprintf(" -strategy-mutation %g", p.Strategies.Mutation)
+	}
+	if p.Web.enabled() {
+		s += fmt.Sprintf(" -species %q -eats %q", formatWebSpecies(p.Web.Species), p.Web.formatDiet())
+	}
+	if p.Introductions != nil {
+		s += fmt.Sprintf(" -introduce %q", formatIntroductions(p.Introductions, &p.Web))
+	}
+	if p.MoveWeights != nil {
+		s += fmt.Sprintf(" -move-weights %q", formatMoveWeights(p.MoveWeights, &p.Web))
+	}
+	if e := p.Eggs; e.enabled() {
+		s += fmt.Sprintf(" -fish-egg-time %d -shark-egg-time %d", e.Fish, e.Shark)
+	}
+	if w := p.Whales; w.Count > 0 {
+		s += fmt.Sprintf(" -whales %d -whale-size %d", w.Count, w.Size)
+	}
+	if t := p.Tide; t.Width > 0 {
+		s += fmt.Sprintf(" -tide-width %d -tide-period %d -low-tide %g", t.Width, t.Period, t.Low)
+	}
+	if d := p.DayNight; d.Length > 0 {
+		s += fmt.Sprintf(" -day-length %d -night-share %g -day-amplitude %g", d.Length, d.Night, d.Amplitude)
+	}
+	if c := p.Climate; c.enabled() {
+		s += fmt.Sprintf(" -temp-gradient %g -equator-temp %g -warming %g -fish-optimum %g -shark-optimum %g -thermal-tolerance %g",
+			c.Gradient, c.Equator, c.Warming, c.FishOptimum, c.SharkOptimum, c.Tolerance)
+	}
+	if p.Pollution.enabled() {
+		s += fmt.Sprintf(" -pollution %q -pollution-diffusion %g -pollution-decay %g -pollution-shark-drain %g -pollution-fish-harm %g",
+			formatPollutionSources(p.Pollution.Sources), p.Pollution.Diffusion, p.Pollution.Decay, p.Pollution.SharkDrain, p.Pollution.FishHarm)
+	}
+	if p.InitPattern != "" {
+		s += " -init-pattern " + p.InitPattern
+	}
+	if p.RandomState {
+		s += " -init-state random"
+	}
+	if p.Land != nil {
+		s += " (with random terrain)"
+	}
+	return s
+}
+
+/*!
+ * \brief Step one random case and check every chronon.
+ * \param t The test.
+ * \param seed Seed of the case: it picks the configuration and the run.
+ * \param chronons Chronons to step.
+ */
+func checkInvariantCase(t *testing.T, seed int64, chronons int) {
+	t.Helper()
+	gen := rand.New(rand.NewSource(seed))
+	p := randomConfig(gen)
+	runSeed := gen.Int63()
+	rng := rand.New(rand.NewSource(runSeed))
+	w := NewWorld(p, rng)
+	for chronon := 0; chronon < chronons; chronon++ {
+		next := Step(w, p, rng)
+		if err := checkInvariants(w, next); err != nil {
+			t.Fatalf("chronon %d: %v\nreproduce with: %s", chronon, err, configFlagsText(p, runSeed))
+		}
+		w = next
+	}
+}
+
+/*!
+ * \brief Random configurations keep every invariant; -short checks fewer.
+ */
+func TestInvariants(t *testing.T) {
+	cases := 200
+	if testing.Short() {
+		cases = 20
+	}
+	for seed := int64(1); seed <= int64(cases); seed++ {
+		checkInvariantCase(t, seed, 200)
+	}
+}
+
+/*!
+ * \brief Search the case seeds with "go test -fuzz FuzzInvariants".
+ */
+func FuzzInvariants(f *testing.F) {
+	for seed := int64(1); seed <= 3; seed++ {
+		f.Add(seed)
+	}
+	f.Fuzz(func(t *testing.T, seed int64) {
+		checkInvariantCase(t, seed, 100)
+	})
+}
@@ -0,0 +1,108 @@
+/*!
+ * \file islands.go
+ * \brief Procedural archipelago maps from Perlin noise.
+ *
+ * Fractal Perlin noise is sampled over the grid and the highest cells
+ * become land, so the sea level sets the fraction of water directly. The
+ * gradient lattice repeats with the grid, so coastlines continue
+ * seamlessly across the edges of the torus.
+ */
+
+package main
+
+import (
+	"math"
+	"math/rand"
+	"sort"
+)
+
+/*!
+ * \brief Number of noise octaves summed; each has half the feature size and amplitude of the previous one.
+ */
+const islandOctaves = 4
+
+/*!
+ * \brief Perlin noise whose gradient lattice wraps around.
+ */
+type periodicNoise struct {
+	period int          ///< Lattice cells per axis before the pattern repeats
+	grads  [][2]float64 ///< Unit gradient per lattice point (index j*period+i)
+}
+
+/*!
+ * \brief Create periodic noise with random gradients.
+ * \param period Lattice cells per axis.
+ * \param rng Random source for the gradients.
+ * \return The noise.
+ */
+func newPeriodicNoise(period int, rng *rand.Rand) *periodicNoise {
+	n := &periodicNoise{period: period, grads: make([][2]float64, period*period)}
+	for i := range n.grads {
+		a := rng.Float64() * 2 * math.Pi
+		n.grads[i] = [2]float64{math.Cos(a), math.Sin(a)}
+	}
+	return n
+}
+
+/*!
+ * \brief Sample the noise.
+ * \param x X position in lattice units.
+ * \param y Y position in lattice units.
+ * \return Noise value, roughly in [-0.7, 0.7].
+ */
+func (n *periodicNoise) at(x, y float64) float64 {
+	x0, y0 := math.Floor(x), math.Floor(y)
+	fx, fy := x-x0, y-y0
+	i0, j0 := int(x0), int(y0)
+
+	dot := func(di, dj int) float64 {
+		g := n.grads[wrap(j0+dj, n.period)*n.period+wrap(i0+di, n.period)]
+		return g[0]*(fx-float64(di)) + g[1]*(fy-float64(dj))
+	}
+	fade := func(t float64) float64 { return t * t * t * (t*(t*6-15) + 10) }
+	lerp := func(a, b, t float64) float64 { return a + (b-a)*t }
+
+	u, v := fade(fx), fade(fy)
+	return lerp(lerp(dot(0, 0), dot(1, 0), u), lerp(dot(0, 1), dot(1, 1), u), v)
+}
+
+/*!
+ * \brief Generate an archipelago land map.
+ * \param size Width/height of the grid.
+ * \param seed Seed of the noise.
+ * \param scale Typical island size in cells.
+ * \param seaLevel Fraction of the cells that are water, in [0, 1].
+ * \return Land cells, row-major (index y*size+x).
+ */
+func generateIslands(size int, seed int64, scale, seaLevel float64) []bool {
+	rng := rand.New(rand.NewSource(seed))
+	period := max(1, int(math.Round(float64(size)/scale)))
+
+	height := make([]float64, size*size)
+	amplitude := 1.0
+	for o := 0; o < islandOctaves; o++ {
+		noise := newPeriodicNoise(period, rng)
+		cells := float64(period) / float64(size)
+		for y := 0; y < size; y++ {
+			for x := 0; x < size; x++ {
+				height[y*size+x] += amplitude * noise.at(float64(x)*cells, float64(y)*cells)
+			}
+		}
+		period *= 2
+		amplitude /= 2
+	}
+
+	// The sea level is the height below which the requested fraction lies
+	sorted := append([]float64{}, height...)
+	sort.Float64s(sorted)
+	water := int(math.Round(seaLevel * float64(len(sorted))))
+	land := make([]bool, size*size)
+	if water >= len(sorted) {
+		return land
+	}
+	threshold := sorted[water]
+	for i, h := range height {
+		land[i] = h >= threshold
+	}
+	return land
+}
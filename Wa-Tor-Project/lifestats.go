@@ -0,0 +1,110 @@
+/*!
+ * \file lifestats.go
+ * \brief Per-creature lifetime statistics aggregated over a run.
+ *
+ * Every death event carries the creature's age, number of offspring and
+ * (for sharks) number of fish eaten. The lifeStats observer collects these
+ * and prints their distributions per species when the run ends.
+ */
+
+package main
+
+import (
+	"fmt"
+	"io"
+	"sort"
+)
+
+/*!
+ * \brief Lifetime values of the creatures of one species that died.
+ */
+type lifeRecords struct {
+	Lifespans []int ///< Age at death
+	Offspring []int ///< Offspring produced over the lifetime
+	Kills     []int ///< Fish eaten over the lifetime
+}
+
+/*!
+ * \brief Observer collecting lifetime statistics from death events.
+ */
+type lifeStats struct {
+	out     io.Writer                ///< Destination of the summary
+	records map[Species]*lifeRecords ///< Records per species
+}
+
+/*!
+ * \brief Create a lifetime statistics observer.
+ * \param out Destination of the end-of-run summary.
+ * \return The observer.
+ */
+func newLifeStats(out io.Writer) *lifeStats {
+	return &lifeStats{
+		out: out,
+		records: map[Species]*lifeRecords{
+			Fish:  {},
+			Shark: {},
+		},
+	}
+}
+
+/*!
+ * \brief Record the deaths of a frame.
+ * \param f The frame to record.
+ * \return nil.
+ */
+func (s *lifeStats) Observe(f *Frame) error {
+	for ev := range f.Events() {
+		if ev.Kind != Eaten && ev.Kind != Starved && ev.Kind != Died {
+			continue
+		}
+		r := s.records[ev.Species]
+		if r == nil {
+			// Registered species of a food web are not summarised
+			continue
+		}
+		r.Lifespans = append(r.Lifespans, ev.Age)
+		r.Offspring = append(r.Offspring, ev.Offspring)
+		r.Kills = append(r.Kills, ev.Kills)
+	}
+	return nil
+}
+
+/*!
+ * \brief Print the distributions.
+ * \return Any write error.
+ */
+func (s *lifeStats) Close() error {
+	fmt.Fprintln(s.out, "Lifetime statistics (creatures that died during the run):")
+	fmt.Fprintf(s.out, "  %-16s %7s %8s %7s %7s %7s\n", "", "count", "mean", "median", "p90", "max")
+	for _, species := range []Species{Fish, Shark} {
+		r := s.records[species]
+		writeDistribution(s.out, species.String()+" lifespan", r.Lifespans)
+		writeDistribution(s.out, species.String()+" offspring", r.Offspring)
+		if species == Shark {
+			writeDistribution(s.out, "shark fish eaten", r.Kills)
+		}
+	}
+	_, err := fmt.Fprintln(s.out)
+	return err
+}
+
+/*!
+ * \brief Print one row of the distribution table.
+ * \param out Destination of the row.
+ * \param label Row label.
+ * \param values The sample (sorted in place).
+ */
+func writeDistribution(out io.Writer, label string, values []int) {
+	if len(values) == 0 {
+		fmt.Fprintf(out, "  %-16s %7s %8s %7s %7s %7s\n", label, "0", "-", "-", "-", "-")
+		return
+	}
+	sort.Ints(values)
+	sum := 0
+	for _, v := range values {
+		sum += v
+	}
+	n, loc := len(values), userLocale()
+	fmt.Fprintf(out, "  %-16s %7s %8s %7s %7s %7s\n", label, loc.integer(n),
+		loc.fixed(float64(sum)/float64(n), 2), loc.integer(values[n/2]), loc.integer(values[(n*9)/10]), loc.integer(values[n-1]))
+}
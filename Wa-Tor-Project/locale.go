@@ -0,0 +1,190 @@
+/*!
+ * \file locale.go
+ * \brief Locale-aware formatting of the numbers in reports and summaries.
+ *
+ * Human-readable output (the HTML report, the end-of-run summary, the
+ * lifetime and memory statistics and the summary renderer) writes its
+ * numbers the way the user's locale does: 12,345.6 in English, 12.345,6
+ * in German, 12 345,6 in French, 1,23,456.7 in Hindi. The locale is taken from LC_ALL,
+ * LC_NUMERIC or LANG, the first one set, as by the C library; unset, C
+ * or POSIX, numbers are written plainly as before, without grouping.
+ * Output meant for programs (CSV, JSON, the summary line of key=value
+ * pairs) never changes with the locale.
+ *
+ * Only separators are localised, from the small table below; text stays
+ * in English. Locales not in the table fall back to their language, and
+ * unknown languages to plain numbers.
+ */
+
+package main
+
+import (
+	"os"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+/*!
+ * \brief Separators of a locale.
+ */
+type numberLocale struct {
+	Group   string ///< Between groups of three digits; "" for no grouping
+	Decimal string ///< Before the fraction
+	Percent string ///< Between a number and its percent sign
+	Lakh    bool   ///< Groups of two digits above the first three, as in India
+}
+
+/*!
+ * \brief No-break space, keeping grouped numbers on one line.
+ */
+const nbsp = "\u00a0"
+
+/*!
+ * \brief Plain numbers, for C, POSIX and unknown locales.
+ */
+var plainLocale = numberLocale{Decimal: "."}
+
+/*!
+ * \brief Separators by language, or language and territory (as in CLDR).
+ */
+var numberLocales = map[string]numberLocale{
+	"en":    {",", ".", "", false},
+	"ja":    {",", ".", "", false},
+	"ko":    {",", ".", "", false},
+	"zh":    {",", ".", "", false},
+	"hi":    {",", ".", "", true},
+	"en_IN": {",", ".", "", true},
+	"de":    {".", ",", nbsp, false},
+	"de_CH": {"\u2019", ".", "", false},
+	"da":    {".", ",", nbsp, false},
+	"es":    {".", ",", nbsp, false},
+	"id":    {".", ",", "", false},
+	"it":    {".", ",", "", false},
+	"nl":    {".", ",", "", false},
+	"pt":    {".", ",", "", false},
+	"pt_PT": {nbsp, ",", "", false},
+	"tr":    {".", ",", "", false},
+	"cs":    {nbsp, ",", nbsp, false},
+	"fi":    {nbsp, ",", nbsp, false},
+	"fr":    {"\u202f", ",", "\u202f", false},
+	"nb":    {nbsp, ",", nbsp, false},
+	"pl":    {nbsp, ",", "", false},
+	"ru":    {nbsp, ",", nbsp, false},
+	"sv":    {nbsp, ",", nbsp, false},
+	"uk":    {nbsp, ",", "", false},
+}
+
+/*!
+ * \brief Separators of a locale name.
+ * \param name A locale such as "de_DE.UTF-8", "fr_CA@euro" or "hi-IN".
+ * \return Those of its language and territory, of its language, or plainLocale.
+ */
+func lookupLocale(name string) numberLocale {
+	name = strings.ReplaceAll(name, "-", "_")
+	name, _, _ = strings.Cut(name, ".")
+	name, _, _ = strings.Cut(name, "@")
+	if l, ok := numberLocales[name]; ok {
+		return l
+	}
+	language, _, _ := strings.Cut(name, "_")
+	if l, ok := numberLocales[language]; ok {
+		return l
+	}
+	return plainLocale
+}
+
+/*!
+ * \brief Separators of the user's locale, looked up once.
+ */
+var userLocale = sync.OnceValue(func() numberLocale {
+	for _, name := range []string{"LC_ALL", "LC_NUMERIC", "LANG"} {
+		if v := os.Getenv(name); v != "" {
+			return lookupLocale(v)
+		}
+	}
+	return plainLocale
+})
+
+/*!
+ * \brief Group the digits of an integer part.
+ * \param digits Decimal digits, optionally after a minus sign.
+ * \return The digits with Group between every three from the right, or
+ *         with Lakh after the first three and then every two.
+ */
+func (l numberLocale) group(digits string) string {
+	sign := ""
+	if strings.HasPrefix(digits, "-") {
+		sign, digits = "-", digits[1:]
+	}
+	if l.Group == "" || len(digits) <= 3 {
+		return sign + digits
+	}
+	var b strings.Builder
+	b.WriteString(sign)
+	for i, d := range digits {
+		left := len(digits) - i
+		if i > 0 && (left%3 == 0 && !l.Lakh || l.Lakh && left >= 3 && (left-3)%2 == 0) {
+			b.WriteString(l.Group)
+		}
+		b.WriteRune(d)
+	}
+	return b.String()
+}
+
+/*!
+ * \brief Format an integer.
+ * \param n The integer.
+ * \return It with its digits grouped.
+ */
+func (l numberLocale) integer(n int) string {
+	return l.group(strconv.Itoa(n))
+}
+
+/*!
+ * \brief Format a number with a fixed number of decimals.
+ * \param v The number.
+ * \param decimals Digits after the decimal separator.
+ * \return It with its digits grouped, like "%.*f" otherwise.
+ */
+func (l numberLocale) fixed(v float64, decimals int) string {
+	s := strconv.FormatFloat(v, 'f', decimals, 64)
+	whole, fraction, ok := strings.Cut(s, ".")
+	if whole == "NaN" || strings.HasSuffix(whole, "Inf") {
+		return s
+	}
+	if !ok {
+		return l.group(whole)
+	}
+	return l.group(whole) + l.Decimal + fraction
+}
+
+/*!
+ * \brief Format a number with a number of significant digits.
+ * \param v The number.
+ * \param digits Significant digits.
+ * \return It like "%.*g", with the locale's decimal separator and no grouping.
+ */
+func (l numberLocale) sig(v float64, digits int) string {
+	return strings.Replace(strconv.FormatFloat(v, 'g', digits, 64), ".", l.Decimal, 1)
+}
+
+/*!
+ * \brief Format a percentage.
+ * \param v The percentage, 100 for all.
+ * \param decimals Digits after the decimal separator.
+ * \return It with its percent sign.
+ */
+func (l numberLocale) percent(v float64, decimals int) string {
+	return l.fixed(v, decimals) + l.Percent + "%"
+}
+
+/*!
+ * \brief Format a duration.
+ * \param d The duration.
+ * \return It like time.Duration.String, with the locale's decimal separator.
+ */
+func (l numberLocale) duration(d time.Duration) string {
+	return strings.Replace(d.String(), ".", l.Decimal, 1)
+}
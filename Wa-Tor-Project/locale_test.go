@@ -0,0 +1,53 @@
+/*!
+ * \file locale_test.go
+ * \brief Digit grouping and separators of the built-in locales.
+ */
+
+package main
+
+import (
+	"testing"
+	"time"
+)
+
+/*!
+ * \brief Integers, decimals, percentages and durations in each locale.
+ */
+func TestLocaleFormats(t *testing.T) {
+	tests := []struct {
+		locale   string
+		integer  int
+		grouped  string
+		fixed    string // 1234567.891 with two decimals
+		percent  string // 12.5 with one decimal
+		duration string // 1.5 seconds
+	}{
+		{"C", 1234567, "1234567", "1234567.89", "12.5%", "1.5s"},
+		{"en_US.UTF-8", 1234567, "1,234,567", "1,234,567.89", "12.5%", "1.5s"},
+		{"en_GB", -1234, "-1,234", "1,234,567.89", "12.5%", "1.5s"},
+		{"de_DE.UTF-8", 1234567, "1.234.567", "1.234.567,89", "12,5\u00a0%", "1,5s"},
+		{"de_CH", 1234567, "1\u2019234\u2019567", "1\u2019234\u2019567.89", "12.5%", "1.5s"},
+		{"fr_FR.UTF-8", 1234567, "1\u202f234\u202f567", "1\u202f234\u202f567,89", "12,5\u202f%", "1,5s"},
+		{"fr_CA@euro", 999, "999", "1\u202f234\u202f567,89", "12,5\u202f%", "1,5s"},
+		{"hi-IN", 1234567, "12,34,567", "12,34,567.89", "12.5%", "1.5s"},
+		{"hi_IN.UTF-8", -1234567890, "-1,23,45,67,890", "12,34,567.89", "12.5%", "1.5s"},
+		{"hi", 100000, "1,00,000", "12,34,567.89", "12.5%", "1.5s"},
+		{"en_IN", 1000, "1,000", "12,34,567.89", "12.5%", "1.5s"},
+		{"xx_YY", 1234567, "1234567", "1234567.89", "12.5%", "1.5s"},
+	}
+	for _, tt := range tests {
+		l := lookupLocale(tt.locale)
+		if got := l.integer(tt.integer); got != tt.grouped {
+			t.Errorf("%s: integer(%d) = %q, want %q", tt.locale, tt.integer, got, tt.grouped)
+		}
+		if got := l.fixed(1234567.891, 2); got != tt.fixed {
+			t.Errorf("%s: fixed = %q, want %q", tt.locale, got, tt.fixed)
+		}
+		if got := l.percent(12.5, 1); got != tt.percent {
+			t.Errorf("%s: percent = %q, want %q", tt.locale, got, tt.percent)
+		}
+		if got := l.duration(1500 * time.Millisecond); got != tt.duration {
+			t.Errorf("%s: duration = %q, want %q", tt.locale, got, tt.duration)
+		}
+	}
+}
@@ -0,0 +1,214 @@
+/*!
+ * \file lotka.go
+ * \brief Lotka-Volterra fit of recorded population series.
+ *
+ * The mean-field model of Wa-Tor is the Lotka-Volterra system
+ *
+ *     dF/dt = a F - b F S
+ *     dS/dt = c F S - d S
+ *
+ * for fish F and sharks S. Dividing by the population makes both
+ * equations linear in the parameters (d ln F/dt = a - b S and
+ * d ln S/dt = c F - d), so they are fitted by least squares on the
+ * smoothed log-derivatives of the series. The fitted system is then
+ * integrated from the first recorded point and compared with the data.
+ */
+
+package main
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"math"
+)
+
+/*!
+ * \brief Chronons averaged on each side when smoothing a series before fitting.
+ */
+const lvSmoothing = 2
+
+/*!
+ * \brief A fitted Lotka-Volterra model and how well it matches the data.
+ */
+type lvFit struct {
+	A, B, C, D float64   ///< Fish growth, predation, shark conversion and shark death rates
+	Fish       []float64 ///< Fish population of the integrated model per chronon
+	Sharks     []float64 ///< Shark population of the integrated model per chronon
+	FishR2     float64   ///< Coefficient of determination of the model against the fish series
+	SharkR2    float64   ///< Coefficient of determination of the model against the shark series
+	FishMean   float64   ///< Observed mean fish population over the fitted range
+	SharkMean  float64   ///< Observed mean shark population over the fitted range
+}
+
+/*!
+ * \brief Equilibrium of the fitted model.
+ * \return Fish and shark populations at which both derivatives vanish.
+ */
+func (m *lvFit) equilibrium() (float64, float64) {
+	return m.D / m.C, m.A / m.B
+}
+
+/*!
+ * \brief Period of small oscillations around the equilibrium.
+ * \return The period in chronons.
+ */
+func (m *lvFit) period() float64 {
+	return 2 * math.Pi / math.Sqrt(m.A*m.D)
+}
+
+/*!
+ * \brief Fit the Lotka-Volterra model to population series.
+ * \param fish Fish population per chronon.
+ * \param sharks Shark population per chronon.
+ * \return The fit, or an error if the series are too short or show no predator-prey coupling.
+ *
+ * Only the part of the series before either species dies out is used.
+ */
+func fitLotkaVolterra(fish, sharks []float64) (*lvFit, error) {
+	n := len(fish)
+	for i := range fish {
+		if fish[i] <= 0 || sharks[i] <= 0 {
+			n = i
+			break
+		}
+	}
+	if n < 4*lvSmoothing+3 {
+		return nil, errors.New("too few chronons with both species alive to fit")
+	}
+	f, s := smoothSeries(fish[:n], lvSmoothing), smoothSeries(sharks[:n], lvSmoothing)
+
+	// Central differences of the logs against the other population
+	var gf, gs, xs, xf []float64
+	for t := 1; t < n-1; t++ {
+		gf = append(gf, (math.Log(f[t+1])-math.Log(f[t-1]))/2)
+		gs = append(gs, (math.Log(s[t+1])-math.Log(s[t-1]))/2)
+		xs = append(xs, s[t])
+		xf = append(xf, f[t])
+	}
+	fishSlope, fishIntercept := linearFit(xs, gf)
+	sharkSlope, sharkIntercept := linearFit(xf, gs)
+
+	m := &lvFit{A: fishIntercept, B: -fishSlope, C: sharkSlope, D: -sharkIntercept}
+	if !(m.A > 0 && m.B > 0 && m.C > 0 && m.D > 0) {
+		return nil, fmt.Errorf("series show no predator-prey coupling (a=%.3g b=%.3g c=%.3g d=%.3g)", m.A, m.B, m.C, m.D)
+	}
+
+	m.Fish, m.Sharks = m.integrate(f[0], s[0], n)
+	m.FishR2 = rSquared(fish[:n], m.Fish)
+	m.SharkR2 = rSquared(sharks[:n], m.Sharks)
+	for i := 0; i < n; i++ {
+		m.FishMean += fish[i] / float64(n)
+		m.SharkMean += sharks[i] / float64(n)
+	}
+	return m, nil
+}
+
+/*!
+ * \brief Integrate the model with fourth-order Runge-Kutta.
+ * \param f0 Initial fish population.
+ * \param s0 Initial shark population.
+ * \param n Number of chronons to produce.
+ * \return Fish and shark populations per chronon.
+ */
+func (m *lvFit) integrate(f0, s0 float64, n int) ([]float64, []float64) {
+	const substeps = 10
+	const h = 1.0 / substeps
+	deriv := func(f, s float64) (float64, float64) {
+		return m.A*f - m.B*f*s, m.C*f*s - m.D*s
+	}
+
+	fish, sharks := make([]float64, n), make([]float64, n)
+	f, s := f0, s0
+	for t := 0; t < n; t++ {
+		fish[t], sharks[t] = f, s
+		for k := 0; k < substeps; k++ {
+			k1f, k1s := deriv(f, s)
+			k2f, k2s := deriv(f+h/2*k1f, s+h/2*k1s)
+			k3f, k3s := deriv(f+h/2*k2f, s+h/2*k2s)
+			k4f, k4s := deriv(f+h*k3f, s+h*k3s)
+			f += h / 6 * (k1f + 2*k2f + 2*k3f + k4f)
+			s += h / 6 * (k1s + 2*k2s + 2*k3s + k4s)
+		}
+	}
+	return fish, sharks
+}
+
+/*!
+ * \brief Centred moving average of a series.
+ * \param v The series.
+ * \param radius Points averaged on each side (fewer at the ends).
+ * \return The smoothed series.
+ */
+func smoothSeries(v []float64, radius int) []float64 {
+	out := make([]float64, len(v))
+	for i := range v {
+		lo, hi := max(0, i-radius), min(len(v)-1, i+radius)
+		for j := lo; j <= hi; j++ {
+			out[i] += v[j]
+		}
+		out[i] /= float64(hi - lo + 1)
+	}
+	return out
+}
+
+/*!
+ * \brief Ordinary least squares line through points.
+ * \param x Independent values.
+ * \param y Dependent values.
+ * \return Slope and intercept (slope 0 if x is constant).
+ */
+func linearFit(x, y []float64) (float64, float64) {
+	n := float64(len(x))
+	mx, my := 0.0, 0.0
+	for i := range x {
+		mx += x[i] / n
+		my += y[i] / n
+	}
+	sxy, sxx := 0.0, 0.0
+	for i := range x {
+		sxy += (x[i] - mx) * (y[i] - my)
+		sxx += (x[i] - mx) * (x[i] - mx)
+	}
+	if sxx == 0 {
+		return 0, my
+	}
+	slope := sxy / sxx
+	return slope, my - slope*mx
+}
+
+/*!
+ * \brief Coefficient of determination of a model against data.
+ * \param data Observed values.
+ * \param model Predicted values.
+ * \return 1 - SS_res/SS_tot; negative when the model is worse than the mean.
+ */
+func rSquared(data, model []float64) float64 {
+	mean := 0.0
+	for _, v := range data {
+		mean += v / float64(len(data))
+	}
+	res, tot := 0.0, 0.0
+	for i := range data {
+		res += (data[i] - model[i]) * (data[i] - model[i])
+		tot += (data[i] - mean) * (data[i] - mean)
+	}
+	if tot == 0 {
+		return 0
+	}
+	return 1 - res/tot
+}
+
+/*!
+ * \brief Print the fitted parameters and how they compare with the simulation.
+ * \param out Destination of the summary.
+ * \param m The fit.
+ */
+func writeLVSummary(out io.Writer, m *lvFit) {
+	fe, se := m.equilibrium()
+	loc := userLocale()
+	fmt.Fprintf(out, "Lotka-Volterra fit: a=%s b=%s c=%s d=%s\n", loc.sig(m.A, 4), loc.sig(m.B, 4), loc.sig(m.C, 4), loc.sig(m.D, 4))
+	fmt.Fprintf(out, "  equilibrium %s fish, %s sharks (observed means %s, %s); period %s chronons\n",
+		loc.fixed(fe, 0), loc.fixed(se, 0), loc.fixed(m.FishMean, 0), loc.fixed(m.SharkMean, 0), loc.fixed(m.period(), 1))
+	fmt.Fprintf(out, "  R² of the model against the simulation: fish %s, sharks %s\n", loc.fixed(m.FishR2, 3), loc.fixed(m.SharkR2, 3))
+}
@@ -5,128 +5,925 @@
  * This file implements the Wa-Tor predator-prey simulation.
  * The simulation contains fish and sharks on a toroidal grid.
  * Each chronon (time step) updates the world according to the rules:
- * - Fish move and reproduce
+ * - Fish move and reproduce, and starve if given an energy budget
  * - Sharks move, hunt fish, reproduce, and starve
  */
 
 package main
 
 import (
+	"errors"
+	"flag"
 	"fmt"
+	"io"
+	"math/bits"
 	"math/rand"
+	"os"
+	"sort"
+	"strings"
+	"sync"
+	"sync/atomic"
 	"time"
 )
 
 /*!
  * \brief Species type for creatures.
- * Used to identify whether a cell is empty, a fish, or a shark.
+ * Used to identify whether a cell is empty, a fish, or a shark. A byte, so
+ * creatures and frames stay compact.
  */
-type Species int
+type Species uint8
 
 const (
 	Empty Species = iota ///< Empty cell
 	Fish                 ///< Fish creature
 	Shark                ///< Shark creature
+	Land                 ///< Land cell; never holds a creature
+	Whale                ///< Cell of a whale's body
 )
 
+/*!
+ * \brief Map character of each species, indexed by Species; the registered
+ *        species of a food web are written as their number.
+ */
+const speciesChars = ".FS#W12345678"
+
+/*!
+ * \brief Lower-case name of a species.
+ * \return "empty", "fish", "shark", "land", "whale" or "species N" for
+ *         the Nth registered species.
+ */
+func (s Species) String() string {
+	switch s {
+	case Fish:
+		return "fish"
+	case Shark:
+		return "shark"
+	case Land:
+		return "land"
+	case Whale:
+		return "whale"
+	case Empty:
+		return "empty"
+	}
+	return fmt.Sprintf("species %d", s-firstWebSpecies+1)
+}
+
+/*!
+ * \brief Order in which the cells of the grid are updated each chronon.
+ */
+type UpdateScheme int
+
+const (
+	Raster       UpdateScheme = iota ///< Row-by-row scan over the whole grid
+	Checkerboard                     ///< Two passes: even (x+y) cells, then odd
+	Shuffle                          ///< The creatures in a new random order every chronon
+	Phased                           ///< Two passes: all fish, then every other creature
+	Priority                         ///< The creatures by a random priority fixed for their life
+)
+
+/*!
+ * \brief Number of chronons a run lasts unless all life dies out first, if not set with -chronons.
+ */
+const defaultChronons = 10000
+
+/*!
+ * \brief Simulation parameters.
+ */
+type Config struct {
+	NumShark        int            ///< Initial number of sharks
+	NumFish         int            ///< Initial number of fish
+	FishBreed       int            ///< Fish reproduction rate
+	SharkBreed      int            ///< Shark reproduction rate
+	Starve          int            ///< Shark starvation time
+	GridSize        int            ///< Size of the square grid
+	Scheme          UpdateScheme   ///< Cell update ordering
+	Workers         int            ///< Goroutines stepping tiles in parallel (1 = sequential)
+	TileSize        int            ///< Width/Height of a parallel work tile
+	Window          int            ///< Chronons in the rolling metrics sampling window
+	Land            []bool         ///< Land cells, row-major (index y*GridSize+x); nil = all water
+	Reef            []bool         ///< Reef cells, where sharks cannot go, row-major; nil = no reefs
+	Layout          []Species      ///< Initial creatures, row-major; nil = random placement
+	InitPattern     string         ///< Spatial pattern of the random placement ("" = uniform)
+	Spawns          []spawnRegion  ///< Regions the random placement of a species is confined to; nil = the whole grid
+	Bounded         bool           ///< Edges are walls instead of wrapping around
+	RandomState     bool           ///< Initial creatures start at a random point of their cycle
+	ImmigrateFish   float64        ///< Chance per chronon that an empty edge cell of a bounded world receives a fish
+	ImmigrateSharks float64        ///< Chance per chronon that an empty edge cell of a bounded world receives a shark
+	Emigrate        float64        ///< Chance per chronon that a creature on an edge cell of a bounded world leaves
+	Aging           ageCurve       ///< Age-dependent fertility and speed
+	FishEnergy      fishEnergy     ///< Energy budget of the fish
+	Breeding        breedingCost   ///< What a litter costs its parent
+	Ambush          ambushRule     ///< When hungry sharks rest in ambush
+	Mortality       mortalityRule  ///< Background mortality of fish and sharks
+	Crowding        crowdingRule   ///< Breeding slowed by crowded neighbours
+	SharkTerritory  float64        ///< How strongly moving sharks avoid cells next to other sharks
+	Starvation      starvationRule ///< Order of starving and eating, and the energy of a meal
+	Conflicts       ConflictPolicy ///< Who gets a cell two creatures move to
+	Pollution       pollutionRules ///< Sources and effects of the pollution field
+	Climate         climateRules   ///< Temperature gradient and warming
+	DayNight        dayCycle       ///< Day/night cycle of hunting success
+	Tide            tideRules      ///< Tides exposing the shoreline
+	Whales          whaleRules     ///< Whales with multi-cell bodies
+	Eggs            eggRules       ///< Egg stage of the newborns
+	Sexes           sexRules       ///< Males, females and mate search
+	Web             foodWeb        ///< Registered species and who eats whom
+	Introductions   []introduction ///< Creatures released at scheduled chronons
+	Strategies      strategyRules  ///< Mutation of the movement strategies
+	MoveWeights     []moveWeights  ///< Preferred directions of movement
+}
+
 /*!
  * \brief Represents an individual fish or shark.
+ *
+ * Creatures are values stored directly in the grid; a cell whose Species
+ * is Empty holds none. The counters are 32-bit and Species is a byte, so
+ * a creature takes 40 bytes and a column of the grid is one contiguous
+ * block the stepper scans without following pointers. The parent of a
+ * creature is only recorded in the event of its birth.
  */
 type Creature struct {
-	Species   Species ///< Type of creature
-	Age       int     ///< Age in chronons
-	Energy    int     ///< Remaining energy (only for sharks)
-	LastBreed int     ///< Chronons since last reproduction
+	ID        int      ///< Unique identifier, never reused within a run
+	Age       int32    ///< Age in chronons
+	Energy    int32    ///< Remaining energy (sharks, and fish with an energy budget)
+	LastBreed int32    ///< Chronons since last reproduction
+	Offspring int32    ///< Number of offspring produced so far
+	Kills     int32    ///< Fish eaten so far (only for sharks)
+	Hatch     int16    ///< Chronons until the egg hatches (0 = hatched)
+	Female    bool     ///< Female rather than male (only with sexes)
+	Species   Species  ///< Type of creature
+	Strategy  Strategy ///< How it picks a free cell, passed on to its offspring
+	Gen       int32    ///< Generation: 0 when placed at start or arriving, one more than the parent's for offspring
 }
 
 /*!
  * \brief Represents the Wa-Tor simulation world.
  */
 type World struct {
-	Grid       [][]*Creature ///< 2D grid of creatures
-	Size       int           ///< Width/Height of the square grid
-	FishBreed  int           ///< Chronons needed for a fish to reproduce
-	SharkBreed int           ///< Chronons needed for a shark to reproduce
-	Starve     int           ///< Shark energy before starvation
+	Grid       [][]Creature   ///< 2D grid of creatures (Grid[x][y]), the columns backed by one array
+	Size       int            ///< Width/Height of the square grid
+	FishBreed  int            ///< Chronons needed for a fish to reproduce
+	SharkBreed int            ///< Chronons needed for a shark to reproduce
+	Starve     int            ///< Shark energy before starvation
+	aging      ageCurve       ///< Age-dependent fertility and speed
+	fishEnergy fishEnergy     ///< Energy budget of the fish
+	breeding   breedingCost   ///< What a litter costs its parent
+	ambush     ambushRule     ///< When hungry sharks rest in ambush
+	mortality  mortalityRule  ///< Background mortality of fish and sharks
+	crowding   crowdingRule   ///< How crowded neighbours slow breeding
+	territory  float64        ///< How strongly moving sharks avoid cells next to other sharks
+	starvation starvationRule ///< Order of starving and eating, and the energy of a meal
+	conflicts  ConflictPolicy ///< Who gets a cell two creatures move to
+	eggs       eggRules       ///< Egg times of the newborns
+	sexes      sexRules       ///< Whether breeding needs a mate, and how mates are sought
+	strategies strategyRules  ///< How offspring inherit movement strategies
+	drift      *moveTable     ///< Movement weights per species; nil = even chances
+	web        *webTable      ///< Compiled food web, shared by successive worlds; nil = sharks eat fish
+	pollution  []float32      ///< Pollution level per cell (y*Size+x), owned by the world; nil = clean water
+	polluting  pollutionRules ///< Effects, diffusion and decay of the pollution
+	climate    climateRules   ///< Temperatures of the rows and their effect on breeding
+	cycle      dayCycle       ///< Day/night cycle of hunting success
+	tide       tideRules      ///< Timing of the tides
+	tidal      []bool         ///< Tidal cells (y*Size+x), shared like the land; nil = no tides
+	whales     []whale        ///< Whales by the anchor of their body, owned by the world
+	whaleSize  int            ///< Width/height of a whale's body
+	elapsed    int            ///< Chronons stepped to reach this world
+	Events     []Event        ///< Births and deaths in the chronon that produced this world
+	ids        *idSource      ///< Allocator of creature IDs, shared by successive worlds
+	creatures  cellMask       ///< Cells holding a creature, kept in step with Grid
+	fish       cellMask       ///< Cells holding a fish, kept in step with Grid
+	moved      cellMask       ///< Cells whose creature was already updated this chronon
+	shared     *bool          ///< Whether parallel tiles are filling the world, so mask access must be atomic
+	land       []bool         ///< Land cells (y*Size+x), shared by successive worlds; nil = all water
+	reef       []bool         ///< Reef cells (y*Size+x), shared like the land; nil = no reefs
+	bounded    bool           ///< Edges are walls instead of wrapping around
+	mu         *sync.Mutex    ///< Guards Events during parallel stepping; a pointer so World can be copied
 }
 
 /*!
- * \brief Main function to run the simulation.
- *
- * It initializes the world, places fish and sharks,
- * and iteratively processes chronons, printing the grid and population.
+ * \brief A running simulation: the world plus everything needed to advance it.
  */
-func main() {
-	fmt.Println("Wa-Tor Simulation:")
-
-	// Simulation parameters
-	params := struct {
-		NumShark   int ///< Initial number of sharks
-		NumFish    int ///< Initial number of fish
-		FishBreed  int ///< Fish reproduction rate
-		SharkBreed int ///< Shark reproduction rate
-		Starve     int ///< Shark starvation time
-		GridSize   int ///< Size of the square grid
-	}{
+type Simulation struct {
+	World   *World          ///< Current world state
+	Params  Config          ///< Simulation parameters
+	Chronon int             ///< Number of chronons processed so far
+	Seed    int64           ///< Seed of the random source
+	rng     *rand.Rand      ///< Random source for placement and movement
+	source  *countingSource ///< Source of rng, counting draws so checkpoints can restore it
+	hunting *huntWindow     ///< Rolling hunting statistics
+	spare   *World          ///< World of the previous chronon, reused for the next one
+	fish    int             ///< Fish alive, kept up to date from the events of every chronon
+	sharks  int             ///< Sharks alive, kept up to date from the events of every chronon
+	hunger  bool            ///< Take the hunger of the sharks into the frames, for the hunger renderer
+	fishMap bool            ///< Take the age and generation of the fish into the frames, for their renderers
+	flow    bool            ///< Take the moves of the creatures into the frames, for the movement field
+}
+
+/*!
+ * \brief Default simulation parameters.
+ * \return Config with the classic settings.
+ */
+func defaultConfig() Config {
+	return Config{
 		NumShark:   100,
 		NumFish:    300,
 		FishBreed:  3,
 		SharkBreed: 10,
 		Starve:     5,
 		GridSize:   50,
+		Workers:    1,
+		TileSize:   8,
+		Window:     50,
+		Aging:      ageCurve{Fertility: 1, Speed: 1},
+		FishEnergy: fishEnergy{Move: 2, Plankton: 1},
+		Breeding:   breedingCost{FishCooldown: 1},
+		Ambush:     ambushRule{Chance: 0.5, Drain: 0.5},
+		Pollution:  pollutionRules{SharkDrain: 0.5, FishHarm: 0.5},
+		Climate:    climateRules{Equator: 28, FishOptimum: 20, SharkOptimum: 20, Tolerance: 8, Bands: 5},
+		DayNight:   dayCycle{Night: 0.5, Amplitude: 0.5},
+		Tide:       tideRules{Period: 20, Low: 0.5},
+		Whales:     whaleRules{Size: 2},
+		Sexes:      sexRules{MateBias: 0.5},
+		Web:        foodWeb{Diet: classicDiet()},
 	}
+}
 
-	rand.Seed(time.Now().UnixNano())
-
-	// Create and initialize world
+/*!
+ * \brief Create a simulation with a freshly populated world.
+ * \param params Simulation parameters.
+ * \param seed Seed of the simulation's random source.
+ * \return Pointer to the new Simulation.
+ *
+ * The parameters must have passed checkFit; creatures that do not fit
+ * are a programming error and panic.
+ */
+func newSimulation(params Config, seed int64) *Simulation {
+	source := newCountingSource(seed)
+	rng := rand.New(source)
 	world := createWorld(params.GridSize)
-	initializeWorld(world, params)
+	if err := initializeWorld(world, params, rng); err != nil {
+		panic(err)
+	}
+	sim := &Simulation{
+		World:   world,
+		Params:  params,
+		Seed:    seed,
+		rng:     rng,
+		source:  source,
+		hunting: newHuntWindow(params.Window),
+	}
+	sim.fish, sim.sharks = countPopulation(world)
+	return sim
+}
+
+/*!
+ * \brief Advance the simulation by one chronon.
+ */
+func (s *Simulation) Step() {
+	// Spawn events of the initial placement are reported with the first chronon
+	var spawned []Event
+	if s.Chronon == 0 {
+		spawned = s.World.Events
+	}
+
+	// Reuse the previous world rather than allocating a grid every chronon
+	next := s.spare
+	if next == nil || next.Size != s.World.Size {
+		next = createWorld(s.World.Size)
+	} else {
+		next.reset()
+	}
+	s.spare = s.World
+	s.World = processChrononInto(s.World, next, s.Params, s.rng)
+	n := countEvents(s.World.Events)
+	s.fish += n.fishChange()
+	s.sharks += n.sharkChange()
+	if spawned != nil {
+		s.World.Events = append(spawned, s.World.Events...)
+	}
+	s.Chronon++
+}
+
+/*!
+ * \brief Current populations.
+ * \return fish Number of fish.
+ * \return sharks Number of sharks.
+ *
+ * The counts follow the births and deaths of every chronon rather than
+ * scanning the world, so checking them after each chronon costs nothing.
+ */
+func (s *Simulation) Population() (fish, sharks int) {
+	return s.fish, s.sharks
+}
 
-	// Run simulation
-	for chronon := 0; chronon < 10000; chronon++ {
-		world = processChronon(world, params)
+/*!
+ * \brief Snapshot of the latest chronon, including rolling metrics.
+ * \return Pointer to the new Frame.
+ *
+ * Must be called exactly once after every Step, since it also advances
+ * the rolling metrics window.
+ */
+func (s *Simulation) Frame() *Frame {
+	f := newFrame(s.World, s.Chronon-1)
+	f.Fish, f.Sharks = s.fish, s.sharks
+	s.hunting.add(f.Sharks, f.events)
+	f.Hunting = s.hunting.metrics()
+	if s.hunger {
+		f.hunger = hungerOf(s.World)
+	}
+	if s.fishMap {
+		f.fishMap = fishMapOf(s.World)
+	}
+	if s.flow {
+		f.moves = movesOf(s.spare, s.World)
+	}
+	return f
+}
 
-		// Count populations
-		fishCount, sharkCount := countPopulation(world)
+/*!
+ * \brief Hands out creature IDs; each parallel tile has its own (see tileView).
+ */
+type idSource struct {
+	last atomic.Int64 ///< Most recently issued ID
+}
 
-		// Print population and grid
-		fmt.Printf("Chronon %d | Fish=%d | Sharks=%d\n", chronon, fishCount, sharkCount)
-		printWorld(world)
+/*!
+ * \brief Allocate a fresh creature ID.
+ * \return The next unused ID (IDs start at 1).
+ */
+func (s *idSource) next() int {
+	return int(s.last.Add(1))
+}
+
+/*!
+ * \brief Command-line flags shared by every mode that runs simulations.
+ */
+type configFlags struct {
+	fs       *flag.FlagSet ///< Flag set the flags are registered on
+	params   *Config       ///< Parameters the flags are written to
+	seed     *int64        ///< Value of -seed
+	scheme   *string       ///< Value of -scheme
+	topology *string       ///< Value of -topology
+	state    *string       ///< Value of -init-state
+	litter   *string       ///< Value of -shark-litter
+	starving *string       ///< Value of -starve-order
+	conflict *string       ///< Value of -conflicts
+	preset   *string       ///< Value of -preset
+
+	config      *string           ///< Value of -config
+	explicit    map[string]bool   ///< Flags given on the command line
+	fileValues  map[string]string ///< Settings last read from the -config file
+	scenario    *string           ///< Value of -scenario
+	mapFile     *string           ///< Value of -map
+	layoutFile  *string           ///< Value of -layout
+	spawn       *string           ///< Value of -spawn
+	pollution   *string           ///< Value of -pollution
+	species     *string           ///< Value of -species
+	eats        *string           ///< Value of -eats
+	introduce   *string           ///< Value of -introduce
+	moveWeights *string           ///< Value of -move-weights
+	islands     *bool             ///< Value of -gen-islands
+	seaLevel    *float64          ///< Value of -sea-level
+	islandScale *float64          ///< Value of -island-scale
+	islandSeed  *int64            ///< Value of -island-seed
+	reefWidth   *int              ///< Value of -reef-width
+	dryRun      *bool             ///< Value of -dry-run
+	ignoreEnv   bool              ///< Skip the WATOR_* environment variables
+	fromEnv     map[string]bool   ///< Flags set from the environment
+	description string            ///< Description of the loaded scenario
+	sources     map[string]string ///< Where each flag not given on the command line got its value
+}
+
+/*!
+ * \brief Register the simulation parameter flags on a flag set.
+ * \param fs The flag set.
+ * \param params Parameters receiving the flag values; their current values are the defaults.
+ * \return Handle used to resolve the flags after parsing.
+ */
+func registerConfigFlags(fs *flag.FlagSet, params *Config) *configFlags {
+	fs.IntVar(&params.GridSize, "grid", params.GridSize, "width/height of the square grid")
+	fs.IntVar(&params.NumFish, "fish", params.NumFish, "initial number of fish")
+	fs.IntVar(&params.NumShark, "sharks", params.NumShark, "initial number of sharks")
+	fs.IntVar(&params.FishBreed, "fishbreed", params.FishBreed, "chronons before a fish can reproduce")
+	fs.IntVar(&params.SharkBreed, "sharkbreed", params.SharkBreed, "chronons before a shark can reproduce")
+	fs.IntVar(&params.Starve, "starve", params.Starve, "shark energy gained from a fish / starvation time")
+	c := &configFlags{fs: fs, params: params}
+	c.preset = fs.String("preset", "", "start from a named preset: "+strings.Join(presetNames(), ", "))
+	c.config = fs.String("config", "", "read settings from a JSON `file` of flag values")
+	c.scenario = fs.String("scenario", "", "load settings, map and layout from a .wator scenario `file`")
+	pattern := params.InitPattern
+	if pattern == "" {
+		pattern = "uniform"
+	}
+	fs.StringVar(&params.InitPattern, "init-pattern", pattern, "initial placement: "+strings.Join(initPatternNames(), ", "))
+	c.state = fs.String("init-state", initStateName(params.RandomState), "initial creatures: newborn (all in step) or random (random breed counters, energy and ages)")
+	c.mapFile = fs.String("map", "", "land map `file`: '#' land, 'R' reef, '1'-'9' polluted water, '.' water")
+	c.layoutFile = fs.String("layout", "", "initial layout `file`: 'F' fish, 'S' shark, '.' empty")
+	c.pollution = fs.String("pollution", formatPollutionSources(params.Pollution.Sources), "pollution `sources` \"X,Y RADIUS [LEVEL]; ...\", e.g. \"25,25 8; 10,40 4 0.5\"")
+	fs.Float64Var(&params.Pollution.Diffusion, "pollution-diffusion", params.Pollution.Diffusion, "share of a cell's pollution exchanged with its neighbours per chronon")
+	fs.Float64Var(&params.Pollution.Decay, "pollution-decay", params.Pollution.Decay, "share of the pollution lost per chronon")
+	fs.Float64Var(&params.Pollution.SharkDrain, "pollution-shark-drain", params.Pollution.SharkDrain, "chance per unit of pollution that a shark loses an extra unit of energy")
+	fs.Float64Var(&params.Pollution.FishHarm, "pollution-fish-harm", params.Pollution.FishHarm, "loss of fish fertility per unit of pollution")
+	fs.Float64Var(&params.Climate.Gradient, "temp-gradient", params.Climate.Gradient, "temperature drop from the equator (middle row) to the poles (top and bottom rows); 0 = uniform")
+	fs.Float64Var(&params.Climate.Equator, "equator-temp", params.Climate.Equator, "temperature of the equator at the start")
+	fs.Float64Var(&params.Climate.Warming, "warming", params.Climate.Warming, "temperature rise per 1000 chronons")
+	fs.Float64Var(&params.Climate.FishOptimum, "fish-optimum", params.Climate.FishOptimum, "temperature at which fish breed fastest")
+	fs.Float64Var(&params.Climate.SharkOptimum, "shark-optimum", params.Climate.SharkOptimum, "temperature at which sharks breed fastest")
+	fs.Float64Var(&params.Climate.Tolerance, "thermal-tolerance", params.Climate.Tolerance, "width of the thermal performance curves")
+	fs.IntVar(&params.Climate.Bands, "climate-bands", params.Climate.Bands, "latitude bands recorded by -bands")
+	fs.IntVar(&params.Whales.Count, "whales", params.Whales.Count, "whales placed at the start, each with a square body of several cells")
+	fs.IntVar(&params.Whales.Size, "whale-size", params.Whales.Size, "width/height of a whale's body in cells")
+	fs.IntVar(&params.Tide.Width, "tide-width", params.Tide.Width, "reach of the tidal zone in steps from the land (0 = no tides)")
+	fs.IntVar(&params.Tide.Period, "tide-period", params.Tide.Period, "chronons per tide cycle")
+	fs.Float64Var(&params.Tide.Low, "low-tide", params.Tide.Low, "share of the tide cycle at low water")
+	fs.IntVar(&params.DayNight.Length, "day-length", params.DayNight.Length, "chronons per day/night cycle (0 = no cycle)")
+	fs.Float64Var(&params.DayNight.Night, "night-share", params.DayNight.Night, "share of the day/night cycle that is night")
+	fs.Float64Var(&params.DayNight.Amplitude, "day-amplitude", params.DayNight.Amplitude, "drop in shark catches by day and in fish movement by night, in [0, 1]")
+	c.spawn = fs.String("spawn", formatSpawnRegions(params.Spawns), "place species in `regions` \"SPECIES COUNT X,Y WxH; ...\", e.g. \"fish 200 0,0 25x50; sharks 50 0,0 10x10\"")
+	c.islands = fs.Bool("gen-islands", false, "generate an archipelago land map from Perlin noise")
+	c.seaLevel = fs.Float64("sea-level", 0.7, "fraction of the generated map that is water")
+	c.islandScale = fs.Float64("island-scale", 16, "typical size of generated islands in cells")
+	c.islandSeed = fs.Int64("island-seed", 0, "seed of the generated map (0 = use the run seed)")
+	c.reefWidth = fs.Int("reef-width", 0, "grow reefs, where fish are safe from sharks, this many cells around the land")
+	c.seed = fs.Int64("seed", 0, "random seed (0 = derive from the clock)")
+	c.scheme = fs.String("scheme", schemeName(params.Scheme), "update order: raster, checkerboard, shuffle, phased (fish, then sharks) or priority (a random priority per creature)")
+	c.topology = fs.String("topology", topologyName(params.Bounded), "edges of the world: torus (wrap around) or bounded (walls)")
+	fs.Float64Var(&params.ImmigrateFish, "immigrate-fish", params.ImmigrateFish, "chance per chronon that an empty edge cell of a bounded world receives a fish")
+	fs.Float64Var(&params.ImmigrateSharks, "immigrate-sharks", params.ImmigrateSharks, "chance per chronon that an empty edge cell of a bounded world receives a shark")
+	fs.IntVar(&params.Aging.Maturity, "maturity", params.Aging.Maturity, "age before which creatures cannot breed")
+	fs.IntVar(&params.Aging.Old, "old-age", params.Aging.Old, "age from which creatures breed less and move slower (0 = never)")
+	fs.Float64Var(&params.Aging.Fertility, "old-fertility", params.Aging.Fertility, "breeding rate of old creatures relative to prime adults, in (0, 1]")
+	fs.Float64Var(&params.Aging.Speed, "old-speed", params.Aging.Speed, "share of chronons on which old creatures move, in (0, 1]")
+	fs.IntVar(&params.FishEnergy.Budget, "fish-energy", params.FishEnergy.Budget, "energy budget of a fish; fish starve when it runs out (0 = fish never starve)")
+	fs.IntVar(&params.FishEnergy.Move, "fish-move-cost", params.FishEnergy.Move, "energy a fish spends on a move")
+	fs.IntVar(&params.FishEnergy.Plankton, "plankton", params.FishEnergy.Plankton, "energy a fish grazes every chronon, up to its budget")
+	fs.IntVar(&params.Breeding.SharkEnergy, "shark-birth-cost", params.Breeding.SharkEnergy, "energy a shark pays for a litter; sharks without more energy than that do not breed")
+	fs.Float64Var(&params.Breeding.FishCooldown, "fish-cooldown", params.Breeding.FishCooldown, "multiplier of the breed time of fish after their first litter (1 = none)")
+	fs.IntVar(&params.Breeding.SharkBirth, "shark-birth-energy", params.Breeding.SharkBirth, "energy of a newborn shark (0 = a full stomach, -starve)")
+	c.litter = fs.String("shark-litter", litterPlaceName(params.Breeding.SharkLitter), "whether and where a shark that eats leaves a newborn: left (the cell it leaves), prey (the prey's cell, the shark staying) or swim (none; it breeds at its next move to an empty cell)")
+	fs.IntVar(&params.Breeding.FishBirth, "fish-birth-energy", params.Breeding.FishBirth, "energy of a newborn fish with a -fish-energy budget (0 = the full budget)")
+	fs.IntVar(&params.Eggs.Fish, "fish-egg-time", params.Eggs.Fish, "chronons a fish egg takes to hatch; sharks eat fish eggs (0 = newborns)")
+	fs.IntVar(&params.Eggs.Shark, "shark-egg-time", params.Eggs.Shark, "chronons a shark egg takes to hatch (0 = newborns)")
+	fs.BoolVar(&params.Sexes.Enabled, "sexes", params.Sexes.Enabled, "make creatures male or female; females only breed next to a male")
+	fs.Float64Var(&params.Sexes.MateBias, "mate-bias", params.Sexes.MateBias, "chance that a moving creature heads for the opposite sex, with -sexes")
+	fs.Float64Var(&params.Strategies.Mutation, "strategy-mutation", params.Strategies.Mutation, "chance that a newborn switches movement strategy (random, flee, pursue) from its parent's (0 = all random)")
+	c.species = fs.String("species", formatWebSpecies(params.Web.Species), "register `species` \"NAME CHAR COUNT BREED [STARVE]; ...\", e.g. \"krill k 600 2; orca O 10 20 12\"")
+	c.eats = fs.String("eats", params.Web.formatDiet(), "predation `matrix` \"PREDATOR PREY [GAIN]; ...\", e.g. \"fish krill 2; shark fish; orca shark 8\"")
+	c.moveWeights = fs.String("move-weights", formatMoveWeights(params.MoveWeights, &params.Web), "preferred `directions` \"[SPECIES:] DIRECTION=WEIGHT ...; ...\" of movement, e.g. \"east=2; shark: north=3\" (unlisted directions weigh 1)")
+	c.introduce = fs.String("introduce", formatIntroductions(params.Introductions, &params.Web), "release `creatures` \"CHRONON SPECIES COUNT X,Y WxH; ...\" at scheduled chronons, or with a compass AREA such as northeast for X,Y WxH")
+	fs.IntVar(&params.Ambush.Below, "ambush-below", params.Ambush.Below, "energy below which a shark may rest in ambush instead of swimming (0 = never)")
+	fs.Float64Var(&params.Ambush.Chance, "ambush-chance", params.Ambush.Chance, "probability that a shark below the ambush threshold rests for a chronon")
+	fs.Float64Var(&params.Ambush.Drain, "ambush-drain", params.Ambush.Drain, "probability that a resting shark still loses a unit of energy")
+	fs.Float64Var(&params.Mortality.Fish, "fish-mortality", params.Mortality.Fish, "probability per chronon that a fish dies of causes other than predation (0 = never)")
+	fs.Float64Var(&params.Mortality.Shark, "shark-mortality", params.Mortality.Shark, "probability per chronon that a shark dies of causes other than starvation (0 = never)")
+	fs.Float64Var(&params.Crowding.Fish, "fish-crowding", params.Crowding.Fish, "share of its breed time a fish waits longer per occupied neighbour beyond the tolerance (0 = none)")
+	fs.Float64Var(&params.Crowding.Shark, "shark-crowding", params.Crowding.Shark, "share of its breed time a shark waits longer per occupied neighbour beyond the tolerance (0 = none)")
+	fs.IntVar(&params.Crowding.Tolerance, "crowding-tolerance", params.Crowding.Tolerance, "occupied neighbours that do not slow breeding yet, with -fish-crowding or -shark-crowding")
+	fs.Float64Var(&params.SharkTerritory, "shark-territory", params.SharkTerritory, "weight with which a moving shark avoids free cells next to other sharks (0 = none)")
+	c.starving = fs.String("starve-order", starveOrderName(params.Starvation.Order), "when a shark out of energy dies: starve (before it hunts) or eat (only if it catches nothing that chronon)")
+	fs.IntVar(&params.Starvation.Meal, "shark-meal", params.Starvation.Meal, "energy a shark gains from a fish, up to -starve (0 = a full stomach)")
+	c.conflict = fs.String("conflicts", conflictPolicyName(params.Conflicts), "who gets a cell two creatures move to: first (first come), random or older; contests are recorded as conflict events")
+	fs.Float64Var(&params.Emigrate, "emigrate", params.Emigrate, "chance per chronon that a creature on an edge cell of a bounded world leaves it")
+	fs.IntVar(&params.Workers, "workers", params.Workers, "goroutines stepping the grid in parallel")
+	fs.IntVar(&params.TileSize, "tile", params.TileSize, "width/height of a parallel work tile")
+	fs.IntVar(&params.Window, "window", params.Window, "chronons in the rolling metrics sampling window")
+	c.dryRun = fs.Bool("dry-run", false, "print the resolved configuration and estimated memory, then exit")
+	return c
+}
 
-		// Stop if all life extinct
-		if fishCount == 0 && sharkCount == 0 {
-			fmt.Println("All life extinct!")
-			break
+/*!
+ * \brief Apply the parsed flags that need interpretation.
+ * \return The seed (derived from the clock if -seed is 0), or an error for a
+ *         bad scheme, preset, scenario, map, island or layout setting or
+ *         an out-of-range parameter.
+ */
+func (c *configFlags) resolve() (int64, error) {
+	// Precedence: command line, then environment, then config file, then scenario, then preset
+	c.explicit = map[string]bool{}
+	c.fs.Visit(func(f *flag.Flag) { c.explicit[f.Name] = true })
+	explicit := map[string]bool{}
+	for name := range c.explicit {
+		explicit[name] = true
+	}
+	c.sources = map[string]string{}
+	claim := func(values map[string]string, source string) {
+		for name := range values {
+			if !explicit[name] {
+				explicit[name] = true
+				c.sources[name] = source
+			}
+		}
+	}
+
+	c.fromEnv = map[string]bool{}
+	if !c.ignoreEnv {
+		env := envFlagValues(c.fs)
+		for name, v := range env {
+			if explicit[name] {
+				continue
+			}
+			if err := c.fs.Set(name, v); err != nil {
+				return 0, fmt.Errorf("%s=%q: %v", envName(name), v, err)
+			}
+			c.fromEnv[name] = true
+		}
+		claim(env, "environment")
+	}
+
+	if *c.config != "" {
+		values, err := readFlagFile(*c.config)
+		if err != nil {
+			return 0, err
+		}
+		if err := applyFlagValues(c.fs, values, explicit, *c.config); err != nil {
+			return 0, err
+		}
+		c.fileValues = values
+		claim(values, "config "+*c.config)
+	}
+	if *c.scenario != "" {
+		sc, err := loadScenario(*c.scenario)
+		if err != nil {
+			return 0, err
+		}
+		if err := applyFlagValues(c.fs, sc.Flags, explicit, *c.scenario); err != nil {
+			return 0, err
+		}
+		claim(sc.Flags, "scenario "+*c.scenario)
+		c.params.Land, c.params.Reef, c.params.Layout = sc.Land, sc.Reef, sc.Layout
+		c.params.Pollution.Map = sc.Pollution
+		c.description = sc.Description
+	}
+	if *c.preset != "" {
+		if err := applyPreset(c.fs, *c.preset, explicit); err != nil {
+			return 0, err
 		}
+		claim(presets[*c.preset].Flags, "preset "+*c.preset)
+	}
+
+	var err error
+	if c.params.Scheme, err = parseUpdateScheme(*c.scheme); err != nil {
+		return 0, err
+	}
+	if c.params.Bounded, err = parseTopology(*c.topology); err != nil {
+		return 0, err
+	}
+	if c.params.RandomState, err = parseInitState(*c.state); err != nil {
+		return 0, err
+	}
+	if c.params.Breeding.SharkLitter, err = parseLitterPlace(*c.litter); err != nil {
+		return 0, err
+	}
+	if c.params.Starvation.Order, err = parseStarveOrder(*c.starving); err != nil {
+		return 0, err
+	}
+	if c.params.Conflicts, err = parseConflictPolicy(*c.conflict); err != nil {
+		return 0, err
+	}
+	seed := *c.seed
+	if seed == 0 {
+		seed = time.Now().UnixNano()
+	}
 
-		time.Sleep(100 * time.Millisecond)
+	if err := checkParams(*c.params); err != nil {
+		return 0, err
+	}
+	if *c.mapFile != "" && *c.islands {
+		return 0, errors.New("-map and -gen-islands are mutually exclusive")
+	}
+	if *c.mapFile != "" {
+		if c.params.Land, _, err = readGridFile(*c.mapFile, parseLandMap); err != nil {
+			return 0, err
+		}
+		if c.params.Reef, _, err = readGridFile(*c.mapFile, parseReefMap); err != nil {
+			return 0, err
+		}
+		if c.params.Pollution.Map, _, err = readGridFile(*c.mapFile, parsePollutionMap); err != nil {
+			return 0, err
+		}
+	}
+	if *c.islands {
+		if *c.seaLevel < 0 || *c.seaLevel > 1 || *c.islandScale <= 0 {
+			return 0, errors.New("-sea-level must be in [0, 1] and -island-scale positive")
+		}
+		islandSeed := *c.islandSeed
+		if islandSeed == 0 {
+			islandSeed = seed
+		}
+		c.params.Land = generateIslands(c.params.GridSize, islandSeed, *c.islandScale, *c.seaLevel)
+		c.params.Reef, c.params.Pollution.Map = nil, nil
+	}
+	if err := resolveReefs(c.params, *c.reefWidth); err != nil {
+		return 0, err
+	}
+	if c.params.Tide.Width > 0 && c.params.Land == nil {
+		return 0, errors.New("-tide-width needs land for the tides to expose (-map or -gen-islands)")
+	}
+	if *c.layoutFile != "" {
+		if c.params.Layout, _, err = readGridFile(*c.layoutFile, parseLayout); err != nil {
+			return 0, err
+		}
+	}
+	if err := checkInitPattern(c.params.InitPattern); err != nil {
+		return 0, err
+	}
+	if c.params.Pollution.Sources, err = parsePollutionSources(*c.pollution); err != nil {
+		return 0, err
+	}
+	if err := c.params.Pollution.check(c.params.GridSize); err != nil {
+		return 0, err
+	}
+	if c.params.Web.Species, err = parseWebSpecies(*c.species); err != nil {
+		return 0, err
+	}
+	if c.params.Web.Diet, err = parseDiet(*c.eats, c.params.Web.Species); err != nil {
+		return 0, err
+	}
+	if err := c.params.Web.check(); err != nil {
+		return 0, err
+	}
+	if c.params.Introductions, err = parseIntroductions(*c.introduce, c.params.GridSize, c.params.Web.Species); err != nil {
+		return 0, err
+	}
+	if err := checkIntroductions(*c.params); err != nil {
+		return 0, err
+	}
+	if c.params.MoveWeights, err = parseMoveWeights(*c.moveWeights, c.params.Web.Species); err != nil {
+		return 0, err
 	}
+	if err := checkMoveWeights(*c.params); err != nil {
+		return 0, err
+	}
+	if c.params.Spawns, err = parseSpawnRegions(*c.spawn); err != nil {
+		return 0, err
+	}
+	if c.params.Layout == nil {
+		if err := checkSpawnRegions(c.params); err != nil {
+			return 0, err
+		}
+	}
+	if err := checkTerrain(c.params); err != nil {
+		return 0, err
+	}
+	return seed, nil
 }
 
 /*!
- * \brief Print the current state of the world grid.
- * \param world Pointer to the World to print.
+ * \brief Upper bounds of the parameters that size allocations.
  *
- * Symbols:
- * - '.' = empty cell
- * - 'F' = fish
- * - 'S' = shark
- */
-func printWorld(world *World) {
-	for y := 0; y < world.Size; y++ {
-		for x := 0; x < world.Size; x++ {
-			c := world.Grid[x][y]
-			if c == nil {
-				fmt.Print(". ")
-			} else if c.Species == Fish {
-				fmt.Print("F ")
-			} else {
-				fmt.Print("S ")
-			}
+ * Settings increasingly come from files other people wrote; without the
+ * bounds a typo or a malicious scenario would exhaust memory instead of
+ * failing with a message.
+ */
+const (
+	maxGridSize = 1 << 15 ///< Largest grid width (a billion cells)
+	maxWorkers  = 1 << 10 ///< Most worker goroutines
+	maxWindow   = 1 << 20 ///< Longest rolling metrics window
+)
+
+/*!
+ * \brief Check that the numeric parameters are in range.
+ * \param params Resolved parameters.
+ * \return An error naming the first parameter out of range.
+ */
+func checkParams(params Config) error {
+	if params.GridSize < 1 || params.GridSize > maxGridSize {
+		return fmt.Errorf("-grid must be between 1 and %d, not %d", maxGridSize, params.GridSize)
+	}
+	for _, p := range tunableParams {
+		if v := *p.Field(&params); v < p.Min {
+			return fmt.Errorf("-%s must be at least %d, not %d", p.Name, p.Min, v)
 		}
-		fmt.Println()
 	}
-	fmt.Println()
+	if params.Workers < 1 || params.Workers > maxWorkers {
+		return fmt.Errorf("-workers must be between 1 and %d, not %d", maxWorkers, params.Workers)
+	}
+	if params.Window > maxWindow {
+		return fmt.Errorf("-window must be at most %d, not %d", maxWindow, params.Window)
+	}
+	if err := params.Aging.check(); err != nil {
+		return err
+	}
+	if err := params.FishEnergy.check(); err != nil {
+		return err
+	}
+	if err := params.Breeding.check(params.Starve, params.FishEnergy.Budget); err != nil {
+		return err
+	}
+	if err := params.Ambush.check(); err != nil {
+		return err
+	}
+	if err := params.Mortality.check(); err != nil {
+		return err
+	}
+	if err := params.Crowding.check(); err != nil {
+		return err
+	}
+	if err := checkTerritory(params.SharkTerritory); err != nil {
+		return err
+	}
+	if err := params.Starvation.check(params.Starve); err != nil {
+		return err
+	}
+	if err := params.Pollution.check(params.GridSize); err != nil {
+		return err
+	}
+	if err := params.Climate.check(); err != nil {
+		return err
+	}
+	if err := params.DayNight.check(); err != nil {
+		return err
+	}
+	if err := params.Tide.check(); err != nil {
+		return err
+	}
+	if err := params.Whales.check(params.GridSize); err != nil {
+		return err
+	}
+	if err := params.Eggs.check(); err != nil {
+		return err
+	}
+	if err := params.Sexes.check(); err != nil {
+		return err
+	}
+	if err := params.Strategies.check(); err != nil {
+		return err
+	}
+	if err := params.Web.check(); err != nil {
+		return err
+	}
+	if err := checkIntroductions(params); err != nil {
+		return err
+	}
+	if err := checkMoveWeights(params); err != nil {
+		return err
+	}
+	return checkExchange(params)
+}
+
+/*!
+ * \brief Process exit codes, so scripts orchestrating many runs can branch on the outcome.
+ */
+const (
+	exitOK            = 0 ///< Completed (for run and replay: both species alive at the end)
+	exitFailure       = 1 ///< Runtime failure, e.g. an output file could not be written
+	exitExtinct       = 2 ///< All life died out
+	exitSharksExtinct = 3 ///< The sharks died out, the fish survived
+	exitConfig        = 4 ///< Bad command line, settings or input files
+	exitFishExtinct   = 5 ///< The fish died out, the sharks survived
+)
+
+/*!
+ * \brief Exit code for a failed flag parse.
+ * \param err Error returned by FlagSet.Parse.
+ * \return exitOK after -help, exitConfig otherwise.
+ */
+func flagExit(err error) int {
+	if errors.Is(err, flag.ErrHelp) {
+		return exitOK
+	}
+	return exitConfig
+}
+
+/*!
+ * \brief A subcommand selected by the first command-line argument.
+ */
+type command struct {
+	Run     func(args []string) int ///< Entry point, given the arguments after the command name
+	Usage   string                  ///< Arguments shown in the usage line
+	Summary string                  ///< One-line description shown in the command list
+}
+
+/*!
+ * \brief Subcommands by name.
+ *
+ * Filled in by init, since the commands' help text refers back to this table.
+ */
+var commands map[string]command
+
+func init() {
+	commands = map[string]command{
+		"run":       {runCommand, "[flags]", "run one simulation with a renderer and output sinks (the default)"},
+		"scaling":   {scalingCommand, "[flags]", "measure chronons per second across grid sizes and densities"},
+		"sweep":     {sweepCommand, "-replicates R | -sensitivity oat|lhs [flags]", "run replicates or a sensitivity analysis headless"},
+		"replay":    {replayCommand, "[flags] FILE.wator|FILE.frames", "re-run a run recorded with run -record, or play a -frames log"},
+		"analyze":   {analyzeCommand, "[flags] FILE.csv", "summarise a statistics CSV written with -csv"},
+		"serve":     {serveCommand, "[flags]", "run a simulation and serve it over HTTP"},
+		"bifurcate": {bifurcateCommand, "[flags]", "scan one parameter and record the long-run population ranges"},
+		"tune":      {tuneCommand, "[flags]", "search for configurations where both species coexist"},
+		"evolve":    {evolveCommand, "[flags]", "evolve parameter sets with a genetic algorithm"},
+		"pack":      {packCommand, "[flags]", "write the given settings to a .wator scenario archive"},
+		"presets":   {presetsCommand, "", "list the built-in presets"},
+		"validate":  {validateCommand, "[flags]", "compare statistics of the presets with stored reference distributions"},
+		"multi":     {multiCommand, "[flags]", "run several simulations side by side on a live dashboard"},
+		"compare":   {compareCommand, "-b NAME=VALUE [flags]", "run two simulations differing in one parameter in lockstep and report when they diverge"},
+		"intervene": {interveneCommand, "-do ACTIONS [flags]", "apply an intervention at a chronon and compare the outcome with a control run"},
+		"fork":      {forkCommand, "[flags] CHECKPOINT", "continue a checkpoint along several branches with different seeds or parameters"},
+		"schema":    {schemaCommand, "[flags]", "print the JSON Schema of the -config files of a command"},
+	}
+}
+
+/*!
+ * \brief Print the list of subcommands.
+ * \param out Destination of the usage text.
+ */
+func writeUsage(out io.Writer) {
+	names := make([]string, 0, len(commands))
+	for name := range commands {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	fmt.Fprintln(out, "usage: wator <command> [flags]")
+	fmt.Fprintln(out, "\nCommands:")
+	for _, name := range names {
+		fmt.Fprintf(out, "  %-10s %s\n", name, commands[name].Summary)
+	}
+	fmt.Fprintln(out, "\nWithout a command, run is assumed. Use \"wator <command> -help\" for a command's flags.")
+}
+
+/*!
+ * \brief Called with every flag set newCommandFlags creates, when set.
+ *
+ * The schema subcommand uses it to collect the flags of another command
+ * by running that command with -help.
+ */
+var commandFlagsHook func(fs *flag.FlagSet)
+
+/*!
+ * \brief Create the flag set of a subcommand, with help text from the command table.
+ * \param name Name of the subcommand.
+ * \return The flag set; Parse reports errors and -help after printing the usage.
+ */
+func newCommandFlags(name string) *flag.FlagSet {
+	fs := flag.NewFlagSet(name, flag.ContinueOnError)
+	if commandFlagsHook != nil {
+		commandFlagsHook(fs)
+	}
+	fs.Usage = func() {
+		cmd := commands[name]
+		fmt.Fprintf(fs.Output(), "usage: wator %s %s\n\n%s.\n", name, cmd.Usage, strings.ToUpper(cmd.Summary[:1])+cmd.Summary[1:])
+		fmt.Fprintln(fs.Output(), "\nFlags:")
+		fs.PrintDefaults()
+	}
+	return fs
+}
+
+/*!
+ * \brief Register -chronons, keeping an older name of the flag as an alias.
+ * \param fs The flag set.
+ * \param alias The older name, still accepted.
+ * \param value Default number of chronons.
+ * \param usage Help text of -chronons.
+ * \return The number of chronons, set by either flag.
+ */
+func chrononsFlag(fs *flag.FlagSet, alias string, value int, usage string) *int {
+	p := fs.Int("chronons", value, usage)
+	fs.IntVar(p, alias, value, "same as -chronons")
+	return p
+}
+
+/*!
+ * \brief Main function: dispatch to the subcommand named by the first argument.
+ *
+ * Arguments that start with a flag (or none at all) select the run
+ * command, so "wator -grid 80" still starts a simulation.
+ */
+func main() {
+	args := os.Args[1:]
+	name := "run"
+	if len(args) > 0 && !strings.HasPrefix(args[0], "-") {
+		name, args = args[0], args[1:]
+	}
+
+	// "wator -help" lists the commands, "wator help run" shows the flags of run
+	if (len(os.Args) == 2 && isHelpFlag(os.Args[1])) || (name == "help" && len(args) == 0) {
+		writeUsage(os.Stdout)
+		return
+	}
+	if name == "help" {
+		name, args = args[0], []string{"-help"}
+	}
+
+	cmd, ok := commands[name]
+	if !ok {
+		fmt.Fprintf(os.Stderr, "unknown command %q\n\n", name)
+		writeUsage(os.Stderr)
+		os.Exit(exitConfig)
+	}
+	os.Exit(cmd.Run(args))
+}
+
+/*!
+ * \brief Check whether an argument asks for help.
+ * \param arg A command-line argument.
+ * \return True for -h, -help, --h and --help.
+ */
+func isHelpFlag(arg string) bool {
+	switch arg {
+	case "-h", "-help", "--h", "--help":
+		return true
+	}
+	return false
 }
 
 /*!
@@ -135,213 +932,635 @@ func printWorld(world *World) {
  * \return Pointer to the newly created World.
  */
 func createWorld(size int) *World {
-	grid := make([][]*Creature, size)
+	cells := make([]Creature, size*size)
+	grid := make([][]Creature, size)
 	for i := range grid {
-		grid[i] = make([]*Creature, size)
+		grid[i] = cells[i*size : (i+1)*size : (i+1)*size]
 	}
+	shared := new(bool)
 	return &World{
-		Grid: grid,
-		Size: size,
+		Grid:      grid,
+		Size:      size,
+		ids:       &idSource{},
+		creatures: newCellMask(size, shared),
+		fish:      newCellMask(size, shared),
+		moved:     newCellMask(size, shared),
+		shared:    shared,
+		mu:        &sync.Mutex{},
 	}
 }
 
 /*!
- * \brief Initialize the world with sharks and fish placed randomly.
- * \param world Pointer to the World to initialize.
+ * \brief Empty a world for reuse.
+ *
+ * The events are dropped rather than truncated, since frames of the
+ * chronon that produced them may still refer to them.
+ */
+func (w *World) reset() {
+	// Only strips that held a creature need clearing
+	for x, column := range w.Grid {
+		for i := 0; i < w.creatures.stride; i++ {
+			if w.creatures.load(x*w.creatures.stride+i) != 0 {
+				clear(column[i<<6 : min((i+1)<<6, w.Size)])
+			}
+		}
+	}
+	w.creatures.reset()
+	w.fish.reset()
+	w.moved.reset()
+	w.Events = nil
+}
+
+/*!
+ * \brief Candidate cells drawn from the placement pattern before falling back to uniform placement.
+ */
+const placementAttempts = 1000
+
+/*!
+ * \brief Check whether a cell is land.
+ * \param x X coordinate.
+ * \param y Y coordinate.
+ * \return True if creatures cannot enter the cell.
+ */
+func (w *World) isLand(x, y int) bool {
+	return w.land != nil && w.land[y*w.Size+x]
+}
+
+/*!
+ * \brief Place a newly spawned creature.
+ * \param world Pointer to the World being initialized.
+ * \param species Fish, Shark or a registered species.
+ * \param x X coordinate.
+ * \param y Y coordinate.
  * \param params Simulation parameters.
+ * \param rng Random source drawing the sex, with sexes, and the state, with a random initial state.
  */
-func initializeWorld(world *World, params struct {
-	NumShark, NumFish, FishBreed, SharkBreed, Starve, GridSize int
-}) {
-	// Place sharks
-	for i := 0; i < params.NumShark; i++ {
-		for {
-			x, y := rand.Intn(world.Size), rand.Intn(world.Size)
-			if world.Grid[x][y] == nil {
-				world.Grid[x][y] = &Creature{
-					Species:   Shark,
-					Energy:    params.Starve,
-					LastBreed: 0,
-				}
+func spawnCreature(world *World, species Species, x, y int, params Config, rng *rand.Rand) {
+	c := Creature{
+		ID:        world.ids.next(),
+		Species:   species,
+		LastBreed: 0,
+		Female:    params.Sexes.female(rng),
+	}
+	if r := params.Web.registered(species); r != nil {
+		c.Energy = int32(r.Starve)
+	} else if species == Shark {
+		c.Energy = int32(params.Starve)
+	} else {
+		c.Energy = int32(params.FishEnergy.Budget)
+	}
+	if params.RandomState {
+		randomizeState(&c, params, rng)
+	}
+	world.put(x, y, &c)
+	world.record(Event{Kind: Spawn, Species: species, ID: c.ID, X: x, Y: y})
+}
+
+/*!
+ * \brief Place creatures of a species at random free water cells, predators off the reefs.
+ * \param world Pointer to the World being initialized.
+ * \param species Fish, Shark or a registered species.
+ * \param n Number of creatures.
+ * \param candidate Draws the cells to try.
+ * \param params Simulation parameters.
+ * \param rng Random source used for placement.
+ * \return An error if fewer than n cells are free for the species.
+ */
+func placeRandomly(world *World, species Species, n int, candidate placement, params Config, rng *rand.Rand) error {
+	barred := world.barredFromReef(species)
+	free := 0
+	for x := 0; x < world.Size; x++ {
+		for y := 0; y < world.Size; y++ {
+			if !world.occupied(x, y) && !world.isLand(x, y) && !(barred && world.isReef(x, y)) {
+				free++
+			}
+		}
+	}
+	if n > free {
+		return fmt.Errorf("%d creatures of species %s do not fit in the %d free cells left", n, species, free)
+	}
+	for i := 0; i < n; i++ {
+		for attempt := 0; ; attempt++ {
+			// A full pattern region spills over to the whole grid
+			x, y := rng.Intn(world.Size), rng.Intn(world.Size)
+			if attempt < placementAttempts {
+				x, y = candidate(rng, species)
+			}
+			if !world.occupied(x, y) && !world.isLand(x, y) && !(barred && world.isReef(x, y)) {
+				spawnCreature(world, species, x, y, params, rng)
 				break
 			}
 		}
 	}
+	return nil
+}
 
-	// Place fish
-	for i := 0; i < params.NumFish; i++ {
-		for {
-			x, y := rand.Intn(world.Size), rand.Intn(world.Size)
-			if world.Grid[x][y] == nil {
-				world.Grid[x][y] = &Creature{
-					Species:   Fish,
-					LastBreed: 0,
+/*!
+ * \brief Initialize the world with sharks and fish.
+ * \param world Pointer to the World to initialize.
+ * \param params Simulation parameters.
+ * \param rng Random source used for placement.
+ *
+ * Creatures are placed as given by params.Layout, or at random water
+ * cells if there is no layout: a species with spawn regions in them, in
+ * the order given, the others drawn from params.InitPattern. The
+ * registered species of a food web are then placed uniformly, layout or not.
+ *
+ * \return An error if the creatures of a species do not fit in the cells
+ *         left free for them.
+ */
+func initializeWorld(world *World, params Config, rng *rand.Rand) error {
+	world.land = params.Land
+	world.reef = params.Reef
+	world.pollution = seedPollution(params)
+	world.polluting = params.Pollution
+	world.climate = params.Climate
+	world.cycle = params.DayNight
+	world.tide = params.Tide
+	world.tidal = tidalZone(params)
+	world.bounded = params.Bounded
+	world.web = params.Web.table()
+
+	if params.Layout != nil {
+		for y := 0; y < world.Size; y++ {
+			for x := 0; x < world.Size; x++ {
+				if s := params.Layout[y*world.Size+x]; s == Fish || s == Shark {
+					spawnCreature(world, s, x, y, params, rng)
 				}
-				break
 			}
 		}
+	} else {
+		pattern := initPatterns[params.InitPattern]
+		if pattern == nil {
+			pattern = uniformPlacement
+		}
+		candidate := pattern(world.Size, rng)
+
+		for _, r := range params.Spawns {
+			if err := placeRandomly(world, r.Species, r.Count, r.placement(), params, rng); err != nil {
+				return err
+			}
+		}
+		// Place sharks, then fish
+		for _, s := range []Species{Shark, Fish} {
+			if hasSpawnRegions(params.Spawns, s) {
+				continue
+			}
+			n := params.NumShark
+			if s == Fish {
+				n = params.NumFish
+			}
+			if err := placeRandomly(world, s, n, candidate, params, rng); err != nil {
+				return err
+			}
+		}
+	}
+	for i, r := range params.Web.Species {
+		if err := placeRandomly(world, firstWebSpecies+Species(i), r.Count, uniformPlacement(world.Size, rng), params, rng); err != nil {
+			return err
+		}
 	}
+	placeWhales(world, params.Whales, rng)
 
 	world.FishBreed = params.FishBreed
 	world.SharkBreed = params.SharkBreed
 	world.Starve = params.Starve
+	world.aging = params.Aging
+	world.fishEnergy = params.FishEnergy
+	world.breeding = params.Breeding
+	world.ambush = params.Ambush
+	world.mortality = params.Mortality
+	world.crowding = params.Crowding
+	world.territory = params.SharkTerritory
+	world.starvation = params.Starvation
+	world.conflicts = params.Conflicts
+	world.eggs = params.Eggs
+	world.sexes = params.Sexes
+	world.strategies = params.Strategies
+	world.drift = moveTableOf(params.MoveWeights)
+	return nil
+}
+
+/*!
+ * \brief Parse the name of an update scheme.
+ * \param name Scheme name as given on the command line.
+ * \return The matching UpdateScheme, or an error if the name is unknown.
+ */
+func parseUpdateScheme(name string) (UpdateScheme, error) {
+	switch name {
+	case "raster":
+		return Raster, nil
+	case "checkerboard":
+		return Checkerboard, nil
+	case "shuffle":
+		return Shuffle, nil
+	case "phased":
+		return Phased, nil
+	case "priority":
+		return Priority, nil
+	}
+	return Raster, fmt.Errorf("unknown update scheme %q (want raster, checkerboard, shuffle, phased or priority)", name)
 }
 
 /*!
  * \brief Process one chronon (time step) for the world.
  * \param oldWorld Current state of the world.
  * \param params Simulation parameters.
+ * \param rng Random source driving movement choices.
  * \return Pointer to the new World state after processing.
+ *
+ * With the Checkerboard scheme the grid is coloured like a chess board
+ * and all cells of one colour are updated before any cell of the other.
+ * Every neighbour of a cell has the opposite colour, so no creature moves
+ * onto a cell whose occupant is updated in the same pass. On a grid of odd
+ * size the wrap-around seam joins two cells of the same colour. The
+ * schemes that order the creatures themselves are in order.go.
+ */
+func processChronon(oldWorld *World, params Config, rng *rand.Rand) *World {
+	return processChrononInto(oldWorld, createWorld(oldWorld.Size), params, rng)
+}
+
+/*!
+ * \brief Process one chronon into a given, empty world.
+ * \param oldWorld Current state of the world.
+ * \param newWorld Empty world of the same size, as from createWorld or reset.
+ * \param params Simulation parameters.
+ * \param rng Random source driving movement choices.
+ * \return newWorld, holding the state after the chronon.
  */
-func processChronon(oldWorld *World, params struct {
-	NumShark, NumFish, FishBreed, SharkBreed, Starve, GridSize int
-}) *World {
-	newWorld := createWorld(oldWorld.Size)
+func processChrononInto(oldWorld, newWorld *World, params Config, rng *rand.Rand) *World {
 	newWorld.FishBreed = oldWorld.FishBreed
 	newWorld.SharkBreed = oldWorld.SharkBreed
 	newWorld.Starve = oldWorld.Starve
+	newWorld.aging = oldWorld.aging
+	newWorld.fishEnergy = oldWorld.fishEnergy
+	newWorld.breeding = oldWorld.breeding
+	newWorld.ambush = oldWorld.ambush
+	newWorld.mortality = oldWorld.mortality
+	newWorld.crowding = oldWorld.crowding
+	newWorld.territory = oldWorld.territory
+	newWorld.starvation = oldWorld.starvation
+	newWorld.conflicts = oldWorld.conflicts
+	newWorld.eggs = oldWorld.eggs
+	newWorld.sexes = oldWorld.sexes
+	newWorld.strategies = oldWorld.strategies
+	newWorld.drift = oldWorld.drift
+	newWorld.web = oldWorld.web
+	newWorld.polluting = oldWorld.polluting
+	newWorld.climate = oldWorld.climate
+	newWorld.cycle = oldWorld.cycle
+	newWorld.tide = oldWorld.tide
+	newWorld.tidal = oldWorld.tidal
+	moveWhales(oldWorld, newWorld, rng)
+	newWorld.elapsed = oldWorld.elapsed + 1
+	newWorld.ids = oldWorld.ids
+	newWorld.land = oldWorld.land
+	newWorld.reef = oldWorld.reef
+	newWorld.bounded = oldWorld.bounded
+	*newWorld.shared = params.Workers > 1
 
-	for x := 0; x < oldWorld.Size; x++ {
-		for y := 0; y < oldWorld.Size; y++ {
-			creature := oldWorld.Grid[x][y]
-			if creature == nil {
-				continue
-			}
+	for pass := 0; pass < schemePasses(params.Scheme); pass++ {
+		if params.Workers > 1 {
+			processTiles(oldWorld, newWorld, params, pass, rng)
+			continue
+		}
+		if orderedScheme(params.Scheme) {
+			processOrdered(oldWorld, newWorld, params.Scheme, 0, oldWorld.Size, 0, oldWorld.Size, rng)
+			continue
+		}
+		for x := 0; x < oldWorld.Size; x++ {
+			processColumn(oldWorld, newWorld, params.Scheme, pass, x, 0, oldWorld.Size, rng)
+		}
+	}
+	*newWorld.shared = false
 
-			// Skip if already moved
-			if newWorld.Grid[x][y] != nil {
-				continue
-			}
+	if isOpen(params) {
+		exchangeAtEdges(newWorld, params, rng)
+	}
+	if params.Introductions != nil {
+		introduce(newWorld, params, rng)
+	}
+	if oldWorld.pollution != nil {
+		newWorld.pollution = spreadPollution(oldWorld, newWorld.pollution)
+	}
+	return newWorld
+}
 
-			creature.Age++
-			creature.LastBreed++
+/*!
+ * \brief Number of passes over the grid an update scheme needs per chronon.
+ * \param scheme The update scheme.
+ * \return 2 for Checkerboard and Phased, 1 otherwise.
+ */
+func schemePasses(scheme UpdateScheme) int {
+	if scheme == Checkerboard || scheme == Phased {
+		return 2
+	}
+	return 1
+}
 
-			switch creature.Species {
-			case Fish:
-				processFish(oldWorld, newWorld, x, y, creature)
-			case Shark:
-				processShark(oldWorld, newWorld, x, y, creature)
+/*!
+ * \brief Check whether a cell is updated during the given pass.
+ * \param w The world being stepped from.
+ * \param scheme The update scheme.
+ * \param x X position of the cell.
+ * \param y Y position of the cell.
+ * \param pass Index of the current pass.
+ * \return True if the cell belongs to the pass.
+ */
+func inPass(w *World, scheme UpdateScheme, x, y, pass int) bool {
+	switch scheme {
+	case Checkerboard:
+		return (x+y)%2 == pass
+	case Phased:
+		return w.fish.has(x, y) == (pass == 0)
+	}
+	return true
+}
+
+/*!
+ * \brief Update the creatures in part of a column that belong to the current pass.
+ * \param oldWorld Current world state.
+ * \param newWorld Next world state.
+ * \param scheme The update scheme.
+ * \param pass Index of the current scheme pass.
+ * \param x X position of the column.
+ * \param y0 First row.
+ * \param y1 Row after the last.
+ * \param rng Random source driving movement choices.
+ *
+ * The cells are visited in increasing y, as a plain loop would, but the
+ * occupancy masks of oldWorld skip the empty ones a word at a time.
+ */
+func processColumn(oldWorld, newWorld *World, scheme UpdateScheme, pass, x, y0, y1 int, rng *rand.Rand) {
+	for i := y0 >> 6; i<<6 < y1; i++ {
+		for word := oldWorld.creatures.columnWord(x, i, y0, y1); word != 0; word &= word - 1 {
+			y := i<<6 + bits.TrailingZeros64(word)
+			if inPass(oldWorld, scheme, x, y, pass) {
+				processCell(oldWorld, newWorld, x, y, rng)
 			}
 		}
 	}
+}
 
-	return newWorld
+/*!
+ * \brief Update the creature (if any) that occupied a cell last chronon.
+ * \param oldWorld Current world state.
+ * \param newWorld Next world state.
+ * \param x X position of the cell.
+ * \param y Y position of the cell.
+ * \param rng Random source driving movement choices.
+ */
+func processCell(oldWorld, newWorld *World, x, y int, rng *rand.Rand) {
+	creature := &oldWorld.Grid[x][y]
+	if creature.Species == Empty || creature.Species == Whale {
+		return
+	}
+
+	// Skip if already moved
+	if newWorld.occupied(x, y) {
+		return
+	}
+
+	newWorld.moved.set(x, y)
+	creature.Age++
+	if creature.Hatch > 0 {
+		incubate(newWorld, x, y, creature)
+		return
+	}
+	creature.LastBreed++
+
+	switch creature.Species {
+	case Fish:
+		processFish(oldWorld, newWorld, x, y, creature, rng)
+	case Shark:
+		processShark(oldWorld, newWorld, x, y, creature, rng)
+	default:
+		processForager(oldWorld, newWorld, x, y, creature, rng)
+	}
 }
 
 /*!
- * \brief Process movement and reproduction of a fish.
+ * \brief Process background mortality, grazing, movement, starvation and reproduction of a fish.
  * \param oldWorld Current world state.
  * \param newWorld Next world state.
  * \param x X position of the fish.
  * \param y Y position of the fish.
- * \param fish Pointer to the fish Creature.
+ * \param fish The fish, in oldWorld's grid; updated in place, then copied to newWorld.
+ * \param rng Random source driving movement choices.
  */
-func processFish(oldWorld, newWorld *World, x, y int, fish *Creature) {
-	adjacent := getAdjacentPositions(x, y, oldWorld.Size)
-
-	emptyCells := [][2]int{}
-	for _, pos := range adjacent {
-		if oldWorld.Grid[pos[0]][pos[1]] == nil &&
-			newWorld.Grid[pos[0]][pos[1]] == nil {
-			emptyCells = append(emptyCells, pos)
-		}
+func processFish(oldWorld, newWorld *World, x, y int, fish *Creature, rng *rand.Rand) {
+	if oldWorld.mortality.dies(fish, rng) {
+		newWorld.record(deathEvent(Died, fish, x, y))
+		return
 	}
-
-	if len(emptyCells) == 0 {
-		newWorld.Grid[x][y] = fish
+	oldWorld.fishEnergy.graze(fish)
+	if !oldWorld.aging.moves(fish) || oldWorld.exposed(x, y) || !oldWorld.cycle.swims(oldWorld.elapsed, rng) {
+		newWorld.put(x, y, fish)
 		return
 	}
+	adjacent := getAdjacentPositions(x, y, oldWorld.Size, oldWorld.bounded)
 
-	newPos := emptyCells[rand.Intn(len(emptyCells))]
+	// Fish with prey in a food web eat before they look for free water
+	var newPos [2]int
+	if prey := preyAround(oldWorld, newWorld, fish, adjacent) &^ oldWorld.sheltered(adjacent); prey != 0 {
+		newPos = adjacent[nthBit(prey, rng.Intn(bits.OnesCount(prey)))]
+		eat(oldWorld, newWorld, newPos, fish)
+	} else {
+		var emptyCells [4][2]int
+		empty := 0
+		for _, pos := range adjacent {
+			if oldWorld.Grid[pos[0]][pos[1]].Species == Empty && oldWorld.claimable(newWorld, pos) &&
+				!oldWorld.isDry(pos[0], pos[1]) {
+				emptyCells[empty] = pos
+				empty++
+			}
+		}
+
+		if empty == 0 {
+			newWorld.put(x, y, fish)
+			return
+		}
+		if !oldWorld.fishEnergy.spend(fish) {
+			newWorld.record(deathEvent(Starved, fish, x, y))
+			return
+		}
+		var moved bool
+		if newPos, moved = oldWorld.pickClaim(newWorld, x, y, fish, &emptyCells, empty, rng); !moved {
+			newWorld.put(x, y, fish)
+			return
+		}
+	}
 	newX, newY := newPos[0], newPos[1]
 
-	if fish.LastBreed >= oldWorld.FishBreed {
-		newWorld.Grid[x][y] = &Creature{
+	breed := oldWorld.warmedBreed(Fish, y, oldWorld.breeding.fishBreed(fish, oldWorld.FishBreed))
+	breed = oldWorld.crowdedBreed(Fish, x, y, breed)
+	if oldWorld.aging.due(fish, oldWorld.pollutedBreed(x, y, breed)) && oldWorld.mated(x, y, fish) {
+		baby := Creature{
+			ID:        newWorld.ids.next(),
 			Species:   Fish,
+			Energy:    oldWorld.breeding.fishNewborn(&oldWorld.fishEnergy),
 			LastBreed: 0,
+			Hatch:     oldWorld.eggs.hatch(Fish),
+			Female:    oldWorld.sexes.female(rng),
+			Strategy:  oldWorld.strategies.inherit(fish, rng),
+			Gen:       fish.Gen + 1,
 		}
-		newWorld.Grid[newX][newY] = fish
+		newWorld.put(x, y, &baby)
+		newWorld.record(Event{Kind: Birth, Species: Fish, ID: baby.ID, ParentID: fish.ID, X: x, Y: y})
 		fish.LastBreed = 0
-	} else {
-		newWorld.Grid[newX][newY] = fish
+		fish.Offspring++
 	}
+	newWorld.put(newX, newY, fish)
 }
 
 /*!
- * \brief Process movement, hunting, and reproduction of a shark.
+ * \brief Process background mortality, movement, hunting, and reproduction of a shark.
  * \param oldWorld Current world state.
  * \param newWorld Next world state.
  * \param x X position of the shark.
  * \param y Y position of the shark.
- * \param shark Pointer to the shark Creature.
+ * \param shark The shark, in oldWorld's grid; updated in place, then copied to newWorld.
+ * \param rng Random source driving movement choices.
  */
-func processShark(oldWorld, newWorld *World, x, y int, shark *Creature) {
-	shark.Energy--
-
-	if shark.Energy <= 0 {
+func processShark(oldWorld, newWorld *World, x, y int, shark *Creature, rng *rand.Rand) {
+	if oldWorld.mortality.dies(shark, rng) {
+		newWorld.record(deathEvent(Died, shark, x, y))
 		return
 	}
+	resting := oldWorld.ambush.rests(shark, rng)
+	shark.Energy -= oldWorld.ambush.cost(resting, rng) + oldWorld.pollutionDrain(x, y, rng)
 
-	adjacent := getAdjacentPositions(x, y, oldWorld.Size)
-
-	// Look for fish to eat
-	fishCells := [][2]int{}
-	for _, pos := range adjacent {
-		if oldWorld.Grid[pos[0]][pos[1]] != nil &&
-			oldWorld.Grid[pos[0]][pos[1]].Species == Fish &&
-			newWorld.Grid[pos[0]][pos[1]] == nil {
-			fishCells = append(fishCells, pos)
+	// Under the eat order a shark out of energy still hunts this turn
+	starving := shark.Energy <= 0
+	if starving && oldWorld.starvation.Order == StarveFirst {
+		newWorld.record(deathEvent(Starved, shark, x, y))
+		return
+	}
+	if !oldWorld.aging.moves(shark) || oldWorld.exposed(x, y) {
+		if starving {
+			newWorld.record(deathEvent(Starved, shark, x, y))
+			return
 		}
+		newWorld.put(x, y, shark)
+		return
 	}
 
-	if len(fishCells) > 0 {
-		newPos := fishCells[rand.Intn(len(fishCells))]
-		newX, newY := newPos[0], newPos[1]
+	adjacent := getAdjacentPositions(x, y, oldWorld.Size, oldWorld.bounded)
+	n := oldWorld.creatures.locate(adjacent)
 
-		shark.Energy = oldWorld.Starve
+	// Look for prey to eat, out of reach on a reef or stranded by the tide
+	var prey uint
+	if oldWorld.web == nil {
+		prey = fishAround(oldWorld, newWorld, &n)
+	} else {
+		prey = preyAround(oldWorld, newWorld, shark, adjacent)
+	}
+	prey &^= oldWorld.sheltered(adjacent)
+	if prey != 0 && !oldWorld.cycle.catches(oldWorld.elapsed, rng) {
+		prey = 0
+	}
+	if prey != 0 {
+		newPos := adjacent[nthBit(prey, rng.Intn(bits.OnesCount(prey)))]
+		newX, newY := newPos[0], newPos[1]
 
-		if shark.LastBreed >= oldWorld.SharkBreed {
-			newWorld.Grid[x][y] = &Creature{
-				Species:   Shark,
-				Energy:    oldWorld.Starve,
-				LastBreed: 0,
+		eat(oldWorld, newWorld, newPos, shark)
+		switch oldWorld.breeding.SharkLitter {
+		case LitterPrey:
+			// A breeding shark stays and its newborn takes the prey's cell
+			if breedShark(oldWorld, newWorld, x, y, newX, newY, shark, rng) {
+				newWorld.put(x, y, shark)
+			} else {
+				newWorld.put(newX, newY, shark)
 			}
-			newWorld.Grid[newX][newY] = shark
-			shark.LastBreed = 0
-		} else {
-			newWorld.Grid[newX][newY] = shark
+		case LitterSwim:
+			newWorld.put(newX, newY, shark)
+		default:
+			breedShark(oldWorld, newWorld, x, y, x, y, shark, rng)
+			newWorld.put(newX, newY, shark)
 		}
 		return
 	}
+	if starving {
+		newWorld.record(deathEvent(Starved, shark, x, y))
+		return
+	}
+	if resting {
+		newWorld.put(x, y, shark)
+		return
+	}
 
 	// Move to empty adjacent cell if no fish
-	emptyCells := [][2]int{}
+	var emptyCells [4][2]int
+	empty := 0
 	for _, pos := range adjacent {
-		if oldWorld.Grid[pos[0]][pos[1]] == nil &&
-			newWorld.Grid[pos[0]][pos[1]] == nil {
-			emptyCells = append(emptyCells, pos)
+		if oldWorld.Grid[pos[0]][pos[1]].Species == Empty && oldWorld.claimable(newWorld, pos) &&
+			!oldWorld.isDry(pos[0], pos[1]) && !oldWorld.isReef(pos[0], pos[1]) {
+			emptyCells[empty] = pos
+			empty++
 		}
 	}
 
-	if len(emptyCells) == 0 {
-		newWorld.Grid[x][y] = shark
+	if empty == 0 {
+		newWorld.put(x, y, shark)
 		return
 	}
 
-	newPos := emptyCells[rand.Intn(len(emptyCells))]
+	newPos, moved := oldWorld.pickClaim(newWorld, x, y, shark, &emptyCells, empty, rng)
+	if !moved {
+		newWorld.put(x, y, shark)
+		return
+	}
 	newX, newY := newPos[0], newPos[1]
+	breedShark(oldWorld, newWorld, x, y, x, y, shark, rng)
+	newWorld.put(newX, newY, shark)
+}
 
-	if shark.LastBreed >= oldWorld.SharkBreed {
-		newWorld.Grid[x][y] = &Creature{
-			Species:   Shark,
-			Energy:    oldWorld.Starve,
-			LastBreed: 0,
-		}
-		newWorld.Grid[newX][newY] = shark
-		shark.LastBreed = 0
-	} else {
-		newWorld.Grid[newX][newY] = shark
+/*!
+ * \brief Leave a newborn shark if a shark that moves or eats is due to breed.
+ * \param oldWorld Current world state.
+ * \param newWorld Next world state.
+ * \param x X position of the shark at the start of the chronon.
+ * \param y Y position of the shark at the start of the chronon.
+ * \param bx X position of the newborn: the cell the shark leaves, or its prey's.
+ * \param by Y position of the newborn.
+ * \param shark The shark; its breeding counters and energy are updated.
+ * \param rng Random source of the shark's cell.
+ * \return True if it bred.
+ */
+func breedShark(oldWorld, newWorld *World, x, y, bx, by int, shark *Creature, rng *rand.Rand) bool {
+	breed := oldWorld.crowdedBreed(Shark, x, y, oldWorld.warmedBreed(Shark, y, newWorld.SharkBreed))
+	if !newWorld.aging.due(shark, breed) || !newWorld.breeding.affords(shark) ||
+		!oldWorld.mated(x, y, shark) {
+		return false
+	}
+	baby := Creature{
+		ID:        newWorld.ids.next(),
+		Species:   Shark,
+		Energy:    newWorld.breeding.sharkNewborn(newWorld.Starve),
+		LastBreed: 0,
+		Hatch:     newWorld.eggs.hatch(Shark),
+		Female:    newWorld.sexes.female(rng),
+		Strategy:  newWorld.strategies.inherit(shark, rng),
+		Gen:       shark.Gen + 1,
 	}
+	newWorld.put(bx, by, &baby)
+	newWorld.record(Event{Kind: Birth, Species: Shark, ID: baby.ID, ParentID: shark.ID, X: bx, Y: by})
+	shark.LastBreed = 0
+	shark.Offspring++
+	shark.Energy -= int32(newWorld.breeding.SharkEnergy)
+	return true
+}
+
+/*!
+ * \brief Find the neighbours holding a fish, part way through a chronon.
+ * \param oldWorld Current world state.
+ * \param newWorld Next world state.
+ * \param n The neighbours, located in the masks.
+ * \return Bit i set if preyAt finds a fish at neighbour i.
+ */
+func fishAround(oldWorld, newWorld *World, n *neighbours) uint {
+	waiting := oldWorld.fish.around(n) &^ newWorld.creatures.around(n) &^ newWorld.moved.around(n)
+	return newWorld.fish.around(n) | waiting
 }
 
 /*!
@@ -349,14 +1568,42 @@ func processShark(oldWorld, newWorld *World, x, y int, shark *Creature) {
  * \param x X coordinate.
  * \param y Y coordinate.
  * \param size Grid size.
- * \return Slice of 4 [x,y] coordinates.
+ * \param bounded Whether the edges are walls rather than wrapping around.
+ * \return The 4 [x,y] coordinates. Past a wall the neighbour is the cell
+ *         itself, which its own creature occupies, so nothing moves there.
  */
-func getAdjacentPositions(x, y, size int) [][2]int {
-	return [][2]int{
-		{(x - 1 + size) % size, y}, // West
-		{(x + 1) % size, y},        // East
-		{x, (y - 1 + size) % size}, // North
-		{x, (y + 1) % size},        // South
+func getAdjacentPositions(x, y, size int, bounded bool) [4][2]int {
+	// Comparisons rather than %, which costs a division per neighbour
+	west, east, north, south := x-1, x+1, y-1, y+1
+	if west < 0 {
+		west = size - 1
+		if bounded {
+			west = x
+		}
+	}
+	if east == size {
+		east = 0
+		if bounded {
+			east = x
+		}
+	}
+	if north < 0 {
+		north = size - 1
+		if bounded {
+			north = y
+		}
+	}
+	if south == size {
+		south = 0
+		if bounded {
+			south = y
+		}
+	}
+	return [4][2]int{
+		{west, y},  // West
+		{east, y},  // East
+		{x, north}, // North
+		{x, south}, // South
 	}
 }
 
@@ -367,17 +1614,10 @@ func getAdjacentPositions(x, y, size int) [][2]int {
  * \return sharkCount Number of sharks.
  */
 func countPopulation(world *World) (int, int) {
-	fish, sharks := 0, 0
-	for x := 0; x < world.Size; x++ {
-		for y := 0; y < world.Size; y++ {
-			if world.Grid[x][y] != nil {
-				if world.Grid[x][y].Species == Fish {
-					fish++
-				} else {
-					sharks++
-				}
-			}
-		}
+	fish := world.fish.count()
+	others := 0
+	for _, n := range world.webPopulations() {
+		others += n
 	}
-	return fish, sharks
+	return fish, world.creatures.count() - fish - others - len(world.whales)*world.whaleSize*world.whaleSize
 }
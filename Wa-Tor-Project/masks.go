@@ -0,0 +1,244 @@
+/*!
+ * \file masks.go
+ * \brief Occupancy bitsets kept alongside the grid.
+ *
+ * Every world carries one bit per cell for creatures and one for fish,
+ * laid out column by column like the grid and updated with every write
+ * to it. A shark's "is there a fish next to me?" is answered for all four
+ * neighbours at once with a few bit operations, the scan over the grid
+ * skips empty cells a word at a time, and counting a species is a
+ * popcount rather than a pass over every creature.
+ *
+ * The creature mask of a world also records where there was any activity
+ * in the chronon that produced it: each word is a strip of 64 cells of a
+ * column. Passes over the grid (clearing a recycled world, taking a
+ * frame, dealing out parallel tiles) skip the strips and tiles that are
+ * empty, which late in a run, with the survivors clustered in patches, is
+ * most of the grid.
+ *
+ * Checking whether a single neighbour is free stays with the grid: the
+ * species byte is in a cache line stepping touches anyway, and measured
+ * no slower than the masks.
+ *
+ * Tiles stepped in parallel may share a word at their borders, so while
+ * a world is filled by more than one goroutine its bits are read and
+ * written atomically. Serial stepping uses plain loads and stores, which
+ * the compiler can schedule freely; locked writes alone would cost it a
+ * fifth of its time.
+ */
+
+package main
+
+import (
+	"math/bits"
+	"sync/atomic"
+)
+
+/*!
+ * \brief One bit per cell of a grid.
+ */
+type cellMask struct {
+	words  []uint64 ///< Bits of all columns, each column starting at a word boundary
+	stride int      ///< Words per column
+	shared *bool    ///< Whether goroutines fill the mask concurrently; the owning world's flag
+}
+
+/*!
+ * \brief Create an empty mask.
+ * \param size Grid size.
+ * \param shared Flag telling whether access must be atomic.
+ * \return A mask with every bit clear.
+ */
+func newCellMask(size int, shared *bool) cellMask {
+	stride := (size + 63) / 64
+	return cellMask{words: make([]uint64, size*stride), stride: stride, shared: shared}
+}
+
+/*!
+ * \brief Check whether a cell's bit is set.
+ * \param x X coordinate.
+ * \param y Y coordinate.
+ * \return True if the bit is set.
+ */
+func (m cellMask) has(x, y int) bool {
+	return m.load(x*m.stride+y>>6)&(1<<(y&63)) != 0
+}
+
+/*!
+ * \brief Read a word of the mask.
+ * \param i Index of the word.
+ * \return The word.
+ */
+func (m cellMask) load(i int) uint64 {
+	if *m.shared {
+		return atomic.LoadUint64(&m.words[i])
+	}
+	return m.words[i]
+}
+
+/*!
+ * \brief Set a cell's bit.
+ * \param x X coordinate.
+ * \param y Y coordinate.
+ */
+func (m cellMask) set(x, y int) {
+	word, bit := &m.words[x*m.stride+y>>6], uint64(1)<<(y&63)
+	if *m.shared {
+		atomic.OrUint64(word, bit)
+	} else {
+		*word |= bit
+	}
+}
+
+/*!
+ * \brief Where the bits of a cell's four neighbours are, in any mask of the grid.
+ */
+type neighbours struct {
+	word  [4]int  ///< Index of each neighbour's word
+	shift [4]uint ///< Position of each neighbour's bit in its word
+}
+
+/*!
+ * \brief Locate the bits of a cell's neighbours.
+ * \param adjacent The neighbours, from getAdjacentPositions.
+ * \return Their locations, valid for every mask of the same grid size.
+ */
+func (m cellMask) locate(adjacent [4][2]int) neighbours {
+	var n neighbours
+	for i, pos := range adjacent {
+		n.word[i] = pos[0]*m.stride + pos[1]>>6
+		n.shift[i] = uint(pos[1] & 63)
+	}
+	return n
+}
+
+/*!
+ * \brief Bits of a cell's four neighbours.
+ * \param n The neighbours, from locate.
+ * \return Bit i set if the bit of neighbour i is.
+ */
+func (m cellMask) around(n *neighbours) uint {
+	return uint(m.load(n.word[0])>>n.shift[0]&1) |
+		uint(m.load(n.word[1])>>n.shift[1]&1)<<1 |
+		uint(m.load(n.word[2])>>n.shift[2]&1)<<2 |
+		uint(m.load(n.word[3])>>n.shift[3]&1)<<3
+}
+
+/*!
+ * \brief Position of the n-th set bit.
+ * \param set The bits.
+ * \param n Index among the set bits, counting from the lowest.
+ * \return The bit's position.
+ */
+func nthBit(set uint, n int) int {
+	for ; n > 0; n-- {
+		set &= set - 1
+	}
+	return bits.TrailingZeros(set)
+}
+
+/*!
+ * \brief Bits of one word of a column, limited to a range of rows.
+ * \param x X coordinate of the column.
+ * \param i Index of the word within the column (rows i*64 to i*64+63).
+ * \param y0 First row.
+ * \param y1 Row after the last.
+ * \return The word with the bits of rows outside [y0, y1) cleared.
+ */
+func (m cellMask) columnWord(x, i, y0, y1 int) uint64 {
+	word := m.load(x*m.stride + i)
+	if i<<6 < y0 {
+		word &^= 1<<(y0&63) - 1
+	}
+	if (i+1)<<6 > y1 {
+		word &= 1<<(y1&63) - 1
+	}
+	return word
+}
+
+/*!
+ * \brief Check whether any bit of a rectangle is set.
+ * \param x0 First column.
+ * \param x1 Column after the last.
+ * \param y0 First row.
+ * \param y1 Row after the last.
+ * \return True if a bit in [x0, x1) x [y0, y1) is set.
+ */
+func (m cellMask) any(x0, x1, y0, y1 int) bool {
+	for x := x0; x < x1; x++ {
+		for i := y0 >> 6; i<<6 < y1; i++ {
+			if m.columnWord(x, i, y0, y1) != 0 {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+/*!
+ * \brief Number of set bits.
+ * \return The count.
+ */
+func (m cellMask) count() int {
+	n := 0
+	for _, w := range m.words {
+		n += bits.OnesCount64(w)
+	}
+	return n
+}
+
+/*!
+ * \brief Clear every bit.
+ */
+func (m cellMask) reset() {
+	clear(m.words)
+}
+
+/*!
+ * \brief Place a creature in a cell of a world.
+ * \param x X coordinate.
+ * \param y Y coordinate.
+ * \param c The creature, copied into the grid; it replaces any occupant.
+ */
+func (w *World) put(x, y int, c *Creature) {
+	w.Grid[x][y] = *c
+	// The hottest write of a chronon, so the masks are updated by hand
+	i, bit := x*w.fish.stride+y>>6, uint64(1)<<(y&63)
+	fish := uint64(0)
+	if c.Species == Fish {
+		fish = bit
+	}
+	if *w.shared {
+		atomic.OrUint64(&w.creatures.words[i], bit)
+		atomic.AndUint64(&w.fish.words[i], ^bit)
+		atomic.OrUint64(&w.fish.words[i], fish)
+		return
+	}
+	w.creatures.words[i] |= bit
+	w.fish.words[i] = w.fish.words[i]&^bit | fish
+}
+
+/*!
+ * \brief Empty a cell of a world.
+ * \param x X coordinate.
+ * \param y Y coordinate.
+ *
+ * Only for serial passes over a world; stepping never empties a cell of
+ * the world it fills.
+ */
+func (w *World) remove(x, y int) {
+	w.Grid[x][y] = Creature{}
+	i, bit := x*w.fish.stride+y>>6, uint64(1)<<(y&63)
+	w.creatures.words[i] &^= bit
+	w.fish.words[i] &^= bit
+}
+
+/*!
+ * \brief Check whether a cell of a world holds a creature.
+ * \param x X coordinate.
+ * \param y Y coordinate.
+ * \return True if a fish or shark is there.
+ */
+func (w *World) occupied(x, y int) bool {
+	return w.creatures.has(x, y)
+}
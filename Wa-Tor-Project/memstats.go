@@ -0,0 +1,95 @@
+/*!
+ * \file memstats.go
+ * \brief Memory usage reporting for a simulation run.
+ */
+
+package main
+
+import (
+	"fmt"
+	"io"
+	"runtime"
+	"sort"
+	"time"
+)
+
+/*!
+ * \brief Tracks heap usage over a run and reports allocation/GC totals.
+ */
+type memTracker struct {
+	start    runtime.MemStats ///< Statistics at the start of the run
+	peakHeap uint64           ///< Largest HeapAlloc seen by sample()
+}
+
+/*!
+ * \brief Start tracking memory usage.
+ * \return Pointer to the new tracker.
+ */
+func newMemTracker() *memTracker {
+	m := &memTracker{}
+	runtime.ReadMemStats(&m.start)
+	m.peakHeap = m.start.HeapAlloc
+	return m
+}
+
+/*!
+ * \brief Record the current heap size, keeping the peak.
+ */
+func (m *memTracker) sample() {
+	var ms runtime.MemStats
+	runtime.ReadMemStats(&ms)
+	if ms.HeapAlloc > m.peakHeap {
+		m.peakHeap = ms.HeapAlloc
+	}
+}
+
+/*!
+ * \brief Print peak heap, allocation totals, and GC pause statistics.
+ * \param out Destination of the report.
+ */
+func (m *memTracker) report(out io.Writer) {
+	var ms runtime.MemStats
+	runtime.ReadMemStats(&ms)
+	if ms.HeapAlloc > m.peakHeap {
+		m.peakHeap = ms.HeapAlloc
+	}
+
+	numGC := ms.NumGC - m.start.NumGC
+
+	// Pauses of the collections during this run still held in the ring buffer
+	pauses := []time.Duration{}
+	for i := uint32(0); i < numGC && i < uint32(len(ms.PauseNs)); i++ {
+		pauses = append(pauses, time.Duration(ms.PauseNs[(ms.NumGC-1-i)%uint32(len(ms.PauseNs))]))
+	}
+	sort.Slice(pauses, func(i, j int) bool { return pauses[i] < pauses[j] })
+
+	loc := userLocale()
+	fmt.Fprintln(out, "Memory statistics:")
+	fmt.Fprintf(out, "  Peak heap:         %s\n", formatBytes(m.peakHeap))
+	fmt.Fprintf(out, "  Total allocated:   %s\n", formatBytes(ms.TotalAlloc-m.start.TotalAlloc))
+	fmt.Fprintf(out, "  Allocations:       %s\n", loc.integer(int(ms.Mallocs-m.start.Mallocs)))
+	fmt.Fprintf(out, "  GC cycles:         %s\n", loc.integer(int(numGC)))
+	fmt.Fprintf(out, "  GC pause total:    %s\n", loc.duration(time.Duration(ms.PauseTotalNs-m.start.PauseTotalNs)))
+	if len(pauses) > 0 {
+		fmt.Fprintf(out, "  GC pause median:   %s\n", loc.duration(pauses[len(pauses)/2]))
+		fmt.Fprintf(out, "  GC pause max:      %s\n", loc.duration(pauses[len(pauses)-1]))
+	}
+}
+
+/*!
+ * \brief Format a byte count with a binary unit suffix.
+ * \param n Number of bytes.
+ * \return Human readable size, e.g. "3.2 MiB", in the user's locale.
+ */
+func formatBytes(n uint64) string {
+	const unit = 1024
+	if n < unit {
+		return fmt.Sprintf("%d B", n)
+	}
+	div, exp := uint64(unit), 0
+	for v := n / unit; v >= unit; v /= unit {
+		div *= unit
+		exp++
+	}
+	return fmt.Sprintf("%s %ciB", userLocale().fixed(float64(n)/float64(div), 1), "KMGTPE"[exp])
+}
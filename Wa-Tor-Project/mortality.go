@@ -0,0 +1,62 @@
+/*!
+ * \file mortality.go
+ * \brief Background mortality: deaths from causes the model leaves out.
+ *
+ * Every hatched fish and shark dies with a fixed probability per
+ * chronon.
+ */
+
+package main
+
+import (
+	"fmt"
+	"math/rand"
+)
+
+/*!
+ * \brief Probability per chronon that a creature dies of background mortality.
+ */
+type mortalityRule struct {
+	Fish  float64 ///< Probability that a fish dies in a chronon
+	Shark float64 ///< Probability that a shark dies in a chronon
+}
+
+/*!
+ * \brief Check a mortality rule.
+ * \param m The rule.
+ * \return An error naming the first probability out of range.
+ */
+func (m mortalityRule) check() error {
+	switch {
+	case !(m.Fish >= 0 && m.Fish <= 1):
+		return fmt.Errorf("-fish-mortality must be between 0 and 1, not %g", m.Fish)
+	case !(m.Shark >= 0 && m.Shark <= 1):
+		return fmt.Errorf("-shark-mortality must be between 0 and 1, not %g", m.Shark)
+	}
+	return nil
+}
+
+/*!
+ * \brief Check whether any species suffers background mortality.
+ * \return True if either probability is above 0.
+ */
+func (m mortalityRule) enabled() bool {
+	return m.Fish > 0 || m.Shark > 0
+}
+
+/*!
+ * \brief Decide whether a creature dies of background mortality this chronon.
+ * \param c The fish or shark, at the start of its turn.
+ * \param rng Random source of the creature's cell.
+ * \return True if it dies.
+ */
+func (m *mortalityRule) dies(c *Creature, rng *rand.Rand) bool {
+	p := m.Fish
+	if c.Species == Shark {
+		p = m.Shark
+	}
+	if p == 0 {
+		return false
+	}
+	return p == 1 || rng.Float64() < p
+}
@@ -0,0 +1,333 @@
+/*!
+ * \file multi.go
+ * \brief The multi subcommand: several simulations side by side on one dashboard.
+ *
+ * Every member of the ensemble runs in its own goroutine through the same
+ * loop as run, with a dashboard slot as its only observer. The dashboard
+ * redraws all slots in place at a fixed refresh rate: a thumbnail of each
+ * grid, its population line and sparklines of the fish and sharks over
+ * the last chronons. A slow terminal therefore never holds up the
+ * simulations, it just skips intermediate chronons.
+ */
+
+package main
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"os"
+	"os/signal"
+	"strings"
+	"sync"
+	"time"
+)
+
+/*!
+ * \brief One simulation of an ensemble.
+ */
+type multiMember struct {
+	Label  string ///< Caption on the dashboard: the seed or the varied parameter
+	Params Config ///< Simulation parameters
+	Seed   int64  ///< Seed of the run
+}
+
+/*!
+ * \brief Build the members of an ensemble.
+ * \param params Baseline parameters.
+ * \param seed Seed of the first member.
+ * \param runs Number of members when no parameter is varied; member i uses seed+i.
+ * \param vary "" or "NAME=V1,V2,...": one member per value of a tunable
+ *             parameter, all with the same seed.
+ * \return The members, or an error for a bad -vary or parameter value or a
+ *         variant whose creatures do not fit in the water.
+ */
+func multiMembers(params Config, seed int64, runs int, vary string) ([]multiMember, error) {
+	if vary == "" {
+		if runs < 1 {
+			return nil, fmt.Errorf("-runs must be at least 1, not %d", runs)
+		}
+		members := make([]multiMember, runs)
+		for i := range members {
+			members[i] = multiMember{fmt.Sprintf("seed %d", seed+int64(i)), params, seed + int64(i)}
+		}
+		return members, nil
+	}
+
+	p, values, err := parseParamValues("-vary", vary)
+	if err != nil {
+		return nil, err
+	}
+	var members []multiMember
+	for _, v := range values {
+		mp := params
+		*p.Field(&mp) = v
+		if err := checkParams(mp); err != nil {
+			return nil, err
+		}
+		if err := checkFit(mp); err != nil {
+			return nil, fmt.Errorf("-vary %s=%d: %v", p.Name, v, err)
+		}
+		members = append(members, multiMember{fmt.Sprintf("%s=%d", p.Name, v), mp, seed})
+	}
+	return members, nil
+}
+
+/*!
+ * \brief Observer keeping what the dashboard shows of one member.
+ */
+type dashboardSlot struct {
+	mu      sync.Mutex ///< Guards every field below
+	latest  *Frame     ///< The latest frame, or nil before the first
+	fish    []int      ///< Fish of the last chronons, oldest first
+	sharks  []int      ///< Sharks of the last chronons, oldest first
+	history int        ///< Chronons kept in fish and sharks
+}
+
+/*!
+ * \brief Keep a frame and extend the population history.
+ * \param f The frame.
+ * \return nil.
+ */
+func (s *dashboardSlot) Observe(f *Frame) error {
+	s.mu.Lock()
+	s.latest = f
+	s.fish = append(s.fish, f.Fish)
+	s.sharks = append(s.sharks, f.Sharks)
+	if len(s.fish) > s.history {
+		s.fish = s.fish[1:]
+		s.sharks = s.sharks[1:]
+	}
+	s.mu.Unlock()
+	return nil
+}
+
+/*!
+ * \brief Nothing to release; the last frame stays on the dashboard.
+ * \return nil.
+ */
+func (s *dashboardSlot) Close() error {
+	return nil
+}
+
+/*!
+ * \brief Levels of a sparkline, lowest first.
+ */
+var sparkLevels = []rune("▁▂▃▄▅▆▇█")
+
+/*!
+ * \brief Draw a series as a sparkline scaled to its own maximum.
+ * \param series The values, oldest first.
+ * \param width Characters to fill; a shorter series is padded on the right.
+ * \return The sparkline, exactly width characters wide.
+ */
+func sparkline(series []int, width int) string {
+	if len(series) > width {
+		series = series[len(series)-width:]
+	}
+	top := 0
+	for _, v := range series {
+		top = max(top, v)
+	}
+	var b strings.Builder
+	for _, v := range series {
+		level := 0
+		if top > 0 {
+			level = v * (len(sparkLevels) - 1) / top
+		}
+		b.WriteRune(sparkLevels[level])
+	}
+	b.WriteString(strings.Repeat(" ", width-len(series)))
+	return b.String()
+}
+
+/*!
+ * \brief Draw a frame shrunk to a thumbnail, in the colours of the TUI renderer.
+ * \param f The frame.
+ * \param cells Thumbnail width in cells; every cell is two characters wide.
+ * \return One string per thumbnail row, each cells*2 characters wide.
+ *
+ * A thumbnail cell covers a square block of the grid and shows the most
+ * common species in it.
+ */
+func thumbnail(f *Frame, cells int) []string {
+	block := (f.Size + cells - 1) / cells
+	rows := make([]string, cells)
+	for ty := range rows {
+		var b strings.Builder
+		for tx := 0; tx < cells; tx++ {
+			var counts [speciesCount]int
+			for y := ty * block; y < min((ty+1)*block, f.Size); y++ {
+				for x := tx * block; x < min((tx+1)*block, f.Size); x++ {
+					counts[f.At(x, y)]++
+				}
+			}
+			if counts == [speciesCount]int{} {
+				b.WriteString(ansiReset + "  ")
+				continue
+			}
+			common := Empty
+			for s := Fish; int(s) < speciesCount; s++ {
+				if counts[s] > counts[common] {
+					common = s
+				}
+			}
+			b.WriteString(tuiColour(common) + "  ")
+		}
+		b.WriteString(ansiReset)
+		rows[ty] = b.String()
+	}
+	return rows
+}
+
+/*!
+ * \brief Lines of one member's panel.
+ * \param label Caption of the member.
+ * \param cells Thumbnail width in cells.
+ * \return The lines, each cells*2 characters wide on screen.
+ */
+func (s *dashboardSlot) panel(label string, cells int) []string {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	width := cells * 2
+	fit := func(text string) string {
+		if len(text) > width {
+			text = text[:width]
+		}
+		return fmt.Sprintf("%-*s", width, text)
+	}
+
+	lines := []string{fit(label)}
+	if s.latest == nil {
+		for i := 0; i < cells+3; i++ {
+			lines = append(lines, fit(""))
+		}
+		return lines
+	}
+	lines = append(lines, thumbnail(s.latest, cells)...)
+	lines = append(lines,
+		fit(fmt.Sprintf("%d F%d S%d", s.latest.Chronon, s.latest.Fish, s.latest.Sharks)),
+		ansiGreen+sparkline(s.fish, width)+ansiReset,
+		ansiRed+sparkline(s.sharks, width)+ansiReset)
+	return lines
+}
+
+/*!
+ * \brief Draw every member's panel, columns of panels side by side.
+ * \param out Destination of the dashboard.
+ * \param members The members.
+ * \param slots Their slots, in the same order.
+ * \param columns Panels per row.
+ * \param cells Thumbnail width in cells.
+ * \return Any write error.
+ */
+func drawDashboard(out io.Writer, members []multiMember, slots []*dashboardSlot, columns, cells int) error {
+	w := bufio.NewWriter(out)
+	w.WriteString(ansiHome)
+	for first := 0; first < len(slots); first += columns {
+		var panels [][]string
+		for i := first; i < min(first+columns, len(slots)); i++ {
+			panels = append(panels, slots[i].panel(members[i].Label, cells))
+		}
+		for line := range panels[0] {
+			for i, p := range panels {
+				if i > 0 {
+					w.WriteString("  ")
+				}
+				w.WriteString(p[line])
+			}
+			w.WriteString(ansiClearLine + "\n")
+		}
+		w.WriteString(ansiClearLine + "\n")
+	}
+	return w.Flush()
+}
+
+/*!
+ * \brief Entry point of the multi subcommand.
+ * \param args Command-line arguments after "multi".
+ * \return Process exit code: exitOK, or exitConfig for bad settings.
+ */
+func multiCommand(args []string) int {
+	fs := newCommandFlags("multi")
+	params := defaultConfig()
+	cfg := registerConfigFlags(fs, &params)
+	runs := fs.Int("runs", 4, "number of simulations, with consecutive seeds")
+	vary := fs.String("vary", "", "run one simulation per value of a parameter instead, e.g. sharkbreed=6,8,10,12")
+	cps := fs.Float64("cps", 10, "target chronons per second of every simulation (0 = as fast as possible)")
+	columns := fs.Int("columns", 4, "panels per dashboard row")
+	cells := fs.Int("thumb", 16, "thumbnail width in cells")
+	refresh := fs.Duration("refresh", 200*time.Millisecond, "dashboard redraw `interval`")
+	duration := fs.Duration("duration", 0, "stop after this wall-clock `time` (0 = no limit)")
+	if err := fs.Parse(args); err != nil {
+		return flagExit(err)
+	}
+
+	seed, err := cfg.resolve()
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		return exitConfig
+	}
+	if *cfg.dryRun {
+		writeDryRun(os.Stdout, cfg, seed)
+		return exitOK
+	}
+	members, err := multiMembers(params, seed, *runs, *vary)
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		return exitConfig
+	}
+	if *columns < 1 || *cells < 1 || *refresh <= 0 {
+		fmt.Fprintln(os.Stderr, "multi: need -columns and -thumb of at least 1 and a positive -refresh")
+		return exitConfig
+	}
+
+	slots := make([]*dashboardSlot, len(members))
+	outcomes := make([]runOutcome, len(members))
+	var wg sync.WaitGroup
+	for i, m := range members {
+		slots[i] = &dashboardSlot{history: *cells * 2}
+		loop := runLoop{gov: newGovernor(*cps), chronons: defaultChronons}
+		if *duration > 0 {
+			loop.deadline = time.Now().Add(*duration)
+		}
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			outcomes[i] = simulate(newSimulation(m.Params, m.Seed), []Observer{slots[i]}, loop)
+		}()
+	}
+	done := make(chan struct{})
+	go func() {
+		wg.Wait()
+		close(done)
+	}()
+
+	interrupt := make(chan os.Signal, 1)
+	signal.Notify(interrupt, os.Interrupt)
+	defer signal.Stop(interrupt)
+	ticker := time.NewTicker(*refresh)
+	defer ticker.Stop()
+
+	fmt.Print(ansiClear + ansiHideCursor)
+	defer fmt.Print(ansiShowCursor)
+	for running := true; running; {
+		select {
+		case <-ticker.C:
+			drawDashboard(os.Stdout, members, slots, *columns, *cells)
+		case <-interrupt:
+			drawDashboard(os.Stdout, members, slots, *columns, *cells)
+			fmt.Println("Interrupted")
+			return exitOK
+		case <-done:
+			running = false
+		}
+	}
+
+	drawDashboard(os.Stdout, members, slots, *columns, *cells)
+	for i, m := range members {
+		status, _ := outcomes[i].status()
+		fmt.Printf("%-16s %-14s chronons=%d fish=%d sharks=%d\n", m.Label, status, outcomes[i].Chronons, outcomes[i].Fish, outcomes[i].Sharks)
+	}
+	return exitOK
+}
@@ -0,0 +1,300 @@
+/*!
+ * \file netcdf.go
+ * \brief NetCDF export of the occupancy grid over time.
+ *
+ * The sink writes a NetCDF classic file (64-bit offset variant) that
+ * standard geoscience and ecology tools (ncdump, xarray, netCDF4, R's
+ * ncdf4, Panoply, ...) open directly:
+ *
+ *     dimensions:  time = UNLIMITED; x = grid; y = grid
+ *     variables:   int  time(time)             chronon of each record
+ *                  int  fish(time), sharks(time)
+ *                  byte occupancy(time, x, y)  0 water, 1 fish, 2 shark,
+ *                                              3 land, 4 whale, 5 on
+ *                                              the registered species
+ *
+ * The parameters and the provenance of the run (see provenance.go) are
+ * stored as global attributes. Records are
+ * appended as the run goes; the record count in the header is written
+ * when the sink is closed.
+ */
+
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/binary"
+	"encoding/json"
+	"os"
+	"strconv"
+)
+
+/*!
+ * \brief NetCDF header tags and types.
+ */
+const (
+	ncDimension = 0x0A ///< Tag of the dimension list
+	ncVariable  = 0x0B ///< Tag of the variable list
+	ncAttribute = 0x0C ///< Tag of an attribute list
+	ncByte      = 1    ///< 8-bit signed integer
+	ncChar      = 2    ///< Text
+	ncInt       = 4    ///< 32-bit signed integer
+)
+
+/*!
+ * \brief Big-endian writer of NetCDF header fields.
+ */
+type ncHeader struct {
+	bytes.Buffer
+}
+
+/*!
+ * \brief Append a 32-bit big-endian integer.
+ * \param v The value.
+ */
+func (h *ncHeader) int32(v int) {
+	binary.Write(h, binary.BigEndian, int32(v))
+}
+
+/*!
+ * \brief Append bytes padded with zeros to a multiple of four.
+ * \param b The bytes.
+ */
+func (h *ncHeader) padded(b []byte) {
+	h.Write(b)
+	h.Write(make([]byte, (4-len(b)%4)%4))
+}
+
+/*!
+ * \brief Append a name: its length, then the padded characters.
+ * \param name The name.
+ */
+func (h *ncHeader) name(name string) {
+	h.int32(len(name))
+	h.padded([]byte(name))
+}
+
+/*!
+ * \brief A NetCDF attribute.
+ */
+type ncAttr struct {
+	Name  string ///< Attribute name
+	Text  string ///< Value of a text attribute
+	Bytes []byte ///< Values of a byte attribute, if Ints and Text are empty
+	Ints  []int  ///< Values of an int attribute, if Text is empty
+}
+
+/*!
+ * \brief Append an attribute list.
+ * \param attrs The attributes.
+ */
+func (h *ncHeader) attrs(attrs []ncAttr) {
+	if len(attrs) == 0 {
+		h.int32(0)
+		h.int32(0)
+		return
+	}
+	h.int32(ncAttribute)
+	h.int32(len(attrs))
+	for _, a := range attrs {
+		h.name(a.Name)
+		switch {
+		case a.Text != "":
+			h.int32(ncChar)
+			h.int32(len(a.Text))
+			h.padded([]byte(a.Text))
+		case a.Ints != nil:
+			h.int32(ncInt)
+			h.int32(len(a.Ints))
+			for _, v := range a.Ints {
+				h.int32(v)
+			}
+		default:
+			h.int32(ncByte)
+			h.int32(len(a.Bytes))
+			h.padded(a.Bytes)
+		}
+	}
+}
+
+/*!
+ * \brief A NetCDF record variable.
+ */
+type ncVar struct {
+	Name  string   ///< Variable name
+	Dims  []int    ///< Dimension IDs; the first is time
+	Type  int      ///< ncByte or ncInt
+	Size  int      ///< Bytes of one record, padded to a multiple of four
+	Attrs []ncAttr ///< Variable attributes
+}
+
+/*!
+ * \brief Global attributes naming the program and settings of a run.
+ * \param p The provenance, or nil.
+ * \return Text attributes of its non-empty fields; the seed as text, as it
+ *         may not fit an int.
+ */
+func provenanceAttrs(p *provenance) []ncAttr {
+	if p == nil {
+		return nil
+	}
+	config, _ := json.Marshal(p.Config)
+	attrs := []ncAttr{}
+	for _, a := range []ncAttr{
+		{Name: "source", Text: p.Program + ", " + p.Go},
+		{Name: "commit", Text: p.Commit},
+		{Name: "command", Text: p.Command},
+		{Name: "seed", Text: strconv.FormatInt(p.Seed, 10)},
+		{Name: "resumed", Text: p.Resumed},
+		{Name: "host", Text: p.Host},
+		{Name: "started", Text: p.Started},
+		{Name: "config", Text: string(config)},
+	} {
+		if a.Text != "" && (a.Name != "seed" || p.Seed != 0) {
+			attrs = append(attrs, a)
+		}
+	}
+	return attrs
+}
+
+/*!
+ * \brief Build the header of a file.
+ * \param size Grid size.
+ * \param params Parameters of the run, stored as global attributes.
+ * \param vars The record variables.
+ * \return The header bytes.
+ */
+func netcdfHeader(size int, params Config, vars []ncVar) []byte {
+	build := func(begin int64) *ncHeader {
+		h := &ncHeader{}
+		h.WriteString("CDF\x02")
+		h.int32(0) // Number of records, written on close
+
+		h.int32(ncDimension)
+		h.int32(3)
+		for _, d := range []struct {
+			name string
+			len  int
+		}{{"time", 0}, {"x", size}, {"y", size}} {
+			h.name(d.name)
+			h.int32(d.len)
+		}
+
+		h.attrs(append([]ncAttr{
+			{Name: "title", Text: "Wa-Tor predator-prey simulation"},
+			{Name: "grid", Ints: []int{params.GridSize}},
+			{Name: "fish", Ints: []int{params.NumFish}},
+			{Name: "sharks", Ints: []int{params.NumShark}},
+			{Name: "fishbreed", Ints: []int{params.FishBreed}},
+			{Name: "sharkbreed", Ints: []int{params.SharkBreed}},
+			{Name: "starve", Ints: []int{params.Starve}},
+			{Name: "scheme", Text: schemeName(params.Scheme)},
+		}, provenanceAttrs(artifactProvenance)...))
+
+		h.int32(ncVariable)
+		h.int32(len(vars))
+		for _, v := range vars {
+			h.name(v.Name)
+			h.int32(len(v.Dims))
+			for _, d := range v.Dims {
+				h.int32(d)
+			}
+			h.attrs(v.Attrs)
+			h.int32(v.Type)
+			h.int32(v.Size)
+			binary.Write(h, binary.BigEndian, begin)
+			begin += int64(v.Size)
+		}
+		return h
+	}
+	// Offsets have a fixed width, so the header length does not depend on them
+	return build(int64(build(0).Len())).Bytes()
+}
+
+/*!
+ * \brief Writes the occupancy grid of every sampled chronon to a NetCDF file.
+ */
+type netcdfSink struct {
+	file    *os.File      ///< Output file
+	w       *bufio.Writer ///< Buffer on top of file
+	records int           ///< Records written
+	cells   []byte        ///< Scratch space of one occupancy record
+}
+
+/*!
+ * \brief Create a NetCDF sink.
+ * \param path Output file path.
+ * \param params Parameters of the run.
+ * \return The sink, or an error if the file cannot be created.
+ */
+func openNetCDFSink(path string, params Config) (Observer, error) {
+	size := params.GridSize
+	cells := size * size
+	flags, meanings := []byte{0, 1, 2, 3, 4}, "water fish shark land whale"
+	for i, r := range params.Web.Species {
+		flags, meanings = append(flags, byte(firstWebSpecies)+byte(i)), meanings+" "+r.Name
+	}
+	vars := []ncVar{
+		{Name: "time", Dims: []int{0}, Type: ncInt, Size: 4,
+			Attrs: []ncAttr{{Name: "units", Text: "chronons"}}},
+		{Name: "fish", Dims: []int{0}, Type: ncInt, Size: 4,
+			Attrs: []ncAttr{{Name: "long_name", Text: "number of fish"}}},
+		{Name: "sharks", Dims: []int{0}, Type: ncInt, Size: 4,
+			Attrs: []ncAttr{{Name: "long_name", Text: "number of sharks"}}},
+		{Name: "occupancy", Dims: []int{0, 1, 2}, Type: ncByte, Size: (cells + 3) / 4 * 4,
+			Attrs: []ncAttr{
+				{Name: "long_name", Text: "species occupying each cell"},
+				{Name: "flag_values", Bytes: flags},
+				{Name: "flag_meanings", Text: meanings},
+			}},
+	}
+
+	file, err := os.Create(path)
+	if err != nil {
+		return nil, err
+	}
+	s := &netcdfSink{file: file, w: bufio.NewWriter(file), cells: make([]byte, vars[3].Size)}
+	if _, err := s.w.Write(netcdfHeader(size, params, vars)); err != nil {
+		file.Close()
+		return nil, err
+	}
+	return s, nil
+}
+
+/*!
+ * \brief Append the record of a frame.
+ * \param f The frame to record.
+ * \return Any write error.
+ */
+func (s *netcdfSink) Observe(f *Frame) error {
+	for _, v := range []int{f.Chronon, f.Fish, f.Sharks} {
+		binary.Write(s.w, binary.BigEndian, int32(v))
+	}
+	// Dimensions are (time, x, y), so y varies fastest
+	for x := 0; x < f.Size; x++ {
+		for y := 0; y < f.Size; y++ {
+			s.cells[x*f.Size+y] = byte(f.At(x, y))
+		}
+	}
+	_, err := s.w.Write(s.cells)
+	s.records++
+	return err
+}
+
+/*!
+ * \brief Write the record count into the header and close the file.
+ * \return Any write or close error.
+ */
+func (s *netcdfSink) Close() error {
+	err := s.w.Flush()
+	if err == nil {
+		var n [4]byte
+		binary.BigEndian.PutUint32(n[:], uint32(s.records))
+		_, err = s.file.WriteAt(n[:], 4)
+	}
+	if cerr := s.file.Close(); err == nil {
+		err = cerr
+	}
+	return err
+}
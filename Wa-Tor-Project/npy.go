@@ -0,0 +1,161 @@
+/*!
+ * \file npy.go
+ * \brief NumPy .npz export of the grid.
+ *
+ * The sink writes a .npz archive (a zip of .npy files) that a notebook
+ * opens with numpy.load, without a parser:
+ *
+ *     z = np.load("run.npz")
+ *     z["chronons"]          # int32, the sampled chronons
+ *     z["chronon_00042"]     # int8 (grid, grid), indexed [y, x]: 0 water, 1 fish, 2 shark, 3 land, 4 whale
+ *     z["fish"], z["sharks"] # int32 populations at the sampled chronons
+ */
+
+package main
+
+import (
+	"archive/zip"
+	"bufio"
+	"encoding/binary"
+	"fmt"
+	"io"
+	"os"
+	"strings"
+)
+
+/*!
+ * \brief Write a .npy array header (format version 1.0).
+ * \param w Destination.
+ * \param descr NumPy type descriptor, e.g. "|i1".
+ * \param shape Dimensions of the array.
+ * \return Any write error.
+ *
+ * The header is padded with spaces so the data starts at a multiple of
+ * 64 bytes, as NumPy itself does.
+ */
+func writeNpyHeader(w io.Writer, descr string, shape ...int) error {
+	dims := make([]string, len(shape))
+	for i, d := range shape {
+		dims[i] = fmt.Sprint(d)
+	}
+	shapeText := strings.Join(dims, ", ")
+	if len(shape) == 1 {
+		shapeText += ","
+	}
+	dict := fmt.Sprintf("{'descr': '%s', 'fortran_order': False, 'shape': (%s), }", descr, shapeText)
+	// Magic, version and length take 10 bytes; the dictionary ends with a newline
+	pad := 63 - (10+len(dict))%64
+	header := dict + strings.Repeat(" ", pad) + "\n"
+
+	prefix := []byte("\x93NUMPY\x01\x00\x00\x00")
+	binary.LittleEndian.PutUint16(prefix[8:], uint16(len(header)))
+	if _, err := w.Write(prefix); err != nil {
+		return err
+	}
+	_, err := io.WriteString(w, header)
+	return err
+}
+
+/*!
+ * \brief Write a one-dimensional int32 .npy array.
+ * \param w Destination.
+ * \param values The values.
+ * \return Any write error.
+ */
+func writeNpyInt32(w io.Writer, values []int) error {
+	if err := writeNpyHeader(w, "<i4", len(values)); err != nil {
+		return err
+	}
+	buf := make([]byte, 4*len(values))
+	for i, v := range values {
+		binary.LittleEndian.PutUint32(buf[4*i:], uint32(int32(v)))
+	}
+	_, err := w.Write(buf)
+	return err
+}
+
+/*!
+ * \brief Writes one int8 grid array per chronon to a .npz archive.
+ */
+type npzSink struct {
+	file     *os.File      ///< Output file
+	w        *bufio.Writer ///< Buffer on top of file
+	zw       *zip.Writer   ///< Archive on top of w
+	chronons []int         ///< Chronons written so far
+	fish     []int         ///< Fish at each written chronon
+	sharks   []int         ///< Sharks at each written chronon
+	cells    []byte        ///< Scratch space of one grid
+}
+
+/*!
+ * \brief Create a .npz sink.
+ * \param path Output file path.
+ * \param params Parameters of the run (unused).
+ * \return The sink, or an error if the file cannot be created.
+ */
+func openNpzSink(path string, params Config) (Observer, error) {
+	file, err := os.Create(path)
+	if err != nil {
+		return nil, err
+	}
+	w := bufio.NewWriter(file)
+	return &npzSink{file: file, w: w, zw: zip.NewWriter(w)}, nil
+}
+
+/*!
+ * \brief Add the grid of a frame as an array named after its chronon.
+ * \param f The frame to record.
+ * \return Any write error.
+ */
+func (s *npzSink) Observe(f *Frame) error {
+	a, err := s.zw.Create(fmt.Sprintf("chronon_%05d.npy", f.Chronon))
+	if err != nil {
+		return err
+	}
+	if err := writeNpyHeader(a, "|i1", f.Size, f.Size); err != nil {
+		return err
+	}
+	if len(s.cells) != f.Size*f.Size {
+		s.cells = make([]byte, f.Size*f.Size)
+	}
+	for i, c := range f.Cells() {
+		s.cells[i] = byte(c)
+	}
+	if _, err := a.Write(s.cells); err != nil {
+		return err
+	}
+	s.chronons = append(s.chronons, f.Chronon)
+	s.fish = append(s.fish, f.Fish)
+	s.sharks = append(s.sharks, f.Sharks)
+	return nil
+}
+
+/*!
+ * \brief Add the chronon and population arrays and close the archive.
+ * \return Any write or close error.
+ */
+func (s *npzSink) Close() error {
+	var err error
+	for _, arr := range []struct {
+		name   string
+		values []int
+	}{{"chronons", s.chronons}, {"fish", s.fish}, {"sharks", s.sharks}} {
+		var a io.Writer
+		if a, err = s.zw.Create(arr.name + ".npy"); err != nil {
+			break
+		}
+		if err = writeNpyInt32(a, arr.values); err != nil {
+			break
+		}
+	}
+	if cerr := s.zw.Close(); err == nil {
+		err = cerr
+	}
+	if ferr := s.w.Flush(); err == nil {
+		err = ferr
+	}
+	if cerr := s.file.Close(); err == nil {
+		err = cerr
+	}
+	return err
+}
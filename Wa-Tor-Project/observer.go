@@ -0,0 +1,284 @@
+/*!
+ * \file observer.go
+ * \brief Observer interface and the registry of renderers and output sinks.
+ *
+ * Every consumer of frames (terminal renderers, CSV/GIF/event writers)
+ * implements Observer. Renderers are selected with -render; every other
+ * sink registers its own flag and is enabled when a path is given, so any
+ * combination can be attached to one run.
+ *
+ * With -output json, stdout carries one JSON object per chronon and
+ * nothing else; renderers and all human-readable text go to stderr.
+ *
+ * The live renderers, which redraw the screen in place, are only ever
+ * handed the latest frame: when the simulation publishes faster than one
+ * draws, the frames it has not got to are skipped instead of queueing up,
+ * so the screen keeps up with the run. -frame-skip=false draws every
+ * chronon, holding the run back to the speed of the terminal.
+ */
+
+package main
+
+import (
+	"flag"
+	"fmt"
+	"io"
+	"os"
+	"sort"
+	"strings"
+)
+
+/*!
+ * \brief Consumer of simulation frames.
+ *
+ * Observe is called once per chronon, in order, from the observer's own
+ * goroutine. Close is called once after the last frame.
+ */
+type Observer interface {
+	Observe(f *Frame) error
+	Close() error
+}
+
+/*!
+ * \brief Registered renderers by name. A nil constructor renders nothing.
+ */
+var renderers = map[string]func(out io.Writer) Observer{
+	"plain":      newPlainRenderer,
+	"tui":        newTUIRenderer,
+	"hunger":     newHungerRenderer,
+	"age":        newAgeRenderer,
+	"generation": newGenerationRenderer,
+	"summary":    newSummaryRenderer,
+	"none":       nil,
+}
+
+/*!
+ * \brief Renderers that redraw the screen in place and may skip frames.
+ */
+var liveRenderers = map[string]bool{"tui": true, "hunger": true, "age": true, "generation": true}
+
+/*!
+ * \brief A file-backed output sink that can be enabled from the command line.
+ */
+type sinkSpec struct {
+	Flag    string                                             ///< Flag naming the output file
+	Usage   string                                             ///< Help text of the flag
+	Open    func(path string, params Config) (Observer, error) ///< Constructor for the sink
+	Sampled bool                                               ///< Records only every -sample-every'th chronon
+}
+
+/*!
+ * \brief Registered output sinks.
+ */
+var sinkRegistry = []sinkSpec{
+	{"csv", "write per-chronon population statistics to this CSV `file`", openCSVSink, false},
+	{"bands", "write the populations of the latitude bands per chronon to this CSV `file`", openBandSink, false},
+	{"food-web", "write the population of every species of the food web per chronon to this CSV `file`", openWebSink, false},
+	{"invasion", "write the population and spread of every introduced species per chronon to this CSV `file`", openInvasionSink, false},
+	{"strategy-mix", "write the creatures of every species and movement strategy per chronon to this CSV `file`", openStrategySink, false},
+	{"sex-ratios", "write the females, males and sex ratios per chronon to this CSV `file`", openSexSink, false},
+	{"gif", "write an animated GIF of the run to this `file`", openGIFSink, false},
+	{"events", "write births and deaths as JSON lines to this `file`", openEventSink, false},
+	{"lineage", "write the family tree of every creature as CSV to this `file`", openLineageSink, false},
+	{"flow", "write the mean movement of fish and sharks per region and window to this CSV `file`", openFlowSink, false},
+	{"residency", "write how often every cell held fish and sharks over the run to this CSV `file`", openResidencySink, false},
+	{"residency-png", "write a heatmap of how often every cell held fish and sharks over the run to this PNG `file`", openResidencyImageSink, false},
+	{"audio", "write the populations and predation of the run as tones to this WAV `file`", openAudioSink, false},
+	{"report", "write a self-contained HTML report of the run to this `file`", openReportSink, false},
+	{"frames", "write a delta-encoded frame log of the run to this `file` for replay", openFrameLogSink, false},
+	{"netcdf", "write the occupancy grid of the sampled chronons to this NetCDF `file`", openNetCDFSink, true},
+	{"npz", "write the grid of the sampled chronons as int8 arrays to this NumPy .npz `file`", openNpzSink, true},
+}
+
+/*!
+ * \brief Forwards only the frames of every n-th chronon to a sink.
+ */
+type sampledObserver struct {
+	Observer     ///< The sink
+	every    int ///< Chronons between recorded frames
+}
+
+/*!
+ * \brief Forward the frame if its chronon is sampled.
+ * \param f The frame.
+ * \return The sink's error, or nil for a skipped frame.
+ */
+func (s sampledObserver) Observe(f *Frame) error {
+	if f.Chronon%s.every != 0 {
+		return nil
+	}
+	return s.Observer.Observe(f)
+}
+
+/*!
+ * \brief A live renderer that is handed only the latest frame.
+ *
+ * The frame bus replaces a frame the renderer has not taken yet with the
+ * next one and counts it here; the counts are read once the bus is closed.
+ */
+type liveObserver struct {
+	Observer      ///< The renderer
+	published int ///< Frames published to it
+	skipped   int ///< Frames replaced before it took them
+}
+
+/*!
+ * \brief Command-line selection of the renderer and sinks.
+ */
+type observerFlags struct {
+	render string             ///< Name of the renderer ("" = plain, or none with -output json)
+	output string             ///< Format of stdout: text or json
+	paths  map[string]*string ///< Output path per sink flag
+	every  int                ///< Value of -sample-every
+	skip   bool               ///< Value of -frame-skip
+	live   *liveObserver      ///< The opened live renderer, or nil
+}
+
+/*!
+ * \brief Register -render and one flag per sink.
+ * \param fs The flag set to register with.
+ * \return The selection, filled in when fs is parsed.
+ */
+func registerObserverFlags(fs *flag.FlagSet) *observerFlags {
+	names := []string{}
+	for name := range renderers {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	o := &observerFlags{paths: map[string]*string{}}
+	fs.StringVar(&o.render, "render", "", "renderer: "+strings.Join(names, ", ")+" (default plain, none with -output json)")
+	fs.StringVar(&o.output, "output", "text", "stdout format: text, or json for one JSON object per chronon")
+	for _, spec := range sinkRegistry {
+		o.paths[spec.Flag] = fs.String(spec.Flag, "", spec.Usage)
+	}
+	fs.IntVar(&o.every, "sample-every", 1, "record every N-th chronon in grid exports (-netcdf, -npz)")
+	fs.BoolVar(&o.skip, "frame-skip", true, "let the live renderers skip frames they cannot draw in time")
+	return o
+}
+
+/*!
+ * \brief Check whether the frames must carry the hunger of the sharks.
+ * \return True with the hunger renderer.
+ */
+func (o *observerFlags) hunger() bool {
+	return o.render == "hunger"
+}
+
+/*!
+ * \brief Check whether the frames must carry the age and generation of the fish.
+ * \return True with the age or generation renderer.
+ */
+func (o *observerFlags) fishMap() bool {
+	return o.render == "age" || o.render == "generation"
+}
+
+/*!
+ * \brief Check whether the frames must carry the moves of the creatures.
+ * \return True with the flow sink.
+ */
+func (o *observerFlags) flow() bool {
+	return *o.paths["flow"] != ""
+}
+
+/*!
+ * \brief Destination of human-readable output.
+ * \return Stderr with -output json, stdout otherwise.
+ */
+func (o *observerFlags) human() io.Writer {
+	if o.output == "json" {
+		return os.Stderr
+	}
+	return os.Stdout
+}
+
+/*!
+ * \brief Check that no sink would truncate an existing file.
+ * \return An error naming the first sink whose file exists.
+ *
+ * A resumed run checks this before opening its sinks, so the output of
+ * the interrupted run is not lost.
+ */
+func (o *observerFlags) checkFresh() error {
+	for _, spec := range sinkRegistry {
+		path := *o.paths[spec.Flag]
+		if path == "" {
+			continue
+		}
+		if _, err := os.Stat(path); err == nil {
+			return fmt.Errorf("-%s: %s exists and a resumed run would truncate it; move it away or choose another file", spec.Flag, path)
+		}
+	}
+	return nil
+}
+
+/*!
+ * \brief Create the selected renderer and every sink that was given a path.
+ * \param params Parameters of the run.
+ * \return The observers, or an error (with already opened sinks closed).
+ */
+func (o *observerFlags) open(params Config) ([]Observer, error) {
+	if o.output != "text" && o.output != "json" {
+		return nil, fmt.Errorf("unknown output format %q (want text or json)", o.output)
+	}
+	if o.every < 1 {
+		return nil, fmt.Errorf("invalid -sample-every %d: must be at least 1", o.every)
+	}
+	render := o.render
+	if render == "" {
+		render = "plain"
+		if o.output == "json" {
+			render = "none"
+		}
+	}
+	newRenderer, ok := renderers[render]
+	if !ok {
+		return nil, fmt.Errorf("unknown renderer %q", render)
+	}
+
+	observers := []Observer{}
+	if o.output == "json" {
+		observers = append(observers, newJSONLineSink(os.Stdout))
+	}
+	if newRenderer != nil {
+		r := newRenderer(o.human())
+		if o.skip && liveRenderers[render] {
+			o.live = &liveObserver{Observer: r}
+			r = o.live
+		}
+		observers = append(observers, r)
+	}
+	for _, spec := range sinkRegistry {
+		path := *o.paths[spec.Flag]
+		if path == "" {
+			continue
+		}
+		obs, err := spec.Open(path, params)
+		if err != nil {
+			for _, opened := range observers {
+				opened.Close()
+			}
+			return nil, fmt.Errorf("-%s: %w", spec.Flag, err)
+		}
+		if spec.Sampled && o.every > 1 {
+			obs = sampledObserver{obs, o.every}
+		}
+		observers = append(observers, obs)
+	}
+	return observers, nil
+}
+
+/*!
+ * \brief Report how many frames the live renderer skipped, if any.
+ * \param out Destination of the report.
+ *
+ * Call once the run has ended.
+ */
+func (o *observerFlags) writeSkipped(out io.Writer) {
+	if o.live == nil || o.live.skipped == 0 {
+		return
+	}
+	loc := userLocale()
+	fmt.Fprintf(out, "Renderer skipped %s of %s frames (%s) to keep up\n", loc.integer(o.live.skipped),
+		loc.integer(o.live.published), loc.percent(100*float64(o.live.skipped)/float64(o.live.published), 1))
+}
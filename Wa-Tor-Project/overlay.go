@@ -0,0 +1,235 @@
+/*!
+ * \file overlay.go
+ * \brief The statistics overlay of the live renderers.
+ *
+ * Pressing i in the TUI, or on the page of the serve subcommand, shows or
+ * hides a panel on top of the grid with the births and deaths per
+ * chronon, the mean shark energy, the mean fish age and the chronons per
+ * second. The rates are averaged over the last overlayWindow frames, so
+ * they do not flicker from one chronon to the next. When the renderer
+ * skips frames to keep up, the panel also shows the share of chronons in
+ * the window it did not draw.
+ *
+ * The TUI reads single key presses by taking the terminal out of line
+ * mode with stty. Without stty, or when standard input is not a terminal,
+ * there are no keys and the overlay stays hidden. The terminal is put
+ * back when the renderer closes or the program is interrupted.
+ */
+
+package main
+
+import (
+	"bufio"
+	"fmt"
+	"os"
+	"os/exec"
+	"os/signal"
+	"strings"
+	"sync"
+	"syscall"
+	"time"
+)
+
+/*!
+ * \brief Frames the rates of the overlay are averaged over.
+ */
+const overlayWindow = 20
+
+/*!
+ * \brief Figures shown in the statistics overlay.
+ */
+type overlayStats struct {
+	Births      float64 `json:"births"`       ///< Births per chronon
+	Deaths      float64 `json:"deaths"`       ///< Creatures eaten, starved or dead otherwise per chronon
+	Eaten       float64 `json:"eaten"`        ///< Creatures eaten per chronon, for the sound of the live view
+	SharkEnergy float64 `json:"shark_energy"` ///< Mean energy of the sharks
+	FishAge     float64 `json:"fish_age"`     ///< Mean age of the fish, in chronons
+	CPS         float64 `json:"cps"`          ///< Chronons per second
+	Skipped     float64 `json:"skipped"`      ///< Share of the chronons in the window whose frame was skipped
+}
+
+/*!
+ * \brief Rolling window of the frames behind the overlay's rates.
+ */
+type overlayMeter struct {
+	births   [overlayWindow]int       ///< Births of each frame in the window
+	deaths   [overlayWindow]int       ///< Deaths of each frame in the window
+	eaten    [overlayWindow]int       ///< Creatures eaten in each frame in the window
+	chronons [overlayWindow]int       ///< Chronon of each frame in the window
+	times    [overlayWindow]time.Time ///< When each frame in the window arrived
+	n        int                      ///< Frames added so far
+}
+
+/*!
+ * \brief Add a frame to the window.
+ * \param f The frame.
+ * \param now When it arrived.
+ * \return The overlay figures up to this frame.
+ */
+func (m *overlayMeter) add(f *Frame, now time.Time) overlayStats {
+	i := m.n % overlayWindow
+	m.births[i], m.deaths[i], m.eaten[i] = 0, 0, 0
+	for ev := range f.Events() {
+		switch ev.Kind {
+		case Birth:
+			m.births[i]++
+		case Eaten:
+			m.deaths[i]++
+			m.eaten[i]++
+		case Starved, Died:
+			m.deaths[i]++
+		}
+	}
+	m.chronons[i], m.times[i] = f.Chronon, now
+	m.n++
+
+	frames := min(m.n, overlayWindow)
+	s := overlayStats{SharkEnergy: f.SharkEnergy, FishAge: f.FishAge}
+	for j := 0; j < frames; j++ {
+		s.Births += float64(m.births[j]) / float64(frames)
+		s.Deaths += float64(m.deaths[j]) / float64(frames)
+		s.Eaten += float64(m.eaten[j]) / float64(frames)
+	}
+	oldest := (m.n - frames) % overlayWindow
+	if elapsed := now.Sub(m.times[oldest]).Seconds(); elapsed > 0 {
+		s.CPS = float64(f.Chronon-m.chronons[oldest]) / elapsed
+	}
+	if span := f.Chronon - m.chronons[oldest]; span > 0 {
+		s.Skipped = 1 - float64(frames-1)/float64(span)
+	}
+	return s
+}
+
+/*!
+ * \brief Lines of the overlay panel.
+ * \param s The figures.
+ * \return One line per figure.
+ */
+func (s overlayStats) lines() []string {
+	return []string{
+		fmt.Sprintf("Births/chronon   %8.1f", s.Births),
+		fmt.Sprintf("Deaths/chronon   %8.1f", s.Deaths),
+		fmt.Sprintf("Shark energy     %8.2f", s.SharkEnergy),
+		fmt.Sprintf("Fish age         %8.1f", s.FishAge),
+		fmt.Sprintf("Chronons/sec     %8.1f", s.CPS),
+		fmt.Sprintf("Frames skipped   %7.1f%%", 100*s.Skipped),
+	}
+}
+
+/*!
+ * \brief Draw the overlay panel over the top left corner of the TUI grid.
+ * \param w Destination of the output, after the grid has been drawn.
+ * \param s The figures.
+ */
+func drawOverlay(w *bufio.Writer, s overlayStats) {
+	lines := s.lines()
+	width := 0
+	for _, line := range lines {
+		width = max(width, len(line))
+	}
+	border := "+" + strings.Repeat("-", width+2) + "+"
+	fmt.Fprintf(w, "\x1b[2;3H%s%s", ansiReset, border)
+	for i, line := range lines {
+		fmt.Fprintf(w, "\x1b[%d;3H| %-*s |", i+3, width, line)
+	}
+	fmt.Fprintf(w, "\x1b[%d;3H%s", len(lines)+3, border)
+}
+
+/*!
+ * \brief Single key presses from the terminal.
+ */
+type keyReader struct {
+	saved   string         ///< Terminal settings to restore, as printed by stty -g
+	restore sync.Once      ///< Restores the settings once
+	stop    chan bool      ///< Closed when the reader is closed
+	keys    chan byte      ///< Keys pressed
+	signals chan os.Signal ///< Interrupts, to restore the terminal before dying
+}
+
+/*!
+ * \brief Start reading single key presses from standard input.
+ * \return The reader, or nil if standard input is not a terminal or stty
+ *         cannot switch it to single keys.
+ */
+func newKeyReader() *keyReader {
+	if !stdinIsTerminal() {
+		return nil
+	}
+	saved, err := stty("-g")
+	if err != nil {
+		return nil
+	}
+	if _, err := stty("-icanon", "-echo", "min", "1"); err != nil {
+		return nil
+	}
+	k := &keyReader{saved: strings.TrimSpace(saved), stop: make(chan bool), keys: make(chan byte, 16),
+		signals: make(chan os.Signal, 1)}
+	signal.Notify(k.signals, os.Interrupt, syscall.SIGTERM)
+	go func() {
+		select {
+		case sig := <-k.signals:
+			// Put the terminal back, then die of the signal as without the reader
+			k.close()
+			if p, err := os.FindProcess(os.Getpid()); err == nil {
+				p.Signal(sig)
+			}
+		case <-k.stop:
+		}
+	}()
+	go func() {
+		buf := make([]byte, 1)
+		for {
+			if n, err := os.Stdin.Read(buf); err != nil {
+				return
+			} else if n == 1 {
+				select {
+				case k.keys <- buf[0]:
+				default: // Nobody is reading fast enough; drop the key
+				}
+			}
+		}
+	}()
+	return k
+}
+
+/*!
+ * \brief Run stty on the terminal of standard input.
+ * \param args Arguments of stty.
+ * \return Its output, or an error if it failed.
+ */
+func stty(args ...string) (string, error) {
+	cmd := exec.Command("stty", args...)
+	cmd.Stdin = os.Stdin
+	out, err := cmd.Output()
+	return string(out), err
+}
+
+/*!
+ * \brief Count the presses of a key since the last call.
+ * \param key The key.
+ * \return How often it was pressed; other keys are discarded.
+ */
+func (k *keyReader) pressed(key byte) int {
+	n := 0
+	for {
+		select {
+		case b := <-k.keys:
+			if b == key {
+				n++
+			}
+		default:
+			return n
+		}
+	}
+}
+
+/*!
+ * \brief Restore the terminal settings.
+ */
+func (k *keyReader) close() {
+	k.restore.Do(func() {
+		signal.Stop(k.signals)
+		close(k.stop)
+		stty(k.saved)
+	})
+}
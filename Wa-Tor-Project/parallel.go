@@ -0,0 +1,320 @@
+/*!
+ * \file parallel.go
+ * \brief Tile-based parallel stepping of the Wa-Tor world.
+ *
+ * The grid is split into square tiles which are handed to a pool of
+ * worker goroutines. Tiles are coloured so that two tiles running at the
+ * same time are never adjacent, which keeps creatures on tile borders from
+ * competing for the same cell. Idle workers steal tiles from busy ones.
+ * Births and events are numbered and ordered by tile after each phase,
+ * so a run does not depend on which worker finishes first.
+ */
+
+package main
+
+import (
+	"math/rand"
+	"sync"
+)
+
+/*!
+ * \brief A rectangular block of cells processed by one worker.
+ */
+type tile struct {
+	X0, X1 int    ///< Column range [X0, X1)
+	Y0, Y1 int    ///< Row range [Y0, Y1)
+	Seed   int64  ///< Seed of the tile's private random source
+	Out    *World ///< View of the next world the tile writes to (see tileView)
+}
+
+/*!
+ * \brief First provisional ID of a tile's newborns, far below any real ID.
+ */
+const provisionalID = -1 << 62
+
+/*!
+ * \brief Tile views not in use, kept with their event buffers between chronons.
+ */
+var tileViews = sync.Pool{New: func() any { return &World{ids: &idSource{}} }}
+
+/*!
+ * \brief View of a world for one tile of a parallel phase.
+ * \return A copy of w sharing its grid and masks, with events of its own
+ *         and an ID allocator handing out provisional, negative IDs.
+ */
+func (w *World) tileView() *World {
+	v := tileViews.Get().(*World)
+	events, ids := v.Events[:0], v.ids
+	*v = *w
+	v.Events, v.ids = events, ids
+	v.ids.last.Store(provisionalID)
+	return v
+}
+
+/*!
+ * \brief Take over the newborns and events of a tile once its phase is done.
+ * \param v The tile's view, from tileView; it must not be used afterwards.
+ *
+ * The newborns get the next IDs of w in the order they were born, and
+ * the events are appended to those of w, so that called for the tiles in
+ * a fixed order the result does not depend on how the tiles were
+ * scheduled. Within a phase tiles never reach each other's cells, so a
+ * provisional ID only ever refers to a newborn of the same tile.
+ */
+func (w *World) adopt(v *World) {
+	n := v.ids.last.Load() - provisionalID
+	base := int(w.ids.last.Add(n)-n) - provisionalID
+	final := func(id int) int {
+		if id < 0 {
+			return base + id
+		}
+		return id
+	}
+	for _, ev := range v.Events {
+		// A newborn stays where it was born unless it was eaten
+		if ev.Kind == Birth && ev.ID < 0 && w.Grid[ev.X][ev.Y].ID == ev.ID {
+			w.Grid[ev.X][ev.Y].ID = final(ev.ID)
+		}
+		ev.ID, ev.ParentID, ev.Rival = final(ev.ID), final(ev.ParentID), final(ev.Rival)
+		w.Events = append(w.Events, ev)
+	}
+	*v = World{Events: v.Events[:0], ids: v.ids}
+	tileViews.Put(v)
+}
+
+/*!
+ * \brief Work-stealing queue of tiles owned by one worker.
+ *
+ * The owner pops from the back, thieves take from the front.
+ */
+type tileQueue struct {
+	mu    sync.Mutex
+	tiles []tile
+}
+
+/*!
+ * \brief Take the most recently queued tile (owner side).
+ * \return The tile and true, or false if the queue is empty.
+ */
+func (q *tileQueue) pop() (tile, bool) {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	if len(q.tiles) == 0 {
+		return tile{}, false
+	}
+	t := q.tiles[len(q.tiles)-1]
+	q.tiles = q.tiles[:len(q.tiles)-1]
+	return t, true
+}
+
+/*!
+ * \brief Take the oldest queued tile (thief side).
+ * \return The tile and true, or false if the queue is empty.
+ */
+func (q *tileQueue) steal() (tile, bool) {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	if len(q.tiles) == 0 {
+		return tile{}, false
+	}
+	t := q.tiles[0]
+	q.tiles = q.tiles[1:]
+	return t, true
+}
+
+/*!
+ * \brief Split one axis of the grid into tile boundaries.
+ * \param size Grid size.
+ * \param tileSize Requested tile width.
+ * \return Start offsets of each tile followed by size as the final entry.
+ *
+ * A trailing tile narrower than two cells is merged into its neighbour so
+ * that a tile always separates the two tiles on either side of it.
+ */
+func tileBounds(size, tileSize int) []int {
+	if tileSize < 2 {
+		tileSize = 2
+	}
+	bounds := []int{}
+	for start := 0; start < size; start += tileSize {
+		bounds = append(bounds, start)
+	}
+	if n := len(bounds); n > 1 && size-bounds[n-1] < 2 {
+		bounds = bounds[:n-1]
+	}
+	return append(bounds, size)
+}
+
+/*!
+ * \brief Colour of a tile along one axis.
+ * \param index Tile index along the axis.
+ * \param count Number of tiles along the axis.
+ * \return 0 or 1 alternating, or 2 for the last tile of an odd count so
+ *         that it does not share a colour with tile 0 across the wrap.
+ */
+func tileColour(index, count int) int {
+	if count%2 == 1 && count > 1 && index == count-1 {
+		return 2
+	}
+	return index % 2
+}
+
+/*!
+ * \brief Process one pass of a chronon using the worker pool.
+ * \param oldWorld Current world state.
+ * \param newWorld Next world state.
+ * \param params Simulation parameters (Workers, TileSize, Scheme).
+ * \param pass Index of the current scheme pass.
+ * \param rng Random source used to seed the tiles.
+ *
+ * Tiles are grouped into up to nine colour phases. The phases run one
+ * after another; within a phase the tiles run concurrently. Each tile has
+ * its own seed, which the worker that takes it seeds its random source
+ * with, so the result does not depend on which worker ends up processing
+ * it. The queues and random sources are made once and reused by every
+ * phase.
+ */
+func processTiles(oldWorld, newWorld *World, params Config, pass int, rng *rand.Rand) {
+	xs := tileBounds(oldWorld.Size, params.TileSize)
+	ys := tileBounds(oldWorld.Size, params.TileSize)
+
+	phases := make([][]tile, 9)
+	for i := 0; i+1 < len(xs); i++ {
+		for j := 0; j+1 < len(ys); j++ {
+			// Empty tiles still draw their seed, so skipping them changes nothing
+			seed := rng.Int63()
+			if !oldWorld.creatures.any(xs[i], xs[i+1], ys[j], ys[j+1]) {
+				continue
+			}
+			colour := tileColour(i, len(xs)-1)*3 + tileColour(j, len(ys)-1)
+			phases[colour] = append(phases[colour], tile{
+				X0: xs[i], X1: xs[i+1],
+				Y0: ys[j], Y1: ys[j+1],
+				Seed: seed,
+			})
+		}
+	}
+
+	queues := make([]*tileQueue, params.Workers)
+	rngs := make([]*rand.Rand, params.Workers)
+	for w := range queues {
+		queues[w] = &tileQueue{}
+		rngs[w] = rand.New(newSplitMix(0))
+	}
+
+	for _, tiles := range phases {
+		if len(tiles) == 0 {
+			continue
+		}
+
+		for i := range tiles {
+			tiles[i].Out = newWorld.tileView()
+		}
+
+		// Deal tiles round-robin onto the workers' queues
+		for _, q := range queues {
+			q.tiles = q.tiles[:0]
+		}
+		for i, t := range tiles {
+			q := queues[i%len(queues)]
+			q.tiles = append(q.tiles, t)
+		}
+
+		var wg sync.WaitGroup
+		for w := range queues {
+			wg.Add(1)
+			go func(id int) {
+				defer wg.Done()
+				for {
+					t, ok := queues[id].pop()
+					if !ok {
+						t, ok = stealTile(queues, id)
+					}
+					if !ok {
+						return
+					}
+					processTile(oldWorld, t.Out, params.Scheme, pass, t, rngs[id])
+				}
+			}(w)
+		}
+		wg.Wait()
+		for _, t := range tiles {
+			newWorld.adopt(t.Out)
+		}
+	}
+}
+
+/*!
+ * \brief Steal a tile from another worker's queue.
+ * \param queues All worker queues.
+ * \param self Index of the stealing worker.
+ * \return A tile and true, or false if every queue is empty.
+ */
+func stealTile(queues []*tileQueue, self int) (tile, bool) {
+	for i := 1; i < len(queues); i++ {
+		if t, ok := queues[(self+i)%len(queues)].steal(); ok {
+			return t, true
+		}
+	}
+	return tile{}, false
+}
+
+/*!
+ * \brief Update every cell of a tile that belongs to the current pass.
+ * \param oldWorld Current world state.
+ * \param newWorld Next world state.
+ * \param scheme The update scheme.
+ * \param pass Index of the current scheme pass.
+ * \param t The tile to process.
+ * \param rng Random source of the worker, seeded here with the tile's seed.
+ */
+func processTile(oldWorld, newWorld *World, scheme UpdateScheme, pass int, t tile, rng *rand.Rand) {
+	rng.Seed(t.Seed)
+	if orderedScheme(scheme) {
+		processOrdered(oldWorld, newWorld, scheme, t.X0, t.X1, t.Y0, t.Y1, rng)
+		return
+	}
+	for x := t.X0; x < t.X1; x++ {
+		processColumn(oldWorld, newWorld, scheme, pass, x, t.Y0, t.Y1, rng)
+	}
+}
+
+/*!
+ * \brief Small, cheaply seeded random source (SplitMix64).
+ *
+ * rand.NewSource allocates and seeds several kilobytes of state, which is
+ * too slow to do for every tile of every chronon.
+ */
+type splitMix struct {
+	state uint64
+}
+
+/*!
+ * \brief Create a SplitMix64 source.
+ * \param seed Initial seed.
+ * \return Pointer to the new source.
+ */
+func newSplitMix(seed int64) *splitMix {
+	return &splitMix{state: uint64(seed)}
+}
+
+/*!
+ * \brief Reset the source to a new seed.
+ * \param seed The new seed.
+ */
+func (s *splitMix) Seed(seed int64) {
+	s.state = uint64(seed)
+}
+
+/*!
+ * \brief Produce the next non-negative 63-bit value.
+ * \return A pseudo-random int64 in [0, 2^63).
+ */
+func (s *splitMix) Int63() int64 {
+	s.state += 0x9e3779b97f4a7c15
+	z := s.state
+	z = (z ^ (z >> 30)) * 0xbf58476d1ce4e5b9
+	z = (z ^ (z >> 27)) * 0x94d049bb133111eb
+	z ^= z >> 31
+	return int64(z >> 1)
+}
@@ -0,0 +1,42 @@
+/*!
+ * \file parallel_test.go
+ * \brief Reproducibility of parallel stepping.
+ */
+
+package main
+
+import (
+	"math/rand"
+	"reflect"
+	"runtime"
+	"testing"
+)
+
+/*!
+ * \brief Step one seed twice with four workers and compare the event streams.
+ *
+ * The workers run on several threads even on one CPU, so the tiles of a
+ * phase finish in a different order every time.
+ */
+func TestParallelRunsReproducible(t *testing.T) {
+	defer runtime.GOMAXPROCS(runtime.GOMAXPROCS(4))
+	cfg := defaultConfig()
+	cfg.Workers, cfg.TileSize = 4, 4
+	for _, scheme := range []UpdateScheme{Raster, Checkerboard, Shuffle, Phased, Priority} {
+		cfg.Scheme = scheme
+		var runs [2][][]Event
+		for i := range runs {
+			rng := rand.New(rand.NewSource(5))
+			w := NewWorld(cfg, rng)
+			for range 200 {
+				w = Step(w, cfg, rng)
+				runs[i] = append(runs[i], w.Events)
+			}
+		}
+		for c := range runs[0] {
+			if !reflect.DeepEqual(runs[0][c], runs[1][c]) {
+				t.Fatalf("scheme %s: events of chronon %d differ between two runs of the same seed", schemeName(scheme), c+1)
+			}
+		}
+	}
+}
@@ -0,0 +1,310 @@
+/*!
+ * \file patterns.go
+ * \brief Spatial patterns for the initial random placement.
+ *
+ * The initial spatial structure strongly affects the early dynamics: a
+ * school of fish far from the sharks grows before it is found, while
+ * well-mixed populations collapse or boom quickly. Each pattern draws a
+ * candidate cell per creature; placement retries until it finds a free
+ * water cell.
+ */
+
+package main
+
+import (
+	"fmt"
+	"math"
+	"math/rand"
+	"sort"
+	"strings"
+)
+
+/*!
+ * \brief Draws a candidate cell for a creature of a species.
+ */
+type placement func(rng *rand.Rand, species Species) (int, int)
+
+/*!
+ * \brief Constructors of the initial placement patterns.
+ *
+ * Each receives the grid size and the random source, which patterns with
+ * random structure (such as cluster centres) draw from once up front.
+ */
+var initPatterns = map[string]func(size int, rng *rand.Rand) placement{
+	"uniform":   uniformPlacement,
+	"clusters":  clusterPlacement,
+	"stripes":   stripePlacement,
+	"quadrants": quadrantPlacement,
+	"ring":      ringPlacement,
+}
+
+/*!
+ * \brief Names of the placement patterns in alphabetical order.
+ * \return The names.
+ */
+func initPatternNames() []string {
+	names := make([]string, 0, len(initPatterns))
+	for name := range initPatterns {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
+
+/*!
+ * \brief Check a placement pattern name.
+ * \param name Pattern name ("" means uniform).
+ * \return An error listing the valid names if it is unknown.
+ */
+func checkInitPattern(name string) error {
+	if _, ok := initPatterns[name]; name != "" && !ok {
+		return fmt.Errorf("unknown init pattern %q (want one of %s)", name, strings.Join(initPatternNames(), ", "))
+	}
+	return nil
+}
+
+/*!
+ * \brief Every cell equally likely, for both species.
+ * \param size Width/height of the grid.
+ * \param rng Random source (unused up front).
+ * \return The placement.
+ */
+func uniformPlacement(size int, rng *rand.Rand) placement {
+	return func(rng *rand.Rand, species Species) (int, int) {
+		return rng.Intn(size), rng.Intn(size)
+	}
+}
+
+/*!
+ * \brief Gaussian blobs: a few schools of fish and packs of sharks.
+ * \param size Width/height of the grid.
+ * \param rng Random source for the centres of the blobs.
+ * \return The placement.
+ *
+ * Each species gets its own four centres; creatures are scattered around
+ * a random one of them with a standard deviation of a twelfth of the
+ * grid, wrapping around the torus.
+ */
+func clusterPlacement(size int, rng *rand.Rand) placement {
+	const blobs = 4
+	centres := map[Species][][2]float64{}
+	for _, s := range []Species{Fish, Shark} {
+		for i := 0; i < blobs; i++ {
+			centres[s] = append(centres[s], [2]float64{rng.Float64() * float64(size), rng.Float64() * float64(size)})
+		}
+	}
+	sigma := math.Max(1, float64(size)/12)
+	return func(rng *rand.Rand, species Species) (int, int) {
+		c := centres[species][rng.Intn(blobs)]
+		x := int(math.Floor(c[0] + rng.NormFloat64()*sigma))
+		y := int(math.Floor(c[1] + rng.NormFloat64()*sigma))
+		return wrap(x, size), wrap(y, size)
+	}
+}
+
+/*!
+ * \brief Alternating horizontal bands of fish and sharks.
+ * \param size Width/height of the grid.
+ * \param rng Random source (unused up front).
+ * \return The placement.
+ *
+ * The grid is divided into bands a tenth of its height (at least one
+ * row); fish start in the even bands and sharks in the odd ones.
+ */
+func stripePlacement(size int, rng *rand.Rand) placement {
+	width := max(1, size/10)
+	bands := (size + width - 1) / width
+	return func(rng *rand.Rand, species Species) (int, int) {
+		if bands < 2 {
+			return rng.Intn(size), rng.Intn(size)
+		}
+		band := 2 * rng.Intn((bands+1)/2)
+		if species == Shark {
+			band = 2*rng.Intn(bands/2) + 1
+		}
+		y := min(size-1, band*width+rng.Intn(width))
+		return rng.Intn(size), y
+	}
+}
+
+/*!
+ * \brief Fish in the western half, sharks in the eastern half.
+ * \param size Width/height of the grid.
+ * \param rng Random source (unused up front).
+ * \return The placement.
+ */
+func quadrantPlacement(size int, rng *rand.Rand) placement {
+	half := max(1, size/2)
+	return func(rng *rand.Rand, species Species) (int, int) {
+		x := rng.Intn(half)
+		if species == Shark {
+			x = min(size-1, half+rng.Intn(max(1, size-half)))
+		}
+		return x, rng.Intn(size)
+	}
+}
+
+/*!
+ * \brief Fish in a central disc surrounded by a ring of sharks.
+ * \param size Width/height of the grid.
+ * \param rng Random source (unused up front).
+ * \return The placement.
+ *
+ * The disc has a radius of a quarter of the grid; the shark ring
+ * extends from there to 0.4 of the grid. Points are drawn uniformly by
+ * area.
+ */
+func ringPlacement(size int, rng *rand.Rand) placement {
+	centre := float64(size) / 2
+	inner, outer := float64(size)/4, float64(size)*0.4
+	return func(rng *rand.Rand, species Species) (int, int) {
+		lo, hi := 0.0, inner
+		if species == Shark {
+			lo, hi = inner, outer
+		}
+		r := math.Sqrt(lo*lo + rng.Float64()*(hi*hi-lo*lo))
+		theta := rng.Float64() * 2 * math.Pi
+		x := int(math.Floor(centre + r*math.Cos(theta)))
+		y := int(math.Floor(centre + r*math.Sin(theta)))
+		return wrap(x, size), wrap(y, size)
+	}
+}
+
+/*!
+ * \brief Wrap a coordinate onto the torus.
+ * \param v The coordinate.
+ * \param size Width/height of the grid.
+ * \return v modulo size, in [0, size).
+ */
+func wrap(v, size int) int {
+	return ((v % size) + size) % size
+}
+
+/*!
+ * \brief A rectangle a number of creatures of one species is placed in.
+ */
+type spawnRegion struct {
+	Species Species ///< Fish or Shark
+	Count   int     ///< Creatures placed in the region
+	X, Y    int     ///< Top left cell
+	Width   int     ///< Columns covered
+	Height  int     ///< Rows covered
+}
+
+/*!
+ * \brief Draw cells uniformly from a spawn region.
+ * \return The placement; it ignores the species asked for.
+ */
+func (r spawnRegion) placement() placement {
+	return func(rng *rand.Rand, species Species) (int, int) {
+		return r.X + rng.Intn(r.Width), r.Y + rng.Intn(r.Height)
+	}
+}
+
+/*!
+ * \brief Text form of a spawn region, as parsed by parseSpawnRegions.
+ * \return E.g. "fish 200 0,0 25x50".
+ */
+func (r spawnRegion) String() string {
+	return fmt.Sprintf("%s %d %d,%d %dx%d", r.Species, r.Count, r.X, r.Y, r.Width, r.Height)
+}
+
+/*!
+ * \brief Parse spawn regions.
+ * \param s Regions separated by ';', each "SPECIES COUNT X,Y WxH" with
+ *          SPECIES fish or sharks, e.g. "fish 200 0,0 25x50; sharks 50 0,0 10x10".
+ * \return The regions in the order given (nil for ""), or a parse error.
+ */
+func parseSpawnRegions(s string) ([]spawnRegion, error) {
+	var regions []spawnRegion
+	for _, text := range strings.Split(s, ";") {
+		if strings.TrimSpace(text) == "" {
+			continue
+		}
+		var r spawnRegion
+		var species string
+		if _, err := fmt.Sscanf(strings.TrimSpace(text), "%s %d %d,%d %dx%d", &species, &r.Count, &r.X, &r.Y, &r.Width, &r.Height); err != nil {
+			return nil, fmt.Errorf("invalid spawn region %q: want SPECIES COUNT X,Y WxH", strings.TrimSpace(text))
+		}
+		switch species {
+		case "fish":
+			r.Species = Fish
+		case "shark", "sharks":
+			r.Species = Shark
+		default:
+			return nil, fmt.Errorf("invalid spawn region %q: unknown species %q (want fish or sharks)", strings.TrimSpace(text), species)
+		}
+		if r.Count < 0 || r.Width < 1 || r.Height < 1 {
+			return nil, fmt.Errorf("invalid spawn region %q: need a count of at least 0 and a size of at least 1x1", strings.TrimSpace(text))
+		}
+		regions = append(regions, r)
+	}
+	return regions, nil
+}
+
+/*!
+ * \brief Check whether any spawn region places a species.
+ * \param regions The regions.
+ * \param species Fish or Shark.
+ * \return True if the species is placed in regions rather than over the whole grid.
+ */
+func hasSpawnRegions(regions []spawnRegion, species Species) bool {
+	for _, r := range regions {
+		if r.Species == species {
+			return true
+		}
+	}
+	return false
+}
+
+/*!
+ * \brief Check spawn regions against the grid and set the populations from them.
+ * \param params Resolved parameters; NumFish and/or NumShark are replaced
+ *               by the totals of the species' regions.
+ * \return An error for a region outside the grid or with more creatures
+ *         than water cells.
+ */
+func checkSpawnRegions(params *Config) error {
+	if params.Spawns == nil {
+		return nil
+	}
+	totals := map[Species]int{}
+	for _, r := range params.Spawns {
+		if r.X < 0 || r.Y < 0 || r.X+r.Width > params.GridSize || r.Y+r.Height > params.GridSize {
+			return fmt.Errorf("spawn region %q does not fit the %dx%d grid", r, params.GridSize, params.GridSize)
+		}
+		water := r.Width * r.Height
+		for y := r.Y; y < r.Y+r.Height; y++ {
+			for x := r.X; x < r.X+r.Width; x++ {
+				i := y*params.GridSize + x
+				if params.Land != nil && params.Land[i] || r.Species == Shark && params.Reef != nil && params.Reef[i] {
+					water--
+				}
+			}
+		}
+		if r.Count > water {
+			return fmt.Errorf("spawn region %q has room for %d creatures only", r, water)
+		}
+		totals[r.Species] += r.Count
+	}
+	if hasSpawnRegions(params.Spawns, Fish) {
+		params.NumFish = totals[Fish]
+	}
+	if hasSpawnRegions(params.Spawns, Shark) {
+		params.NumShark = totals[Shark]
+	}
+	return nil
+}
+
+/*!
+ * \brief Format spawn regions for the -spawn flag.
+ * \param regions The regions.
+ * \return The regions joined with "; ".
+ */
+func formatSpawnRegions(regions []spawnRegion) string {
+	texts := make([]string, len(regions))
+	for i, r := range regions {
+		texts[i] = r.String()
+	}
+	return strings.Join(texts, "; ")
+}
@@ -0,0 +1,296 @@
+/*!
+ * \file pollution.go
+ * \brief A pollution field that damages the creatures living in it.
+ *
+ * Polluted water drains sharks and slows fish breeding; the field may
+ * diffuse and decay.
+ */
+
+package main
+
+import (
+	"fmt"
+	"io"
+	"math"
+	"math/rand"
+	"strings"
+)
+
+/*!
+ * \brief A point polluting the disc around it.
+ */
+type pollutionSource struct {
+	X, Y   int     ///< Centre cell
+	Radius int     ///< Cells reached from the centre
+	Level  float64 ///< Level at the centre, in (0, 1]
+}
+
+/*!
+ * \brief Text form of a source, as parsed by parsePollutionSources.
+ * \return E.g. "25,25 8 0.5".
+ */
+func (s pollutionSource) String() string {
+	return fmt.Sprintf("%d,%d %d %g", s.X, s.Y, s.Radius, s.Level)
+}
+
+/*!
+ * \brief Where the pollution comes from and what it does.
+ */
+type pollutionRules struct {
+	Map        []float64         ///< Levels from the land map (row-major), or nil
+	Sources    []pollutionSource ///< Point sources
+	Diffusion  float64           ///< Share of a cell's pollution exchanged with its neighbours per chronon
+	Decay      float64           ///< Share of the pollution lost per chronon
+	SharkDrain float64           ///< Chance per unit of pollution that a shark loses an extra unit of energy
+	FishHarm   float64           ///< Loss of fish fertility per unit of pollution
+}
+
+/*!
+ * \brief Check whether a run has a pollution field.
+ * \return True if the map or a source pollutes the water.
+ */
+func (p *pollutionRules) enabled() bool {
+	return p.Map != nil || len(p.Sources) > 0
+}
+
+/*!
+ * \brief Check the pollution settings.
+ * \param p The settings.
+ * \param size Width/height of the grid.
+ * \return An error naming the first setting out of range.
+ */
+func (p pollutionRules) check(size int) error {
+	rates := []struct {
+		name string
+		v    float64
+	}{{"pollution-diffusion", p.Diffusion}, {"pollution-decay", p.Decay}, {"pollution-shark-drain", p.SharkDrain}, {"pollution-fish-harm", p.FishHarm}}
+	for _, r := range rates {
+		if !(r.v >= 0 && r.v <= 1) {
+			return fmt.Errorf("-%s must be between 0 and 1, not %g", r.name, r.v)
+		}
+	}
+	for _, s := range p.Sources {
+		if s.X < 0 || s.Y < 0 || s.X >= size || s.Y >= size {
+			return fmt.Errorf("pollution source %q is outside the %dx%d grid", s, size, size)
+		}
+	}
+	return nil
+}
+
+/*!
+ * \brief Parse pollution sources.
+ * \param s Sources separated by ';', each "X,Y RADIUS [LEVEL]" with the
+ *          level 1 if left out, e.g. "25,25 8; 10,40 4 0.5".
+ * \return The sources (nil for ""), or a parse error.
+ */
+func parsePollutionSources(s string) ([]pollutionSource, error) {
+	var sources []pollutionSource
+	for _, text := range strings.Split(s, ";") {
+		text = strings.TrimSpace(text)
+		if text == "" {
+			continue
+		}
+		src := pollutionSource{Level: 1}
+		fields := strings.Fields(text)
+		_, err := fmt.Sscanf(strings.Join(fields[:min(2, len(fields))], " "), "%d,%d %d", &src.X, &src.Y, &src.Radius)
+		if err == nil && len(fields) == 3 {
+			_, err = fmt.Sscanf(fields[2], "%g", &src.Level)
+		}
+		if err != nil || len(fields) > 3 {
+			return nil, fmt.Errorf("invalid pollution source %q: want X,Y RADIUS [LEVEL]", text)
+		}
+		if src.Radius < 0 || !(src.Level > 0 && src.Level <= 1) {
+			return nil, fmt.Errorf("invalid pollution source %q: need a radius of at least 0 and a level in (0, 1]", text)
+		}
+		sources = append(sources, src)
+	}
+	return sources, nil
+}
+
+/*!
+ * \brief Format pollution sources for the -pollution flag.
+ * \param sources The sources.
+ * \return The sources joined with "; ".
+ */
+func formatPollutionSources(sources []pollutionSource) string {
+	texts := make([]string, len(sources))
+	for i, s := range sources {
+		texts[i] = s.String()
+	}
+	return strings.Join(texts, "; ")
+}
+
+/*!
+ * \brief Parse the pollution of a land map.
+ * \param r Source of the map: '1' to '9' polluted water, '#' land, '.',
+ *          '~' or 'R' clean water.
+ * \return Levels (row-major), nil if the map is clean, and the map size,
+ *         or a parse error.
+ */
+func parsePollutionMap(r io.Reader) ([]float64, int, error) {
+	levels, size, err := parseGrid(r, func(ch rune) (float64, bool) {
+		switch {
+		case ch >= '1' && ch <= '9':
+			return float64(ch-'0') / 9, true
+		case ch == '#', ch == '.', ch == '~', ch == 'R':
+			return 0, true
+		}
+		return 0, false
+	})
+	if err != nil {
+		return nil, size, err
+	}
+	for _, l := range levels {
+		if l > 0 {
+			return levels, size, nil
+		}
+	}
+	return nil, size, nil
+}
+
+/*!
+ * \brief Map character of a pollution level.
+ * \param level The level.
+ * \return '1' to '9', or 0 for a level that rounds to clean water.
+ */
+func pollutionDigit(level float64) byte {
+	if d := int(math.Round(level * 9)); d > 0 {
+		return byte('0' + min(d, 9))
+	}
+	return 0
+}
+
+/*!
+ * \brief Seed the pollution field of a run.
+ * \param params Simulation parameters.
+ * \return Levels per cell (index y*GridSize+x), nil without pollution.
+ *
+ * Sources add to the map, each reaching the cells within its radius
+ * (across the edges of a torus) with the level falling off linearly to
+ * a ninth of it at the rim. Levels are capped at 1, and land stays clean.
+ */
+func seedPollution(params Config) []float32 {
+	rules := params.Pollution
+	if !rules.enabled() {
+		return nil
+	}
+	size := params.GridSize
+	field := make([]float32, size*size)
+	for i, l := range rules.Map {
+		field[i] = float32(l)
+	}
+	for _, s := range rules.Sources {
+		for dy := -s.Radius; dy <= s.Radius; dy++ {
+			for dx := -s.Radius; dx <= s.Radius; dx++ {
+				x, y := s.X+dx, s.Y+dy
+				if params.Bounded && (x < 0 || y < 0 || x >= size || y >= size) {
+					continue
+				}
+				x, y = wrap(x, size), wrap(y, size)
+				d := math.Hypot(float64(dx), float64(dy))
+				if d > float64(s.Radius) {
+					continue
+				}
+				i := y*size + x
+				field[i] = float32(min(1, float64(field[i])+s.Level*(1-d/float64(s.Radius+1))))
+			}
+		}
+	}
+	for i, land := range params.Land {
+		if land {
+			field[i] = 0
+		}
+	}
+	return field
+}
+
+/*!
+ * \brief Pollution level of a cell.
+ * \param x X coordinate.
+ * \param y Y coordinate.
+ * \return The level, 0 without pollution.
+ */
+func (w *World) pollutionAt(x, y int) float64 {
+	if w.pollution == nil {
+		return 0
+	}
+	return float64(w.pollution[y*w.Size+x])
+}
+
+/*!
+ * \brief Extra energy a shark loses to the pollution of its cell.
+ * \param x X position of the shark.
+ * \param y Y position of the shark.
+ * \param rng Random source of the shark's cell.
+ * \return 1 with a chance of the level times the shark drain, else 0.
+ *         No random number is drawn in clean water.
+ */
+func (w *World) pollutionDrain(x, y int, rng *rand.Rand) int32 {
+	chance := w.pollutionAt(x, y) * w.polluting.SharkDrain
+	if chance > 0 && rng.Float64() < chance {
+		return 1
+	}
+	return 0
+}
+
+/*!
+ * \brief Breed time of a fish in polluted water.
+ * \param x X position of the fish.
+ * \param y Y position of the fish.
+ * \param breed Breed time in clean water.
+ * \return The breed time divided by the remaining fertility, or a time
+ *         no fish lives to see if none remains.
+ */
+func (w *World) pollutedBreed(x, y, breed int) int {
+	harm := w.pollutionAt(x, y) * w.polluting.FishHarm
+	switch {
+	case harm <= 0:
+		return breed
+	case harm >= 1:
+		return math.MaxInt32
+	}
+	return int(math.Ceil(float64(breed) / (1 - harm)))
+}
+
+/*!
+ * \brief Let the pollution of a world diffuse and decay for a chronon.
+ * \param w The world stepped from, with its field.
+ * \param dst The field of the next world, reused if it has the right size
+ *            and is not w's own.
+ * \return The field of the next world: w's own if it neither diffuses nor
+ *         decays, since it is then never modified.
+ *
+ * A cell keeps 1 - diffusion of its pollution and takes in a quarter of
+ * the diffusion share of each water neighbour; land and walls send back
+ * what would flow into them. The result is then reduced by the decay.
+ */
+func spreadPollution(w *World, dst []float32) []float32 {
+	rules := w.polluting
+	if rules.Diffusion == 0 && rules.Decay == 0 {
+		return w.pollution
+	}
+	if len(dst) != len(w.pollution) || &dst[0] == &w.pollution[0] {
+		dst = make([]float32, len(w.pollution))
+	}
+	keep, share := 1-rules.Diffusion, rules.Diffusion/4
+	for y := 0; y < w.Size; y++ {
+		for x := 0; x < w.Size; x++ {
+			i := y*w.Size + x
+			if w.isLand(x, y) {
+				dst[i] = 0
+				continue
+			}
+			own := float64(w.pollution[i])
+			level := keep * own
+			for _, pos := range getAdjacentPositions(x, y, w.Size, w.bounded) {
+				if w.isLand(pos[0], pos[1]) {
+					level += share * own
+				} else {
+					level += share * float64(w.pollution[pos[1]*w.Size+pos[0]])
+				}
+			}
+			dst[i] = float32(min(1, level*(1-rules.Decay)))
+		}
+	}
+	return dst
+}
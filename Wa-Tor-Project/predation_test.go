@@ -0,0 +1,41 @@
+/*!
+ * \file predation_test.go
+ * \brief Sharks hunt the fish where they are at the shark's turn.
+ */
+
+package main
+
+import "testing"
+
+/*!
+ * \brief A fish a shark eats is gone, wherever it moved in the chronon.
+ *
+ * Sharks that looked for prey where the fish were at the start of the
+ * chronon could eat a fish that had already moved on and lived; the fish
+ * after a chronon would then outnumber those before, plus births, minus
+ * those eaten.
+ */
+func TestPredationConservesFish(t *testing.T) {
+	for _, scheme := range []UpdateScheme{Raster, Checkerboard} {
+		params := defaultConfig()
+		params.GridSize = 20
+		params.NumFish, params.NumShark = 150, 50
+		params.Scheme = scheme
+		sim := newSimulation(params, 1)
+		for chronon := 0; chronon < 200; chronon++ {
+			before, _ := countPopulation(sim.World)
+			sim.Step()
+			births, eaten := 0, 0
+			for _, ev := range sim.World.Events {
+				if ev.Species == Fish && ev.Kind == Birth {
+					births++
+				} else if ev.Species == Fish && ev.Kind == Eaten {
+					eaten++
+				}
+			}
+			if after, _ := countPopulation(sim.World); after != before+births-eaten {
+				t.Fatalf("scheme %d, chronon %d: %d fish + %d born - %d eaten, but %d left", scheme, chronon, before, births, eaten, after)
+			}
+		}
+	}
+}
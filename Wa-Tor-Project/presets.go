@@ -0,0 +1,112 @@
+/*!
+ * \file presets.go
+ * \brief Named scenario presets built into the binary.
+ *
+ * A preset is a set of flag values with a description of what to expect.
+ * Flags given explicitly on the command line take precedence, so a preset
+ * can be used as a starting point and adjusted.
+ */
+
+package main
+
+import (
+	"flag"
+	"fmt"
+	"io"
+	"os"
+	"sort"
+	"strings"
+)
+
+/*!
+ * \brief A named starting configuration.
+ */
+type preset struct {
+	Summary  string            ///< One-line description
+	Expected string            ///< Behaviour to expect
+	Flags    map[string]string ///< Flag values set by the preset
+}
+
+/*!
+ * \brief The built-in presets.
+ */
+var presets = map[string]preset{
+	"classic": {
+		Summary:  "Dewdney's original settings: fish breed 3, sharks breed 10, starve 3",
+		Expected: "both species coexist indefinitely; populations oscillate about 1270 fish and 230 sharks",
+		Flags:    map[string]string{"grid": "50", "fish": "300", "sharks": "100", "fishbreed": "3", "sharkbreed": "10", "starve": "3"},
+	},
+	"fragile": {
+		Summary:  "a handful of slow-breeding, quickly starving sharks in a sea of fish",
+		Expected: "sharks die out in the first few chronons in about half of all seeds; otherwise they recover and persist",
+		Flags:    map[string]string{"grid": "50", "fish": "200", "sharks": "20", "fishbreed": "3", "sharkbreed": "12", "starve": "3"},
+	},
+	"shark-bloom": {
+		Summary:  "sharks breed faster than they starve",
+		Expected: "sharks eat every fish within about 15 chronons, then fill the ocean living off their breeding alone",
+		Flags:    map[string]string{"grid": "50", "fish": "600", "sharks": "400", "fishbreed": "3", "sharkbreed": "3", "starve": "4"},
+	},
+	"oscillator": {
+		Summary:  "a larger ocean with balanced rates",
+		Expected: "pronounced predator-prey cycles with a period of about 50 chronons around 3000 fish and 470 sharks",
+		Flags:    map[string]string{"grid": "80", "fish": "1500", "sharks": "200", "fishbreed": "4", "sharkbreed": "12", "starve": "4"},
+	},
+}
+
+/*!
+ * \brief Names of the presets in alphabetical order.
+ * \return The names.
+ */
+func presetNames() []string {
+	names := make([]string, 0, len(presets))
+	for name := range presets {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
+
+/*!
+ * \brief Apply a preset to the flags not given explicitly.
+ * \param fs Parsed flag set.
+ * \param name Preset name.
+ * \param explicit Flags that take precedence over the preset.
+ * \return An error for an unknown preset.
+ */
+func applyPreset(fs *flag.FlagSet, name string, explicit map[string]bool) error {
+	p, ok := presets[name]
+	if !ok {
+		return fmt.Errorf("unknown preset %q (want one of %s)", name, strings.Join(presetNames(), ", "))
+	}
+	return applyFlagValues(fs, p.Flags, explicit, "preset "+name)
+}
+
+/*!
+ * \brief Print every preset with its flags and expected behaviour.
+ * \param out Destination of the listing.
+ */
+func writePresets(out io.Writer) {
+	for _, name := range presetNames() {
+		p := presets[name]
+		flags := []string{}
+		for _, f := range []string{"grid", "fish", "sharks", "fishbreed", "sharkbreed", "starve"} {
+			if v, ok := p.Flags[f]; ok {
+				flags = append(flags, "-"+f+" "+v)
+			}
+		}
+		fmt.Fprintf(out, "%s: %s\n  %s\n  expect: %s\n", name, p.Summary, strings.Join(flags, " "), p.Expected)
+	}
+}
+
+/*!
+ * \brief Entry point of the presets subcommand.
+ * \param args Command-line arguments after "presets" (only -help).
+ * \return Process exit code.
+ */
+func presetsCommand(args []string) int {
+	if err := newCommandFlags("presets").Parse(args); err != nil {
+		return flagExit(err)
+	}
+	writePresets(os.Stdout)
+	return exitOK
+}
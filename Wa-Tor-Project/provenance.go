@@ -0,0 +1,184 @@
+/*!
+ * \file provenance.go
+ * \brief Provenance of the files a run writes: program, settings, seed, host and time.
+ *
+ * Results are looked at again months later, long after the command line
+ * that made them is forgotten. Every statistics CSV, checkpoint and
+ * report written by run, replay, fork and intervene carries the version
+ * and commit of the program, the command, every flag value as resolved,
+ * the seed, the host and the start time. CSV files begin with comment lines,
+ * which analyze skips (as does pandas with comment='#'):
+ *
+ *     # program: wator devel (commit 3fd489f), go1.27
+ *     # command: run
+ *     # seed: 7
+ *     # host: lab-3
+ *     # started: 2026-10-17T09:12:44Z
+ *     # config: {"cps":"0","fish":"200",...}
+ *
+ * The config line is a valid -config file: with it and the same program
+ * the run can be repeated (but for several -alert rules, which it joins
+ * into one). Snapshot headers and reports carry the same fields.
+ *
+ * The commit is taken from the build information that go build records
+ * in a module checkout; builds from a file list record none, and take it
+ * from -ldflags "-X main.buildCommit=$(git rev-parse --short HEAD)".
+ */
+
+package main
+
+import (
+	"encoding/json"
+	"flag"
+	"fmt"
+	"io"
+	"os"
+	"runtime"
+	"runtime/debug"
+	"time"
+)
+
+/*!
+ * \brief Version and commit of the program, set at build time with -ldflags -X.
+ */
+var (
+	buildVersion = ""
+	buildCommit  = ""
+)
+
+/*!
+ * \brief Where and how an output file was made.
+ */
+type provenance struct {
+	Program string            `json:"program"`           ///< Program name and version
+	Commit  string            `json:"commit,omitempty"`  ///< Commit the program was built from, "-dirty" if modified
+	Go      string            `json:"go"`                ///< Go version of the build
+	Command string            `json:"command"`           ///< Subcommand that wrote the file
+	Seed    int64             `json:"seed,omitempty"`    ///< Resolved seed of the simulation
+	Resumed string            `json:"resumed,omitempty"` ///< Checkpoint the run was resumed from, if any
+	Host    string            `json:"host,omitempty"`    ///< Host name of the machine
+	Started string            `json:"started"`           ///< Start of the command, RFC 3339 in UTC
+	Config  map[string]string `json:"config"`            ///< Every flag value as resolved, usable as a -config file
+}
+
+/*!
+ * \brief Provenance of the files written by this process; nil for commands that stamp none.
+ *
+ * Set by the command once its settings are resolved, before its sinks
+ * are opened.
+ */
+var artifactProvenance *provenance
+
+/*!
+ * \brief Version and commit of the running program.
+ * \return The version ("devel" for untagged builds) and the commit, or "" if unknown.
+ */
+func programVersion() (string, string) {
+	version, commit := buildVersion, buildCommit
+	if info, ok := debug.ReadBuildInfo(); ok {
+		if version == "" && info.Main.Version != "" && info.Main.Version != "(devel)" {
+			version = info.Main.Version
+		}
+		revision, dirty := "", false
+		for _, s := range info.Settings {
+			switch s.Key {
+			case "vcs.revision":
+				revision = s.Value
+			case "vcs.modified":
+				dirty = s.Value == "true"
+			}
+		}
+		if commit == "" && revision != "" {
+			commit = revision
+			if dirty {
+				commit += "-dirty"
+			}
+		}
+	}
+	if version == "" {
+		version = "devel"
+	}
+	return version, commit
+}
+
+/*!
+ * \brief Describe a command about to write its outputs.
+ * \param command Name of the command.
+ * \param fs Its parsed and resolved flags.
+ * \param seed The resolved seed, or 0 if the command has none.
+ * \return The provenance, started now.
+ *
+ * -config is left out of the settings, as its values are already among
+ * them and a config file cannot name another one, and so are -dry-run
+ * and empty values, which mean the same as unset.
+ */
+func newProvenance(command string, fs *flag.FlagSet, seed int64) *provenance {
+	version, commit := programVersion()
+	host, _ := os.Hostname()
+	p := &provenance{
+		Program: "wator " + version,
+		Commit:  commit,
+		Go:      runtime.Version(),
+		Command: command,
+		Seed:    seed,
+		Host:    host,
+		Started: time.Now().UTC().Format(time.RFC3339),
+		Config:  map[string]string{},
+	}
+	fs.VisitAll(func(f *flag.Flag) {
+		if v := f.Value.String(); v != "" && f.Name != "config" && f.Name != "dry-run" {
+			p.Config[f.Name] = v
+		}
+	})
+	if seed != 0 && fs.Lookup("seed") != nil {
+		p.Config["seed"] = fmt.Sprint(seed)
+	}
+	return p
+}
+
+/*!
+ * \brief Write the provenance as comment lines, for the top of a CSV file.
+ * \param w Destination.
+ * \return Any write error.
+ */
+func (p *provenance) writeComments(w io.Writer) error {
+	program := p.Program
+	if p.Commit != "" {
+		program += " (commit " + p.Commit + ")"
+	}
+	config, _ := json.Marshal(p.Config)
+	lines := []string{"program: " + program + ", " + p.Go, "command: " + p.Command}
+	if p.Seed != 0 {
+		lines = append(lines, fmt.Sprint("seed: ", p.Seed))
+	}
+	if p.Resumed != "" {
+		lines = append(lines, "resumed: "+p.Resumed)
+	}
+	if p.Host != "" {
+		lines = append(lines, "host: "+p.Host)
+	}
+	lines = append(lines, "started: "+p.Started, "config: "+string(config))
+	for _, line := range lines {
+		if _, err := fmt.Fprintf(w, "# %s\n", line); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+/*!
+ * \brief Create a CSV file, starting it with the provenance of the process if there is one.
+ * \param path File path.
+ * \return The file, positioned after the comments, or an error.
+ */
+func createCSV(path string) (*os.File, error) {
+	file, err := os.Create(path)
+	if err != nil || artifactProvenance == nil {
+		return file, err
+	}
+	if err := artifactProvenance.writeComments(file); err != nil {
+		file.Close()
+		return nil, err
+	}
+	return file, nil
+}
@@ -0,0 +1,143 @@
+/*!
+ * \file reef.go
+ * \brief Reef cells where fish are safe from the sharks.
+ *
+ * A reef cell is water that fish can swim into but sharks cannot: a
+ * shark neither moves onto a reef nor takes a fish sitting on one. Reefs
+ * give the prey spatial refuges, a classic way of stabilising a
+ * predator-prey system, since the fish hiding in them restock the open
+ * water after the sharks have grazed it bare.
+ *
+ * Reefs come from 'R' cells of the land map, or are grown around the
+ * land with -reef-width as fringing reefs: every water cell within that
+ * many steps of a land cell becomes reef. Like the land, the reef cells
+ * of a run are shared by all its worlds and never change.
+ */
+
+package main
+
+import (
+	"errors"
+	"io"
+)
+
+/*!
+ * \brief Parse the reef cells of a land map.
+ * \param r Source of the map: 'R' reef, '#' land, '.', '~' or '1' to '9'
+ *          water.
+ * \return Reef cells (row-major), nil if there are none, and the map
+ *         size, or a parse error.
+ */
+func parseReefMap(r io.Reader) ([]bool, int, error) {
+	reef, size, err := parseGrid(r, func(ch rune) (bool, bool) {
+		switch ch {
+		case 'R':
+			return true, true
+		case '#', '.', '~', '1', '2', '3', '4', '5', '6', '7', '8', '9':
+			return false, true
+		}
+		return false, false
+	})
+	if err != nil || countCells(reef) == 0 {
+		return nil, size, err
+	}
+	return reef, size, nil
+}
+
+/*!
+ * \brief Number of set cells of a map.
+ * \param cells The map, or nil.
+ * \return The count.
+ */
+func countCells(cells []bool) int {
+	n := 0
+	for _, c := range cells {
+		if c {
+			n++
+		}
+	}
+	return n
+}
+
+/*!
+ * \brief Grow fringing reefs around the land.
+ * \param land Land cells of the grid (row-major), not nil.
+ * \param reef Reef cells already there, or nil; they are kept.
+ * \param size Width/height of the grid.
+ * \param width Reach of the reefs in steps from the shore.
+ * \return Reef cells, row-major: the water cells within width steps (up,
+ *         down, left or right, wrapping around the edges) of a land cell.
+ */
+func growReefs(land, reef []bool, size, width int) []bool {
+	grown := make([]bool, size*size)
+	copy(grown, reef)
+	// Breadth-first from the shore, one ring of cells per step
+	ring := []int{}
+	for i, l := range land {
+		if l {
+			ring = append(ring, i)
+		}
+	}
+	seen := append([]bool{}, land...)
+	for step := 0; step < width && len(ring) > 0; step++ {
+		var next []int
+		for _, i := range ring {
+			x, y := i%size, i/size
+			for _, pos := range getAdjacentPositions(x, y, size, false) {
+				j := pos[1]*size + pos[0]
+				if !seen[j] {
+					seen[j] = true
+					grown[j] = true
+					next = append(next, j)
+				}
+			}
+		}
+		ring = next
+	}
+	return grown
+}
+
+/*!
+ * \brief Resolve the reefs of a run.
+ * \param params Parameters with the land and any reefs from the map;
+ *               Reef is set to the grown reefs.
+ * \param width Value of -reef-width.
+ * \return An error for a negative width or reefs without land to grow on.
+ */
+func resolveReefs(params *Config, width int) error {
+	switch {
+	case width < 0:
+		return errors.New("-reef-width must not be negative")
+	case width == 0:
+		return nil
+	case params.Land == nil:
+		return errors.New("-reef-width needs land to grow reefs around (-map or -gen-islands)")
+	}
+	params.Reef = growReefs(params.Land, params.Reef, params.GridSize, width)
+	return nil
+}
+
+/*!
+ * \brief Check whether a cell is reef.
+ * \param x X coordinate.
+ * \param y Y coordinate.
+ * \return True if the cell is reef.
+ */
+func (w *World) isReef(x, y int) bool {
+	return w.reef != nil && w.reef[y*w.Size+x]
+}
+
+/*!
+ * \brief Which of a cell's neighbours are reef.
+ * \param adjacent The neighbours, from getAdjacentPositions.
+ * \return Bit i set if neighbour i is reef.
+ */
+func (w *World) reefAround(adjacent [4][2]int) uint {
+	var set uint
+	for i, pos := range adjacent {
+		if w.isReef(pos[0], pos[1]) {
+			set |= 1 << i
+		}
+	}
+	return set
+}
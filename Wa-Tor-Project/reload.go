@@ -0,0 +1,175 @@
+/*!
+ * \file reload.go
+ * \brief Hot reload of the -config file while a simulation runs.
+ *
+ * The file is re-read when its modification time or size changes, or when
+ * the process receives SIGHUP. Breed times and the starvation time take
+ * effect from the next chronon; settings that shape the world (grid size,
+ * initial populations, maps) are fixed once it is built and are rejected.
+ */
+
+package main
+
+import (
+	"flag"
+	"fmt"
+	"io"
+	"os"
+	"os/signal"
+	"sort"
+	"syscall"
+	"time"
+)
+
+/*!
+ * \brief Settings that can change while the simulation runs.
+ *
+ * Other settings are bounded by these, such as -shark-birth-cost by
+ * starve, so reloadConfig checks the changed settings as a whole.
+ */
+var reloadableParams = map[string]bool{"fishbreed": true, "sharkbreed": true, "starve": true}
+
+/*!
+ * \brief Notifies of changes to a config file.
+ */
+type configWatcher struct {
+	changed <-chan struct{} ///< Receives a value when the file should be re-read
+	done    chan struct{}   ///< Closed to stop watching
+}
+
+/*!
+ * \brief Start watching a config file for changes and SIGHUP.
+ * \param path The config file.
+ * \param interval How often the file is polled.
+ * \return The watcher; call stop when done.
+ *
+ * Notifications are coalesced: while one is pending, further changes are
+ * folded into it.
+ */
+func watchConfig(path string, interval time.Duration) *configWatcher {
+	changed := make(chan struct{}, 1)
+	w := &configWatcher{changed: changed, done: make(chan struct{})}
+	notify := func() {
+		select {
+		case changed <- struct{}{}:
+		default:
+		}
+	}
+
+	hup := make(chan os.Signal, 1)
+	signal.Notify(hup, syscall.SIGHUP)
+	go func() {
+		defer signal.Stop(hup)
+		ticker := time.NewTicker(interval)
+		defer ticker.Stop()
+
+		var modTime time.Time
+		var size int64
+		if info, err := os.Stat(path); err == nil {
+			modTime, size = info.ModTime(), info.Size()
+		}
+		for {
+			select {
+			case <-w.done:
+				return
+			case <-hup:
+				notify()
+			case <-ticker.C:
+				// Missing files are ignored, since editors often replace the file
+				info, err := os.Stat(path)
+				if err != nil {
+					continue
+				}
+				if !info.ModTime().Equal(modTime) || info.Size() != size {
+					modTime, size = info.ModTime(), info.Size()
+					notify()
+				}
+			}
+		}
+	}()
+	return w
+}
+
+/*!
+ * \brief Stop watching.
+ */
+func (w *configWatcher) stop() {
+	close(w.done)
+}
+
+/*!
+ * \brief Re-read the config file and apply the settings that changed.
+ * \param out Destination of the messages about each change.
+ * \param cfg Resolved flags of the run, including the previous file contents.
+ * \param sim The running simulation.
+ *
+ * Only settings whose value differs from the previous read are considered.
+ * Settings given on the command line or in the environment keep their
+ * value. A file that fails to parse, or whose changes would give settings
+ * startup rejects (checkParams), is ignored as a whole, so a half-written
+ * edit changes nothing.
+ */
+func reloadConfig(out io.Writer, cfg *configFlags, sim *Simulation) {
+	path := *cfg.config
+	values, err := readFlagFile(path)
+	if err != nil {
+		fmt.Fprintf(out, "config: %v; keeping current settings\n", err)
+		return
+	}
+
+	// Parse the new values against a copy of the running parameters
+	params := sim.Params
+	fs := flag.NewFlagSet("reload", flag.ContinueOnError)
+	fs.SetOutput(io.Discard)
+	registerConfigFlags(fs, &params)
+
+	names := make([]string, 0, len(values))
+	for name, v := range values {
+		if old, ok := cfg.fileValues[name]; !ok || old != v {
+			names = append(names, name)
+		}
+	}
+	sort.Strings(names)
+	for _, name := range names {
+		if fs.Lookup(name) == nil {
+			fmt.Fprintf(out, "config: %s: unknown setting %q%s; keeping current settings\n", path, name, flagSuggestion(fs, name))
+			return
+		}
+		if err := fs.Set(name, values[name]); err != nil {
+			fmt.Fprintf(out, "config: %s: %v; keeping current settings\n", path, err)
+			return
+		}
+	}
+
+	// The changes apply together, and only if the run could start with them
+	candidate := sim.Params
+	var applied []string
+	for _, name := range names {
+		switch {
+		case cfg.explicit[name]:
+			fmt.Fprintf(out, "config: %s is set on the command line; ignoring %s\n", name, values[name])
+		case cfg.fromEnv[name]:
+			fmt.Fprintf(out, "config: %s is set by %s; ignoring %s\n", name, envName(name), values[name])
+		case !reloadableParams[name]:
+			fmt.Fprintf(out, "config: %s cannot change while running; restart to apply %s\n", name, values[name])
+		default:
+			p, _ := findParam(name)
+			*p.Field(&candidate) = *p.Field(&params)
+			applied = append(applied, name)
+		}
+	}
+	if err := checkParams(candidate); err != nil {
+		fmt.Fprintf(out, "config: %s: %v; keeping current settings\n", path, err)
+		return
+	}
+	cfg.fileValues = values
+
+	for _, name := range applied {
+		p, _ := findParam(name)
+		fmt.Fprintf(out, "config: %s %d -> %d from chronon %d\n", name, *p.Field(&sim.Params), *p.Field(&candidate), sim.Chronon)
+	}
+	sim.Params = candidate
+	sim.World.FishBreed = sim.Params.FishBreed
+	sim.World.SharkBreed = sim.Params.SharkBreed
+	sim.World.Starve = sim.Params.Starve
+}
@@ -0,0 +1,112 @@
+/*!
+ * \file reload_test.go
+ * \brief Hot reload accepts only settings startup would accept.
+ */
+
+package main
+
+import (
+	"io"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+/*!
+ * \brief Start a simulation from a config file, as run -config does.
+ * \param t The test.
+ * \param file Initial contents of the config file.
+ * \param args Further command-line flags.
+ * \return The resolved flags, the simulation and the path of the file.
+ */
+func startReloadable(t *testing.T, file string, args ...string) (*configFlags, *Simulation, string) {
+	t.Helper()
+	path := filepath.Join(t.TempDir(), "live.json")
+	if err := os.WriteFile(path, []byte(file), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	params := defaultConfig()
+	cfg := registerConfigFlags(newCommandFlags("run"), &params)
+	if err := cfg.fs.Parse(append([]string{"-config", path}, args...)); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := cfg.resolve(); err != nil {
+		t.Fatal(err)
+	}
+	return cfg, newSimulation(params, 1), path
+}
+
+/*!
+ * \brief Rewrite the config file and reload it.
+ * \param t The test.
+ * \param cfg Resolved flags of the run.
+ * \param sim The running simulation.
+ * \param path The config file.
+ * \param file New contents of the file.
+ */
+func reload(t *testing.T, cfg *configFlags, sim *Simulation, path, file string) {
+	t.Helper()
+	if err := os.WriteFile(path, []byte(file), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	reloadConfig(io.Discard, cfg, sim)
+}
+
+/*!
+ * \brief Check the starvation time of the settings and of the world.
+ * \param t The test.
+ * \param sim The running simulation.
+ * \param starve The starvation time both should have.
+ */
+func wantStarve(t *testing.T, sim *Simulation, starve int) {
+	t.Helper()
+	if sim.Params.Starve != starve || sim.World.Starve != starve {
+		t.Fatalf("starve is %d (world %d), want %d", sim.Params.Starve, sim.World.Starve, starve)
+	}
+}
+
+/*!
+ * \brief Valid changes reach both the settings and the world.
+ */
+func TestReloadAppliesValidChanges(t *testing.T) {
+	cfg, sim, path := startReloadable(t, `{"starve": 5}`)
+	reload(t, cfg, sim, path, `{"starve": 7, "fishbreed": 4}`)
+	wantStarve(t, sim, 7)
+	if sim.Params.FishBreed != 4 || sim.World.FishBreed != 4 {
+		t.Fatalf("fishbreed is %d (world %d), want 4", sim.Params.FishBreed, sim.World.FishBreed)
+	}
+}
+
+/*!
+ * \brief One invalid change keeps the valid ones from applying.
+ */
+func TestReloadRejectsFileAsAWhole(t *testing.T) {
+	cfg, sim, path := startReloadable(t, `{"starve": 5}`)
+	reload(t, cfg, sim, path, `{"starve": 0, "fishbreed": 4}`)
+	wantStarve(t, sim, 5)
+	if sim.Params.FishBreed != defaultConfig().FishBreed {
+		t.Fatalf("fishbreed changed to %d along with an invalid starve", sim.Params.FishBreed)
+	}
+}
+
+/*!
+ * \brief Lowering starve to the cost of a litter is refused, as at startup.
+ */
+func TestReloadKeepsBirthCostBelowStarve(t *testing.T) {
+	cfg, sim, path := startReloadable(t, `{"starve": 5}`, "-shark-birth-cost", "3")
+	reload(t, cfg, sim, path, `{"starve": 3}`)
+	wantStarve(t, sim, 5)
+	reload(t, cfg, sim, path, `{"starve": 4}`)
+	wantStarve(t, sim, 4)
+}
+
+/*!
+ * \brief Lowering starve below the energy of a meal or a newborn is refused, as at startup.
+ */
+func TestReloadKeepsMealWithinStarve(t *testing.T) {
+	cfg, sim, path := startReloadable(t, `{"starve": 5}`, "-shark-meal", "5", "-shark-birth-energy", "5")
+	reload(t, cfg, sim, path, `{"starve": 2}`)
+	wantStarve(t, sim, 5)
+	reload(t, cfg, sim, path, `{"starve": 6}`)
+	wantStarve(t, sim, 6)
+}
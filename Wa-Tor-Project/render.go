@@ -0,0 +1,287 @@
+/*!
+ * \file render.go
+ * \brief Terminal renderers.
+ *
+ * - plain: the classic text dump of every chronon
+ * - tui:   a coloured grid redrawn in place with a status bar
+ *
+ * The hunger renderer, a starvation heatmap, is in hunger.go, the age and
+ * generation renderers of the fish in generations.go, and the summary
+ * renderer, a line of narration per chronon, in summary.go.
+ */
+
+package main
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"time"
+)
+
+/*!
+ * \brief Renderer printing the population line and grid of every frame.
+ */
+type plainRenderer struct {
+	out io.Writer ///< Destination of the output
+}
+
+/*!
+ * \brief Create a plain text renderer.
+ * \param out Destination of the output.
+ * \return The renderer.
+ */
+func newPlainRenderer(out io.Writer) Observer {
+	return &plainRenderer{out: out}
+}
+
+/*!
+ * \brief Print the population line and grid of a frame.
+ * \param f The frame to print.
+ * \return Any write error.
+ */
+func (r *plainRenderer) Observe(f *Frame) error {
+	if _, err := fmt.Fprintf(r.out, "Chronon %d | Fish=%d | Sharks=%d%s%s\n", f.Chronon, f.Fish, f.Sharks, webStatus(f), phaseStatus(f)); err != nil {
+		return err
+	}
+	return printFrame(r.out, f)
+}
+
+/*!
+ * \brief Nothing to release.
+ * \return nil.
+ */
+func (r *plainRenderer) Close() error {
+	return nil
+}
+
+/*!
+ * \brief Print the grid of a frame.
+ * \param out Destination of the printed grid.
+ * \param f Pointer to the Frame to print.
+ * \return Any write error.
+ *
+ * Symbols:
+ * - '.' = empty cell
+ * - '~' = empty reef cell
+ * - 'F' = fish
+ * - 'S' = shark
+ * - 'f', 's' = fish egg, shark egg
+ * - '#' = land
+ * - 'W' = whale
+ * - the character given with -species = a registered species
+ */
+func printFrame(out io.Writer, f *Frame) error {
+	w := bufio.NewWriter(out)
+	for y := 0; y < f.Size; y++ {
+		for x := 0; x < f.Size; x++ {
+			switch s := f.At(x, y); s {
+			case Empty:
+				if f.IsReef(x, y) {
+					w.WriteString("~ ")
+				} else {
+					w.WriteString(". ")
+				}
+			case Fish:
+				if f.IsEgg(x, y) {
+					w.WriteString("f ")
+				} else {
+					w.WriteString("F ")
+				}
+			case Land:
+				w.WriteString("# ")
+			case Whale:
+				w.WriteString("W ")
+			case Shark:
+				if f.IsEgg(x, y) {
+					w.WriteString("s ")
+				} else {
+					w.WriteString("S ")
+				}
+			default:
+				w.WriteByte(f.Char(s))
+				w.WriteByte(' ')
+			}
+		}
+		w.WriteByte('\n')
+	}
+	w.WriteByte('\n')
+	return w.Flush()
+}
+
+/*!
+ * \brief ANSI escape sequences used by the TUI renderer.
+ */
+const (
+	ansiHome       = "\x1b[H"
+	ansiClear      = "\x1b[2J"
+	ansiClearLine  = "\x1b[K"
+	ansiHideCursor = "\x1b[?25l"
+	ansiShowCursor = "\x1b[?25h"
+	ansiReset      = "\x1b[0m"
+	ansiGreen      = "\x1b[32m"
+	ansiRed        = "\x1b[31m"
+)
+
+/*!
+ * \brief Background colour of each species in the TUI.
+ */
+var tuiColours = map[Species]string{
+	Empty: "\x1b[44m", // Blue water
+	Fish:  "\x1b[42m", // Green fish
+	Shark: "\x1b[41m", // Red sharks
+	Land:  "\x1b[43m", // Yellow land
+	Whale: "\x1b[45m", // Magenta whales
+}
+
+/*!
+ * \brief Background colour of a species in the TUI.
+ * \param s The species.
+ * \return Its colour in tuiColours, or that of a registered species.
+ */
+func tuiColour(s Species) string {
+	if s >= firstWebSpecies {
+		return webTUIColours[s-firstWebSpecies]
+	}
+	return tuiColours[s]
+}
+
+/*!
+ * \brief Background colour of the eggs of each species in the TUI, darker
+ *        than the hatched creatures (256-colour palette).
+ */
+var tuiEggColours = map[Species]string{
+	Fish:  "\x1b[48;5;22m", // Dark green fish eggs
+	Shark: "\x1b[48;5;52m", // Dark red shark eggs
+}
+
+/*!
+ * \brief Background colour of an empty reef cell in the TUI.
+ */
+const tuiReefColour = "\x1b[46m" // Cyan reef
+
+/*!
+ * \brief Background colours of empty polluted water in the TUI, from
+ *        lightly to heavily polluted (256-colour palette).
+ */
+var tuiPollutionColours = []string{"\x1b[48;5;24m", "\x1b[48;5;60m", "\x1b[48;5;95m", "\x1b[48;5;130m"}
+
+/*!
+ * \brief Renderer redrawing a coloured grid in place with a status bar.
+ */
+type tuiRenderer struct {
+	out     io.Writer    ///< Destination of the output
+	started bool         ///< Whether the screen has been cleared yet
+	keys    *keyReader   ///< Key presses; nil if standard input is not a terminal
+	meter   overlayMeter ///< Rates of the statistics overlay
+	overlay bool         ///< Whether the statistics overlay is shown
+}
+
+/*!
+ * \brief Create a TUI renderer.
+ * \param out Destination of the output (a terminal).
+ * \return The renderer.
+ */
+func newTUIRenderer(out io.Writer) Observer {
+	return &tuiRenderer{out: out}
+}
+
+/*!
+ * \brief Redraw the screen for a frame.
+ * \param f The frame to draw.
+ * \return Any write error.
+ */
+func (r *tuiRenderer) Observe(f *Frame) error {
+	w := bufio.NewWriter(r.out)
+	if !r.started {
+		w.WriteString(ansiClear + ansiHideCursor)
+		r.started = true
+		r.keys = newKeyReader()
+	}
+	stats := r.meter.add(f, time.Now())
+	if r.keys != nil && r.keys.pressed('i')%2 == 1 {
+		r.overlay = !r.overlay
+	}
+	w.WriteString(ansiHome)
+
+	for y := 0; y < f.Size; y++ {
+		var current string
+		for x := 0; x < f.Size; x++ {
+			colour := tuiColour(f.At(x, y))
+			if f.At(x, y) == Empty && f.IsReef(x, y) {
+				colour = tuiReefColour
+			} else if f.IsEgg(x, y) {
+				colour = tuiEggColours[f.At(x, y)]
+			} else if level := f.PollutionAt(x, y); f.At(x, y) == Empty && level > 0 {
+				n := len(tuiPollutionColours)
+				colour = tuiPollutionColours[min(n-1, int(level*float64(n)))]
+			}
+			if x == 0 || colour != current {
+				w.WriteString(colour)
+				current = colour
+			}
+			w.WriteString("  ")
+		}
+		w.WriteString(ansiReset + "\n")
+	}
+
+	births, deaths, starved := 0, 0, 0
+	for ev := range f.Events() {
+		switch ev.Kind {
+		case Birth:
+			births++
+		case Starved:
+			starved++
+			deaths++
+		case Eaten, Died:
+			deaths++
+		}
+	}
+	fmt.Fprintf(w, "Chronon %d | Fish=%d | Sharks=%d%s | Births=%d | Deaths=%d | Starved=%d%s%s\n",
+		f.Chronon, f.Fish, f.Sharks, webStatus(f), births, deaths, starved, phaseStatus(f), ansiClearLine)
+	if r.overlay {
+		drawOverlay(w, stats)
+		fmt.Fprintf(w, "\x1b[%d;1H", f.Size+2) // Back below the status bar
+	}
+	return w.Flush()
+}
+
+/*!
+ * \brief Status bar field of the day/night phase.
+ * \param f The frame.
+ * \return E.g. " | Night", or "" without a day/night cycle.
+ */
+func phaseStatus(f *Frame) string {
+	switch f.Phase {
+	case "day":
+		return " | Day"
+	case "night":
+		return " | Night"
+	}
+	return ""
+}
+
+/*!
+ * \brief Status bar fields of the registered species.
+ * \param f The frame.
+ * \return E.g. " | krill=412 | orca=9", or "" without registered species.
+ */
+func webStatus(f *Frame) string {
+	s := ""
+	for i, r := range f.Registered() {
+		s += fmt.Sprintf(" | %s=%d", r.Name, f.Other(i))
+	}
+	return s
+}
+
+/*!
+ * \brief Restore the cursor and the terminal settings.
+ * \return Any write error.
+ */
+func (r *tuiRenderer) Close() error {
+	if r.keys != nil {
+		r.keys.close()
+	}
+	_, err := io.WriteString(r.out, ansiShowCursor)
+	return err
+}
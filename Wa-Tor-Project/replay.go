@@ -0,0 +1,195 @@
+/*!
+ * \file replay.go
+ * \brief The replay subcommand: re-run a recorded run or play a frame log.
+ *
+ * A run is fully determined by its settings, terrain and seed, so
+ * "run -record" stores exactly those in a .wator scenario and replay
+ * runs it again, possibly with other renderers and sinks attached.
+ * A frame log written with -frames is played back as recorded instead,
+ * which needs no simulation but carries only cells and populations.
+ */
+
+package main
+
+import (
+	"bufio"
+	"encoding/json"
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"os"
+)
+
+/*!
+ * \brief Entry point of the replay subcommand.
+ * \param args Command-line arguments after "replay".
+ * \return Process exit code.
+ */
+func replayCommand(args []string) int {
+	fs := newCommandFlags("replay")
+	cps := fs.Float64("cps", 10, "target chronons per second (0 = as fast as possible)")
+	chronons := fs.Int("chronons", defaultChronons, "stop a recorded run after this many chronons, as run -chronons (0 = no limit)")
+	outputs := registerObserverFlags(fs)
+	if err := fs.Parse(args); err != nil {
+		return flagExit(err)
+	}
+	if fs.NArg() != 1 {
+		fs.Usage()
+		return exitConfig
+	}
+	path := fs.Arg(0)
+	if *chronons < 0 {
+		fmt.Fprintf(os.Stderr, "invalid -chronons %d: must not be negative\n", *chronons)
+		return exitConfig
+	}
+	if isFrameLog(path) {
+		return replayFrameLog(path, outputs, *cps)
+	}
+
+	// Resolve the recording like "run -scenario FILE" with no other flags or environment
+	params := defaultConfig()
+	recorded := flag.NewFlagSet("replay", flag.ContinueOnError)
+	recorded.SetOutput(io.Discard)
+	cfg := registerConfigFlags(recorded, &params)
+	cfg.ignoreEnv = true
+	recorded.Set("scenario", path)
+	seed, err := cfg.resolve()
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		return exitConfig
+	}
+	if *cfg.seed == 0 {
+		fmt.Fprintf(os.Stderr, "%s stores no seed; record runs with run -record\n", path)
+		return exitConfig
+	}
+
+	artifactProvenance = newProvenance("replay "+path, recorded, seed)
+	observers, err := outputs.open(params)
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		return exitFailure
+	}
+	human := outputs.human()
+	if cfg.description != "" {
+		fmt.Fprintln(human, cfg.description)
+	}
+	fmt.Fprintf(human, "Replaying %s (seed %d):\n", path, seed)
+
+	loop := runLoop{gov: newGovernor(*cps), chronons: *chronons}
+	sim := newSimulation(params, seed)
+	sim.hunger, sim.fishMap, sim.flow = outputs.hunger(), outputs.fishMap(), outputs.flow()
+	outcome := simulate(sim, observers, loop)
+	writeRunSummary(human, outcome, loop.gov, *cps, nil)
+	outputs.writeSkipped(human)
+	return writeOutcome(os.Stdout, outputs.output == "json", outcome, seed)
+}
+
+/*!
+ * \brief Check whether a file is a frame log rather than a scenario.
+ * \param path The file.
+ * \return True if the file starts with a JSON object.
+ */
+func isFrameLog(path string) bool {
+	f, err := os.Open(path)
+	if err != nil {
+		return false
+	}
+	defer f.Close()
+	b := make([]byte, 1)
+	_, err = f.Read(b)
+	return err == nil && b[0] == '{'
+}
+
+/*!
+ * \brief Entry point of replay for a frame log.
+ * \param path The frame log.
+ * \param outputs Renderer and sinks selected on the command line.
+ * \param cps Target chronons per second.
+ * \return Process exit code.
+ */
+func replayFrameLog(path string, outputs *observerFlags, cps float64) int {
+	f, err := os.Open(path)
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		return exitFailure
+	}
+	defer f.Close()
+	observers, err := outputs.open(defaultConfig())
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		return exitFailure
+	}
+	human := outputs.human()
+	fmt.Fprintf(human, "Playing %s:\n", path)
+
+	gov := newGovernor(cps)
+	outcome, err := playFrameLog(bufio.NewReader(f), observers, gov, os.Stderr)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "%s: %v\n", path, err)
+		return exitFailure
+	}
+	writeRunSummary(human, outcome, gov, cps, nil)
+	outputs.writeSkipped(human)
+	return writeOutcome(os.Stdout, outputs.output == "json", outcome, 0)
+}
+
+/*!
+ * \brief Publish the frames of a frame log to observers.
+ * \param r The frame log.
+ * \param observers Renderers and sinks receiving every frame; they are closed at the end.
+ * \param gov Paces the frames.
+ * \param errOut Destination of notices about skipped frames.
+ * \return How the recorded run ended, or an error for a malformed log.
+ *
+ * Frames after a gap in the log are skipped until the next keyframe.
+ */
+func playFrameLog(r io.Reader, observers []Observer, gov *governor, errOut io.Writer) (runOutcome, error) {
+	bus := &frameBus{}
+	for _, obs := range observers {
+		bus.attach(obs)
+	}
+	defer func() {
+		if err := bus.close(); err != nil {
+			fmt.Fprintln(errOut, err)
+		}
+	}()
+
+	outcome := runOutcome{FishExtinct: -1, SharksExtinct: -1, Reason: "recording"}
+	dec := json.NewDecoder(r)
+	frames := &deltaDecoder{}
+	skipped := 0
+	for {
+		var rec deltaRecord
+		if err := dec.Decode(&rec); err == io.EOF {
+			break
+		} else if err != nil {
+			return outcome, err
+		}
+		frame, err := frames.apply(rec)
+		if errors.Is(err, errDeltaGap) {
+			skipped++
+			continue
+		} else if err != nil {
+			return outcome, fmt.Errorf("chronon %d: %w", rec.Chronon, err)
+		}
+		if skipped > 0 {
+			fmt.Fprintf(errOut, "skipped %d frames before chronon %d: their keyframe is missing\n", skipped, frame.Chronon)
+			skipped = 0
+		}
+		bus.publish(frame)
+
+		outcome.Chronons, outcome.Fish, outcome.Sharks = frame.Chronon+1, frame.Fish, frame.Sharks
+		if frame.Fish == 0 && outcome.FishExtinct < 0 {
+			outcome.FishExtinct = frame.Chronon
+		}
+		if frame.Sharks == 0 && outcome.SharksExtinct < 0 {
+			outcome.SharksExtinct = frame.Chronon
+		}
+		gov.wait()
+	}
+	if skipped > 0 {
+		fmt.Fprintf(errOut, "skipped the last %d frames: their keyframe is missing\n", skipped)
+	}
+	return outcome, nil
+}
@@ -0,0 +1,243 @@
+/*!
+ * \file replicates.go
+ * \brief Replicate runs with mean ± standard deviation trajectories.
+ *
+ * A single stochastic run says little about a parameter set. The
+ * replicate mode runs the same configuration with R different seeds in
+ * parallel and aggregates the population trajectories and extinctions.
+ */
+
+package main
+
+import (
+	"encoding/csv"
+	"fmt"
+	"io"
+	"math"
+	"os"
+	"runtime"
+	"strconv"
+	"sync"
+)
+
+/*!
+ * \brief Population history of one headless run.
+ */
+type trajectory struct {
+	Seed         int64 ///< Seed of the run
+	Fish         []int ///< Fish population after each chronon
+	Sharks       []int ///< Shark population after each chronon
+	FishExtinct  int   ///< First chronon with no fish, or -1
+	SharkExtinct int   ///< First chronon with no sharks, or -1
+}
+
+/*!
+ * \brief Run a simulation without rendering and record its populations.
+ * \param params Simulation parameters.
+ * \param seed Seed of the run.
+ * \param chronons Number of chronons to run.
+ * \return The recorded trajectory.
+ *
+ * The run stops early once both species are extinct; the remaining
+ * chronons are recorded as zero.
+ */
+func runTrajectory(params Config, seed int64, chronons int) trajectory {
+	t := trajectory{
+		Seed:         seed,
+		Fish:         make([]int, chronons),
+		Sharks:       make([]int, chronons),
+		FishExtinct:  -1,
+		SharkExtinct: -1,
+	}
+	sim := newSimulation(params, seed)
+	for c := 0; c < chronons; c++ {
+		sim.Step()
+		fish, sharks := sim.Population()
+		t.Fish[c], t.Sharks[c] = fish, sharks
+		if fish == 0 && t.FishExtinct < 0 {
+			t.FishExtinct = c
+		}
+		if sharks == 0 && t.SharkExtinct < 0 {
+			t.SharkExtinct = c
+		}
+		if fish == 0 && sharks == 0 {
+			break
+		}
+	}
+	return t
+}
+
+/*!
+ * \brief Run several seeds of the same configuration in parallel.
+ * \param params Simulation parameters.
+ * \param seeds Seeds of the runs.
+ * \param chronons Number of chronons per run.
+ * \return One trajectory per seed, in the order of seeds.
+ */
+func runReplicates(params Config, seeds []int64, chronons int) []trajectory {
+	// Parallelise over runs rather than within them
+	params.Workers = 1
+
+	results := make([]trajectory, len(seeds))
+	parallelFor(len(seeds), func(i int) {
+		results[i] = runTrajectory(params, seeds[i], chronons)
+	})
+	return results
+}
+
+/*!
+ * \brief Call fn(0) .. fn(n-1) on a pool of GOMAXPROCS goroutines.
+ * \param n Number of calls.
+ * \param fn Function to call; calls for different i run concurrently.
+ */
+func parallelFor(n int, fn func(i int)) {
+	jobs := make(chan int)
+	var wg sync.WaitGroup
+	for w := 0; w < runtime.GOMAXPROCS(0); w++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for i := range jobs {
+				fn(i)
+			}
+		}()
+	}
+	for i := 0; i < n; i++ {
+		jobs <- i
+	}
+	close(jobs)
+	wg.Wait()
+}
+
+/*!
+ * \brief Mean and sample standard deviation of a series across runs.
+ * \param runs The trajectories.
+ * \param pick Selects the series (fish or sharks) of a run.
+ * \return Mean and standard deviation per chronon.
+ */
+func seriesStats(runs []trajectory, pick func(t trajectory) []int) ([]float64, []float64) {
+	n := len(pick(runs[0]))
+	mean, sd := make([]float64, n), make([]float64, n)
+	for c := 0; c < n; c++ {
+		sum, sumSq := 0.0, 0.0
+		for _, r := range runs {
+			v := float64(pick(r)[c])
+			sum += v
+			sumSq += v * v
+		}
+		k := float64(len(runs))
+		mean[c] = sum / k
+		if len(runs) > 1 {
+			sd[c] = math.Sqrt(math.Max(0, (sumSq-sum*sum/k)/(k-1)))
+		}
+	}
+	return mean, sd
+}
+
+/*!
+ * \brief Run the replicate mode and write its outputs.
+ * \param out Destination of the summary.
+ * \param params Simulation parameters.
+ * \param replicates Number of runs.
+ * \param horizon Chronons per run.
+ * \param seed Seed of the first run; run i uses seed+i.
+ * \param prefix Output path prefix for PREFIX.csv and PREFIX.svg.
+ * \return Any file error.
+ */
+func replicateMode(out io.Writer, params Config, replicates, horizon int, seed int64, prefix string) error {
+	seeds := make([]int64, replicates)
+	for i := range seeds {
+		seeds[i] = seed + int64(i)
+	}
+	fmt.Fprintf(out, "Running %d replicates of %d chronons (seeds %d..%d)\n", replicates, horizon, seeds[0], seeds[len(seeds)-1])
+	runs := runReplicates(params, seeds, horizon)
+
+	fishMean, fishSD := seriesStats(runs, func(t trajectory) []int { return t.Fish })
+	sharkMean, sharkSD := seriesStats(runs, func(t trajectory) []int { return t.Sharks })
+
+	// Trajectories as CSV
+	file, err := os.Create(prefix + ".csv")
+	if err != nil {
+		return err
+	}
+	w := csv.NewWriter(file)
+	w.Write([]string{"chronon", "fish_mean", "fish_sd", "sharks_mean", "sharks_sd"})
+	for c := range fishMean {
+		w.Write([]string{
+			strconv.Itoa(c),
+			strconv.FormatFloat(fishMean[c], 'f', 2, 64),
+			strconv.FormatFloat(fishSD[c], 'f', 2, 64),
+			strconv.FormatFloat(sharkMean[c], 'f', 2, 64),
+			strconv.FormatFloat(sharkSD[c], 'f', 2, 64),
+		})
+	}
+	w.Flush()
+	if err := w.Error(); err != nil {
+		file.Close()
+		return err
+	}
+	if err := file.Close(); err != nil {
+		return err
+	}
+
+	// Chart with ±1 standard deviation bands
+	xs := make([]float64, len(fishMean))
+	for i := range xs {
+		xs[i] = float64(i)
+	}
+	chart, err := os.Create(prefix + ".svg")
+	if err != nil {
+		return err
+	}
+	series := []chartSeries{
+		{Name: "fish", Colour: "#2a9d3a", X: xs, Y: fishMean, Band: fishSD},
+		{Name: "sharks", Colour: "#d03030", X: xs, Y: sharkMean, Band: sharkSD},
+	}
+	fit, fitErr := fitLotkaVolterra(fishMean, sharkMean)
+	if fitErr == nil {
+		n := len(fit.Fish)
+		series = append(series,
+			chartSeries{Name: "fish (Lotka-Volterra)", Colour: "#2a9d3a", X: xs[:n], Y: fit.Fish, Dashed: true},
+			chartSeries{Name: "sharks (Lotka-Volterra)", Colour: "#d03030", X: xs[:n], Y: fit.Sharks, Dashed: true},
+		)
+	}
+	writeSVGChart(chart, fmt.Sprintf("Mean population ± 1 sd over %d replicates", replicates), "chronon", "population", series)
+	if err := chart.Close(); err != nil {
+		return err
+	}
+
+	writeExtinctionSummary(out, runs)
+	if fitErr == nil {
+		writeLVSummary(out, fit)
+	} else {
+		fmt.Fprintf(out, "Lotka-Volterra fit: %v\n", fitErr)
+	}
+	fmt.Fprintf(out, "Wrote %s.csv and %s.svg\n", prefix, prefix)
+	return nil
+}
+
+/*!
+ * \brief Print how often and how early each species went extinct.
+ * \param out Destination of the summary.
+ * \param runs The trajectories.
+ */
+func writeExtinctionSummary(out io.Writer, runs []trajectory) {
+	report := func(name string, pick func(t trajectory) int) {
+		count, sum := 0, 0
+		for _, r := range runs {
+			if c := pick(r); c >= 0 {
+				count++
+				sum += c
+			}
+		}
+		if count == 0 {
+			fmt.Fprintf(out, "  %-7s extinct in 0/%d runs\n", name, len(runs))
+			return
+		}
+		fmt.Fprintf(out, "  %-7s extinct in %d/%d runs (%.0f%%), mean extinction chronon %.1f\n",
+			name, count, len(runs), 100*float64(count)/float64(len(runs)), float64(sum)/float64(count))
+	}
+	fmt.Fprintln(out, "Extinctions:")
+	report("fish", func(t trajectory) int { return t.FishExtinct })
+	report("sharks", func(t trajectory) int { return t.SharkExtinct })
+}
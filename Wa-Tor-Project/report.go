@@ -0,0 +1,396 @@
+/*!
+ * \file report.go
+ * \brief Self-contained HTML report written at the end of a run.
+ *
+ * The report contains the parameter table, the population chart with a
+ * fitted Lotka-Volterra overlay, the fish/shark phase plot, a timeline of key events and a handful of frame
+ * snapshots embedded as PNG data URIs, so the single file can be attached
+ * to a lab submission as is. Its numbers are written for the user's
+ * locale (see locale.go), and it ends with the provenance of the run:
+ * program, commit, seed, host, start time and every setting.
+ */
+
+package main
+
+import (
+	"bytes"
+	"encoding/base64"
+	"fmt"
+	"html/template"
+	"image"
+	"image/png"
+	"os"
+)
+
+/*!
+ * \brief Maximum number of snapshots kept for the report (plus the final frame).
+ */
+const maxReportSnapshots = 8
+
+/*!
+ * \brief Pixels per cell in report snapshots.
+ */
+const snapshotScale = 3
+
+/*!
+ * \brief Observer collecting a run's history and writing the HTML report on close.
+ */
+type reportSink struct {
+	path      string      ///< Output file path
+	params    Config      ///< Parameters of the run
+	prov      *provenance ///< Where and how the run was made, or nil
+	fish      []int       ///< Fish population per chronon
+	sharks    []int       ///< Shark population per chronon
+	births    []int       ///< Births per chronon
+	deaths    []int       ///< Deaths per chronon
+	snapshots []*Frame    ///< Evenly spaced frames
+	stride    int         ///< Keep one snapshot out of every stride frames
+	last      *Frame      ///< Most recent frame
+}
+
+/*!
+ * \brief Create a report sink.
+ * \param path Output file path.
+ * \param params Parameters of the run, listed in the report.
+ * \return The sink, or an error if the file cannot be created.
+ */
+func openReportSink(path string, params Config) (Observer, error) {
+	file, err := os.Create(path)
+	if err != nil {
+		return nil, err
+	}
+	file.Close()
+	return &reportSink{path: path, params: params, prov: artifactProvenance, stride: 1}, nil
+}
+
+/*!
+ * \brief Record a frame.
+ * \param f The frame to record.
+ * \return nil.
+ */
+func (r *reportSink) Observe(f *Frame) error {
+	r.fish = append(r.fish, f.Fish)
+	r.sharks = append(r.sharks, f.Sharks)
+	births, deaths := 0, 0
+	for ev := range f.Events() {
+		switch ev.Kind {
+		case Birth:
+			births++
+		case Eaten, Starved, Died:
+			deaths++
+		}
+	}
+	r.births = append(r.births, births)
+	r.deaths = append(r.deaths, deaths)
+
+	if f.Chronon%r.stride == 0 {
+		r.snapshots = append(r.snapshots, f)
+		if len(r.snapshots) > maxReportSnapshots {
+			kept := r.snapshots[:0]
+			for i := 0; i < len(r.snapshots); i += 2 {
+				kept = append(kept, r.snapshots[i])
+			}
+			r.snapshots = kept
+			r.stride *= 2
+		}
+	}
+	r.last = f
+	return nil
+}
+
+/*!
+ * \brief Entry of the key events timeline.
+ */
+type reportEvent struct {
+	Chronon int    ///< When it happened
+	Text    string ///< What happened
+}
+
+/*!
+ * \brief Snapshot shown in the report.
+ */
+type reportSnapshot struct {
+	Chronon int          ///< Chronon of the frame
+	Fish    int          ///< Fish population
+	Sharks  int          ///< Shark population
+	Image   template.URL ///< PNG data URI
+}
+
+/*!
+ * \brief Lotka-Volterra section of the report.
+ */
+type lvReport struct {
+	Model           *lvFit  ///< The fit, or nil if it failed
+	Error           string  ///< Why the fit failed
+	FishEq, SharkEq float64 ///< Equilibrium of the fitted model
+	Period          float64 ///< Period of small oscillations of the fitted model
+}
+
+/*!
+ * \brief Write the report.
+ * \return Any encoding or file error.
+ */
+func (r *reportSink) Close() error {
+	if r.last == nil {
+		return nil
+	}
+
+	var chart, phase bytes.Buffer
+	xs, fishY, sharkY := make([]float64, len(r.fish)), make([]float64, len(r.fish)), make([]float64, len(r.fish))
+	for i := range r.fish {
+		xs[i] = float64(i)
+		fishY[i] = float64(r.fish[i])
+		sharkY[i] = float64(r.sharks[i])
+	}
+	series := []chartSeries{
+		{Name: "fish", Colour: "#2a9d3a", X: xs, Y: fishY},
+		{Name: "sharks", Colour: "#d03030", X: xs, Y: sharkY},
+	}
+
+	// Mean-field model fitted to the run, drawn dashed over the data
+	var fit lvReport
+	if m, err := fitLotkaVolterra(fishY, sharkY); err != nil {
+		fit.Error = err.Error()
+	} else {
+		fit.Model = m
+		fit.FishEq, fit.SharkEq = m.equilibrium()
+		fit.Period = m.period()
+		n := len(m.Fish)
+		series = append(series,
+			chartSeries{Name: "fish (Lotka-Volterra)", Colour: "#2a9d3a", X: xs[:n], Y: m.Fish, Dashed: true},
+			chartSeries{Name: "sharks (Lotka-Volterra)", Colour: "#d03030", X: xs[:n], Y: m.Sharks, Dashed: true},
+		)
+	}
+	writeSVGChart(&chart, "Population over time", "chronon", "population", series)
+	writeSVGChart(&phase, "Phase plot", "fish", "sharks", []chartSeries{
+		{Name: "trajectory", Colour: "#3050a0", X: fishY, Y: sharkY},
+	})
+
+	frames := append([]*Frame{}, r.snapshots...)
+	if frames[len(frames)-1] != r.last {
+		frames = append(frames, r.last)
+	}
+	snaps := []reportSnapshot{}
+	for _, f := range frames {
+		uri, err := frameDataURI(f)
+		if err != nil {
+			return err
+		}
+		snaps = append(snaps, reportSnapshot{Chronon: f.Chronon, Fish: f.Fish, Sharks: f.Sharks, Image: uri})
+	}
+
+	data := map[string]any{
+		"Params":     r.params,
+		"Scheme":     schemeName(r.params.Scheme),
+		"Chronons":   len(r.fish),
+		"Chart":      template.HTML(chart.String()),
+		"Phase":      template.HTML(phase.String()),
+		"Fit":        fit,
+		"Events":     r.keyEvents(),
+		"Snapshots":  snaps,
+		"Provenance": r.prov,
+	}
+
+	file, err := os.Create(r.path)
+	if err != nil {
+		return err
+	}
+	if err := reportTemplate.Execute(file, data); err != nil {
+		file.Close()
+		return err
+	}
+	return file.Close()
+}
+
+/*!
+ * \brief Derive the key events timeline from the recorded history.
+ * \return Events in chronological order.
+ */
+func (r *reportSink) keyEvents() []reportEvent {
+	loc := userLocale()
+	events := []reportEvent{{0, fmt.Sprintf("Start: %s fish, %s sharks placed", loc.integer(r.params.NumFish), loc.integer(r.params.NumShark))}}
+
+	peak := func(series []int) int {
+		best := 0
+		for i, v := range series {
+			if v > series[best] {
+				best = i
+			}
+		}
+		return best
+	}
+	fp, sp := peak(r.fish), peak(r.sharks)
+	events = append(events,
+		reportEvent{fp, "Fish peak: " + loc.integer(r.fish[fp])},
+		reportEvent{sp, "Shark peak: " + loc.integer(r.sharks[sp])},
+	)
+
+	busiest := peak(r.births)
+	if r.births[busiest] > 0 {
+		events = append(events, reportEvent{busiest, "Most births in one chronon: " + loc.integer(r.births[busiest])})
+	}
+	deadliest := peak(r.deaths)
+	if r.deaths[deadliest] > 0 {
+		events = append(events, reportEvent{deadliest, "Most deaths in one chronon: " + loc.integer(r.deaths[deadliest])})
+	}
+
+	for i := range r.fish {
+		if r.sharks[i] > r.fish[i] && (i == 0 || r.sharks[i-1] <= r.fish[i-1]) {
+			events = append(events, reportEvent{i, "Sharks outnumber fish"})
+			break
+		}
+	}
+	for i := range r.fish {
+		if r.sharks[i] == 0 {
+			events = append(events, reportEvent{i, "Sharks extinct"})
+			break
+		}
+	}
+	for i := range r.fish {
+		if r.fish[i] == 0 {
+			events = append(events, reportEvent{i, "Fish extinct"})
+			break
+		}
+	}
+	end := len(r.fish) - 1
+	events = append(events, reportEvent{end, fmt.Sprintf("End: %s fish, %s sharks", loc.integer(r.fish[end]), loc.integer(r.sharks[end]))})
+
+	// Insertion sort keeps equal chronons in the order added
+	for i := 1; i < len(events); i++ {
+		for j := i; j > 0 && events[j].Chronon < events[j-1].Chronon; j-- {
+			events[j], events[j-1] = events[j-1], events[j]
+		}
+	}
+	return events
+}
+
+/*!
+ * \brief Encode a frame as a PNG data URI.
+ * \param f The frame to encode.
+ * \return The data URI, or an encoding error.
+ */
+func frameDataURI(f *Frame) (template.URL, error) {
+	img := image.NewPaletted(image.Rect(0, 0, f.Size*snapshotScale, f.Size*snapshotScale), gifPalette)
+	for y := 0; y < f.Size*snapshotScale; y++ {
+		for x := 0; x < f.Size*snapshotScale; x++ {
+			img.Pix[y*img.Stride+x] = uint8(f.At(x/snapshotScale, y/snapshotScale))
+		}
+	}
+	var buf bytes.Buffer
+	if err := png.Encode(&buf, img); err != nil {
+		return "", err
+	}
+	return template.URL("data:image/png;base64," + base64.StdEncoding.EncodeToString(buf.Bytes())), nil
+}
+
+/*!
+ * \brief Name of an update scheme as accepted by -scheme.
+ * \param scheme The update scheme.
+ * \return "raster", "checkerboard", "shuffle", "phased" or "priority".
+ */
+func schemeName(scheme UpdateScheme) string {
+	switch scheme {
+	case Checkerboard:
+		return "checkerboard"
+	case Shuffle:
+		return "shuffle"
+	case Phased:
+		return "phased"
+	case Priority:
+		return "priority"
+	}
+	return "raster"
+}
+
+/*!
+ * \brief Number formats of the HTML report, for the user's locale.
+ */
+var reportFuncs = template.FuncMap{
+	"int":   func(n int) string { return userLocale().integer(n) },
+	"fixed": func(decimals int, v float64) string { return userLocale().fixed(v, decimals) },
+	"sig":   func(digits int, v float64) string { return userLocale().sig(v, digits) },
+}
+
+/*!
+ * \brief Layout of the HTML report.
+ */
+var reportTemplate = template.Must(template.New("report").Funcs(reportFuncs).Parse(`<!DOCTYPE html>
+<html lang="en">
+<head>
+<meta charset="utf-8">
+<title>Wa-Tor run report</title>
+<style>
+body { font-family: sans-serif; margin: 2em auto; max-width: 760px; color: #222; }
+table { border-collapse: collapse; margin-bottom: 1em; }
+td, th { border: 1px solid #ccc; padding: 4px 10px; text-align: left; }
+.snaps { display: flex; flex-wrap: wrap; gap: 12px; }
+.snaps figure { margin: 0; }
+.snaps img { image-rendering: pixelated; border: 1px solid #999; }
+</style>
+</head>
+<body>
+<h1>Wa-Tor run report</h1>
+
+<h2>Parameters</h2>
+<table>
+<tr><th>Grid size</th><td>{{int .Params.GridSize}} &times; {{int .Params.GridSize}}</td></tr>
+<tr><th>Initial fish</th><td>{{int .Params.NumFish}}</td></tr>
+<tr><th>Initial sharks</th><td>{{int .Params.NumShark}}</td></tr>
+<tr><th>Fish breed time</th><td>{{.Params.FishBreed}}</td></tr>
+<tr><th>Shark breed time</th><td>{{.Params.SharkBreed}}</td></tr>
+<tr><th>Shark starve time</th><td>{{.Params.Starve}}</td></tr>
+<tr><th>Update scheme</th><td>{{.Scheme}}</td></tr>
+<tr><th>Workers</th><td>{{.Params.Workers}}</td></tr>
+<tr><th>Chronons run</th><td>{{int .Chronons}}</td></tr>
+</table>
+
+<h2>Population</h2>
+{{.Chart}}
+
+<h2>Lotka-Volterra fit</h2>
+{{with .Fit.Model}}<p>The dashed lines in the population chart are the mean-field model
+dF/dt = aF &minus; bFS, dS/dt = cFS &minus; dS fitted to this run.</p>
+<table>
+<tr><th>a (fish growth)</th><td>{{sig 4 .A}}</td></tr>
+<tr><th>b (predation)</th><td>{{sig 4 .B}}</td></tr>
+<tr><th>c (shark conversion)</th><td>{{sig 4 .C}}</td></tr>
+<tr><th>d (shark death)</th><td>{{sig 4 .D}}</td></tr>
+<tr><th>Equilibrium fish / sharks</th><td>{{fixed 0 $.Fit.FishEq}} / {{fixed 0 $.Fit.SharkEq}} (observed means {{fixed 0 .FishMean}} / {{fixed 0 .SharkMean}})</td></tr>
+<tr><th>Oscillation period</th><td>{{fixed 1 $.Fit.Period}} chronons</td></tr>
+<tr><th>R&sup2; fish / sharks</th><td>{{fixed 3 .FishR2}} / {{fixed 3 .SharkR2}}</td></tr>
+</table>
+{{else}}<p>No fit: {{.Fit.Error}}.</p>
+{{end}}
+<h2>Phase plot</h2>
+{{.Phase}}
+
+<h2>Key events</h2>
+<table>
+<tr><th>Chronon</th><th>Event</th></tr>
+{{range .Events}}<tr><td>{{int .Chronon}}</td><td>{{.Text}}</td></tr>
+{{end}}</table>
+
+<h2>Snapshots</h2>
+<div class="snaps">
+{{range .Snapshots}}<figure><img src="{{.Image}}" alt="chronon {{.Chronon}}"><figcaption>Chronon {{int .Chronon}}: {{int .Fish}} fish, {{int .Sharks}} sharks</figcaption></figure>
+{{end}}</div>
+{{with .Provenance}}
+<h2>Provenance</h2>
+<table>
+<tr><th>Program</th><td>{{.Program}}, {{.Go}}</td></tr>
+{{if .Commit}}<tr><th>Commit</th><td>{{.Commit}}</td></tr>
+{{end}}<tr><th>Command</th><td>{{.Command}}</td></tr>
+{{if .Seed}}<tr><th>Seed</th><td>{{.Seed}}</td></tr>
+{{end}}{{if .Resumed}}<tr><th>Resumed from</th><td>{{.Resumed}}</td></tr>
+{{end}}{{if .Host}}<tr><th>Host</th><td>{{.Host}}</td></tr>
+{{end}}<tr><th>Started</th><td>{{.Started}}</td></tr>
+</table>
+<details><summary>Settings</summary>
+<table>
+{{range $name, $value := .Config}}<tr><th>{{$name}}</th><td>{{$value}}</td></tr>
+{{end}}</table>
+</details>
+{{end}}
+</body>
+</html>
+`))
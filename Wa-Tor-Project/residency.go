@@ -0,0 +1,193 @@
+/*!
+ * \file residency.go
+ * \brief Residency maps: how often each cell held fish or sharks over the whole run.
+ *
+ * Any single frame is a snapshot of creatures that keep moving, but over
+ * a long run some cells hold fish or sharks far more often than others:
+ * territories behind land, around reefs or along a climate band. The
+ * residency sinks count, for every cell, the chronons it held a fish and
+ * the chronons it held a shark (eggs included), and when the run ends
+ * write the counts as shares of the chronons observed, as CSV or as a PNG
+ * heatmap with fish in green and sharks in red. Each species is scaled to
+ * the 99th percentile of its cells, so a handful of exceptional cells do
+ * not leave the rest of the map dark.
+ */
+
+package main
+
+import (
+	"encoding/csv"
+	"image"
+	"image/color"
+	"image/png"
+	"os"
+	"sort"
+	"strconv"
+)
+
+/*!
+ * \brief Counts how often every cell held fish and sharks, and writes them when closed.
+ */
+type residencySink struct {
+	path   string  ///< Output file path
+	image  bool    ///< Write a PNG heatmap rather than CSV
+	size   int     ///< Width/height of the grid counted
+	frames int     ///< Frames counted
+	fish   []int32 ///< Chronons each cell held a fish, row-major
+	sharks []int32 ///< Chronons each cell held a shark, row-major
+	land   []bool  ///< Cells that were land in the last frame, row-major
+}
+
+/*!
+ * \brief Create a residency CSV sink.
+ * \param path Output file path.
+ * \param params Parameters of the run (unused).
+ * \return The sink, or an error if the file cannot be created.
+ *
+ * The file is created immediately so a bad path fails before the run.
+ */
+func openResidencySink(path string, params Config) (Observer, error) {
+	file, err := os.Create(path)
+	if err != nil {
+		return nil, err
+	}
+	file.Close()
+	return &residencySink{path: path}, nil
+}
+
+/*!
+ * \brief Create a residency heatmap sink.
+ * \param path Output PNG file path.
+ * \param params Parameters of the run (unused).
+ * \return The sink, or an error if the file cannot be created.
+ */
+func openResidencyImageSink(path string, params Config) (Observer, error) {
+	obs, err := openResidencySink(path, params)
+	if err != nil {
+		return nil, err
+	}
+	obs.(*residencySink).image = true
+	return obs, nil
+}
+
+/*!
+ * \brief Count the fish and sharks of a frame.
+ * \param f The frame to record.
+ * \return nil.
+ */
+func (s *residencySink) Observe(f *Frame) error {
+	if s.size != f.Size {
+		s.size, s.frames = f.Size, 0
+		s.fish, s.sharks = make([]int32, f.Size*f.Size), make([]int32, f.Size*f.Size)
+		s.land = make([]bool, f.Size*f.Size)
+	}
+	s.frames++
+	for i, sp := range f.Cells() {
+		switch sp {
+		case Fish:
+			s.fish[i]++
+		case Shark:
+			s.sharks[i]++
+		}
+		s.land[i] = sp == Land
+	}
+	return nil
+}
+
+/*!
+ * \brief Write the residency of every cell.
+ * \return Any encoding or file error.
+ */
+func (s *residencySink) Close() error {
+	if s.frames == 0 {
+		return nil
+	}
+	file, err := os.Create(s.path)
+	if err != nil {
+		return err
+	}
+	if s.image {
+		err = png.Encode(file, s.heatmap())
+	} else {
+		err = s.writeCSV(file)
+	}
+	if cerr := file.Close(); err == nil {
+		err = cerr
+	}
+	return err
+}
+
+/*!
+ * \brief Write the residency as CSV.
+ * \param file The output file.
+ * \return Any write error.
+ *
+ * One row per cell, x,y,fish,sharks, the shares of the chronons observed
+ * that the cell held a fish and a shark.
+ */
+func (s *residencySink) writeCSV(file *os.File) error {
+	if artifactProvenance != nil {
+		if err := artifactProvenance.writeComments(file); err != nil {
+			return err
+		}
+	}
+	w := csv.NewWriter(file)
+	w.Write([]string{"x", "y", "fish", "sharks"})
+	share := func(n int32) string {
+		return strconv.FormatFloat(float64(n)/float64(s.frames), 'f', 4, 64)
+	}
+	for y := 0; y < s.size; y++ {
+		for x := 0; x < s.size; x++ {
+			i := y*s.size + x
+			w.Write([]string{strconv.Itoa(x), strconv.Itoa(y), share(s.fish[i]), share(s.sharks[i])})
+		}
+	}
+	w.Flush()
+	return w.Error()
+}
+
+/*!
+ * \brief Count at the 99th percentile of the water cells.
+ * \param counts Count per cell.
+ * \param land Land cells, left out.
+ * \return The count, at least 1.
+ */
+func residencyScale(counts []int32, land []bool) int32 {
+	var water []int32
+	for i, n := range counts {
+		if !land[i] {
+			water = append(water, n)
+		}
+	}
+	if len(water) == 0 {
+		return 1
+	}
+	sort.Slice(water, func(i, j int) bool { return water[i] < water[j] })
+	return max(1, water[len(water)*99/100])
+}
+
+/*!
+ * \brief Draw the residency heatmap.
+ * \return An image of gifScale pixels per cell: fish residency in green
+ *         and shark residency in red, each relative to the 99th
+ *         percentile of its species, over dark water; land in the land
+ *         colour of the GIF.
+ */
+func (s *residencySink) heatmap() image.Image {
+	fish, sharks := int64(residencyScale(s.fish, s.land)), int64(residencyScale(s.sharks, s.land))
+	shade := func(n int32, scale int64) uint8 {
+		return uint8(min(255, 255*int64(n)/scale))
+	}
+	img := image.NewRGBA(image.Rect(0, 0, s.size*gifScale, s.size*gifScale))
+	for y := 0; y < s.size*gifScale; y++ {
+		for x := 0; x < s.size*gifScale; x++ {
+			i := y/gifScale*s.size + x/gifScale
+			c := color.RGBA{shade(s.sharks[i], sharks), shade(s.fish[i], fish), 0x30, 0xff}
+			if s.land[i] {
+				c = gifPalette[Land].(color.RGBA)
+			}
+			img.SetRGBA(x, y, c)
+		}
+	}
+	return img
+}
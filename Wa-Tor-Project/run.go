@@ -0,0 +1,430 @@
+/*!
+ * \file run.go
+ * \brief The run subcommand: one simulation drawn by a renderer and recorded by output sinks.
+ */
+
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"os"
+	"os/signal"
+	"syscall"
+	"time"
+)
+
+/*!
+ * \brief Entry point of the run subcommand.
+ * \param args Command-line arguments after "run".
+ * \return Process exit code.
+ *
+ * It creates the simulation and iteratively processes chronons. Each
+ * chronon is published as a Frame to the selected renderer and output
+ * sinks, each running in its own goroutine.
+ */
+func runCommand(args []string) int {
+	fs := newCommandFlags("run")
+	params := defaultConfig()
+	cfg := registerConfigFlags(fs, &params)
+	cps := fs.Float64("cps", 10, "target chronons per second (0 = as fast as possible)")
+	memstats := fs.Bool("memstats", false, "report heap and GC statistics at the end of the run")
+	lifestats := fs.Bool("lifestats", false, "report lifespan, offspring and kill distributions at the end of the run")
+	var alerts stringList
+	fs.Var(&alerts, "alert", "population alert rule, e.g. \"sharks<10 for 50\" (repeatable)")
+	webhook := fs.String("alert-webhook", "", "`URL` to POST alert notices to as JSON")
+	record := fs.String("record", "", "save the settings and seed of the run to a .wator `file` for replay")
+	chronons := fs.Int("chronons", defaultChronons, "stop after this many chronons (0 = run until a stop condition, -duration or an interrupt)")
+	duration := fs.Duration("duration", 0, "stop after this wall-clock `time`, e.g. 5m (0 = no limit)")
+	checkpointEvery := fs.Int("checkpoint-every", 0, "write a checkpoint every N chronons so an interrupted run can be resumed (0 = off)")
+	checkpointPath := fs.String("checkpoint", defaultCheckpointPath(), "checkpoint `file`")
+	checkpointEncoding := fs.String("checkpoint-encoding", "binary", "checkpoint encoding: json, binary or gzip (compressed binary)")
+	resume := fs.Bool("resume", false, "resume the interrupted run from its checkpoint without asking")
+	snapshotEvery := fs.Int("snapshot-every", 0, "keep a numbered snapshot of the world every N chronons, from the start on (0 = off)")
+	snapshotDir := fs.String("snapshot-dir", "snapshots", "`directory` of the -snapshot-every snapshots, created if missing")
+	snapshotEncoding := fs.String("snapshot-encoding", "binary", "encoding of the -snapshot-every snapshots: json, binary or gzip")
+	sharksOnly := fs.Bool("stop-sharks-only", false, "stop once the fish are extinct and the sharks can live on by breeding alone")
+	fishOnly := fs.Int("stop-fish-only", 0, "stop once the sharks are extinct and the fish population has not changed for N chronons (0 = never)")
+	outputs := registerObserverFlags(fs)
+	if err := fs.Parse(args); err != nil {
+		return flagExit(err)
+	}
+
+	seed, err := cfg.resolve()
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		return exitConfig
+	}
+	if *cfg.dryRun {
+		writeDryRun(os.Stdout, cfg, seed)
+		return exitOK
+	}
+	if *checkpointEvery < 0 {
+		fmt.Fprintf(os.Stderr, "invalid -checkpoint-every %d: must not be negative\n", *checkpointEvery)
+		return exitConfig
+	}
+	if *chronons < 0 {
+		fmt.Fprintf(os.Stderr, "invalid -chronons %d: must not be negative\n", *chronons)
+		return exitConfig
+	}
+	if *fishOnly < 0 {
+		fmt.Fprintf(os.Stderr, "invalid -stop-fish-only %d: must not be negative\n", *fishOnly)
+		return exitConfig
+	}
+	if *snapshotEvery < 0 {
+		fmt.Fprintf(os.Stderr, "invalid -snapshot-every %d: must not be negative\n", *snapshotEvery)
+		return exitConfig
+	}
+	for _, encoding := range []string{*checkpointEncoding, *snapshotEncoding} {
+		if err := checkSnapshotEncoding(encoding); err != nil {
+			fmt.Fprintln(os.Stderr, err)
+			return exitConfig
+		}
+	}
+
+	// A checkpoint left behind by an interrupted run replaces the settings
+	var sim *Simulation
+	var resumed *runOutcome
+	if *checkpointEvery > 0 || *resume {
+		cp, err := findCheckpoint(*checkpointPath, *resume, os.Stderr)
+		if err == nil && cp != nil {
+			sim, err = cp.restore()
+			resumed = &cp.Outcome
+		}
+		if err != nil {
+			fmt.Fprintln(os.Stderr, err)
+			return exitConfig
+		}
+	}
+	if sim != nil {
+		params, seed = sim.Params, sim.Seed
+	} else {
+		sim = newSimulation(params, seed)
+	}
+
+	// Every file the run writes names the program and settings that made it
+	artifactProvenance = newProvenance("run", fs, seed)
+	if resumed != nil {
+		artifactProvenance.Resumed = *checkpointPath
+		if err := outputs.checkFresh(); err != nil {
+			fmt.Fprintln(os.Stderr, err)
+			return exitConfig
+		}
+	}
+	observers, err := outputs.open(params)
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		return exitFailure
+	}
+	sim.hunger, sim.fishMap, sim.flow = outputs.hunger(), outputs.fishMap(), outputs.flow()
+	if *lifestats {
+		observers = append(observers, newLifeStats(outputs.human()))
+	}
+	if len(alerts) > 0 {
+		alerter, err := newAlertObserver(alerts, os.Stderr, *webhook)
+		if err != nil {
+			fmt.Fprintln(os.Stderr, err)
+			return exitConfig
+		}
+		observers = append(observers, alerter)
+	}
+	if *record != "" {
+		if err := saveScenario(*record, params, seed, cfg.description); err != nil {
+			fmt.Fprintln(os.Stderr, err)
+			return exitFailure
+		}
+	}
+
+	human := outputs.human()
+	if cfg.description != "" {
+		fmt.Fprintln(human, cfg.description)
+	}
+	fmt.Fprintln(human, "Wa-Tor Simulation:")
+	if resumed != nil {
+		fmt.Fprintf(human, "Resuming from chronon %d of %s with its settings\n", sim.Chronon, *checkpointPath)
+	}
+
+	var mem *memTracker
+	if *memstats {
+		mem = newMemTracker()
+	}
+
+	loop := runLoop{gov: newGovernor(*cps), chronons: *chronons, mem: mem, resumed: resumed, sharksOnly: *sharksOnly, fishOnly: *fishOnly}
+	if *duration > 0 {
+		loop.deadline = time.Now().Add(*duration)
+	}
+	if *checkpointEvery > 0 || resumed != nil {
+		loop.checkpoints = &checkpointer{path: *checkpointPath, every: *checkpointEvery,
+			encoding: *checkpointEncoding, errOut: os.Stderr}
+	}
+	if *snapshotEvery > 0 {
+		if loop.snapshots, err = newSnapshotSeries(*snapshotDir, *snapshotEvery, *snapshotEncoding, os.Stderr); err != nil {
+			fmt.Fprintln(os.Stderr, err)
+			return exitFailure
+		}
+	}
+
+	// An interrupt ends the run after the current chronon, as -duration does
+	interrupts := make(chan os.Signal, 1)
+	signal.Notify(interrupts, os.Interrupt, syscall.SIGTERM)
+	defer signal.Stop(interrupts)
+	loop.interrupts = interrupts
+
+	// Tunable parameters can be changed mid-run by editing the config file
+	if *cfg.config != "" {
+		watcher := watchConfig(*cfg.config, time.Second)
+		defer watcher.stop()
+		loop.reloads = watcher.changed
+		loop.reload = func() { reloadConfig(os.Stderr, cfg, sim) }
+	}
+
+	outcome := simulate(sim, observers, loop)
+	writeRunSummary(human, outcome, loop.gov, *cps, mem)
+	outputs.writeSkipped(human)
+	if loop.snapshots != nil {
+		fmt.Fprintf(human, "Wrote %s snapshots to %s\n", userLocale().integer(loop.snapshots.written), *snapshotDir)
+	}
+	return writeOutcome(os.Stdout, outputs.output == "json", outcome, seed)
+}
+
+/*!
+ * \brief How a run ended.
+ */
+type runOutcome struct {
+	Chronons      int    ///< Chronons simulated
+	Fish          int    ///< Fish alive at the end
+	Sharks        int    ///< Sharks alive at the end
+	FishExtinct   int    ///< Chronon after which no fish were left, or -1
+	SharksExtinct int    ///< Chronon after which no sharks were left, or -1
+	Reason        string ///< Why the run stopped: "max-chronons", "extinction", "sharks-only", "fish-only", "duration", "interrupted" or "recording" (frame log played to its end)
+}
+
+/*!
+ * \brief Classify the end of a run.
+ * \return Status name and the matching exit code.
+ */
+func (o runOutcome) status() (string, int) {
+	switch {
+	case o.Fish == 0 && o.Sharks == 0:
+		return "extinct", exitExtinct
+	case o.Sharks == 0:
+		return "sharks-extinct", exitSharksExtinct
+	case o.Fish == 0:
+		return "fish-extinct", exitFishExtinct
+	}
+	return "completed", exitOK
+}
+
+/*!
+ * \brief JSON form of the summary line.
+ */
+type outcomeRecord struct {
+	Status        string `json:"status"`
+	Exit          int    `json:"exit"`
+	Reason        string `json:"reason"`
+	Chronons      int    `json:"chronons"`
+	Fish          int    `json:"fish"`
+	Sharks        int    `json:"sharks"`
+	FishExtinct   int    `json:"fish_extinct"`
+	SharksExtinct int    `json:"sharks_extinct"`
+	Seed          int64  `json:"seed"`
+}
+
+/*!
+ * \brief Write the machine-readable summary line of a run.
+ * \param out Destination of the line.
+ * \param asJSON Write a JSON object instead of "summary key=value ...".
+ * \param o The outcome.
+ * \param seed Seed of the run.
+ * \return The exit code of the outcome.
+ */
+func writeOutcome(out io.Writer, asJSON bool, o runOutcome, seed int64) int {
+	status, code := o.status()
+	r := outcomeRecord{status, code, o.Reason, o.Chronons, o.Fish, o.Sharks, o.FishExtinct, o.SharksExtinct, seed}
+	if asJSON {
+		json.NewEncoder(out).Encode(r)
+	} else {
+		fmt.Fprintf(out, "summary status=%s exit=%d reason=%s chronons=%d fish=%d sharks=%d fish_extinct=%d sharks_extinct=%d seed=%d\n",
+			r.Status, r.Exit, r.Reason, r.Chronons, r.Fish, r.Sharks, r.FishExtinct, r.SharksExtinct, r.Seed)
+	}
+	return code
+}
+
+/*!
+ * \brief Pacing, limits and hooks of a simulation loop.
+ */
+type runLoop struct {
+	gov         *governor        ///< Paces the chronons
+	chronons    int              ///< Chronon at which the run stops (0 = no limit)
+	mem         *memTracker      ///< Sampled after every chronon, or nil
+	reloads     <-chan struct{}  ///< Receives a value when reload should be called before the next chronon, or nil
+	reload      func()           ///< Applies changed settings to the simulation
+	deadline    time.Time        ///< Wall-clock time after which the run stops (zero = no limit)
+	interrupts  <-chan os.Signal ///< Receives a signal when the run should stop after the current chronon, or nil
+	checkpoints *checkpointer    ///< Writes rolling checkpoints, or nil
+	snapshots   *snapshotSeries  ///< Writes the numbered snapshots, or nil
+	resumed     *runOutcome      ///< Outcome so far of a run resumed from a checkpoint, or nil
+	sharksOnly  bool             ///< Stop once only sharks that can live without fish remain
+	fishOnly    int              ///< Chronons the fish population must hold still after the sharks are extinct before the run stops (0 = never)
+	steadyFish  int              ///< Fish population at the last change once only fish remain
+	steadyFor   int              ///< Chronons the fish population has held at steadyFish
+}
+
+/*!
+ * \brief Check whether the populations end the run.
+ * \param sim The simulation after a chronon.
+ * \return The reason to stop, or "" to go on.
+ *
+ * Must be called after every chronon. Fish never come back once extinct.
+ * Sharks that starve before they can breed die out within a few
+ * chronons, so they are left to, and the run ends on extinction; sharks
+ * that breed sooner can fill the world on their own for the rest of the
+ * run. Without sharks nothing eats the fish, so their population only
+ * grows until the water they can reach is full; once it has held still
+ * for the grace period the world has settled.
+ */
+func (l *runLoop) stopReason(sim *Simulation) string {
+	fish, sharks := sim.Population()
+	switch {
+	case fish == 0 && sharks == 0:
+		return "extinction"
+	case fish == 0 && l.sharksOnly && sim.World.SharkBreed < sim.World.Starve:
+		return "sharks-only"
+	case sharks == 0 && l.fishOnly > 0:
+		if fish != l.steadyFish {
+			l.steadyFish, l.steadyFor = fish, 0
+			break
+		}
+		if l.steadyFor++; l.steadyFor >= l.fishOnly {
+			return "fish-only"
+		}
+	}
+	return ""
+}
+
+/*!
+ * \brief Run a simulation until stopReason ends it, the chronon limit or the deadline is reached, or it is interrupted.
+ * \param sim The simulation.
+ * \param observers Renderers and sinks receiving every frame; they are closed at the end.
+ * \param loop Pacing, limits and hooks.
+ * \return How the run ended.
+ *
+ * The deadline and interrupts are checked between chronons, so the run
+ * always stops on a complete chronon that every observer has seen. A
+ * resumed simulation continues from its current chronon.
+ */
+func simulate(sim *Simulation, observers []Observer, loop runLoop) runOutcome {
+	// Renderers and sinks consume frames in their own goroutines
+	bus := &frameBus{}
+	for _, obs := range observers {
+		bus.attach(obs)
+	}
+
+	outcome := runOutcome{FishExtinct: -1, SharksExtinct: -1}
+	if loop.resumed != nil {
+		outcome = *loop.resumed
+	}
+	outcome.Reason = "max-chronons"
+	if loop.snapshots != nil {
+		loop.snapshots.observe(sim, outcome)
+	}
+	for loop.chronons == 0 || sim.Chronon < loop.chronons {
+		if !loop.deadline.IsZero() && !time.Now().Before(loop.deadline) {
+			outcome.Reason = "duration"
+			break
+		}
+		select {
+		case <-loop.interrupts:
+			outcome.Reason = "interrupted"
+		case <-loop.reloads:
+			loop.reload()
+		default:
+		}
+		if outcome.Reason == "interrupted" {
+			break
+		}
+
+		sim.Step()
+
+		frame := sim.Frame()
+		bus.publish(frame)
+
+		if loop.mem != nil {
+			loop.mem.sample()
+		}
+
+		outcome.Chronons, outcome.Fish, outcome.Sharks = frame.Chronon+1, frame.Fish, frame.Sharks
+		if frame.Fish == 0 && outcome.FishExtinct < 0 {
+			outcome.FishExtinct = frame.Chronon
+		}
+		if frame.Sharks == 0 && outcome.SharksExtinct < 0 {
+			outcome.SharksExtinct = frame.Chronon
+		}
+
+		// Stop if all life extinct, or nothing but sharks is left to simulate
+		if reason := loop.stopReason(sim); reason != "" {
+			outcome.Reason = reason
+			break
+		}
+		if loop.checkpoints != nil && loop.checkpoints.every > 0 && sim.Chronon%loop.checkpoints.every == 0 {
+			loop.checkpoints.save(sim, outcome)
+		}
+		if loop.snapshots != nil {
+			loop.snapshots.observe(sim, outcome)
+		}
+
+		loop.gov.wait()
+	}
+	if loop.checkpoints != nil {
+		// A run stopped by the time limit or an interrupt can be resumed later
+		if outcome.Reason == "duration" || outcome.Reason == "interrupted" {
+			loop.checkpoints.save(sim, outcome)
+		} else {
+			loop.checkpoints.remove()
+		}
+	}
+	if err := bus.close(); err != nil {
+		fmt.Fprintln(os.Stderr, err)
+	}
+	return outcome
+}
+
+/*!
+ * \brief Print how the run ended, the achieved rate and the memory report.
+ * \param out Destination of the summary.
+ * \param outcome How the run ended.
+ * \param gov The governor that paced the run.
+ * \param cps Target chronons per second.
+ * \param mem Memory tracker of the run, or nil.
+ */
+func writeRunSummary(out io.Writer, outcome runOutcome, gov *governor, cps float64, mem *memTracker) {
+	if outcome.Fish == 0 && outcome.Sharks == 0 {
+		fmt.Fprintln(out, "All life extinct!")
+	}
+	loc := userLocale()
+	if outcome.Reason == "fish-only" {
+		fmt.Fprintf(out, "Sharks extinct after chronon %s; stopped with the fish settled at %s\n",
+			loc.integer(outcome.SharksExtinct), loc.integer(outcome.Fish))
+	}
+	if outcome.Reason == "sharks-only" {
+		fmt.Fprintf(out, "Fish extinct after chronon %s; stopped with %s sharks living on by breeding alone\n",
+			loc.integer(outcome.FishExtinct), loc.integer(outcome.Sharks))
+	}
+	if outcome.Reason == "duration" {
+		fmt.Fprintf(out, "Time limit reached after %s chronons\n", loc.integer(outcome.Chronons))
+	}
+	if outcome.Reason == "interrupted" {
+		fmt.Fprintf(out, "Interrupted after %s chronons\n", loc.integer(outcome.Chronons))
+	}
+
+	if cps > 0 {
+		fmt.Fprintf(out, "Achieved %s chronons/sec (target %s)\n", loc.fixed(gov.rate(), 1), loc.fixed(cps, 1))
+	} else {
+		fmt.Fprintf(out, "Achieved %s chronons/sec\n", loc.fixed(gov.rate(), 1))
+	}
+
+	if mem != nil {
+		mem.report(out)
+	}
+}
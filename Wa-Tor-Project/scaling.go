@@ -0,0 +1,278 @@
+/*!
+ * \file scaling.go
+ * \brief The scaling subcommand: simulation speed across grid sizes and densities.
+ *
+ * The benchmarks measure a few fixed cases and -workers shows how
+ * stepping scales with threads. scaling runs every combination of a list
+ * of grid sizes and of initial densities with the stepping options given
+ * (so at a fixed number of workers) and measures the chronons per second
+ * of each, to characterise how the implementation scales with the size
+ * of the world. As in the benchmarks, a world is re-populated every 100
+ * chronons, outside the timing, so the density stays close to the
+ * requested one.
+ *
+ * Per density, a least-squares line through log time per chronon
+ * against log cells gives the exponent of the growth: 1 is linear in the
+ * area, less means the fixed costs of a chronon still dominate, more that
+ * large worlds fall out of the caches.
+ */
+
+package main
+
+import (
+	"encoding/csv"
+	"fmt"
+	"io"
+	"math"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+)
+
+/*!
+ * \brief Initial density of a scaling case.
+ */
+type scalingDensity struct {
+	Fish   float64 ///< Fraction of cells initially holding a fish
+	Sharks float64 ///< Fraction of cells initially holding a shark
+}
+
+/*!
+ * \brief Speed measured for one grid size and density.
+ */
+type scalingResult struct {
+	Size     int            ///< Width/height of the grid
+	Density  scalingDensity ///< Initial density
+	Chronons int            ///< Chronons timed
+	Elapsed  time.Duration  ///< Time spent stepping them
+}
+
+/*!
+ * \brief Chronons per second of a result.
+ * \return The rate.
+ */
+func (r scalingResult) rate() float64 {
+	return float64(r.Chronons) / r.Elapsed.Seconds()
+}
+
+/*!
+ * \brief Time per cell and chronon of a result.
+ * \return Nanoseconds.
+ */
+func (r scalingResult) nsPerCell() float64 {
+	return float64(r.Elapsed.Nanoseconds()) / float64(r.Chronons) / float64(r.Size*r.Size)
+}
+
+/*!
+ * \brief Parse a list of grid sizes.
+ * \param s Sizes separated by commas, e.g. "50,100,200".
+ * \return The sizes, or an error for a size out of range.
+ */
+func parseScalingSizes(s string) ([]int, error) {
+	var sizes []int
+	for _, field := range strings.Split(s, ",") {
+		n, err := strconv.Atoi(strings.TrimSpace(field))
+		if err != nil || n < 2 || n > maxGridSize {
+			return nil, fmt.Errorf("invalid -sizes entry %q: want a grid size between 2 and %d", field, maxGridSize)
+		}
+		sizes = append(sizes, n)
+	}
+	return sizes, nil
+}
+
+/*!
+ * \brief Parse a list of initial densities.
+ * \param s Densities separated by commas, each FISH/SHARKS in percent of the cells, e.g. "12/4,50/10".
+ * \return The densities, or an error for a malformed or overfull one.
+ */
+func parseScalingDensities(s string) ([]scalingDensity, error) {
+	var densities []scalingDensity
+	for _, field := range strings.Split(s, ",") {
+		fish, sharks, ok := strings.Cut(strings.TrimSpace(field), "/")
+		f, ferr := strconv.ParseFloat(fish, 64)
+		k, kerr := strconv.ParseFloat(sharks, 64)
+		if !ok || ferr != nil || kerr != nil || f < 0 || k < 0 || f+k > 100 {
+			return nil, fmt.Errorf("invalid -densities entry %q: want FISH/SHARKS in percent, together at most 100", field)
+		}
+		densities = append(densities, scalingDensity{f / 100, k / 100})
+	}
+	return densities, nil
+}
+
+/*!
+ * \brief Parameters for a benchmark world.
+ * \param params Base parameters.
+ * \param size Grid size.
+ * \param fishDensity Fraction of cells initially holding a fish.
+ * \param sharkDensity Fraction of cells initially holding a shark.
+ * \return The adjusted Config.
+ */
+func benchConfig(params Config, size int, fishDensity, sharkDensity float64) Config {
+	cells := float64(size * size)
+	params.GridSize = size
+	params.NumFish = int(cells * fishDensity)
+	params.NumShark = int(cells * sharkDensity)
+	return params
+}
+
+/*!
+ * \brief Time the stepping of one grid size and density.
+ * \param params Base parameters; the stepping options apply.
+ * \param size Grid size.
+ * \param d Initial density.
+ * \param minTime Time to step for at least.
+ * \param minChronons Chronons to step at least.
+ * \param seed Seed of the first world; every re-population uses the next.
+ * \return The measurement.
+ */
+func measureScaling(params Config, size int, d scalingDensity, minTime time.Duration, minChronons int, seed int64) scalingResult {
+	cfg := benchConfig(params, size, d.Fish, d.Sharks)
+	r := scalingResult{Size: size, Density: d}
+	sim := newSimulation(cfg, seed)
+	for r.Elapsed < minTime || r.Chronons < minChronons {
+		if r.Chronons > 0 && r.Chronons%100 == 0 {
+			seed++
+			sim = newSimulation(cfg, seed)
+		}
+		start := time.Now()
+		sim.Step()
+		r.Elapsed += time.Since(start)
+		r.Chronons++
+	}
+	return r
+}
+
+/*!
+ * \brief Exponent of the growth of the time per chronon with the cells.
+ * \param results Measurements of one density at several sizes.
+ * \return The slope of log time per chronon against log cells, or NaN with fewer than two sizes.
+ */
+func scalingExponent(results []scalingResult) float64 {
+	if len(results) < 2 {
+		return math.NaN()
+	}
+	var x, y []float64
+	for _, r := range results {
+		x = append(x, math.Log(float64(r.Size*r.Size)))
+		y = append(y, math.Log(r.Elapsed.Seconds()/float64(r.Chronons)))
+	}
+	slope, _ := linearFit(x, y)
+	return slope
+}
+
+/*!
+ * \brief Write the measurements as CSV.
+ * \param path Output file path.
+ * \param results The measurements.
+ * \return Any file error.
+ */
+func writeScalingCSV(path string, results []scalingResult) error {
+	file, err := createCSV(path)
+	if err != nil {
+		return err
+	}
+	w := csv.NewWriter(file)
+	w.Write([]string{"grid", "cells", "fish_density", "shark_density", "chronons", "seconds", "chronons_per_sec", "ns_per_cell"})
+	for _, r := range results {
+		w.Write([]string{
+			strconv.Itoa(r.Size), strconv.Itoa(r.Size * r.Size),
+			strconv.FormatFloat(r.Density.Fish, 'g', -1, 64), strconv.FormatFloat(r.Density.Sharks, 'g', -1, 64),
+			strconv.Itoa(r.Chronons), strconv.FormatFloat(r.Elapsed.Seconds(), 'f', 6, 64),
+			strconv.FormatFloat(r.rate(), 'f', 2, 64), strconv.FormatFloat(r.nsPerCell(), 'f', 3, 64),
+		})
+	}
+	w.Flush()
+	if err := w.Error(); err != nil {
+		file.Close()
+		return err
+	}
+	return file.Close()
+}
+
+/*!
+ * \brief Print the measurements as a table, with the growth exponent of every density.
+ * \param out Destination.
+ * \param results The measurements, grouped by density.
+ * \param densities The densities, in the order measured.
+ */
+func writeScalingTable(out io.Writer, results []scalingResult, densities []scalingDensity) {
+	loc := userLocale()
+	fmt.Fprintf(out, "%8s %12s %7s %7s %9s %13s %11s\n", "grid", "cells", "fish", "sharks", "chronons", "chronons/sec", "ns/cell")
+	for _, d := range densities {
+		var group []scalingResult
+		for _, r := range results {
+			if r.Density == d {
+				group = append(group, r)
+				fmt.Fprintf(out, "%8s %12s %7s %7s %9s %13s %11s\n", fmt.Sprintf("%dx%d", r.Size, r.Size), loc.integer(r.Size*r.Size),
+					loc.percent(100*d.Fish, 0), loc.percent(100*d.Sharks, 0), loc.integer(r.Chronons), loc.fixed(r.rate(), 1), loc.fixed(r.nsPerCell(), 2))
+			}
+		}
+		if e := scalingExponent(group); !math.IsNaN(e) {
+			fmt.Fprintf(out, "Time per chronon grows as cells^%s at %s fish / %s sharks\n",
+				loc.fixed(e, 2), loc.percent(100*d.Fish, 0), loc.percent(100*d.Sharks, 0))
+		}
+	}
+}
+
+/*!
+ * \brief Entry point of the scaling subcommand.
+ * \param args Command-line arguments after "scaling".
+ * \return Process exit code: exitOK, exitConfig for bad settings, or
+ *         exitFailure if the CSV cannot be written.
+ */
+func scalingCommand(args []string) int {
+	fs := newCommandFlags("scaling")
+	params := defaultConfig()
+	cfg := registerConfigFlags(fs, &params)
+	sizes := fs.String("sizes", "50,100,200,500,1000", "grid sizes to measure, separated by commas")
+	densities := fs.String("densities", "5/1,12/4,50/10", "initial densities to measure, FISH/SHARKS in percent of the cells, separated by commas")
+	minTime := fs.Duration("time", time.Second, "step every case for at least this `time`")
+	minChronons := fs.Int("min-chronons", 10, "step every case for at least this many chronons")
+	out := fs.String("o", "", "also write the measurements to this CSV `file`")
+	if err := fs.Parse(args); err != nil {
+		return flagExit(err)
+	}
+
+	seed, err := cfg.resolve()
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		return exitConfig
+	}
+	if *cfg.dryRun {
+		writeDryRun(os.Stdout, cfg, seed)
+		return exitOK
+	}
+	sizeList, err := parseScalingSizes(*sizes)
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		return exitConfig
+	}
+	densityList, err := parseScalingDensities(*densities)
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		return exitConfig
+	}
+	if *minTime <= 0 || *minChronons < 1 {
+		fmt.Fprintln(os.Stderr, "scaling: need a positive -time and -min-chronons")
+		return exitConfig
+	}
+
+	fmt.Printf("Measuring %d grid sizes at %d densities with %d worker(s), %s each\n",
+		len(sizeList), len(densityList), params.Workers, minTime)
+	var results []scalingResult
+	for _, d := range densityList {
+		for _, size := range sizeList {
+			results = append(results, measureScaling(params, size, d, *minTime, *minChronons, seed))
+		}
+	}
+	writeScalingTable(os.Stdout, results, densityList)
+	if *out != "" {
+		artifactProvenance = newProvenance("scaling", fs, seed)
+		if err := writeScalingCSV(*out, results); err != nil {
+			fmt.Fprintln(os.Stderr, err)
+			return exitFailure
+		}
+	}
+	return exitOK
+}
@@ -0,0 +1,765 @@
+/*!
+ * \file scenario.go
+ * \brief Scenario archives bundling configuration, map, layout and description.
+ *
+ * A .wator scenario is a zip archive containing:
+ * - config.json:     flag values, e.g. {"grid": 50, "fish": 300, "scheme": "raster"}
+ * - map.txt:         optional land map, one row per line, '#' land, 'R' reef, '.' water
+ *                    and '1' to '9' polluted water
+ * - layout.txt:      optional initial layout, 'F' fish, 'S' shark and '.' empty
+ * - description.txt: optional free text shown when the scenario is loaded
+ *
+ * Rows may separate cells with spaces, so a grid printed by the plain
+ * renderer can be pasted in as a layout.
+ */
+
+package main
+
+import (
+	"archive/zip"
+	"bufio"
+	"bytes"
+	"encoding/json"
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"os"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+/*!
+ * \brief Names of the files inside a scenario archive.
+ */
+const (
+	scenarioConfig      = "config.json"
+	scenarioMap         = "map.txt"
+	scenarioLayout      = "layout.txt"
+	scenarioDescription = "description.txt"
+)
+
+/*!
+ * \brief Contents of a loaded scenario.
+ */
+type scenario struct {
+	Flags       map[string]string ///< Flag values from config.json
+	Land        []bool            ///< Land map (row-major), or nil
+	Reef        []bool            ///< Reef cells of the land map (row-major), or nil
+	Pollution   []float64         ///< Pollution levels of the land map (row-major), or nil
+	Layout      []Species         ///< Initial layout (row-major), or nil
+	Description string            ///< Free text description
+}
+
+/*!
+ * \brief Read a JSON object of flag values.
+ * \param r Source of the JSON.
+ * \return Flag name to value, or a decoding error.
+ *
+ * Values may be strings, numbers or booleans. A "$schema" key, naming
+ * the JSON Schema of the file for editors, is ignored.
+ */
+func readFlagValues(r io.Reader) (map[string]string, error) {
+	dec := json.NewDecoder(r)
+	dec.UseNumber()
+	raw := map[string]any{}
+	if err := dec.Decode(&raw); err != nil {
+		return nil, err
+	}
+	values := map[string]string{}
+	delete(raw, "$schema")
+	for name, v := range raw {
+		switch v := v.(type) {
+		case string:
+			values[name] = v
+		case json.Number:
+			values[name] = v.String()
+		case bool:
+			values[name] = strconv.FormatBool(v)
+		default:
+			return nil, fmt.Errorf("%s: value must be a string, number or boolean", name)
+		}
+	}
+	return values, nil
+}
+
+/*!
+ * \brief Read a JSON file of flag values.
+ * \param path File path.
+ * \return Flag name to value, or an error naming the file.
+ */
+func readFlagFile(path string) (map[string]string, error) {
+	file, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer file.Close()
+	values, err := readFlagValues(file)
+	if err != nil {
+		return nil, fmt.Errorf("%s: %v", path, err)
+	}
+	return values, nil
+}
+
+/*!
+ * \brief Write flag values as an indented JSON object.
+ * \param w Destination of the JSON.
+ * \param values Flag name to value; numbers and booleans are written unquoted.
+ * \return Any write error.
+ */
+func writeFlagValues(w io.Writer, values map[string]string) error {
+	names := make([]string, 0, len(values))
+	for name := range values {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	var buf bytes.Buffer
+	buf.WriteString("{\n")
+	for i, name := range names {
+		v := values[name]
+		quoted, _ := json.Marshal(v)
+		if _, err := strconv.ParseFloat(v, 64); err == nil || v == "true" || v == "false" {
+			quoted = []byte(v)
+		}
+		key, _ := json.Marshal(name)
+		fmt.Fprintf(&buf, "  %s: %s", key, quoted)
+		if i < len(names)-1 {
+			buf.WriteByte(',')
+		}
+		buf.WriteByte('\n')
+	}
+	buf.WriteString("}\n")
+	_, err := w.Write(buf.Bytes())
+	return err
+}
+
+/*!
+ * \brief Set flags that were not given explicitly.
+ * \param fs Parsed flag set.
+ * \param values Flag name to value.
+ * \param explicit Flags given on the command line; they are left alone.
+ * \param source Where the values come from, for error messages.
+ * \return An error naming every unknown flag, with the closest known one
+ *         where there is one, or an error for a bad value.
+ *
+ * Unknown flags are rejected before any value is set, so a typo fails
+ * the run up front instead of leaving a setting at its default.
+ */
+func applyFlagValues(fs *flag.FlagSet, values map[string]string, explicit map[string]bool, source string) error {
+	names := make([]string, 0, len(values))
+	for name := range values {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	var unknown []string
+	for _, name := range names {
+		if fs.Lookup(name) == nil {
+			unknown = append(unknown, fmt.Sprintf("%q%s", name, flagSuggestion(fs, name)))
+		}
+	}
+	switch {
+	case len(unknown) == 1:
+		return fmt.Errorf("%s: unknown setting %s", source, unknown[0])
+	case len(unknown) > 1:
+		return fmt.Errorf("%s: unknown settings %s", source, strings.Join(unknown, ", "))
+	}
+	for _, name := range names {
+		if explicit[name] {
+			continue
+		}
+		if err := fs.Set(name, values[name]); err != nil {
+			return fmt.Errorf("%s: %v", source, err)
+		}
+	}
+	return nil
+}
+
+/*!
+ * \brief Suggest the flag a misspelt name was probably meant to be.
+ * \param fs The flag set.
+ * \param name The unknown name.
+ * \return " (did you mean "NAME"?)" for the closest flag, or "" if none is close.
+ *
+ * Names that differ only in case, dashes and underscores match outright,
+ * so shark_breed finds sharkbreed; otherwise the flag with the fewest
+ * edits wins, if it takes no more than a third of the name's length.
+ */
+func flagSuggestion(fs *flag.FlagSet, name string) string {
+	normalise := strings.NewReplacer("-", "", "_", "")
+	want := normalise.Replace(strings.ToLower(name))
+	best, bestDistance := "", max(1, len(want)/3)+1
+	fs.VisitAll(func(f *flag.Flag) {
+		d := editDistance(want, normalise.Replace(f.Name))
+		if d < bestDistance {
+			best, bestDistance = f.Name, d
+		}
+	})
+	if best == "" {
+		return ""
+	}
+	return fmt.Sprintf(" (did you mean %q?)", best)
+}
+
+/*!
+ * \brief Levenshtein distance between two strings.
+ * \param a First string.
+ * \param b Second string.
+ * \return The fewest single-character insertions, deletions and substitutions turning a into b.
+ */
+func editDistance(a, b string) int {
+	prev := make([]int, len(b)+1)
+	for j := range prev {
+		prev[j] = j
+	}
+	for i := 1; i <= len(a); i++ {
+		cur := make([]int, len(b)+1)
+		cur[0] = i
+		for j := 1; j <= len(b); j++ {
+			cost := 1
+			if a[i-1] == b[j-1] {
+				cost = 0
+			}
+			cur[j] = min(prev[j]+1, cur[j-1]+1, prev[j-1]+cost)
+		}
+		prev = cur
+	}
+	return prev[len(b)]
+}
+
+/*!
+ * \brief Parse a square character grid.
+ * \param r Source of the text.
+ * \param cell Maps a character to its cell value, or reports it as invalid.
+ * \return The cells (row-major) and the grid size, or a parse error.
+ */
+func parseGrid[T any](r io.Reader, cell func(ch rune) (T, bool)) ([]T, int, error) {
+	var rows [][]T
+	scanner := bufio.NewScanner(r)
+	scanner.Buffer(nil, 1<<20)
+	for line := 1; scanner.Scan(); line++ {
+		text := strings.ReplaceAll(strings.TrimRight(scanner.Text(), "\r"), " ", "")
+		if text == "" {
+			continue
+		}
+		row := []T{}
+		for col, ch := range []rune(text) {
+			v, ok := cell(ch)
+			if !ok {
+				return nil, 0, fmt.Errorf("line %d, cell %d: unexpected %q", line, col+1, ch)
+			}
+			row = append(row, v)
+		}
+		rows = append(rows, row)
+		if len(rows) > maxGridSize {
+			return nil, 0, fmt.Errorf("grid has more than %d rows", maxGridSize)
+		}
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, 0, err
+	}
+	if len(rows) == 0 {
+		return nil, 0, errors.New("empty grid")
+	}
+	size := len(rows)
+	cells := make([]T, 0, size*size)
+	for i, row := range rows {
+		if len(row) != size {
+			return nil, 0, fmt.Errorf("grid must be square: row %d has %d cells, expected %d", i+1, len(row), size)
+		}
+		cells = append(cells, row...)
+	}
+	return cells, size, nil
+}
+
+/*!
+ * \brief Parse a land map.
+ * \param r Source of the map: '#' land, '.', '~', 'R' (reef) or '1' to '9'
+ *          (polluted) water.
+ * \return Land cells (row-major) and the map size, or a parse error.
+ */
+func parseLandMap(r io.Reader) ([]bool, int, error) {
+	return parseGrid(r, func(ch rune) (bool, bool) {
+		switch ch {
+		case '#':
+			return true, true
+		case '.', '~', 'R', '1', '2', '3', '4', '5', '6', '7', '8', '9':
+			return false, true
+		}
+		return false, false
+	})
+}
+
+/*!
+ * \brief Parse an initial layout.
+ * \param r Source of the layout: 'F' fish, 'S' shark, '.' empty.
+ * \return Species per cell (row-major) and the layout size, or a parse error.
+ */
+func parseLayout(r io.Reader) ([]Species, int, error) {
+	return parseGrid(r, func(ch rune) (Species, bool) {
+		switch ch {
+		case 'F':
+			return Fish, true
+		case 'S':
+			return Shark, true
+		case '.':
+			return Empty, true
+		}
+		return Empty, false
+	})
+}
+
+/*!
+ * \brief Format a square grid as text.
+ * \param w Destination of the text.
+ * \param size Width/height of the grid.
+ * \param symbol Character of the cell at a row-major index.
+ * \return Any write error.
+ */
+func writeGrid(w io.Writer, size int, symbol func(i int) byte) error {
+	bw := bufio.NewWriter(w)
+	for y := 0; y < size; y++ {
+		for x := 0; x < size; x++ {
+			bw.WriteByte(symbol(y*size + x))
+		}
+		bw.WriteByte('\n')
+	}
+	return bw.Flush()
+}
+
+/*!
+ * \brief Read a map or layout file from disk.
+ * \param path File path.
+ * \param parse parseLandMap or parseLayout.
+ * \return The cells and the size, or an error naming the file.
+ */
+func readGridFile[T any](path string, parse func(r io.Reader) ([]T, int, error)) ([]T, int, error) {
+	file, err := os.Open(path)
+	if err != nil {
+		return nil, 0, err
+	}
+	defer file.Close()
+	cells, size, err := parse(file)
+	if err != nil {
+		return nil, 0, fmt.Errorf("%s: %v", path, err)
+	}
+	return cells, size, nil
+}
+
+/*!
+ * \brief Largest file inside a scenario archive, uncompressed.
+ */
+const maxScenarioEntry = 64 << 20
+
+/*!
+ * \brief Load a scenario archive.
+ * \param path Path of the .wator file.
+ * \return The scenario, or an error naming the offending file.
+ */
+func loadScenario(path string) (*scenario, error) {
+	file, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer file.Close()
+	info, err := file.Stat()
+	if err != nil {
+		return nil, err
+	}
+	sc, err := readScenario(file, info.Size())
+	if err != nil {
+		return nil, fmt.Errorf("%s: %v", path, err)
+	}
+	return sc, nil
+}
+
+/*!
+ * \brief Read a scenario archive.
+ * \param r Source of the archive.
+ * \param size Length of the archive in bytes.
+ * \return The scenario, or an error naming the offending file inside it.
+ *
+ * Files larger than maxScenarioEntry are rejected before they are
+ * decompressed.
+ */
+func readScenario(r io.ReaderAt, size int64) (*scenario, error) {
+	zr, err := zip.NewReader(r, size)
+	if err != nil {
+		return nil, err
+	}
+
+	sc := &scenario{Flags: map[string]string{}}
+	for _, f := range zr.File {
+		switch f.Name {
+		case scenarioConfig, scenarioMap, scenarioLayout, scenarioDescription:
+		default:
+			continue
+		}
+		if f.UncompressedSize64 > maxScenarioEntry {
+			return nil, fmt.Errorf("%s: larger than %d bytes", f.Name, maxScenarioEntry)
+		}
+		rc, err := f.Open()
+		if err != nil {
+			return nil, fmt.Errorf("%s: %v", f.Name, err)
+		}
+		switch f.Name {
+		case scenarioConfig:
+			sc.Flags, err = readFlagValues(rc)
+		case scenarioMap:
+			var text []byte
+			if text, err = io.ReadAll(rc); err == nil {
+				sc.Land, _, err = parseLandMap(bytes.NewReader(text))
+			}
+			if err == nil {
+				sc.Reef, _, err = parseReefMap(bytes.NewReader(text))
+			}
+			if err == nil {
+				sc.Pollution, _, err = parsePollutionMap(bytes.NewReader(text))
+			}
+		case scenarioLayout:
+			sc.Layout, _, err = parseLayout(rc)
+		case scenarioDescription:
+			var text []byte
+			text, err = io.ReadAll(rc)
+			sc.Description = strings.TrimSpace(string(text))
+		}
+		rc.Close()
+		if err != nil {
+			return nil, fmt.Errorf("%s: %v", f.Name, err)
+		}
+	}
+	return sc, nil
+}
+
+/*!
+ * \brief Write a scenario archive.
+ * \param path Path of the .wator file.
+ * \param params Parameters to store; Land and Layout are stored if set.
+ * \param seed Seed to store, or 0 to leave the seed to the user.
+ * \param description Free text description ("" = none).
+ * \return Any file error.
+ */
+func saveScenario(path string, params Config, seed int64, description string) error {
+	file, err := os.Create(path)
+	if err != nil {
+		return err
+	}
+	zw := zip.NewWriter(file)
+
+	values := map[string]string{
+		"grid":   strconv.Itoa(params.GridSize),
+		"scheme": schemeName(params.Scheme),
+		"window": strconv.Itoa(params.Window),
+	}
+	for _, p := range tunableParams {
+		values[p.Name] = strconv.Itoa(*p.Field(&params))
+	}
+	if params.InitPattern != "" && params.InitPattern != "uniform" {
+		values["init-pattern"] = params.InitPattern
+	}
+	if params.RandomState {
+		values["init-state"] = initStateName(true)
+	}
+	if params.Bounded {
+		values["topology"] = topologyName(true)
+		for name, rate := range map[string]float64{"immigrate-fish": params.ImmigrateFish,
+			"immigrate-sharks": params.ImmigrateSharks, "emigrate": params.Emigrate} {
+			if rate > 0 {
+				values[name] = strconv.FormatFloat(rate, 'g', -1, 64)
+			}
+		}
+	}
+	if params.Aging.Maturity > 0 {
+		values["maturity"] = strconv.Itoa(params.Aging.Maturity)
+	}
+	if params.Aging.Old > 0 {
+		values["old-age"] = strconv.Itoa(params.Aging.Old)
+		values["old-fertility"] = strconv.FormatFloat(params.Aging.Fertility, 'g', -1, 64)
+		values["old-speed"] = strconv.FormatFloat(params.Aging.Speed, 'g', -1, 64)
+	}
+	if params.FishEnergy.Budget > 0 {
+		values["fish-energy"] = strconv.Itoa(params.FishEnergy.Budget)
+		values["fish-move-cost"] = strconv.Itoa(params.FishEnergy.Move)
+		values["plankton"] = strconv.Itoa(params.FishEnergy.Plankton)
+	}
+	if params.Breeding.SharkEnergy > 0 {
+		values["shark-birth-cost"] = strconv.Itoa(params.Breeding.SharkEnergy)
+	}
+	if params.Breeding.FishCooldown > 1 {
+		values["fish-cooldown"] = strconv.FormatFloat(params.Breeding.FishCooldown, 'g', -1, 64)
+	}
+	if params.Breeding.SharkBirth > 0 {
+		values["shark-birth-energy"] = strconv.Itoa(params.Breeding.SharkBirth)
+	}
+	if params.Breeding.SharkLitter != LitterLeft {
+		values["shark-litter"] = litterPlaceName(params.Breeding.SharkLitter)
+	}
+	if params.Breeding.FishBirth > 0 {
+		values["fish-birth-energy"] = strconv.Itoa(params.Breeding.FishBirth)
+	}
+	if params.Eggs.Fish > 0 {
+		values["fish-egg-time"] = strconv.Itoa(params.Eggs.Fish)
+	}
+	if params.Eggs.Shark > 0 {
+		values["shark-egg-time"] = strconv.Itoa(params.Eggs.Shark)
+	}
+	if params.Sexes.Enabled {
+		values["sexes"] = "true"
+		values["mate-bias"] = strconv.FormatFloat(params.Sexes.MateBias, 'g', -1, 64)
+	}
+	if params.Strategies.Mutation > 0 {
+		values["strategy-mutation"] = strconv.FormatFloat(params.Strategies.Mutation, 'g', -1, 64)
+	}
+	if params.Web.enabled() {
+		values["species"] = formatWebSpecies(params.Web.Species)
+		values["eats"] = params.Web.formatDiet()
+	}
+	if params.Introductions != nil {
+		values["introduce"] = formatIntroductions(params.Introductions, &params.Web)
+	}
+	if params.MoveWeights != nil {
+		values["move-weights"] = formatMoveWeights(params.MoveWeights, &params.Web)
+	}
+	if params.Ambush.Below > 0 {
+		values["ambush-below"] = strconv.Itoa(params.Ambush.Below)
+		values["ambush-chance"] = strconv.FormatFloat(params.Ambush.Chance, 'g', -1, 64)
+		values["ambush-drain"] = strconv.FormatFloat(params.Ambush.Drain, 'g', -1, 64)
+	}
+	if m := params.Mortality; m.enabled() {
+		values["fish-mortality"] = strconv.FormatFloat(m.Fish, 'g', -1, 64)
+		values["shark-mortality"] = strconv.FormatFloat(m.Shark, 'g', -1, 64)
+	}
+	if c := params.Crowding; c.enabled() {
+		values["fish-crowding"] = strconv.FormatFloat(c.Fish, 'g', -1, 64)
+		values["shark-crowding"] = strconv.FormatFloat(c.Shark, 'g', -1, 64)
+		values["crowding-tolerance"] = strconv.Itoa(c.Tolerance)
+	}
+	if params.SharkTerritory > 0 {
+		values["shark-territory"] = strconv.FormatFloat(params.SharkTerritory, 'g', -1, 64)
+	}
+	if params.Starvation.Order != StarveFirst {
+		values["starve-order"] = starveOrderName(params.Starvation.Order)
+	}
+	if params.Starvation.Meal > 0 {
+		values["shark-meal"] = strconv.Itoa(params.Starvation.Meal)
+	}
+	if params.Conflicts != FirstCome {
+		values["conflicts"] = conflictPolicyName(params.Conflicts)
+	}
+	if params.Whales.Count > 0 {
+		values["whales"] = strconv.Itoa(params.Whales.Count)
+		values["whale-size"] = strconv.Itoa(params.Whales.Size)
+	}
+	if t := params.Tide; t.Width > 0 {
+		values["tide-width"] = strconv.Itoa(t.Width)
+		values["tide-period"] = strconv.Itoa(t.Period)
+		values["low-tide"] = strconv.FormatFloat(t.Low, 'g', -1, 64)
+	}
+	if d := params.DayNight; d.Length > 0 {
+		values["day-length"] = strconv.Itoa(d.Length)
+		values["night-share"] = strconv.FormatFloat(d.Night, 'g', -1, 64)
+		values["day-amplitude"] = strconv.FormatFloat(d.Amplitude, 'g', -1, 64)
+	}
+	if c := params.Climate; c.enabled() {
+		for name, value := range map[string]float64{"temp-gradient": c.Gradient, "equator-temp": c.Equator,
+			"warming": c.Warming, "fish-optimum": c.FishOptimum, "shark-optimum": c.SharkOptimum,
+			"thermal-tolerance": c.Tolerance} {
+			values[name] = strconv.FormatFloat(value, 'g', -1, 64)
+		}
+		values["climate-bands"] = strconv.Itoa(c.Bands)
+	}
+	if params.Pollution.enabled() {
+		if params.Pollution.Sources != nil {
+			values["pollution"] = formatPollutionSources(params.Pollution.Sources)
+		}
+		for name, rate := range map[string]float64{"pollution-diffusion": params.Pollution.Diffusion,
+			"pollution-decay": params.Pollution.Decay, "pollution-shark-drain": params.Pollution.SharkDrain,
+			"pollution-fish-harm": params.Pollution.FishHarm} {
+			values[name] = strconv.FormatFloat(rate, 'g', -1, 64)
+		}
+	}
+	if params.Spawns != nil && params.Layout == nil {
+		values["spawn"] = formatSpawnRegions(params.Spawns)
+	}
+	if params.Workers > 1 {
+		// Parallel stepping draws from per-tile random sources, so the tiling is part of the run
+		values["workers"] = strconv.Itoa(params.Workers)
+		values["tile"] = strconv.Itoa(params.TileSize)
+	}
+	if seed != 0 {
+		values["seed"] = strconv.FormatInt(seed, 10)
+	}
+
+	type entry struct {
+		name  string                  // File name inside the archive
+		write func(w io.Writer) error // Writes the file's contents
+	}
+	entries := []entry{
+		{scenarioConfig, func(w io.Writer) error { return writeFlagValues(w, values) }},
+	}
+	if params.Land != nil || params.Reef != nil || params.Pollution.Map != nil {
+		entries = append(entries, entry{scenarioMap, func(w io.Writer) error {
+			return writeGrid(w, params.GridSize, func(i int) byte {
+				switch {
+				case params.Land != nil && params.Land[i]:
+					return '#'
+				case params.Reef != nil && params.Reef[i]:
+					return 'R'
+				case params.Pollution.Map != nil && pollutionDigit(params.Pollution.Map[i]) != 0:
+					return pollutionDigit(params.Pollution.Map[i])
+				}
+				return '.'
+			})
+		}})
+	}
+	if params.Layout != nil {
+		entries = append(entries, entry{scenarioLayout, func(w io.Writer) error {
+			return writeGrid(w, params.GridSize, func(i int) byte {
+				return ".FS"[params.Layout[i]]
+			})
+		}})
+	}
+	if description != "" {
+		entries = append(entries, entry{scenarioDescription, func(w io.Writer) error {
+			_, err := io.WriteString(w, description+"\n")
+			return err
+		}})
+	}
+
+	for _, e := range entries {
+		w, err := zw.Create(e.name)
+		if err == nil {
+			err = e.write(w)
+		}
+		if err != nil {
+			file.Close()
+			return err
+		}
+	}
+	if err := zw.Close(); err != nil {
+		file.Close()
+		return err
+	}
+	return file.Close()
+}
+
+/*!
+ * \brief Check that the land map and layout fit the grid and the populations.
+ * \param params Resolved parameters; NumFish/NumShark are set from the layout.
+ * \return An error describing the first inconsistency.
+ */
+func checkTerrain(params *Config) error {
+	cells := params.GridSize * params.GridSize
+	if params.Land != nil && len(params.Land) != cells || params.Reef != nil && len(params.Reef) != cells ||
+		params.Pollution.Map != nil && len(params.Pollution.Map) != cells {
+		return fmt.Errorf("map does not match the %dx%d grid", params.GridSize, params.GridSize)
+	}
+	if params.Layout != nil {
+		if len(params.Layout) != cells {
+			return fmt.Errorf("layout does not match the %dx%d grid", params.GridSize, params.GridSize)
+		}
+		params.NumFish, params.NumShark = 0, 0
+		for i, s := range params.Layout {
+			if s == Empty {
+				continue
+			}
+			if params.Land != nil && params.Land[i] {
+				return fmt.Errorf("layout places a %s on land at (%d, %d)", s, i%params.GridSize, i/params.GridSize)
+			}
+			if s == Shark && params.Reef != nil && params.Reef[i] {
+				return fmt.Errorf("layout places a shark on a reef at (%d, %d)", i%params.GridSize, i/params.GridSize)
+			}
+			if s == Fish {
+				params.NumFish++
+			} else {
+				params.NumShark++
+			}
+		}
+	}
+	return checkFit(*params)
+}
+
+/*!
+ * \brief Check that the populations fit in the water of the grid.
+ * \param params Parameters with the land map, reefs and populations.
+ * \return An error if the creatures outnumber the free cells they may take.
+ *
+ * Commands that derive parameters from resolved ones, such as sweeps,
+ * check every derived set with this before starting a run.
+ */
+func checkFit(params Config) error {
+	water := params.GridSize * params.GridSize
+	for _, land := range params.Land {
+		if land {
+			water--
+		}
+	}
+	if params.NumFish+params.NumShark > water {
+		return fmt.Errorf("%d fish and %d sharks do not fit in %d water cells", params.NumFish, params.NumShark, water)
+	}
+	if params.NumFish+params.NumShark+params.Whales.cells() > water {
+		return fmt.Errorf("%d fish, %d sharks and %d whales of %d cells do not fit in %d water cells",
+			params.NumFish, params.NumShark, params.Whales.Count, params.Whales.Size*params.Whales.Size, water)
+	}
+	if others := params.Web.population(); params.NumFish+params.NumShark+params.Whales.cells()+others > water {
+		return fmt.Errorf("%d fish, %d sharks, %d whale cells and %d creatures of registered species do not fit in %d water cells",
+			params.NumFish, params.NumShark, params.Whales.cells(), others, water)
+	}
+	open := water
+	for _, reef := range params.Reef {
+		if reef {
+			open--
+		}
+	}
+	if params.NumShark > open {
+		return fmt.Errorf("%d sharks do not fit in %d water cells outside the reefs", params.NumShark, open)
+	}
+	return nil
+}
+
+/*!
+ * \brief Entry point of the pack subcommand.
+ * \param args Command-line arguments after "pack".
+ * \return Process exit code.
+ */
+func packCommand(args []string) int {
+	fs := newCommandFlags("pack")
+	params := defaultConfig()
+	cfg := registerConfigFlags(fs, &params)
+	out := fs.String("o", "scenario.wator", "output `file`")
+	description := fs.String("description", "", "description stored in the scenario")
+	descriptionFile := fs.String("description-file", "", "read the description from this `file`")
+	if err := fs.Parse(args); err != nil {
+		return flagExit(err)
+	}
+
+	seed, err := cfg.resolve()
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		return exitConfig
+	}
+	if *cfg.dryRun {
+		writeDryRun(os.Stdout, cfg, seed)
+		return exitOK
+	}
+	text := *description
+	if text == "" {
+		text = cfg.description
+	}
+	if *descriptionFile != "" {
+		b, err := os.ReadFile(*descriptionFile)
+		if err != nil {
+			fmt.Fprintln(os.Stderr, err)
+			return exitFailure
+		}
+		text = strings.TrimSpace(string(b))
+	}
+
+	if err := saveScenario(*out, params, *cfg.seed, text); err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		return exitFailure
+	}
+	fmt.Printf("Wrote %s\n", *out)
+	return exitOK
+}
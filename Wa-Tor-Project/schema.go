@@ -0,0 +1,182 @@
+/*!
+ * \file schema.go
+ * \brief The schema subcommand: a JSON Schema of the -config files of a command.
+ *
+ * A -config file is a JSON object of flag values, and a misspelt key is
+ * rejected when the run starts (with the closest flag suggested). The
+ * schema catches it earlier: editors that understand JSON Schema
+ * complete the keys, show the help text of each and flag unknown keys
+ * and values of the wrong type while the file is written, and checkers
+ * such as check-jsonschema can vet experiment configs in CI.
+ *
+ * The schema is generated from the flags of the command itself, so it
+ * always matches what the command accepts. Every flag is a property of
+ * its type (boolean, integer, number, or string for durations, lists and
+ * everything else), with its help text as description and its default.
+ * Files may also give numbers and booleans as strings, as the loader
+ * accepts them, so those types also allow a string that matches the
+ * number or boolean. Flags with a fixed set of values, like -scheme,
+ * list them. -config itself is left out, since a config file cannot
+ * name another one, and "$schema" is allowed so a file can name its
+ * schema.
+ */
+
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"flag"
+	"fmt"
+	"io"
+	"os"
+	"time"
+)
+
+/*!
+ * \brief A JSON Schema, as far as the schema subcommand writes one.
+ */
+type jsonSchema struct {
+	Schema      string                 `json:"$schema,omitempty"`
+	Title       string                 `json:"title,omitempty"`
+	Description string                 `json:"description,omitempty"`
+	Type        any                    `json:"type"`
+	Pattern     string                 `json:"pattern,omitempty"`
+	Enum        []string               `json:"enum,omitempty"`
+	Default     any                    `json:"default,omitempty"`
+	Properties  map[string]*jsonSchema `json:"properties,omitempty"`
+	Additional  *bool                  `json:"additionalProperties,omitempty"`
+}
+
+/*!
+ * \brief Strings a config file may give for a number or boolean flag, as
+ *        strconv parses them.
+ */
+var (
+	integerPattern = `^[+-]?([0-9]+|0[xX][0-9a-fA-F]+|0[oO][0-7]+|0[bB][01]+)$`
+	numberPattern  = `^[+-]?([0-9]+\.?[0-9]*|\.[0-9]+)([eE][+-]?[0-9]+)?$`
+	booleanPattern = `^(1|0|t|f|T|F|true|false|TRUE|FALSE|True|False)$`
+)
+
+/*!
+ * \brief Values of the flags that accept only a fixed set of names.
+ * \return Flag name to its values.
+ */
+func flagEnums() map[string][]string {
+	var schemes []string
+	for s := Raster; s <= Priority; s++ {
+		schemes = append(schemes, schemeName(s))
+	}
+	return map[string][]string{"scheme": schemes}
+}
+
+/*!
+ * \brief Collect the flags of a command.
+ * \param name Name of the command.
+ * \return Its flag set, unparsed, or nil if it has none.
+ *
+ * The command is run with -help, which every command handles by
+ * returning right after parsing its flags; its usage text is discarded.
+ */
+func commandFlagSet(name string) *flag.FlagSet {
+	var fs *flag.FlagSet
+	commandFlagsHook = func(created *flag.FlagSet) {
+		if fs == nil {
+			fs = created
+			fs.SetOutput(io.Discard)
+		}
+	}
+	defer func() { commandFlagsHook = nil }()
+	commands[name].Run([]string{"-help"})
+	return fs
+}
+
+/*!
+ * \brief Schema of the value of a flag.
+ * \param f The flag, at its default.
+ * \return Its type, help text and default.
+ */
+func flagSchema(f *flag.Flag) *jsonSchema {
+	_, usage := flag.UnquoteUsage(f)
+	s := &jsonSchema{Type: "string", Description: usage, Default: f.DefValue}
+	getter, ok := f.Value.(flag.Getter)
+	if !ok {
+		return s
+	}
+	switch v := getter.Get().(type) {
+	case bool:
+		s.Type, s.Pattern, s.Default = []string{"boolean", "string"}, booleanPattern, v
+	case int, int64, uint, uint64:
+		s.Type, s.Pattern, s.Default = []string{"integer", "string"}, integerPattern, v
+	case float64:
+		s.Type, s.Pattern, s.Default = []string{"number", "string"}, numberPattern, v
+	case time.Duration:
+		s.Default = v.String()
+	}
+	return s
+}
+
+/*!
+ * \brief Generate the schema of the -config files of a command.
+ * \param name Name of the command.
+ * \param fs Its flag set.
+ * \return The schema, allowing no keys but the command's flags.
+ */
+func configSchema(name string, fs *flag.FlagSet) *jsonSchema {
+	closed := false
+	s := &jsonSchema{
+		Schema:      "https://json-schema.org/draft/2020-12/schema",
+		Title:       "wator " + name + " -config file",
+		Description: "Flag values of the " + name + " command; flags given on the command line or in the environment override them.",
+		Type:        "object",
+		Properties:  map[string]*jsonSchema{},
+		Additional:  &closed,
+	}
+	s.Properties["$schema"] = &jsonSchema{Type: "string", Description: "URI or path of this schema, for editors; ignored by wator"}
+	enums := flagEnums()
+	fs.VisitAll(func(f *flag.Flag) {
+		if f.Name != "config" {
+			s.Properties[f.Name] = flagSchema(f)
+			s.Properties[f.Name].Enum = enums[f.Name]
+		}
+	})
+	return s
+}
+
+/*!
+ * \brief Entry point of the schema subcommand.
+ * \param args Command-line arguments after "schema".
+ * \return Process exit code.
+ */
+func schemaCommand(args []string) int {
+	fs := newCommandFlags("schema")
+	name := fs.String("command", "run", "`command` whose -config files the schema describes")
+	out := fs.String("o", "", "write the schema to this `file` instead of stdout")
+	if err := fs.Parse(args); err != nil {
+		return flagExit(err)
+	}
+	if _, ok := commands[*name]; !ok || *name == "schema" {
+		fmt.Fprintf(os.Stderr, "unknown command %q\n", *name)
+		return exitConfig
+	}
+	flags := commandFlagSet(*name)
+	if flags == nil || flags.Lookup("config") == nil {
+		fmt.Fprintf(os.Stderr, "%s reads no -config file\n", *name)
+		return exitConfig
+	}
+
+	var b bytes.Buffer
+	enc := json.NewEncoder(&b)
+	enc.SetEscapeHTML(false)
+	enc.SetIndent("", "  ")
+	enc.Encode(configSchema(*name, flags))
+	if *out == "" {
+		os.Stdout.Write(b.Bytes())
+		return exitOK
+	}
+	if err := os.WriteFile(*out, b.Bytes(), 0o644); err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		return exitFailure
+	}
+	return exitOK
+}
@@ -0,0 +1,49 @@
+/*!
+ * \file schema_test.go
+ * \brief The config schema allows what the loader accepts.
+ */
+
+package main
+
+import (
+	"regexp"
+	"testing"
+)
+
+/*!
+ * \brief Numbers and booleans given as strings match the pattern of their
+ *        flag and are accepted by it, and every scheme in the enum parses.
+ */
+func TestSchemaMatchesLoader(t *testing.T) {
+	fs := commandFlagSet("run")
+	schema := configSchema("run", fs)
+	given := map[string][]string{
+		"grid":        {"50", "+7", "0x20"},
+		"sea-level":   {"0.4", "1", ".5", "1e-1"},
+		"gen-islands": {"true", "F", "1"},
+	}
+	for name, values := range given {
+		pattern := regexp.MustCompile(schema.Properties[name].Pattern)
+		for _, v := range values {
+			if !pattern.MatchString(v) {
+				t.Errorf("-%s %q does not match the schema pattern %s", name, v, pattern)
+			}
+			if err := fs.Set(name, v); err != nil {
+				t.Errorf("-%s %q: %v", name, v, err)
+			}
+		}
+		if pattern.MatchString("fifty") {
+			t.Errorf("-%s: the schema pattern allows %q", name, "fifty")
+		}
+	}
+
+	enum := schema.Properties["scheme"].Enum
+	if len(enum) != 5 {
+		t.Errorf("scheme enum %q, want five schemes", enum)
+	}
+	for _, name := range enum {
+		if _, err := parseUpdateScheme(name); err != nil {
+			t.Error(err)
+		}
+	}
+}
@@ -0,0 +1,359 @@
+/*!
+ * \file sensitivity.go
+ * \brief One-at-a-time and Latin hypercube sensitivity analysis.
+ *
+ * Each design point is run with several seeds. For every point the
+ * extinction probability of each species and the oscillation amplitude
+ * of each population are recorded, so the influence of every parameter
+ * on the outcome can be compared.
+ */
+
+package main
+
+import (
+	"encoding/csv"
+	"fmt"
+	"io"
+	"math"
+	"math/rand"
+	"os"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+/*!
+ * \brief A numeric parameter that experiments can vary.
+ */
+type paramSpec struct {
+	Name  string               ///< Name, matching the command-line flag
+	Field func(c *Config) *int ///< Location of the value in a Config
+	Min   int                  ///< Smallest meaningful value
+}
+
+/*!
+ * \brief Parameters varied by the experiment modes.
+ */
+var tunableParams = []paramSpec{
+	{"fish", func(c *Config) *int { return &c.NumFish }, 0},
+	{"sharks", func(c *Config) *int { return &c.NumShark }, 0},
+	{"fishbreed", func(c *Config) *int { return &c.FishBreed }, 1},
+	{"sharkbreed", func(c *Config) *int { return &c.SharkBreed }, 1},
+	{"starve", func(c *Config) *int { return &c.Starve }, 1},
+}
+
+/*!
+ * \brief Look up a tunable parameter by name.
+ * \param name Parameter name.
+ * \return The parameter, or an error listing the valid names.
+ */
+func findParam(name string) (paramSpec, error) {
+	names := []string{}
+	for _, p := range tunableParams {
+		if p.Name == name {
+			return p, nil
+		}
+		names = append(names, p.Name)
+	}
+	return paramSpec{}, fmt.Errorf("unknown parameter %q (want one of %v)", name, names)
+}
+
+/*!
+ * \brief Parse a "NAME=V1,V2,..." setting of a tunable parameter.
+ * \param flagName Flag the setting came from, for error messages.
+ * \param s The setting.
+ * \return The parameter and its values, or an error.
+ */
+func parseParamValues(flagName, s string) (paramSpec, []int, error) {
+	name, list, ok := strings.Cut(s, "=")
+	if !ok || list == "" {
+		return paramSpec{}, nil, fmt.Errorf("invalid %s %q: want NAME=VALUE[,VALUE...]", flagName, s)
+	}
+	p, err := findParam(name)
+	if err != nil {
+		return paramSpec{}, nil, err
+	}
+	var values []int
+	for _, field := range strings.Split(list, ",") {
+		v, err := strconv.Atoi(strings.TrimSpace(field))
+		if err != nil {
+			return paramSpec{}, nil, fmt.Errorf("invalid %s value %q of %s", flagName, field, name)
+		}
+		values = append(values, v)
+	}
+	return p, values, nil
+}
+
+/*!
+ * \brief Outcome of a design point over several seeds.
+ */
+type pointOutcome struct {
+	FishExtinctP  float64 ///< Fraction of runs in which fish died out
+	SharkExtinctP float64 ///< Fraction of runs in which sharks died out
+	FishAmp       float64 ///< Mean oscillation amplitude of the fish population
+	SharkAmp      float64 ///< Mean oscillation amplitude of the shark population
+}
+
+/*!
+ * \brief Oscillation amplitude of a population series.
+ * \param series Population per chronon.
+ * \return Half the spread between the 5th and 95th percentiles over the
+ *         second half of the series (the first half is treated as transient).
+ */
+func oscillationAmplitude(series []int) float64 {
+	tail := append([]int{}, series[len(series)/2:]...)
+	if len(tail) == 0 {
+		return 0
+	}
+	sort.Ints(tail)
+	lo := tail[len(tail)*5/100]
+	hi := tail[(len(tail)*95)/100]
+	return float64(hi-lo) / 2
+}
+
+/*!
+ * \brief Run a design point with several seeds and summarise the outcome.
+ * \param params Parameters of the point.
+ * \param seed Seed of the first run.
+ * \param runs Number of seeds.
+ * \param horizon Chronons per run.
+ * \return The outcome.
+ */
+func evaluatePoint(params Config, seed int64, runs, horizon int) pointOutcome {
+	seeds := make([]int64, runs)
+	for i := range seeds {
+		seeds[i] = seed + int64(i)
+	}
+	o := pointOutcome{}
+	for _, t := range runReplicates(params, seeds, horizon) {
+		if t.FishExtinct >= 0 {
+			o.FishExtinctP++
+		}
+		if t.SharkExtinct >= 0 {
+			o.SharkExtinctP++
+		}
+		o.FishAmp += oscillationAmplitude(t.Fish)
+		o.SharkAmp += oscillationAmplitude(t.Sharks)
+	}
+	k := float64(runs)
+	o.FishExtinctP /= k
+	o.SharkExtinctP /= k
+	o.FishAmp /= k
+	o.SharkAmp /= k
+	return o
+}
+
+/*!
+ * \brief Relative levels used for one-at-a-time analysis.
+ */
+var oatLevels = []float64{0.5, 0.75, 1, 1.25, 1.5}
+
+/*!
+ * \brief Scale a parameter value, respecting its minimum.
+ * \param p The parameter.
+ * \param base Baseline value.
+ * \param factor Scale factor.
+ * \return The rounded, clamped value.
+ */
+func scaleParam(p paramSpec, base int, factor float64) int {
+	v := int(math.Round(float64(base) * factor))
+	if v < p.Min {
+		v = p.Min
+	}
+	return v
+}
+
+/*!
+ * \brief Run a sensitivity analysis and write the design points to CSV.
+ * \param out Destination of the summary.
+ * \param params Baseline parameters.
+ * \param method "oat" or "lhs".
+ * \param samples Number of Latin hypercube samples.
+ * \param runs Seeds per design point.
+ * \param horizon Chronons per run.
+ * \param seed Seed of the first run of every point (and of the LHS design).
+ * \param path Output CSV path.
+ * \return Any argument or file error, or an error naming the first
+ *         design point whose creatures do not fit in the water.
+ *
+ * One-at-a-time varies each parameter over 50%..150% of its baseline
+ * while holding the others fixed. The Latin hypercube draws every
+ * parameter from the same range at once, stratified so each of the
+ * samples falls in a different slice of every range, and reports the rank
+ * correlation of each parameter with each outcome.
+ */
+func sensitivityMode(out io.Writer, params Config, method string, samples, runs, horizon int, seed int64, path string) error {
+	type point struct {
+		Varied string
+		Params Config
+	}
+	points := []point{}
+
+	switch method {
+	case "oat":
+		for _, p := range tunableParams {
+			for _, f := range oatLevels {
+				c := params
+				*p.Field(&c) = scaleParam(p, *p.Field(&params), f)
+				points = append(points, point{p.Name, c})
+			}
+		}
+	case "lhs":
+		rng := rand.New(rand.NewSource(seed))
+		strata := make([][]int, len(tunableParams))
+		for i := range strata {
+			strata[i] = rng.Perm(samples)
+		}
+		for s := 0; s < samples; s++ {
+			c := params
+			for i, p := range tunableParams {
+				u := (float64(strata[i][s]) + rng.Float64()) / float64(samples)
+				*p.Field(&c) = scaleParam(p, *p.Field(&params), 0.5+u)
+			}
+			points = append(points, point{"all", c})
+		}
+	default:
+		return fmt.Errorf("unknown sensitivity method %q (want oat or lhs)", method)
+	}
+	for i, pt := range points {
+		if err := checkFit(pt.Params); err != nil {
+			return fmt.Errorf("design point %d (varying %s): %v", i, pt.Varied, err)
+		}
+	}
+
+	file, err := os.Create(path)
+	if err != nil {
+		return err
+	}
+	w := csv.NewWriter(file)
+	header := []string{"point", "varied"}
+	for _, p := range tunableParams {
+		header = append(header, p.Name)
+	}
+	w.Write(append(header, "fish_extinct_p", "shark_extinct_p", "fish_amplitude", "shark_amplitude"))
+
+	fmt.Fprintf(out, "Sensitivity (%s): %d points x %d runs x %d chronons\n", method, len(points), runs, horizon)
+	outcomes := make([]pointOutcome, len(points))
+	for i, pt := range points {
+		o := evaluatePoint(pt.Params, seed, runs, horizon)
+		outcomes[i] = o
+		row := []string{strconv.Itoa(i), pt.Varied}
+		for _, p := range tunableParams {
+			row = append(row, strconv.Itoa(*p.Field(&pt.Params)))
+		}
+		w.Write(append(row,
+			strconv.FormatFloat(o.FishExtinctP, 'f', 3, 64),
+			strconv.FormatFloat(o.SharkExtinctP, 'f', 3, 64),
+			strconv.FormatFloat(o.FishAmp, 'f', 2, 64),
+			strconv.FormatFloat(o.SharkAmp, 'f', 2, 64),
+		))
+		w.Flush()
+	}
+	if err := w.Error(); err != nil {
+		file.Close()
+		return err
+	}
+	if err := file.Close(); err != nil {
+		return err
+	}
+
+	// Summary: spread of each outcome per parameter (OAT) or rank correlation (LHS)
+	metrics := []struct {
+		Name string
+		Get  func(o pointOutcome) float64
+	}{
+		{"P(shark extinct)", func(o pointOutcome) float64 { return o.SharkExtinctP }},
+		{"P(fish extinct)", func(o pointOutcome) float64 { return o.FishExtinctP }},
+		{"shark amplitude", func(o pointOutcome) float64 { return o.SharkAmp }},
+		{"fish amplitude", func(o pointOutcome) float64 { return o.FishAmp }},
+	}
+	if method == "oat" {
+		fmt.Fprintf(out, "%-11s", "parameter")
+		for _, m := range metrics {
+			fmt.Fprintf(out, " %20s", m.Name+" range")
+		}
+		fmt.Fprintln(out)
+		for pi, p := range tunableParams {
+			fmt.Fprintf(out, "%-11s", p.Name)
+			for _, m := range metrics {
+				lo, hi := math.Inf(1), math.Inf(-1)
+				for l := range oatLevels {
+					v := m.Get(outcomes[pi*len(oatLevels)+l])
+					lo, hi = math.Min(lo, v), math.Max(hi, v)
+				}
+				fmt.Fprintf(out, " %9.2f .. %-8.2f", lo, hi)
+			}
+			fmt.Fprintln(out)
+		}
+	} else {
+		fmt.Fprintf(out, "%-11s", "parameter")
+		for _, m := range metrics {
+			fmt.Fprintf(out, " %18s", "rho "+m.Name)
+		}
+		fmt.Fprintln(out)
+		for _, p := range tunableParams {
+			xs := make([]float64, len(points))
+			for i, pt := range points {
+				xs[i] = float64(*p.Field(&pt.Params))
+			}
+			fmt.Fprintf(out, "%-11s", p.Name)
+			for _, m := range metrics {
+				ys := make([]float64, len(points))
+				for i := range outcomes {
+					ys[i] = m.Get(outcomes[i])
+				}
+				fmt.Fprintf(out, " %18.3f", spearman(xs, ys))
+			}
+			fmt.Fprintln(out)
+		}
+	}
+	fmt.Fprintf(out, "Wrote %s\n", path)
+	return nil
+}
+
+/*!
+ * \brief Spearman rank correlation of two samples.
+ * \param xs First sample.
+ * \param ys Second sample (same length).
+ * \return The correlation in [-1, 1], or 0 if either sample is constant.
+ */
+func spearman(xs, ys []float64) float64 {
+	rx, ry := ranks(xs), ranks(ys)
+	n := float64(len(xs))
+	mx, my := (n-1)/2, (n-1)/2
+	cov, vx, vy := 0.0, 0.0, 0.0
+	for i := range rx {
+		cov += (rx[i] - mx) * (ry[i] - my)
+		vx += (rx[i] - mx) * (rx[i] - mx)
+		vy += (ry[i] - my) * (ry[i] - my)
+	}
+	if vx == 0 || vy == 0 {
+		return 0
+	}
+	return cov / math.Sqrt(vx*vy)
+}
+
+/*!
+ * \brief Ranks of a sample, ties receiving their average rank (0-based).
+ * \param v The sample.
+ * \return Rank of each element.
+ */
+func ranks(v []float64) []float64 {
+	idx := make([]int, len(v))
+	for i := range idx {
+		idx[i] = i
+	}
+	sort.Slice(idx, func(a, b int) bool { return v[idx[a]] < v[idx[b]] })
+	r := make([]float64, len(v))
+	for i := 0; i < len(idx); {
+		j := i
+		for j+1 < len(idx) && v[idx[j+1]] == v[idx[i]] {
+			j++
+		}
+		for k := i; k <= j; k++ {
+			r[idx[k]] = float64(i+j) / 2
+		}
+		i = j + 1
+	}
+	return r
+}
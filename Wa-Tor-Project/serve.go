@@ -0,0 +1,799 @@
+/*!
+ * \file serve.go
+ * \brief The serve subcommand: a simulation watched from a web browser.
+ *
+ * Endpoints:
+ * - /:       a page drawing the grid on a canvas, updated live, that
+ *            pans across the wrap-around edges of a torus
+ * - /frame:  the latest frame as JSON
+ * - /stream: server-sent events, one delta-encoded frame per chronon with
+ *            the figures of the statistics overlay, and the movement field
+ *            of every window of flowWindow chronons once
+ * - /history: the populations so far as JSON, for the plots of a page
+ *            opened in the middle of a run
+ *
+ * Slow clients of /stream skip frames instead of holding up the
+ * simulation; they always receive the latest one. The stream starts with
+ * a keyframe and sends one after every skip, so deltas always apply to
+ * the frame the client has. A client that still finds a delta not
+ * following its frame reconnects, which starts a new stream.
+ */
+
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"os"
+	"os/signal"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+/*!
+ * \brief JSON form of a frame served over HTTP.
+ */
+type frameRecord struct {
+	Chronon int    `json:"chronon"`
+	Size    int    `json:"size"`
+	Fish    int    `json:"fish"`
+	Sharks  int    `json:"sharks"`
+	Cells   string `json:"cells"` ///< One of speciesChars per cell, row-major
+}
+
+/*!
+ * \brief Encode a frame as JSON.
+ * \param f The frame.
+ * \return The JSON encoding.
+ */
+func encodeFrameJSON(f *Frame) []byte {
+	cells := make([]byte, f.Size*f.Size)
+	for i, s := range f.Cells() {
+		cells[i] = speciesChars[s]
+	}
+	b, _ := json.Marshal(frameRecord{Chronon: f.Chronon, Size: f.Size, Fish: f.Fish, Sharks: f.Sharks, Cells: string(cells)})
+	return b
+}
+
+/*!
+ * \brief Observer keeping the latest frame for HTTP clients.
+ */
+type frameServer struct {
+	mu      sync.Mutex        ///< Guards latest, meter, field, history and updated
+	latest  servedFrame       ///< The latest frame, with a nil frame before the first
+	meter   overlayMeter      ///< Rates of the statistics overlay
+	field   *flowField        ///< Movement of the current window; nil before its first frame
+	history populationHistory ///< Populations of the run so far, thinned
+	updated chan struct{}     ///< Closed and replaced whenever latest changes
+}
+
+/*!
+ * \brief Populations of a run, kept to at most 2*maxChartPoints chronons
+ *        by dropping every other one and halving the rate whenever full.
+ */
+type populationHistory struct {
+	Chronon []int `json:"chronon"` ///< Chronons kept, ascending
+	Fish    []int `json:"fish"`    ///< Fish at each chronon kept
+	Sharks  []int `json:"sharks"`  ///< Sharks at each chronon kept
+	Step    int   `json:"step"`    ///< Chronons between those kept; 0 before the first
+}
+
+/*!
+ * \brief Add the populations of a frame, if its chronon is due.
+ * \param f The frame.
+ */
+func (h *populationHistory) add(f *Frame) {
+	if h.Step == 0 {
+		h.Step = 1
+	}
+	if n := len(h.Chronon); n > 0 && f.Chronon-h.Chronon[n-1] < h.Step {
+		return
+	}
+	h.Chronon = append(h.Chronon, f.Chronon)
+	h.Fish = append(h.Fish, f.Fish)
+	h.Sharks = append(h.Sharks, f.Sharks)
+	if len(h.Chronon) < 2*maxChartPoints {
+		return
+	}
+	for i := 0; 2*i < len(h.Chronon); i++ {
+		h.Chronon[i], h.Fish[i], h.Sharks[i] = h.Chronon[2*i], h.Fish[2*i], h.Sharks[2*i]
+	}
+	n := (len(h.Chronon) + 1) / 2
+	h.Chronon, h.Fish, h.Sharks = h.Chronon[:n], h.Fish[:n], h.Sharks[:n]
+	h.Step *= 2
+}
+
+/*!
+ * \brief A frame with what the live view shows besides the grid.
+ */
+type servedFrame struct {
+	frame *Frame       ///< The frame
+	stats overlayStats ///< Statistics overlay figures up to the frame
+	flow  *flowRecord  ///< Movement field of the last complete window; nil before the first
+}
+
+/*!
+ * \brief Server-sent event of a frame: the frame, delta-encoded, the
+ *        overlay figures, and a movement field the client has not had yet.
+ */
+type streamRecord struct {
+	deltaRecord
+	Stats overlayStats `json:"stats"`
+	Flow  *flowRecord  `json:"flow,omitempty"`
+}
+
+/*!
+ * \brief Create a frame server.
+ * \return The server, with no frame yet.
+ */
+func newFrameServer() *frameServer {
+	return &frameServer{updated: make(chan struct{})}
+}
+
+/*!
+ * \brief Publish a frame to the HTTP clients.
+ * \param f The frame.
+ * \return nil.
+ */
+func (s *frameServer) Observe(f *Frame) error {
+	s.mu.Lock()
+	s.latest.frame = f
+	s.latest.stats = s.meter.add(f, time.Now())
+	s.history.add(f)
+	if f.HasMoves() {
+		if s.field == nil || s.field.size != f.Size {
+			s.field = newFlowField(f.Size, f.Chronon)
+		}
+		s.field.add(f)
+		if s.field.full(f.Chronon) {
+			s.latest.flow = s.field.record(f.Chronon)
+			s.field = nil
+		}
+	}
+	close(s.updated)
+	s.updated = make(chan struct{})
+	s.mu.Unlock()
+	return nil
+}
+
+/*!
+ * \brief Nothing to release; the last frame stays available.
+ * \return nil.
+ */
+func (s *frameServer) Close() error {
+	return nil
+}
+
+/*!
+ * \brief Latest frame and a channel closed when it is replaced.
+ * \return The latest frame (a nil frame before the first) and the channel.
+ */
+func (s *frameServer) current() (servedFrame, <-chan struct{}) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.latest, s.updated
+}
+
+/*!
+ * \brief Serve the latest frame as JSON.
+ * \param w Response writer.
+ * \param r The request.
+ */
+func (s *frameServer) serveFrame(w http.ResponseWriter, r *http.Request) {
+	latest, _ := s.current()
+	f := latest.frame
+	if f == nil {
+		http.Error(w, "no frame yet", http.StatusServiceUnavailable)
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	w.Write(encodeFrameJSON(f))
+}
+
+/*!
+ * \brief Serve the populations of the run so far as JSON.
+ * \param w Response writer.
+ * \param r The request.
+ */
+func (s *frameServer) serveHistory(w http.ResponseWriter, r *http.Request) {
+	s.mu.Lock()
+	b, _ := json.Marshal(&s.history)
+	s.mu.Unlock()
+	w.Header().Set("Content-Type", "application/json")
+	w.Write(b)
+}
+
+/*!
+ * \brief Stream every new frame as a delta-encoded server-sent event until the client disconnects.
+ * \param w Response writer.
+ * \param r The request.
+ */
+func (s *frameServer) serveStream(w http.ResponseWriter, r *http.Request) {
+	flusher, ok := w.(http.Flusher)
+	if !ok {
+		http.Error(w, "streaming unsupported", http.StatusInternalServerError)
+		return
+	}
+	w.Header().Set("Content-Type", "text/event-stream")
+	w.Header().Set("Cache-Control", "no-cache")
+
+	enc := newDeltaEncoder(keyframeInterval)
+	latest, updated := s.current()
+	var sent *flowRecord
+	for {
+		if latest.frame != nil {
+			rec := streamRecord{deltaRecord: enc.encode(latest.frame), Stats: latest.stats}
+			if latest.flow != sent {
+				rec.Flow, sent = latest.flow, latest.flow
+			}
+			b, _ := json.Marshal(rec)
+			if _, err := fmt.Fprintf(w, "data: %s\n\n", b); err != nil {
+				return
+			}
+			flusher.Flush()
+		}
+		select {
+		case <-r.Context().Done():
+			return
+		case <-updated:
+			latest, updated = s.current()
+		}
+	}
+}
+
+/*!
+ * \brief Entry point of the serve subcommand.
+ * \param args Command-line arguments after "serve".
+ * \return Process exit code.
+ *
+ * The page keeps showing the final frame after the run ends, until the
+ * process is interrupted.
+ */
+func serveCommand(args []string) int {
+	fs := newCommandFlags("serve")
+	params := defaultConfig()
+	cfg := registerConfigFlags(fs, &params)
+	addr := fs.String("addr", "localhost:8080", "`address` to listen on")
+	cps := fs.Float64("cps", 10, "target chronons per second (0 = as fast as possible)")
+	if err := fs.Parse(args); err != nil {
+		return flagExit(err)
+	}
+
+	seed, err := cfg.resolve()
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		return exitConfig
+	}
+	if *cfg.dryRun {
+		writeDryRun(os.Stdout, cfg, seed)
+		return exitOK
+	}
+
+	server := newFrameServer()
+	page := strings.NewReplacer("{{torus}}", strconv.FormatBool(!params.Bounded),
+		"{{flowWindow}}", strconv.Itoa(flowWindow), "{{historyPoints}}", strconv.Itoa(2*maxChartPoints)).Replace(servePage)
+	mux := http.NewServeMux()
+	mux.HandleFunc("/", func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path != "/" {
+			http.NotFound(w, r)
+			return
+		}
+		w.Header().Set("Content-Type", "text/html; charset=utf-8")
+		w.Write([]byte(page))
+	})
+	mux.HandleFunc("/frame", server.serveFrame)
+	mux.HandleFunc("/stream", server.serveStream)
+	mux.HandleFunc("/history", server.serveHistory)
+
+	errs := make(chan error, 1)
+	go func() { errs <- http.ListenAndServe(*addr, mux) }()
+	fmt.Printf("Serving on http://%s/\n", *addr)
+
+	interrupt := make(chan os.Signal, 1)
+	signal.Notify(interrupt, os.Interrupt)
+	done := make(chan runOutcome, 1)
+	go func() {
+		sim := newSimulation(params, seed)
+		sim.flow = true // For the movement arrows
+		done <- simulate(sim, []Observer{server}, runLoop{gov: newGovernor(*cps), chronons: defaultChronons})
+	}()
+
+	for {
+		select {
+		case err := <-errs:
+			fmt.Fprintln(os.Stderr, err)
+			return exitFailure
+		case outcome := <-done:
+			if outcome.Fish == 0 && outcome.Sharks == 0 {
+				fmt.Println("All life extinct!")
+			}
+			fmt.Println("Simulation finished; serving the final frame until interrupted")
+		case <-interrupt:
+			return exitOK
+		}
+	}
+}
+
+/*!
+ * \brief Page drawing the streamed frames on a canvas.
+ *
+ * The view zooms in with the mouse wheel or the + and - keys, and pans by
+ * dragging it or with the arrow keys (Shift for ten cells at a time, Home
+ * to reset). On a torus the grid continues across its edges, so the page
+ * draws it four times, offset by a grid width and height, and the copies
+ * fill in whatever the pan moved out of view; an optional marker shows
+ * where the edges meet. A bounded world pans only as far as its edges.
+ * While zoomed in, a minimap in the corner shows the density of fish and
+ * sharks over the whole grid with the part in view framed; clicking it
+ * centres the view there. Arrows at the centres of the regions can show
+ * the mean movement of the fish or the sharks over the last window,
+ * scaled so the fastest region's reaches across most of its region. The i
+ * key shows or hides the statistics overlay
+ * over the top left corner of the view. A panel beside the view plots the
+ * fish and sharks over time and against each other (the phase plot), from
+ * /history and then every frame streamed; opened as /?plots the page shows
+ * only the plots, to watch them in a window of their own. "{{torus}}" is
+ * replaced by whether the world wraps around, "{{flowWindow}}" by
+ * flowWindow and "{{historyPoints}}" by the number of chronons the page
+ * keeps for the plots, thinned like populationHistory. The sound box plays
+ * the tones of the audio sink, the predation taken from the overlay's
+ * eaten per chronon.
+ */
+const servePage = `<!DOCTYPE html>
+<html>
+<head>
+<meta charset="utf-8">
+<title>Wa-Tor</title>
+<style>
+body { font-family: sans-serif; background: #222; color: #eee; }
+#view { position: relative; width: 600px; height: 600px; }
+#grid { image-rendering: pixelated; width: 600px; height: 600px; cursor: move; }
+#minimap { position: absolute; right: 8px; bottom: 8px; width: 150px; height: 150px; border: 1px solid #eee;
+  cursor: pointer; display: none; }
+#overlay { position: absolute; left: 8px; top: 8px; padding: 4px 8px; background: rgba(0, 0, 0, 0.7);
+  font-family: monospace; white-space: pre; pointer-events: none; display: none; }
+#panels { display: flex; gap: 16px; align-items: flex-start; }
+#plots canvas { display: block; background: #111; margin-bottom: 8px; }
+</style>
+</head>
+<body>
+<div id="status">waiting for the first frame</div>
+<div><label><input type="checkbox" id="sound"> sound: fish and shark tones</label></div>
+<div id="panels">
+<div id="main"><div id="view"><canvas id="grid" tabindex="0"></canvas><canvas id="minimap"></canvas>
+<div id="overlay"></div></div>
+<div><label><input type="checkbox" id="seam"> mark the wrap-around seam</label>
+<select id="arrows"><option value="">no movement arrows</option><option value="fish">fish movement</option>
+<option value="sharks">shark movement</option></select>
+<label><input type="checkbox" id="showPlots" checked> plots</label>
+<a href="/?plots" target="_blank" style="color: #8cf">open the plots in a new window</a></div></div>
+<div id="plots"><canvas id="series" width="420" height="292"></canvas><canvas id="phase" width="420" height="292"></canvas></div>
+</div>
+<script>
+const colours = {".": [16, 48, 128], "F": [48, 192, 64], "S": [224, 48, 48], "#": [200, 176, 112], "W": [150, 90, 200],
+  "1": [240, 144, 176], "2": [240, 144, 48], "3": [48, 176, 176], "4": [224, 224, 224],
+  "5": [144, 144, 32], "6": [144, 80, 32], "7": [128, 176, 240], "8": [112, 112, 112]};
+const chars = ".FS#W12345678";
+const torus = {{torus}}, flowWindow = {{flowWindow}}, historyPoints = {{historyPoints}};
+const canvas = document.getElementById("grid");
+const ctx = canvas.getContext("2d");
+const minimap = document.getElementById("minimap");
+const mctx = minimap.getContext("2d");
+const seam = document.getElementById("seam");
+const overlay = document.getElementById("overlay");
+const arrows = document.getElementById("arrows");
+const plots = document.getElementById("plots");
+const showPlots = document.getElementById("showPlots");
+const plotsOnly = new URLSearchParams(location.search).has("plots");
+const sound = document.getElementById("sound");
+const world = document.createElement("canvas");
+const wctx = world.getContext("2d");
+const viewPixels = 600, mapPixels = 150;
+let img = null, cells = null, chronon = -1, summary = "", size = 0, ox = 0, oy = 0, zoom = 1;
+let density = null, densityAt = 0, stats = null, flow = null;
+let populations = {chronon: [], fish: [], sharks: [], step: 1}, plottedAt = 0, audio = null;
+canvas.width = canvas.height = viewPixels;
+if (!torus) {
+  seam.parentElement.style.display = "none";
+}
+if (plotsOnly) {
+  document.getElementById("main").style.display = "none";
+  document.title = "Wa-Tor plots";
+}
+// Keep the populations of a chronon, thinned like the history on the server
+function record(c, fish, sharks) {
+  const p = populations, n = p.chronon.length;
+  if (n > 0 && c - p.chronon[n - 1] < p.step) {
+    return;
+  }
+  p.chronon.push(c);
+  p.fish.push(fish);
+  p.sharks.push(sharks);
+  if (p.chronon.length >= historyPoints) {
+    for (const k of ["chronon", "fish", "sharks"]) {
+      p[k] = p[k].filter((v, i) => i % 2 === 0);
+    }
+    p.step *= 2;
+  }
+}
+// Tones as in audio.go: pitch by the square root of the share of cells, shark volume by predation
+function pitch(count, cells, low) {
+  return low * Math.pow(4, Math.sqrt(Math.min(1, count / Math.max(1, cells))));
+}
+function playTones(f) {
+  if (audio === null || !sound.checked || stats === null) {
+    return;
+  }
+  const t = audio.ctx.currentTime, cells = size * size;
+  audio.fish.frequency.setTargetAtTime(pitch(f.fish, cells, 220), t, 0.05);
+  audio.sharks.frequency.setTargetAtTime(pitch(f.sharks, cells, 110), t, 0.05);
+  const volume = 0.15 + 0.85 * Math.min(1, stats.eaten / Math.max(1, f.sharks));
+  audio.sharkGain.gain.setTargetAtTime(0.3 * volume, t, 0.05);
+}
+// Browsers only start sound from a user action, so the tones are made when the box is first ticked
+sound.addEventListener("change", () => {
+  if (audio === null && sound.checked) {
+    const ctx = new AudioContext();
+    audio = {ctx: ctx, fish: ctx.createOscillator(), sharks: ctx.createOscillator(), sharkGain: ctx.createGain()};
+    const fishGain = ctx.createGain();
+    fishGain.gain.value = 0.3;
+    audio.sharkGain.gain.value = 0;
+    audio.sharks.type = "triangle";
+    audio.fish.connect(fishGain).connect(ctx.destination);
+    audio.sharks.connect(audio.sharkGain).connect(ctx.destination);
+    audio.fish.start();
+    audio.sharks.start();
+  } else if (audio !== null) {
+    if (sound.checked) {
+      audio.ctx.resume();
+    } else {
+      audio.ctx.suspend();
+    }
+  }
+});
+// Axes with the largest value of each, leaving margins for the labels
+function axes(pctx, width, height, xLabel, yLabel, xMin, xMax, yMax) {
+  pctx.fillStyle = "#111";
+  pctx.fillRect(0, 0, width, height);
+  pctx.strokeStyle = "#888";
+  pctx.lineWidth = 1;
+  pctx.beginPath();
+  pctx.moveTo(50.5, 10);
+  pctx.lineTo(50.5, height - 29.5);
+  pctx.lineTo(width - 10, height - 29.5);
+  pctx.stroke();
+  pctx.fillStyle = "#ccc";
+  pctx.font = "11px sans-serif";
+  pctx.textAlign = "right";
+  pctx.fillText(String(yMax), 46, 18);
+  pctx.fillText("0", 46, height - 30);
+  pctx.fillText(String(xMax), width - 10, height - 16);
+  pctx.textAlign = "left";
+  pctx.fillText(String(xMin), 52, height - 16);
+  pctx.textAlign = "center";
+  pctx.fillText(xLabel, (width + 40) / 2, height - 4);
+  pctx.save();
+  pctx.translate(12, (height - 20) / 2);
+  pctx.rotate(-Math.PI / 2);
+  pctx.fillText(yLabel, 0, 0);
+  pctx.restore();
+}
+// Populations over time, and sharks against fish with the latest points brightest
+function drawPlots() {
+  const p = populations, n = p.chronon.length;
+  if (n === 0) {
+    return;
+  }
+  plottedAt = performance.now();
+  const sc = document.getElementById("series"), sctx = sc.getContext("2d");
+  const w = sc.width - 60, h = sc.height - 40;
+  const first = p.chronon[0], last = Math.max(p.chronon[n - 1], first + 1);
+  const most = Math.max(1, Math.max(...p.fish), Math.max(...p.sharks));
+  axes(sctx, sc.width, sc.height, "chronon", "population", first, p.chronon[n - 1], most);
+  for (const [k, colour] of [["fish", "rgb(48, 192, 64)"], ["sharks", "rgb(224, 48, 48)"]]) {
+    sctx.strokeStyle = colour;
+    sctx.beginPath();
+    for (let i = 0; i < n; i++) {
+      sctx.lineTo(50 + (p.chronon[i] - first) / (last - first) * w, 10 + h - p[k][i] / most * h);
+    }
+    sctx.stroke();
+  }
+  sctx.textAlign = "right";
+  sctx.fillStyle = "rgb(48, 192, 64)";
+  sctx.fillText("fish " + p.fish[n - 1], sc.width - 12, 20);
+  sctx.fillStyle = "rgb(224, 48, 48)";
+  sctx.fillText("sharks " + p.sharks[n - 1], sc.width - 12, 34);
+
+  const pc = document.getElementById("phase"), pctx = pc.getContext("2d");
+  const pw = pc.width - 60, ph = pc.height - 40;
+  const fish = Math.max(1, Math.max(...p.fish)), sharks = Math.max(1, Math.max(...p.sharks));
+  axes(pctx, pc.width, pc.height, "fish", "sharks", 0, fish, sharks);
+  const recent = Math.max(0, n - 200);
+  for (const [from, to, colour] of [[0, recent + 1, "rgba(255, 255, 255, 0.3)"], [recent, n, "#fff"]]) {
+    pctx.strokeStyle = colour;
+    pctx.beginPath();
+    for (let i = from; i < to; i++) {
+      pctx.lineTo(50 + p.fish[i] / fish * pw, 10 + ph - p.sharks[i] / sharks * ph);
+    }
+    pctx.stroke();
+  }
+  pctx.fillStyle = "#fc3";
+  pctx.beginPath();
+  pctx.arc(50 + p.fish[n - 1] / fish * pw, 10 + ph - p.sharks[n - 1] / sharks * ph, 3, 0, 2 * Math.PI);
+  pctx.fill();
+}
+// Cells across the view
+function span() {
+  return size / zoom;
+}
+// Keep the offset on the grid: wrapped on a torus, inside the walls otherwise
+function place(x, y) {
+  if (torus) {
+    ox = (Math.round(x) % size + size) % size;
+    oy = (Math.round(y) % size + size) % size;
+  } else {
+    ox = Math.min(Math.max(Math.round(x), 0), Math.floor(size - span()));
+    oy = Math.min(Math.max(Math.round(y), 0), Math.floor(size - span()));
+  }
+}
+// Density of fish (green) and sharks (red) in blocks of the grid, one pixel each
+function updateDensity() {
+  const m = Math.min(size, mapPixels), block = size / m;
+  const counts = new Float32Array(m * m * 3);
+  for (let y = 0; y < size; y++) {
+    const row = Math.floor(y / block) * m;
+    for (let x = 0; x < size; x++) {
+      const s = cells[y * size + x], b = (row + Math.floor(x / block)) * 3;
+      counts[b + (s === 2 ? 0 : s === 1 ? 1 : 2)]++;
+    }
+  }
+  density = new ImageData(m, m);
+  for (let b = 0; b < m * m; b++) {
+    const n = counts[b * 3] + counts[b * 3 + 1] + counts[b * 3 + 2];
+    density.data.set([255 * counts[b * 3] / n, 255 * counts[b * 3 + 1] / n, 40, 255], b * 4);
+  }
+  densityAt = performance.now();
+}
+function drawMinimap() {
+  if (zoom === 1) {
+    minimap.style.display = "none";
+    return;
+  }
+  minimap.style.display = "block";
+  // Recounting a big grid on every frame would slow the page down
+  if (density === null || density.width !== Math.min(size, mapPixels) || performance.now() - densityAt > 500) {
+    updateDensity();
+  }
+  minimap.width = minimap.height = density.width;
+  mctx.putImageData(density, 0, 0);
+  const k = density.width / size;
+  mctx.strokeStyle = "#fff";
+  mctx.lineWidth = 1;
+  // The frame of the view, wrapped around the edges of a torus
+  for (const dx of [0, -size]) {
+    for (const dy of [0, -size]) {
+      mctx.strokeRect((ox + dx) * k + 0.5, (oy + dy) * k + 0.5, Math.max(1, span() * k - 1), Math.max(1, span() * k - 1));
+    }
+  }
+}
+// Mean movement per region as arrows from the region centres, wrapped around a torus
+function drawArrows(cell) {
+  const v = flow[arrows.value], regions = Math.ceil(size / flow.region);
+  let fastest = 0;
+  for (let i = 0; i < v.length; i += 2) {
+    fastest = Math.max(fastest, Math.hypot(v[i], v[i + 1]));
+  }
+  if (fastest === 0) {
+    return;
+  }
+  const scale = 0.8 * flow.region * cell / fastest;
+  ctx.strokeStyle = ctx.fillStyle = "#fff";
+  ctx.lineWidth = 1.5;
+  for (let r = 0; r < regions * regions; r++) {
+    const dx = v[2 * r] * scale, dy = v[2 * r + 1] * scale;
+    const len = Math.hypot(dx, dy);
+    if (len < 2) {
+      continue;
+    }
+    let cx = (r % regions + 0.5) * flow.region - ox, cy = (Math.floor(r / regions) + 0.5) * flow.region - oy;
+    if (torus) {
+      cx = (cx % size + size) % size;
+      cy = (cy % size + size) % size;
+    }
+    const x = cx * cell, y = cy * cell, ux = dx / len, uy = dy / len;
+    const hx = x + dx / 2, hy = y + dy / 2, head = Math.min(6, len / 2);
+    ctx.beginPath();
+    ctx.moveTo(x - dx / 2, y - dy / 2);
+    ctx.lineTo(hx, hy);
+    ctx.stroke();
+    ctx.beginPath();
+    ctx.moveTo(hx, hy);
+    ctx.lineTo(hx - head * (ux - uy / 2), hy - head * (uy + ux / 2));
+    ctx.lineTo(hx - head * (ux + uy / 2), hy - head * (uy - ux / 2));
+    ctx.fill();
+  }
+}
+function draw() {
+  if (img === null) {
+    return;
+  }
+  wctx.putImageData(img, 0, 0);
+  ctx.imageSmoothingEnabled = false;
+  ctx.fillStyle = "#222";
+  ctx.fillRect(0, 0, viewPixels, viewPixels);
+  const cell = viewPixels / span();
+  // Four copies of the grid cover the view whatever the offset
+  for (const dx of torus ? [0, size] : [0]) {
+    for (const dy of torus ? [0, size] : [0]) {
+      ctx.drawImage(world, (dx - ox) * cell, (dy - oy) * cell, size * cell, size * cell);
+    }
+  }
+  if (seam.checked && torus) {
+    ctx.fillStyle = "rgba(255, 255, 255, 0.35)";
+    if (ox > size - span()) {
+      ctx.fillRect((size - ox) * cell, 0, 1, viewPixels);
+    }
+    if (oy > size - span()) {
+      ctx.fillRect(0, (size - oy) * cell, viewPixels, 1);
+    }
+  }
+  if (arrows.value && flow !== null) {
+    drawArrows(cell);
+  }
+  drawMinimap();
+  let view = "";
+  if (ox || oy || zoom > 1) {
+    view = " | View from (" + ox + "," + oy + ")" + (zoom > 1 ? " at " + zoom + "x" : "");
+  }
+  if (arrows.value && flow !== null) {
+    view += " | Movement of chronons " + (flow.chronon - flowWindow + 1) + "-" + flow.chronon;
+  }
+  document.getElementById("status").textContent = summary + view;
+  if (stats !== null) {
+    overlay.textContent = "Births/chronon " + stats.births.toFixed(1).padStart(9) +
+      "\nDeaths/chronon " + stats.deaths.toFixed(1).padStart(9) +
+      "\nShark energy   " + stats.shark_energy.toFixed(2).padStart(9) +
+      "\nFish age       " + stats.fish_age.toFixed(1).padStart(9) +
+      "\nChronons/sec   " + stats.cps.toFixed(1).padStart(9);
+  }
+}
+function pan(dx, dy) {
+  if (size > 0) {
+    place(ox + dx, oy + dy);
+    draw();
+  }
+}
+// Zoom by a factor, keeping the centre of the view in place; at least 8 cells stay in view
+function zoomBy(factor) {
+  const next = Math.min(Math.max(zoom * factor, 1), Math.max(1, size / 8));
+  if (size === 0 || next === zoom) {
+    return;
+  }
+  const cx = ox + span() / 2, cy = oy + span() / 2;
+  zoom = next;
+  place(cx - span() / 2, cy - span() / 2);
+  draw();
+}
+let drag = null;
+canvas.addEventListener("mousedown", (e) => { drag = {x: e.clientX, y: e.clientY}; canvas.focus(); });
+window.addEventListener("mouseup", () => { drag = null; });
+window.addEventListener("mousemove", (e) => {
+  if (drag === null || size === 0) {
+    return;
+  }
+  // Whole cells only, keeping the remainder for the next move
+  const cell = canvas.clientWidth / span();
+  const dx = Math.trunc((e.clientX - drag.x) / cell), dy = Math.trunc((e.clientY - drag.y) / cell);
+  drag.x += dx * cell;
+  drag.y += dy * cell;
+  pan(-dx, -dy);
+});
+canvas.addEventListener("wheel", (e) => {
+  e.preventDefault();
+  zoomBy(e.deltaY < 0 ? 2 : 0.5);
+});
+canvas.addEventListener("keydown", (e) => {
+  const step = e.shiftKey ? 10 : 1;
+  const moves = {ArrowLeft: [-step, 0], ArrowRight: [step, 0], ArrowUp: [0, -step], ArrowDown: [0, step]};
+  if (e.key === "Home") {
+    pan(-ox, -oy);
+  } else if (e.key === "+" || e.key === "=") {
+    zoomBy(2);
+  } else if (e.key === "-") {
+    zoomBy(0.5);
+  } else if (moves[e.key]) {
+    pan(moves[e.key][0], moves[e.key][1]);
+  } else {
+    return;
+  }
+  e.preventDefault();
+});
+minimap.addEventListener("mousedown", (e) => {
+  e.stopPropagation();
+  const rect = minimap.getBoundingClientRect();
+  const x = (e.clientX - rect.left) / rect.width * size, y = (e.clientY - rect.top) / rect.height * size;
+  place(x - span() / 2, y - span() / 2);
+  draw();
+});
+seam.addEventListener("change", draw);
+showPlots.addEventListener("change", () => {
+  plots.style.display = showPlots.checked ? "block" : "none";
+  drawPlots();
+});
+arrows.addEventListener("change", draw);
+window.addEventListener("keydown", (e) => {
+  if (e.key === "i" && !e.ctrlKey && !e.metaKey && !e.altKey) {
+    overlay.style.display = overlay.style.display === "block" ? "none" : "block";
+  }
+});
+function connect() {
+  const source = new EventSource("/stream");
+  source.onmessage = (e) => {
+    const f = JSON.parse(e.data);
+    if (f.kind === "key") {
+      if (f.size !== size) {
+        size = f.size;
+        world.width = world.height = size;
+        ox = oy = 0;
+        zoom = 1;
+        flow = null;
+      }
+      img = ctx.createImageData(f.size, f.size);
+      cells = new Uint8Array(f.cells.length);
+      for (let i = 0; i < f.cells.length; i++) {
+        const c = colours[f.cells[i]];
+        img.data.set([c[0], c[1], c[2], 255], i * 4);
+        cells[i] = chars.indexOf(f.cells[i]);
+      }
+    } else if (img === null || f.base !== chronon) {
+      // Missed a frame: a new stream starts with a keyframe
+      source.close();
+      img = null;
+      connect();
+      return;
+    } else {
+      for (let i = 0; i < f.changes.length; i += 2) {
+        const c = colours[chars[f.changes[i + 1]]];
+        img.data.set([c[0], c[1], c[2], 255], f.changes[i] * 4);
+        cells[f.changes[i]] = f.changes[i + 1];
+      }
+    }
+    chronon = f.chronon;
+    stats = f.stats;
+    if (f.flow) {
+      flow = f.flow;
+    }
+    summary = "Chronon " + f.chronon + " | Fish=" + f.fish + " | Sharks=" + f.sharks;
+    record(f.chronon, f.fish, f.sharks);
+    playTones(f);
+    if (plotsOnly) {
+      document.getElementById("status").textContent = summary;
+    } else {
+      draw();
+    }
+    // Redrawing thousands of points on every frame would slow the page down
+    if ((plotsOnly || showPlots.checked) && performance.now() - plottedAt > 250) {
+      drawPlots();
+    }
+  };
+}
+// The chronons before the page was opened, then those streamed meanwhile
+fetch("/history").then((r) => r.json()).then((h) => {
+  const streamed = populations;
+  populations = {chronon: h.chronon || [], fish: h.fish || [], sharks: h.sharks || [], step: h.step || 1};
+  const n = populations.chronon.length, latest = n > 0 ? populations.chronon[n - 1] : -1;
+  for (let i = 0; i < streamed.chronon.length; i++) {
+    if (streamed.chronon[i] > latest) {
+      record(streamed.chronon[i], streamed.fish[i], streamed.sharks[i]);
+    }
+  }
+  drawPlots();
+});
+connect();
+</script>
+</body>
+</html>
+`
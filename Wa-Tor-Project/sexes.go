@@ -0,0 +1,166 @@
+/*!
+ * \file sexes.go
+ * \brief Male and female creatures that need a mate to breed.
+ *
+ * Only a female with an adult male next to it breeds, and creatures swim
+ * towards mates.
+ */
+
+package main
+
+import (
+	"encoding/csv"
+	"errors"
+	"fmt"
+	"math/rand"
+	"strconv"
+)
+
+/*!
+ * \brief Whether creatures have sexes, and how they seek mates.
+ */
+type sexRules struct {
+	Enabled  bool    ///< Creatures are male or female, and females need a mate to breed
+	MateBias float64 ///< Chance that a moving creature heads for the opposite sex
+}
+
+/*!
+ * \brief Check the sex rules.
+ * \param s The rules.
+ * \return An error naming the first setting out of range.
+ */
+func (s sexRules) check() error {
+	if !(s.MateBias >= 0 && s.MateBias <= 1) {
+		return fmt.Errorf("-mate-bias must be between 0 and 1, not %g", s.MateBias)
+	}
+	return nil
+}
+
+/*!
+ * \brief Draw the sex of a new creature.
+ * \param rng Random source of the creature's cell.
+ * \return True for a female; always false without sexes.
+ */
+func (s *sexRules) female(rng *rand.Rand) bool {
+	return s.Enabled && rng.Intn(2) == 0
+}
+
+/*!
+ * \brief Check whether a cell has a mate for a creature next to it.
+ * \param w The world the creature is stepped from.
+ * \param x X coordinate of the cell.
+ * \param y Y coordinate of the cell.
+ * \param c The creature.
+ * \return True if a neighbour is a hatched creature of the same species
+ *         and the opposite sex.
+ */
+func (w *World) mateAround(x, y int, c *Creature) bool {
+	for _, pos := range getAdjacentPositions(x, y, w.Size, w.bounded) {
+		n := &w.Grid[pos[0]][pos[1]]
+		if n.Species == c.Species && n.Female != c.Female && n.Hatch == 0 {
+			return true
+		}
+	}
+	return false
+}
+
+/*!
+ * \brief Check whether a creature that is due to breed may.
+ * \param w The world the creature is stepped from.
+ * \param x X coordinate of the creature.
+ * \param y Y coordinate of the creature.
+ * \param c The creature.
+ * \return True without sexes, or for a female with a mate next to her.
+ */
+func (w *World) mated(x, y int, c *Creature) bool {
+	return !w.sexes.Enabled || c.Female && w.mateAround(x, y, c)
+}
+
+/*!
+ * \brief Pick the free cell a creature moves to.
+ * \param w The world the creature is stepped from.
+ * \param newWorld Next world state.
+ * \param x X coordinate of the creature.
+ * \param y Y coordinate of the creature.
+ * \param c The creature.
+ * \param cells The free cells.
+ * \param n Number of free cells, at least 1.
+ * \param rng Random source of the creature's cell.
+ * \return One of the cells its strategy leaves, drawn by the movement
+ *         weights: among all of them, or with the mate bias as probability
+ *         among those next to a mate.
+ */
+func (w *World) pickMove(newWorld *World, x, y int, c *Creature, cells *[4][2]int, n int, rng *rand.Rand) [2]int {
+	n = w.strategyCells(c, cells, n)
+	if w.sexes.Enabled && w.sexes.MateBias > 0 {
+		var near [4][2]int
+		m := 0
+		for _, pos := range cells[:n] {
+			if w.mateAround(pos[0], pos[1], c) {
+				near[m] = pos
+				m++
+			}
+		}
+		if m > 0 && m < n && rng.Float64() < w.sexes.MateBias {
+			return w.pickWeighted(newWorld, x, y, c, near[:m], rng)
+		}
+	}
+	return w.pickWeighted(newWorld, x, y, c, cells[:n], rng)
+}
+
+/*!
+ * \brief Writes the sexes of the populations as CSV.
+ */
+type sexSink struct {
+	*csvSink ///< Output file and encoder
+}
+
+/*!
+ * \brief Create a sex sink.
+ * \param path Output file path.
+ * \param params Parameters of the run, with sexes.
+ * \return The sink, or an error if the run has no sexes or the file
+ *         cannot be created.
+ */
+func openSexSink(path string, params Config) (Observer, error) {
+	if !params.Sexes.Enabled {
+		return nil, errors.New("needs -sexes")
+	}
+	file, err := createCSV(path)
+	if err != nil {
+		return nil, err
+	}
+	s := &sexSink{&csvSink{file: file, w: csv.NewWriter(file)}}
+	s.w.Write([]string{"chronon", "female_fish", "male_fish", "female_sharks", "male_sharks", "fish_sex_ratio", "shark_sex_ratio"})
+	return s, nil
+}
+
+/*!
+ * \brief Sex ratio of a population.
+ * \param females Number of females.
+ * \param total Size of the population.
+ * \return Share of females, formatted, or "" for no population.
+ */
+func sexRatio(females, total int) string {
+	if total == 0 {
+		return ""
+	}
+	return strconv.FormatFloat(float64(females)/float64(total), 'f', 3, 64)
+}
+
+/*!
+ * \brief Write the row of a frame.
+ * \param f The frame to record.
+ * \return Any write error.
+ */
+func (s *sexSink) Observe(f *Frame) error {
+	return s.w.Write([]string{
+		strconv.Itoa(f.Chronon),
+		strconv.Itoa(f.FemaleFish),
+		strconv.Itoa(f.Fish - f.FemaleFish),
+		strconv.Itoa(f.FemaleSharks),
+		strconv.Itoa(f.Sharks - f.FemaleSharks),
+		sexRatio(f.FemaleFish, f.Fish),
+		sexRatio(f.FemaleSharks, f.Sharks),
+	})
+}
@@ -0,0 +1,416 @@
+/*!
+ * \file sinks.go
+ * \brief Output sinks: CSV statistics, animated GIF, event log, lineage tree, and JSON lines on stdout.
+ */
+
+package main
+
+import (
+	"bufio"
+	"encoding/csv"
+	"encoding/json"
+	"image/color"
+	"io"
+	"os"
+	"runtime"
+	"strconv"
+)
+
+/*!
+ * \brief Writes one row of population statistics per chronon.
+ */
+type csvSink struct {
+	file *os.File    ///< Output file
+	w    *csv.Writer ///< CSV encoder on top of file
+}
+
+/*!
+ * \brief Create a CSV statistics sink.
+ * \param path Output file path.
+ * \param params Parameters of the run (unused).
+ * \return The sink, or an error if the file cannot be created.
+ */
+func openCSVSink(path string, params Config) (Observer, error) {
+	file, err := createCSV(path)
+	if err != nil {
+		return nil, err
+	}
+	s := &csvSink{file: file, w: csv.NewWriter(file)}
+	s.w.Write([]string{"chronon", "fish", "sharks", "fish_births", "shark_births", "fish_eaten", "sharks_starved",
+		"hunt_efficiency", "time_to_starve"})
+	return s, nil
+}
+
+/*!
+ * \brief Write the statistics row of a frame.
+ * \param f The frame to record.
+ * \return Any write error.
+ */
+func (s *csvSink) Observe(f *Frame) error {
+	n := f.EventCounts()
+	return s.w.Write([]string{
+		strconv.Itoa(f.Chronon),
+		strconv.Itoa(f.Fish),
+		strconv.Itoa(f.Sharks),
+		strconv.Itoa(n.FishBirths),
+		strconv.Itoa(n.SharkBirths),
+		strconv.Itoa(n.FishEaten),
+		strconv.Itoa(n.SharksStarved),
+		strconv.FormatFloat(f.Hunting.Efficiency, 'f', 4, 64),
+		strconv.FormatFloat(f.Hunting.MeanTimeToStarve, 'f', 2, 64),
+	})
+}
+
+/*!
+ * \brief Flush and close the file.
+ * \return Any write or close error.
+ */
+func (s *csvSink) Close() error {
+	s.w.Flush()
+	if err := s.w.Error(); err != nil {
+		s.file.Close()
+		return err
+	}
+	return s.file.Close()
+}
+
+/*!
+ * \brief JSON form of a chronon written by the JSON line sink.
+ */
+type chrononRecord struct {
+	Chronon        int     `json:"chronon"`
+	Fish           int     `json:"fish"`
+	Sharks         int     `json:"sharks"`
+	FishBirths     int     `json:"fish_births"`
+	SharkBirths    int     `json:"shark_births"`
+	FishEaten      int     `json:"fish_eaten"`
+	FishStarved    int     `json:"fish_starved,omitempty"` ///< Only fish with an energy budget starve
+	SharksStarved  int     `json:"sharks_starved"`
+	FishDied       int     `json:"fish_died,omitempty"`   ///< Only with background mortality
+	SharksDied     int     `json:"sharks_died,omitempty"` ///< Only with background mortality
+	HuntEfficiency float64 `json:"hunt_efficiency"`
+	TimeToStarve   float64 `json:"time_to_starve"`
+	Checksum       string  `json:"checksum"`
+	Phase          string  `json:"phase,omitempty"` ///< Only with a day/night cycle
+}
+
+/*!
+ * \brief Writes one JSON object per chronon, for piping into jq or a log collector.
+ */
+type jsonLineSink struct {
+	enc *json.Encoder ///< JSON encoder on top of the destination
+}
+
+/*!
+ * \brief Create a JSON line sink.
+ * \param out Destination of the lines; each line is written as soon as its chronon is done.
+ * \return The sink.
+ */
+func newJSONLineSink(out io.Writer) Observer {
+	return &jsonLineSink{enc: json.NewEncoder(out)}
+}
+
+/*!
+ * \brief Write the line of a frame.
+ * \param f The frame to record.
+ * \return Any write error.
+ */
+func (s *jsonLineSink) Observe(f *Frame) error {
+	n := f.EventCounts()
+	return s.enc.Encode(chrononRecord{
+		Chronon:        f.Chronon,
+		Fish:           f.Fish,
+		Sharks:         f.Sharks,
+		FishBirths:     n.FishBirths,
+		SharkBirths:    n.SharkBirths,
+		FishEaten:      n.FishEaten,
+		FishStarved:    n.FishStarved,
+		SharksStarved:  n.SharksStarved,
+		FishDied:       n.FishDied,
+		SharksDied:     n.SharksDied,
+		HuntEfficiency: f.Hunting.Efficiency,
+		TimeToStarve:   f.Hunting.MeanTimeToStarve,
+		Checksum:       strconv.FormatUint(f.Checksum(), 16),
+		Phase:          f.Phase,
+	})
+}
+
+/*!
+ * \brief Nothing to release; the destination is not owned by the sink.
+ * \return nil.
+ */
+func (s *jsonLineSink) Close() error {
+	return nil
+}
+
+/*!
+ * \brief Pixels per cell in GIF output.
+ */
+const gifScale = 4
+
+/*!
+ * \brief Maximum number of frames kept for the GIF.
+ *
+ * When the limit is reached every other stored frame is dropped and only
+ * every second chronon is kept from then on, so long runs still fit in
+ * memory and cover the whole run.
+ */
+const maxGIFFrames = 512
+
+/*!
+ * \brief GIF palette indexed by Species, the registered species last.
+ */
+var gifPalette = append(color.Palette{
+	color.RGBA{0x10, 0x30, 0x80, 0xff}, // Empty water
+	color.RGBA{0x30, 0xc0, 0x40, 0xff}, // Fish
+	color.RGBA{0xe0, 0x30, 0x30, 0xff}, // Shark
+	color.RGBA{0xc8, 0xb0, 0x70, 0xff}, // Land
+	color.RGBA{0x96, 0x5a, 0xc8, 0xff}, // Whale
+}, webGIFColours...)
+
+/*!
+ * \brief Encodes frames in the background and writes an animated GIF when closed.
+ */
+type gifSink struct {
+	path    string      ///< Output file path
+	encoder *gifEncoder ///< Pool compressing the kept frames; nil before the first frame
+	frames  []*gifFrame ///< Kept frames, encoded or queued
+	size    int         ///< Grid size of the frames
+	stride  int         ///< Keep one frame out of every stride
+	seen    int         ///< Frames observed so far
+}
+
+/*!
+ * \brief Create a GIF sink.
+ * \param path Output file path.
+ * \param params Parameters of the run (unused).
+ * \return The sink, or an error if the file cannot be created.
+ *
+ * The file is created immediately so a bad path fails before the run.
+ */
+func openGIFSink(path string, params Config) (Observer, error) {
+	file, err := os.Create(path)
+	if err != nil {
+		return nil, err
+	}
+	file.Close()
+	return &gifSink{path: path, stride: 1}, nil
+}
+
+/*!
+ * \brief Queue a frame for encoding if it falls on the current stride.
+ * \param f The frame to record.
+ * \return nil.
+ *
+ * Waits while the encoders' queue is full. A dropped frame may still be
+ * encoded; its block is simply not written.
+ */
+func (s *gifSink) Observe(f *Frame) error {
+	s.seen++
+	if (s.seen-1)%s.stride != 0 {
+		return nil
+	}
+
+	if s.encoder == nil {
+		s.encoder = newGIFEncoder(runtime.GOMAXPROCS(0))
+		s.size = f.Size
+	}
+	s.frames = append(s.frames, s.encoder.encode(f))
+
+	if len(s.frames) == maxGIFFrames {
+		kept := s.frames[:0]
+		for i := 0; i < len(s.frames); i += 2 {
+			kept = append(kept, s.frames[i])
+		}
+		s.frames = kept
+		s.stride *= 2
+	}
+	return nil
+}
+
+/*!
+ * \brief Wait for the encoders and write the GIF file.
+ * \return Any file error.
+ */
+func (s *gifSink) Close() error {
+	if s.encoder == nil {
+		return nil
+	}
+	s.encoder.close()
+
+	file, err := os.Create(s.path)
+	if err != nil {
+		return err
+	}
+	if err := writeGIF(file, s.size, s.frames); err != nil {
+		file.Close()
+		return err
+	}
+	return file.Close()
+}
+
+/*!
+ * \brief JSON form of an event written by the event sink.
+ */
+type eventRecord struct {
+	Chronon  int    `json:"chronon"`
+	Kind     string `json:"kind"`
+	Species  string `json:"species"`
+	ID       int    `json:"id"`
+	ParentID int    `json:"parent,omitempty"`
+	Rival    int    `json:"rival,omitempty"`
+	X        int    `json:"x"`
+	Y        int    `json:"y"`
+
+	// Life summary of a creature that died or emigrated, zero counts left out
+	Age       int `json:"age,omitempty"`
+	Offspring int `json:"offspring,omitempty"`
+	Kills     int `json:"kills,omitempty"`
+}
+
+/*!
+ * \brief Writes every event as one JSON object per line.
+ */
+type eventSink struct {
+	file *os.File      ///< Output file
+	w    *bufio.Writer ///< Buffered writer on top of file
+	enc  *json.Encoder ///< JSON encoder on top of w
+}
+
+/*!
+ * \brief Create an event log sink.
+ * \param path Output file path.
+ * \param params Parameters of the run (unused).
+ * \return The sink, or an error if the file cannot be created.
+ */
+func openEventSink(path string, params Config) (Observer, error) {
+	file, err := os.Create(path)
+	if err != nil {
+		return nil, err
+	}
+	w := bufio.NewWriter(file)
+	return &eventSink{file: file, w: w, enc: json.NewEncoder(w)}, nil
+}
+
+/*!
+ * \brief Write the events of a frame.
+ * \param f The frame whose events to write.
+ * \return Any write error.
+ */
+func (s *eventSink) Observe(f *Frame) error {
+	for ev := range f.Events() {
+		err := s.enc.Encode(eventRecord{
+			Chronon:   f.Chronon,
+			Kind:      ev.Kind.String(),
+			Species:   ev.Species.String(),
+			ID:        ev.ID,
+			ParentID:  ev.ParentID,
+			Rival:     ev.Rival,
+			X:         ev.X,
+			Y:         ev.Y,
+			Age:       ev.Age,
+			Offspring: ev.Offspring,
+			Kills:     ev.Kills,
+		})
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+/*!
+ * \brief Flush and close the file.
+ * \return Any write or close error.
+ */
+func (s *eventSink) Close() error {
+	if err := s.w.Flush(); err != nil {
+		s.file.Close()
+		return err
+	}
+	return s.file.Close()
+}
+
+/*!
+ * \brief One node of the lineage tree.
+ */
+type lineageNode struct {
+	Parent  int     ///< Parent ID (0 for founders)
+	Species Species ///< Species of the creature
+	Born    int     ///< Chronon of birth (0 for founders)
+	Died    int     ///< Chronon of death, or -1 while alive
+}
+
+/*!
+ * \brief Collects the family tree of every creature and writes it as CSV on close.
+ */
+type lineageSink struct {
+	path  string        ///< Output file path
+	nodes []lineageNode ///< Nodes indexed by creature ID (index 0 unused)
+}
+
+/*!
+ * \brief Create a lineage sink.
+ * \param path Output file path.
+ * \param params Parameters of the run (unused).
+ * \return The sink, or an error if the file cannot be created.
+ */
+func openLineageSink(path string, params Config) (Observer, error) {
+	file, err := os.Create(path)
+	if err != nil {
+		return nil, err
+	}
+	file.Close()
+	return &lineageSink{path: path, nodes: []lineageNode{{}}}, nil
+}
+
+/*!
+ * \brief Record the births and deaths of a frame.
+ * \param f The frame to record.
+ * \return nil.
+ */
+func (s *lineageSink) Observe(f *Frame) error {
+	for ev := range f.Events() {
+		switch ev.Kind {
+		case Spawn, Birth, Immigrated, Introduced:
+			for len(s.nodes) <= ev.ID {
+				s.nodes = append(s.nodes, lineageNode{Died: -1})
+			}
+			s.nodes[ev.ID] = lineageNode{Parent: ev.ParentID, Species: ev.Species, Born: f.Chronon, Died: -1}
+		case Eaten, Starved, Died, Emigrated:
+			if ev.ID < len(s.nodes) {
+				s.nodes[ev.ID].Died = f.Chronon
+			}
+		}
+	}
+	return nil
+}
+
+/*!
+ * \brief Write the lineage tree (id, parent, species, born, died).
+ * \return Any write or close error.
+ */
+func (s *lineageSink) Close() error {
+	file, err := createCSV(s.path)
+	if err != nil {
+		return err
+	}
+	w := csv.NewWriter(file)
+	w.Write([]string{"id", "parent", "species", "born", "died"})
+	for id, n := range s.nodes {
+		if n.Species == Empty {
+			continue
+		}
+		died := ""
+		if n.Died >= 0 {
+			died = strconv.Itoa(n.Died)
+		}
+		w.Write([]string{strconv.Itoa(id), strconv.Itoa(n.Parent), n.Species.String(), strconv.Itoa(n.Born), died})
+	}
+	w.Flush()
+	if err := w.Error(); err != nil {
+		file.Close()
+		return err
+	}
+	return file.Close()
+}
@@ -0,0 +1,96 @@
+/*!
+ * \file snapseries.go
+ * \brief Numbered snapshots of the world written throughout a run.
+ *
+ * A checkpoint is a single file overwritten as the run goes on and
+ * removed at its end; it exists to survive crashes. For analysing how
+ * the spatial structure develops, -snapshot-every N keeps one snapshot
+ * every N chronons instead, from the initial world on, named by chronon
+ * so they sort in order:
+ *
+ *     out/snapshot-000000.snap
+ *     out/snapshot-000500.snap
+ *     ...
+ *
+ * Each is a complete snapshot in the format of snapshot.go, by default
+ * in the compact binary encoding, so it loads wherever a checkpoint does
+ * (fork, intervene -from) and can be continued from. The series does not
+ * depend on checkpointing and leaves its files in place when the run ends.
+ */
+
+package main
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+)
+
+/*!
+ * \brief Writes the numbered snapshots of a run.
+ */
+type snapshotSeries struct {
+	dir      string    ///< Directory of the snapshots
+	every    int       ///< Chronons between snapshots
+	encoding string    ///< Snapshot encoding of the files
+	errOut   io.Writer ///< Destination of write errors
+	written  int       ///< Snapshots written so far
+}
+
+/*!
+ * \brief Prepare a series of snapshots.
+ * \param dir Directory of the snapshots, created if missing.
+ * \param every Chronons between snapshots.
+ * \param encoding Snapshot encoding, see writeSnapshot.
+ * \param errOut Destination of write errors.
+ * \return The series, or an error if the directory cannot be created.
+ */
+func newSnapshotSeries(dir string, every int, encoding string, errOut io.Writer) (*snapshotSeries, error) {
+	if err := os.MkdirAll(dir, 0o755); err != nil {
+		return nil, fmt.Errorf("snapshot directory %s: %w", dir, err)
+	}
+	return &snapshotSeries{dir: dir, every: every, encoding: encoding, errOut: errOut}, nil
+}
+
+/*!
+ * \brief Path of the snapshot of a chronon.
+ * \param chronon Chronons simulated when it was taken.
+ * \return The file path.
+ */
+func (s *snapshotSeries) path(chronon int) string {
+	return filepath.Join(s.dir, fmt.Sprintf("snapshot-%06d.snap", chronon))
+}
+
+/*!
+ * \brief Write a snapshot if the chronon is due, reporting rather than returning failures.
+ * \param sim The simulation, between two chronons.
+ * \param outcome Outcome of the run so far.
+ *
+ * A failed snapshot does not stop the run; it is missing from the series.
+ */
+func (s *snapshotSeries) observe(sim *Simulation, outcome runOutcome) {
+	if sim.Chronon%s.every != 0 {
+		return
+	}
+	path := s.path(sim.Chronon)
+	f, err := os.Create(path)
+	if err != nil {
+		fmt.Fprintln(s.errOut, err)
+		return
+	}
+	w := bufio.NewWriter(f)
+	err = writeSnapshot(w, takeCheckpoint(sim, outcome), s.encoding)
+	if err == nil {
+		err = w.Flush()
+	}
+	if cerr := f.Close(); err == nil {
+		err = cerr
+	}
+	if err != nil {
+		fmt.Fprintf(s.errOut, "snapshot %s: %v\n", path, err)
+		return
+	}
+	s.written++
+}
@@ -0,0 +1,604 @@
+/*!
+ * \file snapshot.go
+ * \brief Versioned on-disk format of a simulation state.
+ *
+ * A snapshot is a header line naming the format, its version and the
+ * encoding of the rest, followed by the state. In the JSON encoding the
+ * state is one JSON object:
+ *
+ *     {"format":"wator-snapshot","version":2,"encoding":"json"}
+ *     {"seed":7,"draws":123456,"chronon":2000,"params":{...},"creatures":[...],...}
+ *
+ * The binary encoding of binary.go is much smaller for large worlds, and
+ * either can be gzip-compressed after the header line. Checkpoints of a
+ * run also name the program and settings that wrote them in the header
+ * (see provenance.go); loading ignores them.
+ *
+ * The state uses its own record types rather than the simulation's
+ * structs, so renaming a Go field does not change the format. Decoding
+ * is tolerant: unknown fields are skipped, so a snapshot written by a
+ * newer program still loads as long as its version is understood, and
+ * fields missing from an older snapshot keep their zero value unless a
+ * migration fills them in. Adding a field that needs a non-zero default
+ * means bumping snapshotVersion and adding a migration from the previous
+ * version.
+ */
+
+package main
+
+import (
+	"bufio"
+	"compress/gzip"
+	"encoding/json"
+	"fmt"
+	"io"
+	"math"
+	"strings"
+)
+
+/*!
+ * \brief Format name in the header of every snapshot.
+ */
+const snapshotFormat = "wator-snapshot"
+
+/*!
+ * \brief Version of the snapshots this program writes.
+ */
+const snapshotVersion = 2
+
+/*!
+ * \brief Names of the snapshot encodings, as given to -checkpoint-encoding.
+ */
+var snapshotEncodings = []string{"json", "binary", "gzip"}
+
+/*!
+ * \brief Upgrades of older snapshots, keyed by the version they upgrade from.
+ *
+ * Each migration turns a decoded snapshot of its version into one of the
+ * next version; they are applied in order up to snapshotVersion.
+ */
+var snapshotMigrations = map[int]func(*snapshotRecord){
+	// Version 2 added the encoding to the header; the state is unchanged
+	1: func(*snapshotRecord) {},
+}
+
+/*!
+ * \brief First line of a snapshot.
+ */
+type snapshotHeader struct {
+	Format      string      `json:"format"`
+	Version     int         `json:"version"`
+	Encoding    string      `json:"encoding,omitempty"`    ///< "json" (also if absent) or "binary"
+	Compression string      `json:"compression,omitempty"` ///< "gzip", or absent
+	Provenance  *provenance `json:"provenance,omitempty"`  ///< Program, settings, host and time of the run that wrote it
+}
+
+/*!
+ * \brief Simulation parameters in a snapshot.
+ */
+type paramsRecord struct {
+	Grid            int     `json:"grid"`
+	Fish            int     `json:"fish"`
+	Sharks          int     `json:"sharks"`
+	FishBreed       int     `json:"fishbreed"`
+	SharkBreed      int     `json:"sharkbreed"`
+	Starve          int     `json:"starve"`
+	Scheme          string  `json:"scheme"`
+	Workers         int     `json:"workers"`
+	Tile            int     `json:"tile"`
+	Window          int     `json:"window"`
+	InitPattern     string  `json:"init_pattern,omitempty"`
+	InitState       string  `json:"init_state,omitempty"`
+	Topology        string  `json:"topology,omitempty"`
+	ImmigrateFish   float64 `json:"immigrate_fish,omitempty"`
+	ImmigrateSharks float64 `json:"immigrate_sharks,omitempty"`
+	Emigrate        float64 `json:"emigrate,omitempty"`
+	Maturity        int     `json:"maturity,omitempty"`
+	OldAge          int     `json:"old_age,omitempty"`
+	OldFertility    float64 `json:"old_fertility,omitempty"` ///< Only set with an old age
+	OldSpeed        float64 `json:"old_speed,omitempty"`     ///< Only set with an old age
+	FishEnergy      int     `json:"fish_energy,omitempty"`
+	FishMoveCost    int     `json:"fish_move_cost,omitempty"` ///< Only set with a fish energy budget
+	Plankton        int     `json:"plankton,omitempty"`       ///< Only set with a fish energy budget
+	SharkBirthCost  int     `json:"shark_birth_cost,omitempty"`
+	FishCooldown    float64 `json:"fish_cooldown,omitempty"` ///< Only set above 1
+	SharkBirth      int     `json:"shark_birth_energy,omitempty"`
+	FishBirth       int     `json:"fish_birth_energy,omitempty"`
+	FishMortality   float64 `json:"fish_mortality,omitempty"`
+	SharkMortality  float64 `json:"shark_mortality,omitempty"`
+	FishCrowding    float64 `json:"fish_crowding,omitempty"`
+	SharkCrowding   float64 `json:"shark_crowding,omitempty"`
+	CrowdTolerance  int     `json:"crowding_tolerance,omitempty"`
+	SharkTerritory  float64 `json:"shark_territory,omitempty"`
+	SharkMeal       int     `json:"shark_meal,omitempty"`
+	SharkLitter     string  `json:"shark_litter,omitempty"` ///< Only set if not left
+	StarveOrder     string  `json:"starve_order,omitempty"` ///< Only set if not starve
+	Conflicts       string  `json:"conflicts,omitempty"`    ///< Only set if not first
+	FishEggTime     int     `json:"fish_egg_time,omitempty"`
+	SharkEggTime    int     `json:"shark_egg_time,omitempty"`
+	Sexes           bool    `json:"sexes,omitempty"`
+	MateBias        float64 `json:"mate_bias,omitempty"`         ///< Only set with sexes
+	Mutation        float64 `json:"strategy_mutation,omitempty"` ///< Chance that a newborn switches movement strategy
+	Species         string  `json:"species,omitempty"`           ///< Registered species, as for -species
+	Eats            *string `json:"eats,omitempty"`              ///< Predation matrix, as for -eats; absent = sharks eat fish
+	Introduce       string  `json:"introduce,omitempty"`         ///< Scheduled introductions, as for -introduce
+	MoveWeights     string  `json:"move_weights,omitempty"`      ///< Movement weights, as for -move-weights
+	AmbushBelow     int     `json:"ambush_below,omitempty"`
+	AmbushChance    float64 `json:"ambush_chance,omitempty"` ///< Only set with an ambush threshold
+	AmbushDrain     float64 `json:"ambush_drain,omitempty"`  ///< Only set with an ambush threshold
+	Land            string  `json:"land,omitempty"`          ///< One of ".#" per cell, row-major
+	Reef            string  `json:"reef,omitempty"`          ///< One of ".R" per cell, row-major
+	Pollution       string  `json:"pollution,omitempty"`     ///< Sources, as for -pollution
+	Diffusion       float64 `json:"pollution_diffusion,omitempty"`
+	Decay           float64 `json:"pollution_decay,omitempty"`
+	SharkDrain      float64 `json:"pollution_shark_drain,omitempty"` ///< Only set with a pollution field
+	FishHarm        float64 `json:"pollution_fish_harm,omitempty"`   ///< Only set with a pollution field
+	TempGradient    float64 `json:"temp_gradient,omitempty"`
+	Warming         float64 `json:"warming,omitempty"`
+	EquatorTemp     float64 `json:"equator_temp,omitempty"`      ///< Only set with a gradient or warming
+	FishOptimum     float64 `json:"fish_optimum,omitempty"`      ///< Only set with a gradient or warming
+	SharkOptimum    float64 `json:"shark_optimum,omitempty"`     ///< Only set with a gradient or warming
+	Tolerance       float64 `json:"thermal_tolerance,omitempty"` ///< Only set with a gradient or warming
+	ClimateBands    int     `json:"climate_bands,omitempty"`     ///< Only set with a gradient or warming
+	DayLength       int     `json:"day_length,omitempty"`
+	TideWidth       int     `json:"tide_width,omitempty"`
+	Whales          int     `json:"whales,omitempty"`
+	WhaleSize       int     `json:"whale_size,omitempty"`    ///< Only set with whales
+	TidePeriod      int     `json:"tide_period,omitempty"`   ///< Only set with a tide width
+	LowTide         float64 `json:"low_tide,omitempty"`      ///< Only set with a tide width
+	NightShare      float64 `json:"night_share,omitempty"`   ///< Only set with a day length
+	DayAmplitude    float64 `json:"day_amplitude,omitempty"` ///< Only set with a day length
+	Layout          string  `json:"layout,omitempty"`        ///< One of ".FS" per cell, row-major
+}
+
+/*!
+ * \brief A creature and its cell in a snapshot.
+ */
+type creatureRecord struct {
+	X         int    `json:"x"`
+	Y         int    `json:"y"`
+	ID        int    `json:"id"`
+	ParentID  int    `json:"parent,omitempty"` ///< Only in snapshots of older versions; ignored
+	Species   string `json:"species"`
+	Age       int    `json:"age"`
+	Energy    int    `json:"energy,omitempty"`
+	LastBreed int    `json:"last_breed"`
+	Offspring int    `json:"offspring,omitempty"`
+	Kills     int    `json:"kills,omitempty"`
+	Hatch     int    `json:"hatch,omitempty"` ///< Chronons until an egg hatches
+	Female    bool   `json:"female,omitempty"`
+	Strategy  string `json:"strategy,omitempty"` ///< "flee" or "pursue"; absent = random
+	Gen       int    `json:"generation,omitempty"`
+}
+
+/*!
+ * \brief One chronon of the hunting window in a snapshot.
+ */
+type huntRecord struct {
+	Hunts         int `json:"hunts"`
+	SharkChronons int `json:"shark_chronons"`
+	Starved       int `json:"starved"`
+	StarvedAge    int `json:"starved_age"`
+}
+
+/*!
+ * \brief Outcome of the run so far in a snapshot.
+ */
+type progressRecord struct {
+	Chronons      int `json:"chronons"`
+	Fish          int `json:"fish"`
+	Sharks        int `json:"sharks"`
+	FishExtinct   int `json:"fish_extinct"`
+	SharksExtinct int `json:"sharks_extinct"`
+}
+
+/*!
+ * \brief State of a snapshot after the header.
+ */
+type snapshotRecord struct {
+	Seed      int64            `json:"seed"`
+	Draws     uint64           `json:"draws"`
+	Chronon   int              `json:"chronon"`
+	Params    paramsRecord     `json:"params"`
+	LastID    int64            `json:"last_id"`
+	Creatures []creatureRecord `json:"creatures"`
+	Hunting   []huntRecord     `json:"hunting"`
+	Progress  progressRecord   `json:"progress"`
+	Pollution []float32        `json:"pollution,omitempty"` ///< Pollution level per cell, row-major
+	Whales    []whale          `json:"whales,omitempty"`    ///< Whales by the top left cell of their body
+}
+
+/*!
+ * \brief Encode cells as one character each.
+ * \param n Number of cells.
+ * \param symbol Character of cell i.
+ * \return The encoding.
+ */
+func cellString(n int, symbol func(i int) byte) string {
+	b := make([]byte, n)
+	for i := range b {
+		b[i] = symbol(i)
+	}
+	return string(b)
+}
+
+/*!
+ * \brief Convert a checkpoint to its snapshot record.
+ * \param cp The checkpoint.
+ * \return The record.
+ */
+func snapshotOf(cp *checkpoint) *snapshotRecord {
+	p := cp.Params
+	r := &snapshotRecord{
+		Seed:    cp.Seed,
+		Draws:   cp.Draws,
+		Chronon: cp.Chronon,
+		Params: paramsRecord{
+			Grid: p.GridSize, Fish: p.NumFish, Sharks: p.NumShark,
+			FishBreed: p.FishBreed, SharkBreed: p.SharkBreed, Starve: p.Starve,
+			Scheme: schemeName(p.Scheme), Workers: p.Workers, Tile: p.TileSize, Window: p.Window,
+			InitPattern:   p.InitPattern,
+			ImmigrateFish: p.ImmigrateFish, ImmigrateSharks: p.ImmigrateSharks, Emigrate: p.Emigrate,
+			Maturity: p.Aging.Maturity, OldAge: p.Aging.Old, FishEnergy: p.FishEnergy.Budget,
+			SharkBirthCost: p.Breeding.SharkEnergy, AmbushBelow: p.Ambush.Below,
+			FishEggTime: p.Eggs.Fish, SharkEggTime: p.Eggs.Shark,
+			SharkBirth: p.Breeding.SharkBirth, FishBirth: p.Breeding.FishBirth,
+			FishMortality: p.Mortality.Fish, SharkMortality: p.Mortality.Shark,
+			FishCrowding: p.Crowding.Fish, SharkCrowding: p.Crowding.Shark, CrowdTolerance: p.Crowding.Tolerance,
+			SharkTerritory: p.SharkTerritory, SharkMeal: p.Starvation.Meal,
+		},
+		LastID: cp.LastID,
+		Progress: progressRecord{cp.Outcome.Chronons, cp.Outcome.Fish, cp.Outcome.Sharks,
+			cp.Outcome.FishExtinct, cp.Outcome.SharksExtinct},
+	}
+	if p.RandomState {
+		r.Params.InitState = initStateName(true)
+	}
+	if p.Bounded {
+		r.Params.Topology = topologyName(true)
+	}
+	if p.Aging.Old > 0 {
+		r.Params.OldFertility, r.Params.OldSpeed = p.Aging.Fertility, p.Aging.Speed
+	}
+	if p.FishEnergy.Budget > 0 {
+		r.Params.FishMoveCost, r.Params.Plankton = p.FishEnergy.Move, p.FishEnergy.Plankton
+	}
+	if p.Breeding.FishCooldown > 1 {
+		r.Params.FishCooldown = p.Breeding.FishCooldown
+	}
+	if p.Breeding.SharkLitter != LitterLeft {
+		r.Params.SharkLitter = litterPlaceName(p.Breeding.SharkLitter)
+	}
+	if p.Starvation.Order != StarveFirst {
+		r.Params.StarveOrder = starveOrderName(p.Starvation.Order)
+	}
+	if p.Conflicts != FirstCome {
+		r.Params.Conflicts = conflictPolicyName(p.Conflicts)
+	}
+	if p.Sexes.Enabled {
+		r.Params.Sexes, r.Params.MateBias = true, p.Sexes.MateBias
+	}
+	r.Params.Mutation = p.Strategies.Mutation
+	if p.Web.enabled() {
+		eats := p.Web.formatDiet()
+		r.Params.Species, r.Params.Eats = formatWebSpecies(p.Web.Species), &eats
+	}
+	r.Params.Introduce = formatIntroductions(p.Introductions, &p.Web)
+	r.Params.MoveWeights = formatMoveWeights(p.MoveWeights, &p.Web)
+	if p.Ambush.Below > 0 {
+		r.Params.AmbushChance, r.Params.AmbushDrain = p.Ambush.Chance, p.Ambush.Drain
+	}
+	if c := p.Climate; c.enabled() {
+		r.Params.TempGradient, r.Params.Warming, r.Params.EquatorTemp = c.Gradient, c.Warming, c.Equator
+		r.Params.FishOptimum, r.Params.SharkOptimum, r.Params.Tolerance = c.FishOptimum, c.SharkOptimum, c.Tolerance
+		r.Params.ClimateBands = c.Bands
+	}
+	if p.Whales.Count > 0 || len(cp.Whales) > 0 {
+		r.Params.Whales, r.Params.WhaleSize = p.Whales.Count, p.Whales.Size
+		r.Whales = cp.Whales
+	}
+	if t := p.Tide; t.Width > 0 {
+		r.Params.TideWidth, r.Params.TidePeriod, r.Params.LowTide = t.Width, t.Period, t.Low
+	}
+	if d := p.DayNight; d.Length > 0 {
+		r.Params.DayLength, r.Params.NightShare, r.Params.DayAmplitude = d.Length, d.Night, d.Amplitude
+	}
+	if p.Land != nil {
+		r.Params.Land = cellString(len(p.Land), func(i int) byte {
+			if p.Land[i] {
+				return '#'
+			}
+			return '.'
+		})
+	}
+	if cp.Pollution != nil {
+		r.Pollution = cp.Pollution
+		r.Params.Pollution = formatPollutionSources(p.Pollution.Sources)
+		r.Params.Diffusion, r.Params.Decay = p.Pollution.Diffusion, p.Pollution.Decay
+		r.Params.SharkDrain, r.Params.FishHarm = p.Pollution.SharkDrain, p.Pollution.FishHarm
+	}
+	if p.Reef != nil {
+		r.Params.Reef = cellString(len(p.Reef), func(i int) byte {
+			if p.Reef[i] {
+				return 'R'
+			}
+			return '.'
+		})
+	}
+	if p.Layout != nil {
+		r.Params.Layout = cellString(len(p.Layout), func(i int) byte { return ".FS"[p.Layout[i]] })
+	}
+	for _, pc := range cp.Creatures {
+		c := pc.Creature
+		strategy := ""
+		if c.Strategy != Random {
+			strategy = c.Strategy.String()
+		}
+		r.Creatures = append(r.Creatures, creatureRecord{pc.X, pc.Y, c.ID, 0, p.Web.name(c.Species),
+			int(c.Age), int(c.Energy), int(c.LastBreed), int(c.Offspring), int(c.Kills), int(c.Hatch), c.Female, strategy, int(c.Gen)})
+	}
+	for _, s := range cp.Hunting {
+		r.Hunting = append(r.Hunting, huntRecord(s))
+	}
+	return r
+}
+
+/*!
+ * \brief Convert a snapshot record back to a checkpoint.
+ * \return The checkpoint, or an error for values this program cannot represent.
+ */
+func (r *snapshotRecord) checkpoint() (*checkpoint, error) {
+	p := defaultConfig()
+	rp := r.Params
+	p.GridSize, p.NumFish, p.NumShark = rp.Grid, rp.Fish, rp.Sharks
+	p.FishBreed, p.SharkBreed, p.Starve = rp.FishBreed, rp.SharkBreed, rp.Starve
+	p.Workers, p.TileSize, p.Window, p.InitPattern = rp.Workers, rp.Tile, rp.Window, rp.InitPattern
+	var err error
+	if p.Scheme, err = parseUpdateScheme(rp.Scheme); err != nil {
+		return nil, err
+	}
+	if rp.InitState != "" {
+		if p.RandomState, err = parseInitState(rp.InitState); err != nil {
+			return nil, err
+		}
+	}
+	if rp.Topology != "" {
+		if p.Bounded, err = parseTopology(rp.Topology); err != nil {
+			return nil, err
+		}
+	}
+	p.ImmigrateFish, p.ImmigrateSharks, p.Emigrate = rp.ImmigrateFish, rp.ImmigrateSharks, rp.Emigrate
+	p.Aging.Maturity, p.Aging.Old = rp.Maturity, rp.OldAge
+	if rp.OldAge > 0 {
+		p.Aging.Fertility, p.Aging.Speed = rp.OldFertility, rp.OldSpeed
+	}
+	p.FishEnergy.Budget = rp.FishEnergy
+	if rp.FishEnergy > 0 {
+		p.FishEnergy.Move, p.FishEnergy.Plankton = rp.FishMoveCost, rp.Plankton
+	}
+	p.Breeding.SharkEnergy, p.Breeding.SharkBirth, p.Breeding.FishBirth = rp.SharkBirthCost, rp.SharkBirth, rp.FishBirth
+	p.Eggs = eggRules{Fish: rp.FishEggTime, Shark: rp.SharkEggTime}
+	if rp.Sexes {
+		p.Sexes = sexRules{Enabled: true, MateBias: rp.MateBias}
+	}
+	p.Strategies.Mutation = rp.Mutation
+	if p.Web.Species, err = parseWebSpecies(rp.Species); err != nil {
+		return nil, err
+	}
+	if rp.Eats != nil {
+		if p.Web.Diet, err = parseDiet(*rp.Eats, p.Web.Species); err != nil {
+			return nil, err
+		}
+	}
+	if p.Introductions, err = parseIntroductions(rp.Introduce, p.GridSize, p.Web.Species); err != nil {
+		return nil, err
+	}
+	if p.MoveWeights, err = parseMoveWeights(rp.MoveWeights, p.Web.Species); err != nil {
+		return nil, err
+	}
+	if rp.SharkLitter != "" {
+		if p.Breeding.SharkLitter, err = parseLitterPlace(rp.SharkLitter); err != nil {
+			return nil, err
+		}
+	}
+	if rp.StarveOrder != "" {
+		if p.Starvation.Order, err = parseStarveOrder(rp.StarveOrder); err != nil {
+			return nil, err
+		}
+	}
+	if rp.Conflicts != "" {
+		if p.Conflicts, err = parseConflictPolicy(rp.Conflicts); err != nil {
+			return nil, err
+		}
+	}
+	if rp.FishCooldown != 0 {
+		p.Breeding.FishCooldown = rp.FishCooldown
+	}
+	p.Ambush.Below = rp.AmbushBelow
+	p.Mortality = mortalityRule{Fish: rp.FishMortality, Shark: rp.SharkMortality}
+	p.Crowding = crowdingRule{Fish: rp.FishCrowding, Shark: rp.SharkCrowding, Tolerance: rp.CrowdTolerance}
+	p.SharkTerritory = rp.SharkTerritory
+	p.Starvation.Meal = rp.SharkMeal
+	if rp.AmbushBelow > 0 {
+		p.Ambush.Chance, p.Ambush.Drain = rp.AmbushChance, rp.AmbushDrain
+	}
+	if rp.TempGradient != 0 || rp.Warming != 0 {
+		p.Climate = climateRules{Equator: rp.EquatorTemp, Gradient: rp.TempGradient, Warming: rp.Warming,
+			FishOptimum: rp.FishOptimum, SharkOptimum: rp.SharkOptimum, Tolerance: rp.Tolerance, Bands: rp.ClimateBands}
+	}
+	if rp.WhaleSize > 0 {
+		p.Whales = whaleRules{Count: rp.Whales, Size: rp.WhaleSize}
+	}
+	if rp.TideWidth > 0 {
+		p.Tide = tideRules{Width: rp.TideWidth, Period: rp.TidePeriod, Low: rp.LowTide}
+	}
+	if rp.DayLength > 0 {
+		p.DayNight = dayCycle{Length: rp.DayLength, Night: rp.NightShare, Amplitude: rp.DayAmplitude}
+	}
+	if rp.Land != "" {
+		p.Land = make([]bool, len(rp.Land))
+		for i := range rp.Land {
+			p.Land[i] = rp.Land[i] == '#'
+		}
+	}
+	if p.Pollution.Sources, err = parsePollutionSources(rp.Pollution); err != nil {
+		return nil, err
+	}
+	if r.Pollution != nil {
+		p.Pollution.Diffusion, p.Pollution.Decay = rp.Diffusion, rp.Decay
+		p.Pollution.SharkDrain, p.Pollution.FishHarm = rp.SharkDrain, rp.FishHarm
+	}
+	if rp.Reef != "" {
+		p.Reef = make([]bool, len(rp.Reef))
+		for i := range rp.Reef {
+			p.Reef[i] = rp.Reef[i] == 'R'
+		}
+	}
+	if rp.Layout != "" {
+		p.Layout = make([]Species, len(rp.Layout))
+		for i := range rp.Layout {
+			s := strings.IndexByte(".FS", rp.Layout[i])
+			if s < 0 {
+				return nil, fmt.Errorf("layout has unknown cell %q", rp.Layout[i])
+			}
+			p.Layout[i] = Species(s)
+		}
+	}
+
+	cp := &checkpoint{
+		Seed:    r.Seed,
+		Draws:   r.Draws,
+		Chronon: r.Chronon,
+		Params:  p,
+		LastID:  r.LastID,
+		Outcome: runOutcome{r.Progress.Chronons, r.Progress.Fish, r.Progress.Sharks,
+			r.Progress.FishExtinct, r.Progress.SharksExtinct, ""},
+		Pollution: r.Pollution,
+		Whales:    r.Whales,
+	}
+	for _, cr := range r.Creatures {
+		s, ok := p.Web.lookup(cr.Species)
+		if !ok || cr.Species == "sharks" {
+			return nil, fmt.Errorf("creature %d has unknown species %q", cr.ID, cr.Species)
+		}
+		for _, v := range []int{cr.Age, cr.Energy, cr.LastBreed, cr.Offspring, cr.Kills, cr.Gen} {
+			if v != int(int32(v)) {
+				return nil, fmt.Errorf("creature %d has a counter out of range (%d)", cr.ID, v)
+			}
+		}
+		if cr.Hatch < 0 || cr.Hatch > math.MaxInt16 {
+			return nil, fmt.Errorf("creature %d has a hatch counter out of range (%d)", cr.ID, cr.Hatch)
+		}
+		strategy, ok := parseStrategy(cr.Strategy)
+		if !ok && cr.Strategy != "" {
+			return nil, fmt.Errorf("creature %d has unknown strategy %q", cr.ID, cr.Strategy)
+		}
+		cp.Creatures = append(cp.Creatures, placedCreature{cr.X, cr.Y, Creature{
+			ID: cr.ID, Species: s, Age: int32(cr.Age), Energy: int32(cr.Energy),
+			LastBreed: int32(cr.LastBreed), Offspring: int32(cr.Offspring), Kills: int32(cr.Kills),
+			Hatch: int16(cr.Hatch), Female: cr.Female, Strategy: strategy, Gen: int32(cr.Gen),
+		}})
+	}
+	for _, h := range r.Hunting {
+		cp.Hunting = append(cp.Hunting, huntSample(h))
+	}
+	return cp, nil
+}
+
+/*!
+ * \brief Check the name of a snapshot encoding.
+ * \param name Encoding name.
+ * \return Error naming the valid encodings if name is not one of them.
+ */
+func checkSnapshotEncoding(name string) error {
+	for _, e := range snapshotEncodings {
+		if e == name {
+			return nil
+		}
+	}
+	return fmt.Errorf("unknown snapshot encoding %q (want %s)", name, strings.Join(snapshotEncodings, ", "))
+}
+
+/*!
+ * \brief Write a checkpoint as a snapshot.
+ * \param w Destination.
+ * \param cp The checkpoint.
+ * \param encoding "json", "binary" or "gzip" (gzip-compressed binary).
+ * \return Error if writing fails.
+ */
+func writeSnapshot(w io.Writer, cp *checkpoint, encoding string) error {
+	h := snapshotHeader{Format: snapshotFormat, Version: snapshotVersion, Encoding: encoding, Provenance: artifactProvenance}
+	if encoding == "gzip" {
+		h.Encoding, h.Compression = "binary", "gzip"
+	}
+	line, _ := json.Marshal(h)
+	if _, err := w.Write(append(line, '\n')); err != nil {
+		return err
+	}
+
+	var zw *gzip.Writer
+	if h.Compression == "gzip" {
+		zw = gzip.NewWriter(w)
+		w = zw
+	}
+	var err error
+	if h.Encoding == "binary" {
+		err = writeBinarySnapshot(w, snapshotOf(cp))
+	} else {
+		err = json.NewEncoder(w).Encode(snapshotOf(cp))
+	}
+	if zw != nil {
+		if cerr := zw.Close(); err == nil {
+			err = cerr
+		}
+	}
+	return err
+}
+
+/*!
+ * \brief Read a snapshot in any encoding, upgrading older versions.
+ * \param r Source.
+ * \return The checkpoint, or an error if the data is not a snapshot, is
+ *         of a newer version or is malformed.
+ */
+func readSnapshot(r io.Reader) (*checkpoint, error) {
+	br := bufio.NewReader(r)
+	line, err := br.ReadBytes('\n')
+	var h snapshotHeader
+	if err != nil || json.Unmarshal(line, &h) != nil || h.Format != snapshotFormat {
+		return nil, fmt.Errorf("not a Wa-Tor snapshot")
+	}
+	if h.Version < 1 || h.Version > snapshotVersion {
+		return nil, fmt.Errorf("snapshot version %d is not supported (this program reads versions 1 to %d)", h.Version, snapshotVersion)
+	}
+
+	switch h.Compression {
+	case "":
+	case "gzip":
+		zr, err := gzip.NewReader(br)
+		if err != nil {
+			return nil, err
+		}
+		defer zr.Close()
+		br = bufio.NewReader(zr)
+	default:
+		return nil, fmt.Errorf("unknown snapshot compression %q", h.Compression)
+	}
+
+	rec := &snapshotRecord{}
+	switch h.Encoding {
+	case "", "json":
+		err = json.NewDecoder(br).Decode(rec)
+	case "binary":
+		rec, err = readBinarySnapshot(br)
+	default:
+		err = fmt.Errorf("unknown snapshot encoding %q", h.Encoding)
+	}
+	if err != nil {
+		return nil, err
+	}
+	for v := h.Version; v < snapshotVersion; v++ {
+		snapshotMigrations[v](rec)
+	}
+	return rec.checkpoint()
+}
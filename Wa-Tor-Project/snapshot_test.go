@@ -0,0 +1,182 @@
+/*!
+ * \file snapshot_test.go
+ * \brief Snapshots restore the state they were taken from, in every encoding and version.
+ */
+
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/json"
+	"reflect"
+	"testing"
+)
+
+/*!
+ * \brief A simulation a few chronons in, with sexes and strategies set.
+ * \return The simulation.
+ */
+func snapshotSimulation() *Simulation {
+	cfg := defaultConfig()
+	cfg.GridSize = 20
+	cfg.NumFish, cfg.NumShark = 120, 30
+	cfg.Sexes.Enabled = true
+	cfg.Strategies.Mutation = 0.2
+	sim := newSimulation(cfg, 1)
+	for i := 0; i < 10; i++ {
+		sim.Step()
+	}
+	return sim
+}
+
+/*!
+ * \brief Write a snapshot of a simulation and load it again.
+ * \param t The test.
+ * \param sim The simulation.
+ * \param encoding Snapshot encoding.
+ * \return The restored simulation.
+ */
+func roundTrip(t *testing.T, sim *Simulation, encoding string) *Simulation {
+	t.Helper()
+	var buf bytes.Buffer
+	if err := writeSnapshot(&buf, takeCheckpoint(sim, runOutcome{}), encoding); err != nil {
+		t.Fatal(err)
+	}
+	return loadSnapshot(t, buf.Bytes())
+}
+
+/*!
+ * \brief Load a snapshot and restore its simulation.
+ * \param t The test.
+ * \param data The snapshot.
+ * \return The restored simulation.
+ */
+func loadSnapshot(t *testing.T, data []byte) *Simulation {
+	t.Helper()
+	cp, err := readSnapshot(bytes.NewReader(data))
+	if err != nil {
+		t.Fatal(err)
+	}
+	sim, err := cp.restore()
+	if err != nil {
+		t.Fatal(err)
+	}
+	return sim
+}
+
+/*!
+ * \brief Check that two simulations hold the same state and continue alike.
+ * \param t The test.
+ * \param want The original simulation.
+ * \param got The restored one.
+ */
+func sameContinuation(t *testing.T, want, got *Simulation) {
+	t.Helper()
+	for i := 0; i <= 20; i++ {
+		if !reflect.DeepEqual(got.World.Grid, want.World.Grid) {
+			t.Fatalf("chronon %d: the grids differ", want.Chronon)
+		}
+		wf, ws := countPopulation(want.World)
+		gf, gs := countPopulation(got.World)
+		if got.Chronon != want.Chronon || gf != wf || gs != ws {
+			t.Fatalf("chronon %d: %d fish and %d sharks at chronon %d, want %d and %d", want.Chronon, gf, gs, got.Chronon, wf, ws)
+		}
+		want.Step()
+		got.Step()
+	}
+}
+
+/*!
+ * \brief A saved and loaded simulation has the same grid and populations
+ *        and draws the same random numbers from there on.
+ */
+func TestSnapshotRoundTrip(t *testing.T) {
+	for _, encoding := range snapshotEncodings {
+		t.Run(encoding, func(t *testing.T) {
+			sim := snapshotSimulation()
+			sameContinuation(t, sim, roundTrip(t, sim, encoding))
+		})
+	}
+}
+
+/*!
+ * \brief Split a JSON snapshot into its header and state.
+ * \param t The test.
+ * \param sim The simulation to snapshot.
+ * \return The header and the state, as generic JSON objects.
+ */
+func snapshotJSON(t *testing.T, sim *Simulation) (header, state map[string]any) {
+	t.Helper()
+	var buf bytes.Buffer
+	if err := writeSnapshot(&buf, takeCheckpoint(sim, runOutcome{}), "json"); err != nil {
+		t.Fatal(err)
+	}
+	br := bufio.NewReader(&buf)
+	line, _ := br.ReadBytes('\n')
+	if err := json.Unmarshal(line, &header); err != nil {
+		t.Fatal(err)
+	}
+	if err := json.NewDecoder(br).Decode(&state); err != nil {
+		t.Fatal(err)
+	}
+	return header, state
+}
+
+/*!
+ * \brief Join a header and state into a JSON snapshot.
+ * \param header The header.
+ * \param state The state.
+ * \return The snapshot.
+ */
+func joinSnapshot(header, state map[string]any) []byte {
+	h, _ := json.Marshal(header)
+	s, _ := json.Marshal(state)
+	return append(append(h, '\n'), s...)
+}
+
+/*!
+ * \brief A version 1 snapshot, without the encoding and the fields added
+ *        since, loads with those fields at their zero value.
+ */
+func TestSnapshotMissingFields(t *testing.T) {
+	sim := snapshotSimulation()
+	header, state := snapshotJSON(t, sim)
+	header["version"] = 1
+	delete(header, "encoding")
+	delete(header, "provenance")
+	delete(state, "pollution")
+	delete(state, "whales")
+	for _, c := range state["creatures"].([]any) {
+		creature := c.(map[string]any)
+		for _, field := range []string{"generation", "strategy", "female", "hatch"} {
+			delete(creature, field)
+		}
+	}
+
+	got := loadSnapshot(t, joinSnapshot(header, state))
+	for x, column := range sim.World.Grid {
+		for y, c := range column {
+			c.Gen, c.Strategy, c.Female, c.Hatch = 0, Random, false, 0
+			if g := got.World.Grid[x][y]; g != c {
+				t.Fatalf("cell (%d,%d) is %+v, want %+v", x, y, g, c)
+			}
+		}
+	}
+}
+
+/*!
+ * \brief Fields a later version might add are skipped, and the snapshot
+ *        loads as if they were not there.
+ */
+func TestSnapshotExtraFields(t *testing.T) {
+	sim := snapshotSimulation()
+	header, state := snapshotJSON(t, sim)
+	header["checksum"] = "sha256:0"
+	state["currents"] = []any{1, 2, 3}
+	state["params"].(map[string]any)["salinity"] = 35
+	for _, c := range state["creatures"].([]any) {
+		c.(map[string]any)["genes"] = "ACGT"
+	}
+	sameContinuation(t, sim, loadSnapshot(t, joinSnapshot(header, state)))
+}
@@ -0,0 +1,69 @@
+/*!
+ * \file starvation.go
+ * \brief When a starving shark dies, and how much a meal feeds it.
+ *
+ * A shark out of energy may still hunt, and a meal may refill it only
+ * partly.
+ */
+
+package main
+
+import "fmt"
+
+/*!
+ * \brief Whether a shark that runs out of energy may still hunt first.
+ */
+type StarveOrder uint8
+
+const (
+	StarveFirst StarveOrder = iota ///< The shark dies before it hunts
+	EatFirst                       ///< The shark hunts, and only dies if it catches nothing
+)
+
+/*!
+ * \brief Parse the name of a starvation order.
+ * \param name "starve" or "eat".
+ * \return The order, or an error if the name is unknown.
+ */
+func parseStarveOrder(name string) (StarveOrder, error) {
+	switch name {
+	case "starve":
+		return StarveFirst, nil
+	case "eat":
+		return EatFirst, nil
+	}
+	return StarveFirst, fmt.Errorf("unknown starvation order %q (want starve or eat)", name)
+}
+
+/*!
+ * \brief Name of a starvation order.
+ * \param o The order.
+ * \return "starve" or "eat".
+ */
+func starveOrderName(o StarveOrder) string {
+	if o == EatFirst {
+		return "eat"
+	}
+	return "starve"
+}
+
+/*!
+ * \brief Order of starving and eating, and the energy of a meal.
+ */
+type starvationRule struct {
+	Order StarveOrder ///< Whether a shark that runs out of energy may still hunt first
+	Meal  int         ///< Energy a shark gains from a fish (0 = a full stomach)
+}
+
+/*!
+ * \brief Check a starvation rule.
+ * \param s The rule.
+ * \param starve Shark starvation time, the most energy a shark can have.
+ * \return An error if the meal energy is out of range.
+ */
+func (s starvationRule) check(starve int) error {
+	if s.Meal < 0 || s.Meal > starve {
+		return fmt.Errorf("-shark-meal must be between 0 and -starve (%d), not %d", starve, s.Meal)
+	}
+	return nil
+}
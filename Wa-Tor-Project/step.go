@@ -0,0 +1,110 @@
+/*!
+ * \file step.go
+ * \brief Functional stepping API for custom drivers.
+ *
+ * Simulation advances its world in place, which is what the commands
+ * need. Researchers wrapping the model in their own drivers, property
+ * tests or optimizers usually want values instead: NewWorld and Step
+ * take and return World values, never modify their inputs, use no
+ * global state and print nothing. All randomness comes from the
+ * caller's rng, so the same world, parameters and rng state give the
+ * same result.
+ *
+ * The model is part of package main, which Go programs cannot import.
+ * Drivers are added as files of this package (like the subcommands) or
+ * by copying the package into a library of their own.
+ *
+ * A World value refers to its creatures rather than holding them, so
+ * assigning it does not copy the grid: both copies see every change made
+ * through either. Clone makes an independent copy, for keeping a world
+ * to return to, comparing two continuations of one state, or handing a
+ * world to another goroutine while the original moves on.
+ */
+
+package main
+
+import "math/rand"
+
+/*!
+ * \brief Create a freshly populated world.
+ * \param cfg Simulation parameters.
+ * \param rng Random source used for placement.
+ * \return The world before its first chronon. Its Events are the spawns.
+ *
+ * Like newSimulation, it panics if the creatures do not fit (see checkFit).
+ */
+func NewWorld(cfg Config, rng *rand.Rand) World {
+	w := createWorld(cfg.GridSize)
+	if err := initializeWorld(w, cfg, rng); err != nil {
+		panic(err)
+	}
+	return *w
+}
+
+/*!
+ * \brief Advance a world by one chronon.
+ * \param w The world; it is not modified.
+ * \param cfg Simulation parameters (update scheme and parallel stepping).
+ *            Breed and starve times are taken from w.
+ * \param rng Random source driving movement choices.
+ * \return The world after the chronon, with the births and deaths of the
+ *         chronon as its Events.
+ *
+ * Given the same rng state, Step computes exactly what Simulation.Step
+ * does; it only pays for copying the creatures first.
+ */
+func Step(w World, cfg Config, rng *rand.Rand) World {
+	return *processChronon(w.Clone(), cfg, rng)
+}
+
+/*!
+ * \brief Deep copy of a world that shares nothing mutable with it.
+ * \return The copy, with its own creatures, cell masks, whales, events,
+ *         pollution field and ID allocator. Stepping or changing either
+ *         world leaves the other as it was.
+ *
+ * The land, reef and tidal maps, movement weights and food web are shared,
+ * since nothing ever modifies them. The copy issues the same IDs as the
+ * original from here on, so two continuations of one world stay
+ * comparable creature by creature.
+ */
+func (w *World) Clone() *World {
+	c := createWorld(w.Size)
+	c.FishBreed, c.SharkBreed, c.Starve = w.FishBreed, w.SharkBreed, w.Starve
+	c.land = w.land
+	c.reef = w.reef
+	c.pollution = append([]float32(nil), w.pollution...)
+	c.polluting = w.polluting
+	c.climate = w.climate
+	c.cycle = w.cycle
+	c.tide = w.tide
+	c.tidal = w.tidal
+	c.whales = append([]whale(nil), w.whales...)
+	c.whaleSize = w.whaleSize
+	c.elapsed = w.elapsed
+	c.bounded = w.bounded
+	c.aging = w.aging
+	c.fishEnergy = w.fishEnergy
+	c.breeding = w.breeding
+	c.ambush = w.ambush
+	c.mortality = w.mortality
+	c.crowding = w.crowding
+	c.territory = w.territory
+	c.starvation = w.starvation
+	c.conflicts = w.conflicts
+	c.eggs = w.eggs
+	c.sexes = w.sexes
+	c.strategies = w.strategies
+	c.drift = w.drift
+	c.web = w.web
+	c.Events = append([]Event(nil), w.Events...)
+	if w.ids != nil {
+		c.ids.last.Store(w.ids.last.Load())
+	}
+	for x, column := range w.Grid {
+		copy(c.Grid[x], column)
+	}
+	copy(c.creatures.words, w.creatures.words)
+	copy(c.fish.words, w.fish.words)
+	return c
+}
@@ -0,0 +1,107 @@
+/*!
+ * \file step_test.go
+ * \brief Clone and Step leave the world they were given as it was.
+ */
+
+package main
+
+import (
+	"math/rand"
+	"reflect"
+	"testing"
+)
+
+/*!
+ * \brief A populated world with whales, pollution and events to copy.
+ * \return The world and its parameters.
+ */
+func cloneWorld() (World, Config) {
+	cfg := defaultConfig()
+	cfg.GridSize = 30
+	cfg.NumFish, cfg.NumShark = 200, 60
+	cfg.Whales = whaleRules{Count: 2, Size: 2}
+	cfg.Pollution.Sources = []pollutionSource{{X: 10, Y: 10, Radius: 4, Level: 1}}
+	w := NewWorld(cfg, rand.New(rand.NewSource(1)))
+	return w, cfg
+}
+
+/*!
+ * \brief Changing a clone in every way leaves the original unchanged.
+ */
+func TestCloneIsIndependent(t *testing.T) {
+	w, _ := cloneWorld()
+	want := w.Clone()
+	if !reflect.DeepEqual(&w, want) {
+		t.Fatal("the clone differs from the original")
+	}
+
+	c := w.Clone()
+	for x, column := range c.Grid {
+		for y := range column {
+			if column[y].Species == Empty {
+				c.put(x, y, &Creature{ID: c.ids.next(), Species: Shark})
+			} else {
+				column[y].Age += 100
+			}
+		}
+	}
+	c.Events[0].ID = -1
+	c.Events = append(c.Events, Event{Kind: Birth})
+	c.pollution[0] = 1
+	c.whales[0].X++
+	c.elapsed++
+	if !reflect.DeepEqual(&w, want) {
+		t.Error("changing the clone changed the original")
+	}
+}
+
+/*!
+ * \brief A clone shares no grid column, cell mask, event list or pollution
+ *        field with the original.
+ */
+func TestCloneSharesNoStorage(t *testing.T) {
+	w, _ := cloneWorld()
+	c := w.Clone()
+	for x := range w.Grid {
+		if &c.Grid[x][0] == &w.Grid[x][0] {
+			t.Fatalf("column %d is shared", x)
+		}
+	}
+	shared := []struct {
+		name string
+		a, b any
+	}{
+		{"creature mask", &c.creatures.words[0], &w.creatures.words[0]},
+		{"fish mask", &c.fish.words[0], &w.fish.words[0]},
+		{"events", &c.Events[0], &w.Events[0]},
+		{"pollution", &c.pollution[0], &w.pollution[0]},
+		{"whales", &c.whales[0], &w.whales[0]},
+	}
+	for _, s := range shared {
+		if s.a == s.b {
+			t.Errorf("the %s are shared", s.name)
+		}
+	}
+	if c.ids == w.ids {
+		t.Error("the ID allocator is shared")
+	}
+}
+
+/*!
+ * \brief Step returns a new world and leaves the one it was given as it was.
+ */
+func TestStepLeavesWorld(t *testing.T) {
+	w, cfg := cloneWorld()
+	want := w.Clone()
+	rng := rand.New(rand.NewSource(2))
+	next := w
+	for i := 0; i < 5; i++ {
+		next = Step(next, cfg, rng)
+	}
+	if !reflect.DeepEqual(&w, want) {
+		t.Error("stepping changed the world it started from")
+	}
+	if reflect.DeepEqual(&next, want) {
+		t.Error("stepping did not change anything")
+	}
+}
@@ -0,0 +1,212 @@
+/*!
+ * \file strategy.go
+ * \brief Inheritable movement strategies that mutate as creatures breed.
+ *
+ * A creature moves at random, flees its predators or pursues its prey,
+ * like its parent.
+ */
+
+package main
+
+import (
+	"encoding/csv"
+	"errors"
+	"fmt"
+	"math/rand"
+	"strconv"
+)
+
+/*!
+ * \brief How a creature chooses between free cells.
+ */
+type Strategy uint8
+
+const (
+	Random        Strategy = iota ///< Any free cell, the classic rule
+	Flee                          ///< Free cells away from predators
+	Pursue                        ///< Free cells next to prey
+	strategyCount                 ///< Number of strategies
+)
+
+/*!
+ * \brief Lower-case name of a strategy.
+ * \return "random", "flee" or "pursue".
+ */
+func (s Strategy) String() string {
+	switch s {
+	case Flee:
+		return "flee"
+	case Pursue:
+		return "pursue"
+	}
+	return "random"
+}
+
+/*!
+ * \brief Look a strategy up by name.
+ * \param name "random", "flee" or "pursue".
+ * \return The strategy, and false for an unknown name.
+ */
+func parseStrategy(name string) (Strategy, bool) {
+	for s := Random; s < strategyCount; s++ {
+		if s.String() == name {
+			return s, true
+		}
+	}
+	return Random, false
+}
+
+/*!
+ * \brief Number of creatures of a species with each strategy.
+ */
+type strategyMix [strategyCount]int
+
+/*!
+ * \brief How strategies are passed on to the offspring.
+ */
+type strategyRules struct {
+	Mutation float64 ///< Chance that a newborn switches to another strategy than its parent's
+}
+
+/*!
+ * \brief Check the strategy rules.
+ * \param s The rules.
+ * \return An error if the mutation rate is not a probability.
+ */
+func (s strategyRules) check() error {
+	if !(s.Mutation >= 0 && s.Mutation <= 1) {
+		return fmt.Errorf("-strategy-mutation must be between 0 and 1, not %g", s.Mutation)
+	}
+	return nil
+}
+
+/*!
+ * \brief Draw the strategy of a newborn.
+ * \param parent The parent.
+ * \param rng Random source of the parent's cell.
+ * \return The parent's strategy, or with the mutation rate as probability
+ *         one of the other two.
+ */
+func (s *strategyRules) inherit(parent *Creature, rng *rand.Rand) Strategy {
+	if s.Mutation == 0 || rng.Float64() >= s.Mutation {
+		return parent.Strategy
+	}
+	return (parent.Strategy + 1 + Strategy(rng.Intn(int(strategyCount)-1))) % strategyCount
+}
+
+/*!
+ * \brief Check whether one species eats another.
+ * \param predator The species that would eat.
+ * \param prey The species that would be eaten.
+ * \return True if the food web, or without one the classic rules, lets it.
+ */
+func (w *World) eats(predator, prey Species) bool {
+	if w.web == nil {
+		return predator == Shark && prey == Fish
+	}
+	return w.web.preys[predator]&(1<<prey) != 0
+}
+
+/*!
+ * \brief Check whether a cell is next to a creature a strategy looks for.
+ * \param w The world the creature is stepped from.
+ * \param x X coordinate of the cell.
+ * \param y Y coordinate of the cell.
+ * \param c The creature.
+ * \return For Flee, whether a hatched predator of the creature is next to
+ *         the cell; for Pursue, whether its prey is.
+ */
+func (w *World) strategyTarget(x, y int, c *Creature) bool {
+	for _, pos := range getAdjacentPositions(x, y, w.Size, w.bounded) {
+		n := &w.Grid[pos[0]][pos[1]]
+		if n.Species == Empty || n.Species == Land {
+			continue
+		}
+		if c.Strategy == Flee && n.Hatch == 0 && w.eats(n.Species, c.Species) ||
+			c.Strategy == Pursue && w.eats(c.Species, n.Species) {
+			return true
+		}
+	}
+	return false
+}
+
+/*!
+ * \brief Narrow the free cells a creature may move to by its strategy.
+ * \param w The world the creature is stepped from.
+ * \param c The creature.
+ * \param cells The free cells; the chosen ones are moved to the front.
+ * \param n Number of free cells.
+ * \return Number of cells left to pick from: those away from predators
+ *         when fleeing or next to prey when pursuing, if some but not
+ *         all are, and otherwise all n.
+ */
+func (w *World) strategyCells(c *Creature, cells *[4][2]int, n int) int {
+	if c.Strategy == Random {
+		return n
+	}
+	var kept [4][2]int
+	m := 0
+	for _, pos := range cells[:n] {
+		if w.strategyTarget(pos[0], pos[1], c) != (c.Strategy == Flee) {
+			kept[m] = pos
+			m++
+		}
+	}
+	if m == 0 || m == n {
+		return n
+	}
+	copy(cells[:], kept[:m])
+	return m
+}
+
+/*!
+ * \brief Writes the strategy mix of every species as CSV.
+ */
+type strategySink struct {
+	*csvSink           ///< Output file and encoder
+	species  []Species ///< Species of the columns, in order
+}
+
+/*!
+ * \brief Create a strategy sink.
+ * \param path Output file path.
+ * \param params Parameters of the run, with strategy mutation.
+ * \return The sink, or an error if strategies do not mutate or the file
+ *         cannot be created.
+ */
+func openStrategySink(path string, params Config) (Observer, error) {
+	if params.Strategies.Mutation == 0 {
+		return nil, errors.New("needs -strategy-mutation")
+	}
+	file, err := createCSV(path)
+	if err != nil {
+		return nil, err
+	}
+	s := &strategySink{csvSink: &csvSink{file: file, w: csv.NewWriter(file)}, species: []Species{Fish, Shark}}
+	for i := range params.Web.Species {
+		s.species = append(s.species, firstWebSpecies+Species(i))
+	}
+	header := []string{"chronon"}
+	for _, sp := range s.species {
+		for st := Random; st < strategyCount; st++ {
+			header = append(header, params.Web.name(sp)+"_"+st.String())
+		}
+	}
+	s.w.Write(header)
+	return s, nil
+}
+
+/*!
+ * \brief Write the row of a frame.
+ * \param f The frame to record.
+ * \return Any write error.
+ */
+func (s *strategySink) Observe(f *Frame) error {
+	row := []string{strconv.Itoa(f.Chronon)}
+	for _, sp := range s.species {
+		for _, n := range f.StrategyMix(sp) {
+			row = append(row, strconv.Itoa(n))
+		}
+	}
+	return s.w.Write(row)
+}
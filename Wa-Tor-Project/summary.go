@@ -0,0 +1,187 @@
+/*!
+ * \file summary.go
+ * \brief The summary renderer: a sentence per chronon instead of the grid.
+ *
+ * Screen readers cannot make sense of a grid of coloured cells redrawn
+ * in place, and logs of grids are bulky. The summary renderer prints one
+ * plain line per chronon narrating what changed:
+ *
+ *     Chronon 12: fish up 12 (3%) to 412, sharks down 9 (10%) to 80, 9 sharks starved, largest shark cluster 23 in the north-west.
+ *
+ * The cluster is the largest group of sharks (eggs included) touching
+ * each other side by side, and its place the ninth of the grid its centre
+ * falls in. Clusters are not joined across the edges of the grid. A
+ * species dying out is said so once, starvations are counted, and no
+ * escape sequences are written.
+ * Numbers are written for the user's locale (see locale.go).
+ */
+
+package main
+
+import (
+	"fmt"
+	"io"
+	"math"
+	"strings"
+)
+
+/*!
+ * \brief Names of the ninths of the grid, by row and then column.
+ */
+var compassRegions = [3][3]string{
+	{"north-west", "north", "north-east"},
+	{"west", "centre", "east"},
+	{"south-west", "south", "south-east"},
+}
+
+/*!
+ * \brief Renderer narrating the changes of every chronon in a line of text.
+ */
+type summaryRenderer struct {
+	out     io.Writer ///< Destination of the output
+	started bool      ///< Whether a frame has been narrated yet
+	fish    int       ///< Fish of the previous frame
+	sharks  int       ///< Sharks of the previous frame
+}
+
+/*!
+ * \brief Create a summary renderer.
+ * \param out Destination of the output.
+ * \return The renderer.
+ */
+func newSummaryRenderer(out io.Writer) Observer {
+	return &summaryRenderer{out: out}
+}
+
+/*!
+ * \brief Describe the change of a population.
+ * \param name Plural name of the species.
+ * \param before Population of the previous frame.
+ * \param after Population of this frame.
+ * \return A phrase such as "fish up 12 (3%) to 412".
+ */
+func populationChange(name string, before, after int) string {
+	loc := userLocale()
+	switch {
+	case after == before && after == 0:
+		return "no " + name
+	case after == 0:
+		return name + " died out"
+	case after == before:
+		return fmt.Sprintf("%s steady at %s", name, loc.integer(after))
+	case before == 0:
+		return fmt.Sprintf("%s back from none to %s", name, loc.integer(after))
+	}
+	way, delta := "up", after-before
+	if delta < 0 {
+		way, delta = "down", -delta
+	}
+	percent := loc.percent(100*float64(delta)/float64(before), 0)
+	if percent == loc.percent(0, 0) {
+		percent = "under " + loc.percent(1, 0)
+	}
+	return fmt.Sprintf("%s %s %s (%s) to %s", name, way, loc.integer(delta), percent, loc.integer(after))
+}
+
+/*!
+ * \brief Find the largest cluster of sharks in a frame.
+ * \param f The frame.
+ * \return Its size and the x and y of its centre; a size of 0 without sharks.
+ */
+func largestSharkCluster(f *Frame) (int, float64, float64) {
+	seen := make([]bool, f.Size*f.Size)
+	best, bx, by := 0, 0.0, 0.0
+	var stack []int
+	for start, s := range f.Cells() {
+		if s != Shark || seen[start] {
+			continue
+		}
+		// Flood fill from this shark
+		n, sx, sy := 0, 0, 0
+		seen[start] = true
+		stack = append(stack[:0], start)
+		for len(stack) > 0 {
+			i := stack[len(stack)-1]
+			stack = stack[:len(stack)-1]
+			x, y := i%f.Size, i/f.Size
+			n, sx, sy = n+1, sx+x, sy+y
+			for _, d := range directionSteps {
+				nx, ny := x+int(d[0]), y+int(d[1])
+				if nx < 0 || ny < 0 || nx >= f.Size || ny >= f.Size {
+					continue
+				}
+				if j := ny*f.Size + nx; f.Cell(j) == Shark && !seen[j] {
+					seen[j] = true
+					stack = append(stack, j)
+				}
+			}
+		}
+		if n > best {
+			best, bx, by = n, float64(sx)/float64(n), float64(sy)/float64(n)
+		}
+	}
+	return best, bx, by
+}
+
+/*!
+ * \brief Name the ninth of a grid a point falls in.
+ * \param x X coordinate.
+ * \param y Y coordinate.
+ * \param size Width/height of the grid.
+ * \return A name from compassRegions.
+ */
+func compassRegion(x, y float64, size int) string {
+	third := func(v float64) int {
+		return min(2, max(0, int(math.Floor(3*v/float64(size)))))
+	}
+	return compassRegions[third(y)][third(x)]
+}
+
+/*!
+ * \brief Narrate a frame.
+ * \param f The frame to describe.
+ * \return Any write error.
+ */
+func (r *summaryRenderer) Observe(f *Frame) error {
+	var parts []string
+	loc := userLocale()
+	if r.started {
+		parts = append(parts, populationChange("fish", r.fish, f.Fish), populationChange("sharks", r.sharks, f.Sharks))
+	} else {
+		parts = append(parts, loc.integer(f.Fish)+" fish", loc.integer(f.Sharks)+" sharks")
+	}
+	// Starvations, which the populations alone do not tell apart from predation
+	counts := f.EventCounts()
+	switch {
+	case counts.SharksStarved == 1:
+		parts = append(parts, "1 shark starved")
+	case counts.SharksStarved > 1:
+		parts = append(parts, loc.integer(counts.SharksStarved)+" sharks starved")
+	}
+	if counts.FishStarved > 0 {
+		parts = append(parts, loc.integer(counts.FishStarved)+" fish starved")
+	}
+	switch {
+	case counts.SharksDied == 1:
+		parts = append(parts, "1 shark died")
+	case counts.SharksDied > 1:
+		parts = append(parts, loc.integer(counts.SharksDied)+" sharks died")
+	}
+	if counts.FishDied > 0 {
+		parts = append(parts, loc.integer(counts.FishDied)+" fish died")
+	}
+	if n, x, y := largestSharkCluster(f); n > 0 {
+		parts = append(parts, fmt.Sprintf("largest shark cluster %s in the %s", loc.integer(n), compassRegion(x, y, f.Size)))
+	}
+	r.started, r.fish, r.sharks = true, f.Fish, f.Sharks
+	_, err := fmt.Fprintf(r.out, "Chronon %s: %s.\n", loc.integer(f.Chronon), strings.Join(parts, ", "))
+	return err
+}
+
+/*!
+ * \brief Nothing to release.
+ * \return nil.
+ */
+func (r *summaryRenderer) Close() error {
+	return nil
+}
@@ -0,0 +1,57 @@
+/*!
+ * \file sweep.go
+ * \brief The sweep subcommand: headless replicates and sensitivity analysis.
+ */
+
+package main
+
+import (
+	"fmt"
+	"os"
+)
+
+/*!
+ * \brief Entry point of the sweep subcommand.
+ * \param args Command-line arguments after "sweep".
+ * \return Process exit code.
+ */
+func sweepCommand(args []string) int {
+	fs := newCommandFlags("sweep")
+	params := defaultConfig()
+	cfg := registerConfigFlags(fs, &params)
+	replicates := fs.Int("replicates", 0, "run this many seeds headless and report mean ± sd trajectories")
+	replicatesOut := fs.String("replicates-out", "replicates", "output `prefix` of the replicate CSV and SVG chart")
+	sensitivity := fs.String("sensitivity", "", "run a sensitivity analysis: oat (one-at-a-time) or lhs (Latin hypercube)")
+	samples := fs.Int("samples", 20, "number of Latin hypercube samples")
+	runs := fs.Int("runs", 5, "seeds per design point in sensitivity analysis")
+	sensitivityOut := fs.String("sensitivity-out", "sensitivity.csv", "output `file` of the sensitivity analysis")
+	horizon := chrononsFlag(fs, "horizon", defaultChronons, "chronons per run")
+	if err := fs.Parse(args); err != nil {
+		return flagExit(err)
+	}
+
+	seed, err := cfg.resolve()
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		return exitConfig
+	}
+	if *cfg.dryRun {
+		writeDryRun(os.Stdout, cfg, seed)
+		return exitOK
+	}
+	if (*replicates > 0) == (*sensitivity != "") {
+		fmt.Fprintln(os.Stderr, "sweep: give exactly one of -replicates R or -sensitivity oat|lhs")
+		return exitConfig
+	}
+
+	if *replicates > 0 {
+		err = replicateMode(os.Stdout, params, *replicates, *horizon, seed, *replicatesOut)
+	} else {
+		err = sensitivityMode(os.Stdout, params, *sensitivity, *samples, *runs, *horizon, seed, *sensitivityOut)
+	}
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		return exitFailure
+	}
+	return exitOK
+}
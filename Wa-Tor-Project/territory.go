@@ -0,0 +1,57 @@
+/*!
+ * \file territory.go
+ * \brief Territorial sharks that keep their distance from each other.
+ *
+ * A moving shark is less likely to pick a free cell next to other
+ * sharks.
+ */
+
+package main
+
+import (
+	"fmt"
+	"math"
+)
+
+/*!
+ * \brief Check the territory weight of the sharks.
+ * \param weight The weight.
+ * \return An error if it is negative or not finite.
+ */
+func checkTerritory(weight float64) error {
+	if !(weight >= 0 && weight < math.Inf(1)) {
+		return fmt.Errorf("-shark-territory must be a non-negative number, not %g", weight)
+	}
+	return nil
+}
+
+/*!
+ * \brief Check whether a creature's moves are weighed by the sharks around.
+ * \param c The creature.
+ * \return True for a shark in a world with a territory weight.
+ */
+func (w *World) territorial(c *Creature) bool {
+	return w.territory > 0 && c.Species == Shark
+}
+
+/*!
+ * \brief Territory factor of a free cell for a moving shark.
+ * \param newWorld Next world state, holding the sharks already stepped.
+ * \param pos The free cell.
+ * \return 1 / (1 + weight × other sharks next to the cell).
+ *
+ * Counting only where the sharks started would send every shark of a
+ * crowded patch into the same gap, so a shark that has already moved is
+ * counted in its new cell. The moving shark itself is marked as moved and
+ * not yet placed, so it does not count.
+ */
+func (w *World) territoryWeight(newWorld *World, pos [2]int) float64 {
+	sharks := 0
+	for _, n := range getAdjacentPositions(pos[0], pos[1], w.Size, w.bounded) {
+		if newWorld.Grid[n[0]][n[1]].Species == Shark ||
+			w.Grid[n[0]][n[1]].Species == Shark && !newWorld.moved.has(n[0], n[1]) {
+			sharks++
+		}
+	}
+	return 1 / (1 + w.territory*float64(sharks))
+}
@@ -0,0 +1,97 @@
+/*!
+ * \file tide.go
+ * \brief Tides that flood and expose a fringe of shoreline cells.
+ *
+ * At low water the zone near the land lies dry, stranding the creatures
+ * in it.
+ */
+
+package main
+
+import "fmt"
+
+/*!
+ * \brief Extent and timing of the tides.
+ */
+type tideRules struct {
+	Width  int     ///< Reach of the tidal zone in steps from the land (0 = no tides)
+	Period int     ///< Chronons per tide cycle
+	Low    float64 ///< Share of the cycle at low water
+}
+
+/*!
+ * \brief Check the tides.
+ * \param t The tides.
+ * \return An error naming the first setting out of range.
+ */
+func (t tideRules) check() error {
+	switch {
+	case t.Width < 0:
+		return fmt.Errorf("-tide-width must not be negative, not %d", t.Width)
+	case t.Period < 1:
+		return fmt.Errorf("-tide-period must be at least 1, not %d", t.Period)
+	case !(t.Low >= 0 && t.Low <= 1):
+		return fmt.Errorf("-low-tide must be between 0 and 1, not %g", t.Low)
+	}
+	return nil
+}
+
+/*!
+ * \brief Tidal zone of a run.
+ * \param params Parameters with the land and the tides.
+ * \return Tidal cells (row-major), nil without tides.
+ */
+func tidalZone(params Config) []bool {
+	if params.Tide.Width == 0 || params.Land == nil {
+		return nil
+	}
+	return growReefs(params.Land, nil, params.GridSize, params.Tide.Width)
+}
+
+/*!
+ * \brief Check whether a chronon falls at low water.
+ * \param chronon The chronon, counted from 0.
+ * \return True while the tidal zone lies dry.
+ */
+func (t *tideRules) out(chronon int) bool {
+	if t.Width == 0 {
+		return false
+	}
+	return chronon%t.Period >= t.Period-int(float64(t.Period)*t.Low+0.5)
+}
+
+/*!
+ * \brief Check whether a cell is exposed by the tide.
+ * \param x X coordinate.
+ * \param y Y coordinate.
+ * \return True for a tidal cell at low water during the chronon stepped
+ *         from the world.
+ */
+func (w *World) exposed(x, y int) bool {
+	return w.tidal != nil && w.tidal[y*w.Size+x] && w.tide.out(w.elapsed)
+}
+
+/*!
+ * \brief Check whether a cell is out of the water.
+ * \param x X coordinate.
+ * \param y Y coordinate.
+ * \return True for land and for cells the tide has exposed.
+ */
+func (w *World) isDry(x, y int) bool {
+	return w.isLand(x, y) || w.exposed(x, y)
+}
+
+/*!
+ * \brief Which of a cell's neighbours the tide has exposed.
+ * \param adjacent The neighbours, from getAdjacentPositions.
+ * \return Bit i set if neighbour i is exposed.
+ */
+func (w *World) exposedAround(adjacent [4][2]int) uint {
+	var set uint
+	for i, pos := range adjacent {
+		if w.exposed(pos[0], pos[1]) {
+			set |= 1 << i
+		}
+	}
+	return set
+}
@@ -0,0 +1,294 @@
+/*!
+ * \file tune.go
+ * \brief Parameter auto-tuner searching for stable coexistence.
+ *
+ * Simulated annealing over the breed times, starvation time and initial
+ * populations. A configuration scores by how long both species survive
+ * (up to the target K chronons) and, among configurations that reach
+ * K, by how far the populations stay from zero.
+ */
+
+package main
+
+import (
+	"encoding/csv"
+	"fmt"
+	"io"
+	"math"
+	"math/rand"
+	"os"
+	"sort"
+	"strconv"
+)
+
+/*!
+ * \brief Score of one configuration.
+ */
+type tuneResult struct {
+	Values      []int   ///< Value of each tunable parameter
+	Persistence float64 ///< Mean chronons both species survived, as a fraction of K
+	Robustness  float64 ///< Mean of the smaller min/mean ratio of the two populations after the transient
+	Score       float64 ///< Persistence + 0.1 * Robustness
+}
+
+/*!
+ * \brief Range searched for a tunable parameter.
+ * \param p The parameter.
+ * \param params Baseline parameters (the grid size bounds the populations).
+ * \return Smallest and largest value searched.
+ */
+func tuneBounds(p paramSpec, params Config) (int, int) {
+	switch p.Name {
+	case "fish", "sharks":
+		return 1, params.GridSize * params.GridSize / 2
+	}
+	return 1, 30
+}
+
+/*!
+ * \brief Score a configuration over several seeds.
+ * \param params Configuration to score.
+ * \param seeds Seeds of the runs.
+ * \param k Target number of chronons.
+ * \return Persistence and robustness (Values and Score are left for the caller).
+ */
+func scoreCoexistence(params Config, seeds []int64, k int) tuneResult {
+	r := tuneResult{}
+	for _, t := range runReplicates(params, seeds, k) {
+		persistence, robustness := coexistence(t)
+		r.Persistence += persistence
+		r.Robustness += robustness
+	}
+	r.Persistence /= float64(len(seeds))
+	r.Robustness /= float64(len(seeds))
+	return r
+}
+
+/*!
+ * \brief Measure how well both species coexisted in one run.
+ * \param t The trajectory.
+ * \return Chronons until either species died out as a fraction of the run
+ *         length, and (for runs where both survived) the smaller min/mean
+ *         ratio of the two populations over the second half of the run.
+ */
+func coexistence(t trajectory) (float64, float64) {
+	k := len(t.Fish)
+	survived := k
+	for _, c := range []int{t.FishExtinct, t.SharkExtinct} {
+		if c >= 0 && c < survived {
+			survived = c
+		}
+	}
+	if survived < k {
+		return float64(survived) / float64(k), 0
+	}
+	return 1, math.Min(minOverMean(t.Fish[k/2:]), minOverMean(t.Sharks[k/2:]))
+}
+
+/*!
+ * \brief Ratio of the minimum of a series to its mean.
+ * \param series Population per chronon.
+ * \return min/mean in [0, 1], or 0 for an empty or all-zero series.
+ */
+func minOverMean(series []int) float64 {
+	if len(series) == 0 {
+		return 0
+	}
+	lo, sum := series[0], 0
+	for _, v := range series {
+		lo = min(lo, v)
+		sum += v
+	}
+	if sum == 0 {
+		return 0
+	}
+	return float64(lo) * float64(len(series)) / float64(sum)
+}
+
+/*!
+ * \brief Entry point of the tune subcommand.
+ * \param args Command-line arguments after "tune".
+ * \return Process exit code.
+ */
+func tuneCommand(args []string) int {
+	fs := newCommandFlags("tune")
+	params := defaultConfig()
+	cfg := registerConfigFlags(fs, &params)
+	k := chrononsFlag(fs, "k", 1000, "chronons both species must survive")
+	iters := fs.Int("iters", 200, "configurations evaluated by the annealer")
+	runs := fs.Int("runs", 3, "seeds per configuration")
+	top := fs.Int("top", 5, "number of best configurations reported")
+	out := fs.String("out", "", "also write every evaluated configuration to this CSV `file`")
+	if err := fs.Parse(args); err != nil {
+		return flagExit(err)
+	}
+
+	seed, err := cfg.resolve()
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		return exitConfig
+	}
+	if *cfg.dryRun {
+		writeDryRun(os.Stdout, cfg, seed)
+		return exitOK
+	}
+	if *k < 2 || *iters < 1 || *runs < 1 {
+		fmt.Fprintln(os.Stderr, "tune: need -chronons >= 2, -iters >= 1 and -runs >= 1")
+		return exitConfig
+	}
+
+	results := tune(os.Stdout, params, *k, *iters, *runs, seed)
+	writeTuneTable(os.Stdout, results[:min(*top, len(results))])
+	if *out != "" {
+		if err := writeTuneCSV(*out, results); err != nil {
+			fmt.Fprintln(os.Stderr, err)
+			return exitFailure
+		}
+		fmt.Printf("Wrote %s\n", *out)
+	}
+	return exitOK
+}
+
+/*!
+ * \brief Search for configurations where both species persist.
+ * \param out Destination of progress lines.
+ * \param params Starting configuration.
+ * \param k Target number of chronons.
+ * \param iters Number of annealing steps.
+ * \param runs Seeds per configuration.
+ * \param seed Seed of the search; configuration runs use seed, seed+1, ...
+ * \return Every distinct configuration evaluated, best first.
+ *
+ * Each step changes one parameter by up to 20% (at least 1) and accepts
+ * the move by the Metropolis rule, with the temperature falling
+ * geometrically from 0.1 to 0.001.
+ */
+func tune(out io.Writer, params Config, k, iters, runs int, seed int64) []tuneResult {
+	rng := rand.New(rand.NewSource(seed))
+	seeds := make([]int64, runs)
+	for i := range seeds {
+		seeds[i] = seed + int64(i)
+	}
+
+	seen := map[string]tuneResult{}
+	evaluate := func(values []int) tuneResult {
+		key := fmt.Sprint(values)
+		if r, ok := seen[key]; ok {
+			return r
+		}
+		c := params
+		for i, p := range tunableParams {
+			*p.Field(&c) = values[i]
+		}
+		r := scoreCoexistence(c, seeds, k)
+		r.Values = append([]int{}, values...)
+		r.Score = r.Persistence + 0.1*r.Robustness
+		seen[key] = r
+		return r
+	}
+
+	current := make([]int, len(tunableParams))
+	for i, p := range tunableParams {
+		lo, hi := tuneBounds(p, params)
+		current[i] = max(lo, min(hi, *p.Field(&params)))
+	}
+	cur := evaluate(current)
+	best := cur
+	fmt.Fprintf(out, "Tuning for coexistence over %d chronons (%d steps x %d runs); start score %.3f\n", k, iters, runs, cur.Score)
+
+	for i := 0; i < iters; i++ {
+		temp := 0.1 * math.Pow(0.01, float64(i)/float64(iters))
+
+		next := append([]int{}, current...)
+		j := rng.Intn(len(tunableParams))
+		lo, hi := tuneBounds(tunableParams[j], params)
+		delta := max(1, next[j]/5)
+		next[j] = max(lo, min(hi, next[j]+rng.Intn(2*delta+1)-delta))
+
+		cand := evaluate(next)
+		if cand.Score >= cur.Score || rng.Float64() < math.Exp((cand.Score-cur.Score)/temp) {
+			current, cur = next, cand
+		}
+		if cand.Score > best.Score {
+			best = cand
+			fmt.Fprintf(out, "  step %d: new best %.3f %s\n", i, best.Score, formatTuneValues(best.Values))
+		}
+	}
+
+	results := make([]tuneResult, 0, len(seen))
+	for _, r := range seen {
+		results = append(results, r)
+	}
+	sort.Slice(results, func(a, b int) bool {
+		if results[a].Score != results[b].Score {
+			return results[a].Score > results[b].Score
+		}
+		return fmt.Sprint(results[a].Values) < fmt.Sprint(results[b].Values)
+	})
+	return results
+}
+
+/*!
+ * \brief Format parameter values as command-line flags.
+ * \param values Value of each tunable parameter.
+ * \return E.g. "-fish 300 -sharks 100 ...".
+ */
+func formatTuneValues(values []int) string {
+	s := ""
+	for i, p := range tunableParams {
+		if i > 0 {
+			s += " "
+		}
+		s += fmt.Sprintf("-%s %d", p.Name, values[i])
+	}
+	return s
+}
+
+/*!
+ * \brief Print the best configurations.
+ * \param out Destination of the table.
+ * \param results Configurations to print, best first.
+ */
+func writeTuneTable(out io.Writer, results []tuneResult) {
+	fmt.Fprintln(out, "Best configurations:")
+	fmt.Fprintf(out, "  %5s %11s %10s  %s\n", "score", "persistence", "robustness", "flags")
+	for _, r := range results {
+		fmt.Fprintf(out, "  %5.3f %11.3f %10.3f  %s\n", r.Score, r.Persistence, r.Robustness, formatTuneValues(r.Values))
+	}
+}
+
+/*!
+ * \brief Write every evaluated configuration as CSV.
+ * \param path Output file path.
+ * \param results The configurations, best first.
+ * \return Any file error.
+ */
+func writeTuneCSV(path string, results []tuneResult) error {
+	file, err := os.Create(path)
+	if err != nil {
+		return err
+	}
+	w := csv.NewWriter(file)
+	header := []string{}
+	for _, p := range tunableParams {
+		header = append(header, p.Name)
+	}
+	w.Write(append(header, "persistence", "robustness", "score"))
+	for _, r := range results {
+		row := []string{}
+		for _, v := range r.Values {
+			row = append(row, strconv.Itoa(v))
+		}
+		w.Write(append(row,
+			strconv.FormatFloat(r.Persistence, 'f', 4, 64),
+			strconv.FormatFloat(r.Robustness, 'f', 4, 64),
+			strconv.FormatFloat(r.Score, 'f', 4, 64),
+		))
+	}
+	w.Flush()
+	if err := w.Error(); err != nil {
+		file.Close()
+		return err
+	}
+	return file.Close()
+}
@@ -0,0 +1,339 @@
+/*!
+ * \file validate.go
+ * \brief Statistical validation against stored reference distributions.
+ *
+ * A change to the rules that still keeps every invariant can shift the
+ * dynamics: sharks starving a chronon late, fish breeding a chronon
+ * early. The validate subcommand guards against such drift. It runs the
+ * built-in presets, whose behaviour is documented, over many seeds and
+ * compares statistics of the runs (oscillation period, mean populations,
+ * extinction rates and times) with reference distributions measured once
+ * and stored below.
+ *
+ * A statistic passes if the mean over the runs lies within -tolerance
+ * standard errors of the reference mean. After an intended change of the
+ * dynamics, "validate -update" measures new references and prints them
+ * for pasting into validationReferences.
+ */
+
+package main
+
+import (
+	"flag"
+	"fmt"
+	"io"
+	"math"
+	"os"
+	"sort"
+	"strings"
+)
+
+/*!
+ * \brief A statistic of one run.
+ */
+type validationStat struct {
+	Name    string                                            ///< Name, unique within its case
+	Measure func(t trajectory, transient int) (float64, bool) ///< Value for a run, or false if undefined for it
+}
+
+/*!
+ * \brief A canonical configuration and the statistics checked on it.
+ */
+type validationCase struct {
+	Preset    string           ///< Preset providing the parameters
+	Chronons  int              ///< Chronons per run
+	Transient int              ///< Chronons discarded before measuring long-run statistics
+	Stats     []validationStat ///< Statistics compared with their references
+}
+
+/*!
+ * \brief Distribution of a statistic over reference runs.
+ */
+type validationReference struct {
+	Mean float64 ///< Mean over the runs it is defined for
+	SD   float64 ///< Sample standard deviation
+	Runs int     ///< Runs it was defined for
+}
+
+/*!
+ * \brief Long-run mean of a population, for runs in which both species survive.
+ * \param pick Selects the fish or shark series.
+ * \return The statistic's measure.
+ */
+func meanPopulation(pick func(t trajectory) []int) func(t trajectory, transient int) (float64, bool) {
+	return func(t trajectory, transient int) (float64, bool) {
+		if t.FishExtinct >= 0 || t.SharkExtinct >= 0 {
+			return 0, false
+		}
+		sum := 0
+		series := pick(t)[transient:]
+		for _, v := range series {
+			sum += v
+		}
+		return float64(sum) / float64(len(series)), true
+	}
+}
+
+/*!
+ * \brief Oscillation period of the fish population.
+ * \param t The run.
+ * \param transient Chronons to discard.
+ * \return The lag of the first autocorrelation peak after it turns
+ *         negative, or false if a species died out or the series does not
+ *         oscillate.
+ */
+func oscillationPeriod(t trajectory, transient int) (float64, bool) {
+	if t.FishExtinct >= 0 || t.SharkExtinct >= 0 {
+		return 0, false
+	}
+	x := make([]float64, len(t.Fish)-transient)
+	mean := 0.0
+	for i := range x {
+		x[i] = float64(t.Fish[transient+i])
+		mean += x[i]
+	}
+	mean /= float64(len(x))
+	for i := range x {
+		x[i] -= mean
+	}
+	autocorrelation := func(lag int) float64 {
+		sum := 0.0
+		for i := lag; i < len(x); i++ {
+			sum += x[i] * x[i-lag]
+		}
+		return sum
+	}
+
+	lag := 1
+	for lag < len(x)/2 && autocorrelation(lag) >= 0 {
+		lag++
+	}
+	// The first peak after the trough; later peaks are multiples of the period
+	best, period := 0.0, 0
+	for ; lag < len(x)/2; lag++ {
+		r := autocorrelation(lag)
+		if r > best {
+			best, period = r, lag
+		} else if period > 0 && r < 0 {
+			break
+		}
+	}
+	return float64(period), period > 0
+}
+
+/*!
+ * \brief The canonical configurations.
+ */
+var validationCases = []validationCase{
+	{"classic", 1500, 500, []validationStat{
+		{"coexistence", func(t trajectory, _ int) (float64, bool) {
+			return float64(boolToInt(t.FishExtinct < 0 && t.SharkExtinct < 0)), true
+		}},
+		{"mean-fish", meanPopulation(func(t trajectory) []int { return t.Fish })},
+		{"mean-sharks", meanPopulation(func(t trajectory) []int { return t.Sharks })},
+		{"period", oscillationPeriod},
+	}},
+	{"oscillator", 1000, 300, []validationStat{
+		{"mean-fish", meanPopulation(func(t trajectory) []int { return t.Fish })},
+		{"mean-sharks", meanPopulation(func(t trajectory) []int { return t.Sharks })},
+		{"period", oscillationPeriod},
+	}},
+	{"fragile", 300, 0, []validationStat{
+		{"shark-extinction", func(t trajectory, _ int) (float64, bool) {
+			return float64(boolToInt(t.SharkExtinct >= 0)), true
+		}},
+	}},
+	{"shark-bloom", 100, 0, []validationStat{
+		{"fish-extinct-at", func(t trajectory, _ int) (float64, bool) {
+			return float64(t.FishExtinct), t.FishExtinct >= 0
+		}},
+	}},
+}
+
+/*!
+ * \brief Reference distributions, keyed "preset/statistic".
+ *
+ * Measured with "validate -update" over 200 runs with seeds from
+ * validationReferenceSeed on.
+ */
+var validationReferences = map[string]validationReference{
+	"classic/coexistence":         {Mean: 1.000, SD: 0.000, Runs: 200},
+	"classic/mean-fish":           {Mean: 1248.971, SD: 12.706, Runs: 200},
+	"classic/mean-sharks":         {Mean: 234.232, SD: 2.177, Runs: 200},
+	"classic/period":              {Mean: 40.070, SD: 0.275, Runs: 200},
+	"fragile/shark-extinction":    {Mean: 0.490, SD: 0.501, Runs: 200},
+	"oscillator/mean-fish":        {Mean: 2986.056, SD: 41.408, Runs: 200},
+	"oscillator/mean-sharks":      {Mean: 474.048, SD: 4.878, Runs: 200},
+	"oscillator/period":           {Mean: 53.985, SD: 3.061, Runs: 200},
+	"shark-bloom/fish-extinct-at": {Mean: 13.700, SD: 2.441, Runs: 200},
+}
+
+/*!
+ * \brief First seed of the reference runs, far from the seeds validate uses by default.
+ */
+const validationReferenceSeed = 1000000
+
+/*!
+ * \brief Convert a boolean to 0 or 1.
+ * \param b The boolean.
+ * \return 1 if b is true, 0 otherwise.
+ */
+func boolToInt(b bool) int {
+	if b {
+		return 1
+	}
+	return 0
+}
+
+/*!
+ * \brief Parameters of a preset.
+ * \param name Preset name.
+ * \return The resolved parameters, or an error for an unknown preset.
+ */
+func presetConfig(name string) (Config, error) {
+	params := defaultConfig()
+	fs := flag.NewFlagSet("validate", flag.ContinueOnError)
+	fs.SetOutput(io.Discard)
+	cfg := registerConfigFlags(fs, &params)
+	cfg.ignoreEnv = true
+	fs.Set("preset", name)
+	fs.Set("seed", "1")
+	_, err := cfg.resolve()
+	return params, err
+}
+
+/*!
+ * \brief Mean and sample standard deviation of a statistic over runs.
+ * \param stat The statistic.
+ * \param runs The runs.
+ * \param transient Chronons discarded before measuring.
+ * \return The distribution over the runs the statistic is defined for.
+ */
+func measureStat(stat validationStat, runs []trajectory, transient int) validationReference {
+	var values []float64
+	for _, t := range runs {
+		if v, ok := stat.Measure(t, transient); ok {
+			values = append(values, v)
+		}
+	}
+	r := validationReference{Runs: len(values)}
+	for _, v := range values {
+		r.Mean += v
+	}
+	if r.Runs > 0 {
+		r.Mean /= float64(r.Runs)
+	}
+	for _, v := range values {
+		r.SD += (v - r.Mean) * (v - r.Mean)
+	}
+	if r.Runs > 1 {
+		r.SD = math.Sqrt(r.SD / float64(r.Runs-1))
+	}
+	return r
+}
+
+/*!
+ * \brief Entry point of the validate subcommand.
+ * \param args Command-line arguments after "validate".
+ * \return Process exit code: exitOK if every statistic is within tolerance, exitFailure otherwise.
+ */
+func validateCommand(args []string) int {
+	fs := newCommandFlags("validate")
+	seeds := fs.Int("seeds", 20, "runs per configuration")
+	seed := fs.Int64("seed", 1, "seed of the first run")
+	tolerance := fs.Float64("tolerance", 4, "largest accepted distance from the reference mean, in standard errors")
+	only := fs.String("case", "", "validate only this preset")
+	update := fs.Bool("update", false, "measure new references over 200 runs and print them as Go source")
+	if err := fs.Parse(args); err != nil {
+		return flagExit(err)
+	}
+	if *seeds < 1 {
+		fmt.Fprintln(os.Stderr, "-seeds must be at least 1")
+		return exitConfig
+	}
+	runSeeds := make([]int64, *seeds)
+	for i := range runSeeds {
+		runSeeds[i] = *seed + int64(i)
+	}
+	if *update {
+		runSeeds = make([]int64, 200)
+		for i := range runSeeds {
+			runSeeds[i] = validationReferenceSeed + int64(i)
+		}
+	}
+
+	measured := map[string]validationReference{}
+	failed := 0
+	found := false
+	for _, vc := range validationCases {
+		if *only != "" && *only != vc.Preset {
+			continue
+		}
+		found = true
+		params, err := presetConfig(vc.Preset)
+		if err != nil {
+			fmt.Fprintln(os.Stderr, err)
+			return exitFailure
+		}
+		runs := runReplicates(params, runSeeds, vc.Chronons)
+		for _, stat := range vc.Stats {
+			key := vc.Preset + "/" + stat.Name
+			got := measureStat(stat, runs, vc.Transient)
+			measured[key] = got
+			if *update {
+				continue
+			}
+
+			ref, ok := validationReferences[key]
+			verdict := "ok"
+			z := 0.0
+			switch {
+			case !ok:
+				verdict = "FAIL (no reference)"
+			case got.Runs == 0:
+				verdict = "FAIL (undefined in every run)"
+			default:
+				// A reference without spread still admits one run in ref.Runs deviating by one
+				floor := math.Sqrt(float64(ref.Runs-1)) / float64(ref.Runs)
+				stderr := max(ref.SD, floor) / math.Sqrt(float64(got.Runs))
+				z = math.Abs(got.Mean-ref.Mean) / stderr
+				if z > *tolerance {
+					verdict = "FAIL"
+				}
+			}
+			if verdict != "ok" {
+				failed++
+			}
+			fmt.Printf("%-12s %-17s %3d runs  mean %9.2f  reference %9.2f ± %-8.2f  %5.1f SE  %s\n",
+				vc.Preset, stat.Name, got.Runs, got.Mean, ref.Mean, ref.SD, z, verdict)
+		}
+	}
+	if !found {
+		names := make([]string, len(validationCases))
+		for i, vc := range validationCases {
+			names[i] = vc.Preset
+		}
+		fmt.Fprintf(os.Stderr, "unknown case %q (want one of %s)\n", *only, strings.Join(names, ", "))
+		return exitConfig
+	}
+
+	if *update {
+		keys := make([]string, 0, len(measured))
+		for key := range measured {
+			keys = append(keys, key)
+		}
+		sort.Strings(keys)
+		fmt.Println("var validationReferences = map[string]validationReference{")
+		for _, key := range keys {
+			r := measured[key]
+			fmt.Printf("\t%q: {Mean: %.3f, SD: %.3f, Runs: %d},\n", key, r.Mean, r.SD, r.Runs)
+		}
+		fmt.Println("}")
+		return exitOK
+	}
+	if failed > 0 {
+		fmt.Printf("%d statistics drifted from their references\n", failed)
+		return exitFailure
+	}
+	return exitOK
+}
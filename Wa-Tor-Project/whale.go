@@ -0,0 +1,214 @@
+/*!
+ * \file whale.go
+ * \brief Whales: creatures with a body of several cells.
+ *
+ * A whale moves its whole body as one and neither hunts, breeds nor
+ * starves.
+ */
+
+package main
+
+import (
+	"fmt"
+	"math/rand"
+)
+
+/*!
+ * \brief Number and size of the whales.
+ */
+type whaleRules struct {
+	Count int ///< Whales placed at the start
+	Size  int ///< Width/height of a whale's body in cells
+}
+
+/*!
+ * \brief Check the whales.
+ * \param w The whales.
+ * \param grid Width/height of the grid.
+ * \return An error naming the first setting out of range; the size only
+ *         matters with whales.
+ */
+func (w whaleRules) check(grid int) error {
+	switch {
+	case w.Count < 0:
+		return fmt.Errorf("-whales must not be negative, not %d", w.Count)
+	case w.Count > 0 && (w.Size < 1 || w.Size >= grid):
+		return fmt.Errorf("-whale-size must be between 1 and the grid size - 1 (%d), not %d", grid-1, w.Size)
+	}
+	return nil
+}
+
+/*!
+ * \brief Cells taken by the bodies of all whales.
+ * \return Count times the area of a body.
+ */
+func (w whaleRules) cells() int {
+	return w.Count * w.Size * w.Size
+}
+
+/*!
+ * \brief A whale, by the anchor of its body.
+ */
+type whale struct {
+	ID  int   `json:"id"`  ///< ID of the whale, shared by all cells of its body
+	X   int   `json:"x"`   ///< X coordinate of the top left cell of the body
+	Y   int   `json:"y"`   ///< Y coordinate of the top left cell of the body
+	Age int32 `json:"age"` ///< Age in chronons
+}
+
+/*!
+ * \brief Visit the cells of a whale's body.
+ * \param x X coordinate of the anchor.
+ * \param y Y coordinate of the anchor.
+ * \param visit Called with each cell in turn; returning false stops.
+ * \return False if visit stopped or the body would reach past the edge
+ *         of a bounded world.
+ */
+func (w *World) whaleBody(x, y int, visit func(cx, cy int) bool) bool {
+	k := w.whaleSize
+	if w.bounded && (x+k > w.Size || y+k > w.Size) {
+		return false
+	}
+	for dx := 0; dx < k; dx++ {
+		for dy := 0; dy < k; dy++ {
+			if !visit(wrap(x+dx, w.Size), wrap(y+dy, w.Size)) {
+				return false
+			}
+		}
+	}
+	return true
+}
+
+/*!
+ * \brief Check whether a whale's body fits at an anchor.
+ * \param oldWorld World the whale moves from; its cells must be water and
+ *                 empty or part of the whale itself.
+ * \param newWorld World the whale moves into, or nil; its cells must be empty.
+ * \param id ID of the whale, 0 for a whale still to be placed.
+ * \param x X coordinate of the anchor.
+ * \param y Y coordinate of the anchor.
+ * \return True if the body fits.
+ */
+func whaleFits(oldWorld, newWorld *World, id, x, y int) bool {
+	return oldWorld.whaleBody(x, y, func(cx, cy int) bool {
+		c := &oldWorld.Grid[cx][cy]
+		return !oldWorld.isDry(cx, cy) && (c.Species == Empty || c.Species == Whale && c.ID == id) &&
+			(newWorld == nil || !newWorld.occupied(cx, cy))
+	})
+}
+
+/*!
+ * \brief Put a whale's body into a world.
+ * \param w The world.
+ * \param wh The whale.
+ */
+func (w *World) putWhale(wh whale) {
+	body := Creature{ID: wh.ID, Species: Whale, Age: wh.Age}
+	w.whaleBody(wh.X, wh.Y, func(cx, cy int) bool {
+		w.put(cx, cy, &body)
+		return true
+	})
+	w.whales = append(w.whales, wh)
+}
+
+/*!
+ * \brief Place the whales of a run.
+ * \param world World holding the fish and sharks already.
+ * \param rules Number and size of the whales.
+ * \param rng Random source used for placement.
+ *
+ * Each whale is placed at an anchor drawn from all the anchors where its
+ * body fits; placing stops early when there are none left.
+ */
+func placeWhales(world *World, rules whaleRules, rng *rand.Rand) {
+	world.whaleSize = rules.Size
+	for i := 0; i < rules.Count; i++ {
+		var anchors [][2]int
+		for x := 0; x < world.Size; x++ {
+			for y := 0; y < world.Size; y++ {
+				if whaleFits(world, nil, 0, x, y) {
+					anchors = append(anchors, [2]int{x, y})
+				}
+			}
+		}
+		if len(anchors) == 0 {
+			return
+		}
+		a := anchors[rng.Intn(len(anchors))]
+		world.putWhale(whale{ID: world.ids.next(), X: a[0], Y: a[1]})
+	}
+}
+
+/*!
+ * \brief Move the whales for a chronon.
+ * \param oldWorld Current world state.
+ * \param newWorld Next world state, still without creatures.
+ * \param rng Random source of the simulation.
+ */
+func moveWhales(oldWorld, newWorld *World, rng *rand.Rand) {
+	newWorld.whaleSize = oldWorld.whaleSize
+	newWorld.whales = newWorld.whales[:0]
+	for _, wh := range oldWorld.whales {
+		wh.Age++
+		stranded := !oldWorld.whaleBody(wh.X, wh.Y, func(cx, cy int) bool { return !oldWorld.exposed(cx, cy) })
+		var moves [4][2]int
+		n := 0
+		if !stranded {
+			for _, d := range [4][2]int{{0, -1}, {0, 1}, {-1, 0}, {1, 0}} {
+				x, y := wh.X+d[0], wh.Y+d[1]
+				if oldWorld.bounded && (x < 0 || y < 0 || x >= oldWorld.Size || y >= oldWorld.Size) {
+					continue
+				}
+				x, y = wrap(x, oldWorld.Size), wrap(y, oldWorld.Size)
+				if whaleFits(oldWorld, newWorld, wh.ID, x, y) {
+					moves[n] = [2]int{x, y}
+					n++
+				}
+			}
+		}
+		if n > 0 {
+			m := moves[rng.Intn(n)]
+			wh.X, wh.Y = m[0], m[1]
+		}
+		newWorld.putWhale(wh)
+	}
+}
+
+/*!
+ * \brief Check that the whale list of a world matches its grid.
+ * \param w The world.
+ * \return nil, or an error for the first whale whose body is broken or
+ *         on land, or for stray whale cells.
+ */
+func (w *World) checkWhales() error {
+	cells := 0
+	for _, wh := range w.whales {
+		var err error
+		w.whaleBody(wh.X, wh.Y, func(cx, cy int) bool {
+			c := &w.Grid[cx][cy]
+			switch {
+			case c.Species != Whale || c.ID != wh.ID:
+				err = fmt.Errorf("whale %d is missing from (%d,%d)", wh.ID, cx, cy)
+			case w.isLand(cx, cy):
+				err = fmt.Errorf("whale %d lies on land at (%d,%d)", wh.ID, cx, cy)
+			}
+			cells++
+			return err == nil
+		})
+		if err != nil {
+			return err
+		}
+	}
+	stray := -cells
+	for _, column := range w.Grid {
+		for _, c := range column {
+			if c.Species == Whale {
+				stray++
+			}
+		}
+	}
+	if stray != 0 {
+		return fmt.Errorf("%d whale cells are not part of a listed whale", stray)
+	}
+	return nil
+}